@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ComponentTemplateSpec defines the desired state of ComponentTemplate
+type ComponentTemplateSpec struct {
+
+	// Settings, mappings and aliases of the template in JSON or YAML, as in the template of the
+	// Elasticsearch component template API. Indices compose from it with spec.componentTemplates
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// Version of the template for external management
+	// +optional
+	Version int64 `json:"version,omitempty"`
+}
+
+// ComponentTemplateStatus defines the observed state of ComponentTemplate
+type ComponentTemplateStatus struct {
+	// Generation of the spec last applied to Elasticsearch
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions of the template, see ConditionSynced
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ConditionSynced is true when the last generation of a template was applied to Elasticsearch
+const ConditionSynced = "Synced"
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// ComponentTemplate is the Schema for the componenttemplates API, it's synced to the Elasticsearch
+// component template with the same name
+type ComponentTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ComponentTemplateSpec   `json:"spec,omitempty"`
+	Status ComponentTemplateStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ComponentTemplateList contains a list of ComponentTemplate
+type ComponentTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ComponentTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ComponentTemplate{}, &ComponentTemplateList{})
+}
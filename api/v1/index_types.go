@@ -29,7 +29,8 @@ type IndexSpec struct {
 	// Application Name
 	Application string `json:"application"`
 
-	// Config Map name to be used contained the create Index Payload including settings and mappings
+	// Config Map name to be used contained the create Index Payload including settings and mappings.
+	// The payload keys are rendered as Go templates and can be written in JSON or YAML
 	// +optional
 	ConfigMap string `json:"configMap,omitempty"`
 
@@ -45,6 +46,54 @@ type IndexSpec struct {
 	SourceEnabled bool `json:"sourceEnabled,omitempty"`
 	// +optional
 	Properties string `json:"properties,omitempty"`
+
+	// Adopt takes over the existing index or alias with the index Name instead of creating a new one.
+	// The mappings are validated against the spec and the role, user and secret created around it
+	// +optional
+	Adopt bool `json:"adopt,omitempty"`
+
+	// DryRun renders and validates the provisioning requests against Elasticsearch without creating anything,
+	// the result is reported in the status. Also enabled with the DryRunAnnotation
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Component templates merged into the index settings, mappings and aliases in order, the Index spec takes
+	// precedence. Changes to ComponentTemplate resources are applied to the index like spec changes
+	// +optional
+	ComponentTemplates []string `json:"componentTemplates,omitempty"`
+
+	// Name of the IngestPipeline of the namespace run on the documents indexed without a pipeline,
+	// _none disables the pipeline set by an index template
+	// +optional
+	DefaultPipeline string `json:"defaultPipeline,omitempty"`
+
+	// Name of the IngestPipeline of the namespace run on all the documents indexed after the other pipelines,
+	// _none disables the pipeline set by an index template
+	// +optional
+	FinalPipeline string `json:"finalPipeline,omitempty"`
+
+	// Synonym sets loaded from Config Maps and exposed as synonym token filters.
+	// Changes to the Config Maps are applied to the index without a reindex
+	// +optional
+	Synonyms []SynonymSet `json:"synonyms,omitempty"`
+}
+
+// SynonymSet references a Config Map containing a list of synonyms in Solr format, one rule per line
+type SynonymSet struct {
+	// Token filter name, use it in the analyzers defined for the index
+	Name string `json:"name"`
+	// Config Map name containing the synonyms
+	ConfigMap string `json:"configMap"`
+	// Key in the Config Map containing the synonyms, defaults to synonyms.txt
+	// +optional
+	Key string `json:"key,omitempty"`
+	// Token filter type, defaults to synonym_graph
+	// +kubebuilder:validation:Enum=synonym;synonym_graph
+	// +optional
+	Type string `json:"type,omitempty"`
+	// Only use the filter in search analyzers so it can be reloaded without closing the index
+	// +optional
+	Updateable bool `json:"updateable,omitempty"`
 }
 
 type IndexStatusEnum string
@@ -57,11 +106,175 @@ const (
 	Ready IndexStatusEnum = "Ready"
 
 	Error IndexStatusEnum = "Error"
+
+	DryRun IndexStatusEnum = "DryRun"
+)
+
+// SecretName is the Secret created in the Index namespace with the credentials and index names
+const SecretName = "es-provisioner-index-secret"
+
+// SecretOwnerAnnotation is set on the Secret to the name of the Index that wrote it
+const SecretOwnerAnnotation = "es-provisioner.com.ramos/index"
+
+// Annotations to request operations on a Ready Index. The value is a token, usually a timestamp,
+// the operation runs once for each new value
+const (
+	// RotatePasswordAnnotation sets a new password for the index user and updates the Secret
+	RotatePasswordAnnotation = "es-provisioner.com.ramos/rotate-password"
+	// ReindexAnnotation migrates the data to a new index with the current spec
+	ReindexAnnotation = "es-provisioner.com.ramos/reindex"
+	// SnapshotAnnotation takes a snapshot of the index in the SnapshotRepositoryAnnotation repository,
+	// or the operator SNAPSHOT_REPOSITORY when not set
+	SnapshotAnnotation           = "es-provisioner.com.ramos/snapshot"
+	SnapshotRepositoryAnnotation = "es-provisioner.com.ramos/snapshot-repository"
+)
+
+// DryRunAnnotation set to "true" enables the dry run mode like spec.dryRun
+const DryRunAnnotation = "es-provisioner.com.ramos/dry-run"
+
+// Index condition types
+const (
+	// ConditionDrifted is true when Elasticsearch no longer matches the Index and it couldn't be repaired
+	ConditionDrifted = "Drifted"
+	// ConditionProvisioned is true when all the provisioning steps completed, the reason and message
+	// explain why while it's false
+	ConditionProvisioned = "Provisioned"
+	// ConditionValidated is true when the last dry run found no errors
+	ConditionValidated = "Validated"
+	// ConditionWaiting is true while the provisioning waits for the Elasticsearch cluster to be healthy and
+	// have capacity for the index
+	ConditionWaiting = "Waiting"
+	// ConditionCleanupBlocked is true when the Index is being deleted and its resources couldn't be
+	// removed from Elasticsearch, the message explains why
+	ConditionCleanupBlocked = "CleanupBlocked"
 )
 
+// ElasticsearchResources are the names of the resources created in Elasticsearch for the Index
+type ElasticsearchResources struct {
+	// +optional
+	Index string `json:"index,omitempty"`
+	// +optional
+	Alias string `json:"alias,omitempty"`
+	// +optional
+	Role string `json:"role,omitempty"`
+	// +optional
+	User string `json:"user,omitempty"`
+	// Adopted is true when the index existed before the Index was created
+	// +optional
+	Adopted bool `json:"adopted,omitempty"`
+	// AliasAdopted is true when the alias of an adopted index existed before the Index was created
+	// +optional
+	AliasAdopted bool `json:"aliasAdopted,omitempty"`
+	// Created are the kinds of the objects created by the provisioning, the only ones a failed
+	// provisioning deletes
+	// +optional
+	Created []string `json:"created,omitempty"`
+}
+
+// DryRunRequest is a request the provisioning would send to Elasticsearch
+type DryRunRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// +optional
+	Body string `json:"body,omitempty"`
+}
+
+// DryRunStatus is the result of the last dry run
+type DryRunStatus struct {
+	// Generation of the spec validated
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Config Map resource version validated
+	// +optional
+	ConfigMapVersion string `json:"configMapVersion,omitempty"`
+	// Requests the provisioning would send, the password is masked
+	// +optional
+	Requests []DryRunRequest `json:"requests,omitempty"`
+	// Errors that would make the provisioning fail
+	// +optional
+	Errors []string `json:"errors,omitempty"`
+	// Existing resources the provisioning would modify
+	// +optional
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// IndexMigration is a migration of the data to a new index in progress
+type IndexMigration struct {
+	// New index the data is copied to, the alias is moved to it when the reindex task completes
+	Index string `json:"index"`
+	// Id of the Elasticsearch reindex task copying the data
+	Task string `json:"task"`
+
+	// Generation of the spec migrated, recorded as applied when the migration completes
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Config Map resource version migrated
+	// +optional
+	ConfigMapVersion string `json:"configMapVersion,omitempty"`
+
+	// Config Map resource version migrated for each synonym set
+	// +optional
+	Synonyms map[string]string `json:"synonyms,omitempty"`
+
+	// Generation migrated for each ComponentTemplate resource
+	// +optional
+	ComponentTemplates map[string]string `json:"componentTemplates,omitempty"`
+}
+
 // IndexStatus defines the observed state of Index
 type IndexStatus struct {
 	IndexStatus IndexStatusEnum `json:"indexStatus,omitempty"`
+
+	// Last provisioning step completed, provisioning resumes from the next step
+	// +optional
+	ProvisioningStep string `json:"provisioningStep,omitempty"`
+
+	// Resources created in Elasticsearch
+	// +optional
+	Elasticsearch ElasticsearchResources `json:"elasticsearch,omitempty"`
+
+	// Consecutive failed attempts of the current provisioning step
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+
+	// Time of the next provisioning attempt after a transient error
+	// +optional
+	NextRetry *metav1.Time `json:"nextRetry,omitempty"`
+
+	// Generation of the spec last applied to the index
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Config Map resource version last applied to the index
+	// +optional
+	ConfigMapVersion string `json:"configMapVersion,omitempty"`
+
+	// Config Map resource version applied for each synonym set
+	// +optional
+	Synonyms map[string]string `json:"synonyms,omitempty"`
+
+	// Generation applied for each ComponentTemplate resource, empty for templates managed outside the operator
+	// +optional
+	ComponentTemplates map[string]string `json:"componentTemplates,omitempty"`
+
+	// Migration to a new index in progress, the other changes are applied once it completes
+	// +optional
+	Migration *IndexMigration `json:"migration,omitempty"`
+
+	// Annotation values of the operations already run, see RotatePasswordAnnotation
+	// +optional
+	Operations map[string]string `json:"operations,omitempty"`
+
+	// Result of the last dry run, see IndexSpec.DryRun
+	// +optional
+	DryRun *DryRunStatus `json:"dryRun,omitempty"`
+
+	// Conditions of the index, see ConditionDrifted
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
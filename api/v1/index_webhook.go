@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var indexlog = logf.Log.WithName("index-webhook")
+
+// SetupWebhookWithManager registers the validating webhook of the Indices
+func (r *Index) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&IndexValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-es-provisioner-com-ramos-v1-index,mutating=false,failurePolicy=fail,sideEffects=None,groups=es-provisioner.com.ramos,resources=indices,verbs=create;update,versions=v1,name=vindex.kb.io,admissionReviewVersions=v1
+
+// IndexValidator rejects the Indices exceeding an IndexQuota of their namespace. The shards and replicas are
+// taken from the specs and the store size from the usage measured in the IndexQuota status.
+// +kubebuilder:object:generate=false
+type IndexValidator struct {
+	Client client.Reader
+}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *IndexValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	index := obj.(*Index)
+	indexlog.V(1).Info("validate create", "namespace", index.Namespace, "name", index.Name)
+	return v.validateQuotas(ctx, index, nil)
+}
+
+// ValidateUpdate implements admission.CustomValidator
+func (v *IndexValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	index := newObj.(*Index)
+	indexlog.V(1).Info("validate update", "namespace", index.Namespace, "name", index.Name)
+	return v.validateQuotas(ctx, index, oldObj.(*Index))
+}
+
+// ValidateDelete implements admission.CustomValidator, deleting always frees quota
+func (v *IndexValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (v *IndexValidator) validateQuotas(ctx context.Context, index *Index, previous *Index) error {
+	if !index.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	var quotas IndexQuotaList
+	if err := v.Client.List(ctx, &quotas, client.InNamespace(index.Namespace)); err != nil {
+		return apierrors.NewInternalError(fmt.Errorf("cannot list IndexQuotas: %s", err))
+	}
+	if len(quotas.Items) == 0 {
+		return nil
+	}
+	var indices IndexList
+	if err := v.Client.List(ctx, &indices, client.InNamespace(index.Namespace)); err != nil {
+		return apierrors.NewInternalError(fmt.Errorf("cannot list Indices: %s", err))
+	}
+
+	others := QuotaUsage{}
+	for i := range indices.Items {
+		other := &indices.Items[i]
+		if other.Name != index.Name && other.DeletionTimestamp.IsZero() {
+			others.Add(&other.Spec)
+		}
+	}
+
+	for _, quota := range quotas.Items {
+		usage := others
+		usage.StoreSize = quota.Status.Used.StoreSize
+		usage.Add(&index.Spec)
+		var before *QuotaUsage
+		if previous != nil {
+			b := others
+			b.StoreSize = quota.Status.Used.StoreSize
+			b.Add(&previous.Spec)
+			before = &b
+		}
+		if exceeded := quota.Spec.Exceeded(&usage, before); len(exceeded) > 0 {
+			return apierrors.NewForbidden(GroupVersion.WithResource("indices").GroupResource(), index.Name,
+				fmt.Errorf("exceeded IndexQuota %s: %s", quota.Name, strings.Join(exceeded, ", ")))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIndexValidator(t *testing.T) {
+	limit := func(n int32) *int32 { return &n }
+	index := func(name string, shards int, replicas int) *Index {
+		return &Index{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Spec:       IndexSpec{Application: name, NumberOfShards: shards, NumberOfReplicas: replicas},
+		}
+	}
+	quota := func(spec IndexQuotaSpec, storeSize string) *IndexQuota {
+		q := &IndexQuota{ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "test"}, Spec: spec}
+		if storeSize != "" {
+			q.Status.Used.StoreSize = resource.MustParse(storeSize)
+		}
+		return q
+	}
+	maxStore := resource.MustParse("10Gi")
+
+	tests := []struct {
+		name     string
+		objects  []client.Object
+		index    *Index
+		previous *Index
+		// message of the Forbidden error, allowed when empty
+		message string
+	}{
+		{
+			name:  "allows any Index without quota",
+			index: index("app", 50, 5),
+		},
+		{
+			name:    "rejects an Index over the number of Indices",
+			objects: []client.Object{quota(IndexQuotaSpec{MaxIndices: limit(1)}, ""), index("other", 1, 0)},
+			index:   index("app", 1, 0),
+			message: "exceeded IndexQuota tenant: maximum 1 Indices exceeded: 2",
+		},
+		{
+			name:    "counts the default shards of the Indices",
+			objects: []client.Object{quota(IndexQuotaSpec{MaxPrimaryShards: limit(6)}, ""), index("other", 0, 0)},
+			index:   index("app", 3, 0),
+			message: "exceeded IndexQuota tenant: maximum 6 primary shards exceeded: 7",
+		},
+		{
+			name:    "allows an Index within the quota",
+			objects: []client.Object{quota(IndexQuotaSpec{MaxIndices: limit(2), MaxPrimaryShards: limit(8), MaxReplicas: limit(1)}, ""), index("other", 4, 1)},
+			index:   index("app", 4, 1),
+		},
+		{
+			name:     "rejects the replicas of an update",
+			objects:  []client.Object{quota(IndexQuotaSpec{MaxReplicas: limit(1)}, ""), index("app", 1, 1)},
+			index:    index("app", 1, 2),
+			previous: index("app", 1, 1),
+			message:  "exceeded IndexQuota tenant: maximum 1 replicas exceeded: 2",
+		},
+		{
+			name:    "rejects a new Index when the store size is exceeded",
+			objects: []client.Object{quota(IndexQuotaSpec{MaxStoreSize: &maxStore}, "12Gi"), index("other", 1, 0)},
+			index:   index("app", 1, 0),
+			message: "exceeded IndexQuota tenant: maximum store size 10Gi exceeded: 12Gi",
+		},
+		{
+			name:     "allows updates reducing a namespace over quota",
+			objects:  []client.Object{quota(IndexQuotaSpec{MaxStoreSize: &maxStore, MaxReplicas: limit(1)}, "12Gi"), index("app", 1, 3)},
+			index:    index("app", 1, 2),
+			previous: index("app", 1, 3),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			scheme := runtime.NewScheme()
+			g.Expect(AddToScheme(scheme)).To(Succeed())
+			v := &IndexValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.objects...).Build()}
+
+			var err error
+			if tt.previous == nil {
+				err = v.ValidateCreate(context.Background(), tt.index)
+			} else {
+				err = v.ValidateUpdate(context.Background(), tt.previous, tt.index)
+			}
+			if tt.message == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			g.Expect(apierrors.IsForbidden(err)).To(BeTrue())
+			g.Expect(err.Error()).To(HaveSuffix(tt.message))
+		})
+	}
+}
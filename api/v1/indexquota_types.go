@@ -0,0 +1,147 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+
+	"com.ramos/es-provisioner/pkg/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IndexQuotaSpec defines the desired state of IndexQuota, the limits not set are unlimited
+type IndexQuotaSpec struct {
+
+	// Maximum number of Indices in the namespace
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxIndices *int32 `json:"maxIndices,omitempty"`
+
+	// Maximum number of primary shards of all the Indices in the namespace
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxPrimaryShards *int32 `json:"maxPrimaryShards,omitempty"`
+
+	// Maximum number of replicas of each Index
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxReplicas *int32 `json:"maxReplicas,omitempty"`
+
+	// Maximum store size of all the Indices including the replicas, for example 50Gi
+	// +optional
+	MaxStoreSize *resource.Quantity `json:"maxStoreSize,omitempty"`
+}
+
+// QuotaUsage is the usage of the Indices of a namespace
+type QuotaUsage struct {
+	Indices int32 `json:"indices"`
+
+	PrimaryShards int32 `json:"primaryShards"`
+
+	// Highest number of replicas of the Indices
+	Replicas int32 `json:"replicas"`
+
+	// Store size of the Indices including the replicas
+	// +optional
+	StoreSize resource.Quantity `json:"storeSize,omitempty"`
+}
+
+// IndexQuotaStatus defines the observed state of IndexQuota
+type IndexQuotaStatus struct {
+	// Usage of the namespace measured in Elasticsearch, the Indices not provisioned yet count with their spec
+	// +optional
+	Used QuotaUsage `json:"used,omitempty"`
+
+	// +optional
+	LastCheckedTime *metav1.Time `json:"lastCheckedTime,omitempty"`
+
+	// Conditions of the quota, see ConditionWithinQuota
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ConditionWithinQuota is false when the usage of a namespace exceeds a limit of its quota
+const ConditionWithinQuota = "WithinQuota"
+
+// Exceeded returns a message for each limit exceeded by the usage. With the previous usage, the limits that
+// were already exceeded are only reported when the usage increases, so a namespace over quota can reduce it.
+func (s *IndexQuotaSpec) Exceeded(usage *QuotaUsage, previous *QuotaUsage) []string {
+	messages := []string{}
+	check := func(max *int32, used int32, before func(*QuotaUsage) int32, format string) {
+		if max == nil || used <= *max {
+			return
+		}
+		if previous != nil && before(previous) > *max && used <= before(previous) {
+			return
+		}
+		messages = append(messages, fmt.Sprintf(format, *max, used))
+	}
+	check(s.MaxIndices, usage.Indices, func(u *QuotaUsage) int32 { return u.Indices },
+		"maximum %d Indices exceeded: %d")
+	check(s.MaxPrimaryShards, usage.PrimaryShards, func(u *QuotaUsage) int32 { return u.PrimaryShards },
+		"maximum %d primary shards exceeded: %d")
+	check(s.MaxReplicas, usage.Replicas, func(u *QuotaUsage) int32 { return u.Replicas },
+		"maximum %d replicas exceeded: %d")
+
+	if max := s.MaxStoreSize; max != nil && usage.StoreSize.Cmp(*max) > 0 &&
+		(previous == nil || previous.StoreSize.Cmp(*max) <= 0 || usage.StoreSize.Cmp(previous.StoreSize) > 0) {
+		messages = append(messages, fmt.Sprintf("maximum store size %s exceeded: %s", max.String(), usage.StoreSize.String()))
+	}
+	return messages
+}
+
+// Add counts the Index with the shards and replicas of its spec
+func (u *QuotaUsage) Add(spec *IndexSpec) {
+	shards := spec.NumberOfShards
+	if shards == 0 {
+		shards = model.DEFAULT_SHARDS
+	}
+	u.Indices++
+	u.PrimaryShards += int32(shards)
+	if int32(spec.NumberOfReplicas) > u.Replicas {
+		u.Replicas = int32(spec.NumberOfReplicas)
+	}
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// IndexQuota is the Schema for the indexquotas API, it limits the Indices of its namespace. The limits are
+// enforced by the Index admission webhook and before provisioning an Index, all the quotas of a namespace apply.
+type IndexQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IndexQuotaSpec   `json:"spec,omitempty"`
+	Status IndexQuotaStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IndexQuotaList contains a list of IndexQuota
+type IndexQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IndexQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IndexQuota{}, &IndexQuotaList{})
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IndexRestoreSpec defines the desired state of IndexRestore. The snapshot is either an IndexSnapshot or
+// a snapshot taken by a SnapshotSchedule, both in the namespace of the Index.
+type IndexRestoreSpec struct {
+
+	// Index of the namespace whose alias is moved to the restored index, it must be Ready
+	Index string `json:"index"`
+
+	// IndexSnapshot to restore, it must be Completed
+	// +optional
+	IndexSnapshot string `json:"indexSnapshot,omitempty"`
+
+	// SnapshotSchedule that took the snapshot to restore, with SnapshotName
+	// +optional
+	SnapshotSchedule string `json:"snapshotSchedule,omitempty"`
+
+	// Name of the snapshot taken by the SnapshotSchedule, see its status.lastSuccess
+	// +optional
+	SnapshotName string `json:"snapshotName,omitempty"`
+
+	// DeletePreviousIndex deletes the index replaced by the restored one, it's kept by default
+	// +optional
+	DeletePreviousIndex bool `json:"deletePreviousIndex,omitempty"`
+}
+
+// IndexRestorePhase is the progress of an IndexRestore, the restore runs once
+type IndexRestorePhase string
+
+const (
+	RestorePending IndexRestorePhase = "Pending"
+
+	Restoring IndexRestorePhase = "Restoring"
+
+	RestoreCompleted IndexRestorePhase = "Completed"
+
+	RestoreFailed IndexRestorePhase = "Failed"
+)
+
+// IndexRestoreStatus defines the observed state of IndexRestore
+type IndexRestoreStatus struct {
+	// +optional
+	Phase IndexRestorePhase `json:"phase,omitempty"`
+
+	// Repository of the snapshot restored
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Name of the snapshot restored
+	// +optional
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Index restored from the snapshot
+	// +optional
+	SourceIndex string `json:"sourceIndex,omitempty"`
+
+	// New index the snapshot is restored to, the alias is moved to it once it's recovered
+	// +optional
+	RestoredIndex string `json:"restoredIndex,omitempty"`
+
+	// Index the alias pointed to before the restore
+	// +optional
+	PreviousIndex string `json:"previousIndex,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Why the restore is pending or failed
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// IndexRestore is the Schema for the indexrestores API, it restores a snapshot of an Index into a new index
+// and moves the alias and the role of the Index to it. Changes to the spec after the restore started are ignored.
+type IndexRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IndexRestoreSpec   `json:"spec,omitempty"`
+	Status IndexRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IndexRestoreList contains a list of IndexRestore
+type IndexRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IndexRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IndexRestore{}, &IndexRestoreList{})
+}
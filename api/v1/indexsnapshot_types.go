@@ -0,0 +1,114 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IndexSnapshotSpec defines the desired state of IndexSnapshot
+type IndexSnapshotSpec struct {
+
+	// Index of the namespace whose backing index is snapshotted, it must be Ready
+	Index string `json:"index"`
+
+	// Snapshot repository, the operator SNAPSHOT_REPOSITORY when empty
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// DeletionPolicy Delete removes the snapshot from the repository with the resource, Retain keeps it
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +kubebuilder:default=Retain
+	// +optional
+	DeletionPolicy SnapshotDeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// SnapshotDeletionPolicy is what happens to the Elasticsearch snapshot when the IndexSnapshot is deleted
+type SnapshotDeletionPolicy string
+
+const (
+	SnapshotRetain SnapshotDeletionPolicy = "Retain"
+
+	SnapshotDelete SnapshotDeletionPolicy = "Delete"
+)
+
+// IndexSnapshotPhase is the progress of an IndexSnapshot, the snapshot is taken once
+type IndexSnapshotPhase string
+
+const (
+	SnapshotPending IndexSnapshotPhase = "Pending"
+
+	SnapshotInProgress IndexSnapshotPhase = "InProgress"
+
+	SnapshotCompleted IndexSnapshotPhase = "Completed"
+
+	SnapshotFailed IndexSnapshotPhase = "Failed"
+)
+
+// IndexSnapshotStatus defines the observed state of IndexSnapshot
+type IndexSnapshotStatus struct {
+	// +optional
+	Phase IndexSnapshotPhase `json:"phase,omitempty"`
+
+	// Repository the snapshot was taken in
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Name of the snapshot in the repository
+	// +optional
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Backing index of the Index when the snapshot was taken
+	// +optional
+	Index string `json:"index,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Why the snapshot is pending or failed
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// IndexSnapshot is the Schema for the indexsnapshots API, it takes a snapshot of the backing index of an Index
+// that can be restored with an IndexRestore. Changes to the spec after the snapshot started are ignored.
+type IndexSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IndexSnapshotSpec   `json:"spec,omitempty"`
+	Status IndexSnapshotStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IndexSnapshotList contains a list of IndexSnapshot
+type IndexSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IndexSnapshot `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IndexSnapshot{}, &IndexSnapshotList{})
+}
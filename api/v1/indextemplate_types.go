@@ -0,0 +1,85 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IndexTemplateSpec defines the desired state of IndexTemplate
+type IndexTemplateSpec struct {
+
+	// Index name patterns the template is applied to when the indices are created, wildcards are supported
+	// +kubebuilder:validation:MinItems=1
+	IndexPatterns []string `json:"indexPatterns"`
+
+	// Component templates the template is composed of, merged in order
+	// +optional
+	ComposedOf []string `json:"composedOf,omitempty"`
+
+	// Priority when several templates match an index, the highest one is applied
+	// +optional
+	Priority int64 `json:"priority,omitempty"`
+
+	// Settings, mappings and aliases of the template in JSON or YAML, they take precedence over the component templates
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// Version of the template for external management
+	// +optional
+	Version int64 `json:"version,omitempty"`
+}
+
+// IndexTemplateStatus defines the observed state of IndexTemplate
+type IndexTemplateStatus struct {
+	// Generation of the spec last applied to Elasticsearch
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions of the template, see ConditionSynced
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// IndexTemplate is the Schema for the indextemplates API, it's synced to the Elasticsearch
+// index template with the same name
+type IndexTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IndexTemplateSpec   `json:"spec,omitempty"`
+	Status IndexTemplateStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IndexTemplateList contains a list of IndexTemplate
+type IndexTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IndexTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IndexTemplate{}, &IndexTemplateList{})
+}
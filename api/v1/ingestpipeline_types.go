@@ -0,0 +1,106 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IngestPipelineSpec defines the desired state of IngestPipeline
+type IngestPipelineSpec struct {
+
+	// Description of the pipeline
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Processors of the pipeline in JSON or YAML, a list as in the processors of the Elasticsearch ingest pipeline API
+	Processors string `json:"processors"`
+
+	// Processors run when a processor fails, in JSON or YAML
+	// +optional
+	OnFailure string `json:"onFailure,omitempty"`
+
+	// Version of the pipeline for external management
+	// +optional
+	Version int64 `json:"version,omitempty"`
+
+	// Sample documents in JSON or YAML run through the pipeline before it's applied, the pipeline
+	// is not applied while one of them fails
+	// +optional
+	SampleDocuments []string `json:"sampleDocuments,omitempty"`
+}
+
+// PipelineSample is a sample document after running through the pipeline
+type PipelineSample struct {
+	// Document returned by the pipeline in JSON
+	// +optional
+	Document string `json:"document,omitempty"`
+
+	// Error of the processor that failed
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// IngestPipelineStatus defines the observed state of IngestPipeline
+type IngestPipelineStatus struct {
+	// Generation of the spec last applied to Elasticsearch
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Id of the pipeline in Elasticsearch
+	// +optional
+	PipelineID string `json:"pipelineId,omitempty"`
+
+	// Sample documents of the last simulation, in the order of spec.sampleDocuments
+	// +optional
+	Samples []PipelineSample `json:"samples,omitempty"`
+
+	// Conditions of the pipeline, see ConditionSynced and ConditionSimulated
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ConditionSimulated is true when all the sample documents of a pipeline were processed without errors
+const ConditionSimulated = "Simulated"
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// IngestPipeline is the Schema for the ingestpipelines API, it's synced to an Elasticsearch ingest pipeline
+// and used by the Indices of the namespace with spec.defaultPipeline and spec.finalPipeline
+type IngestPipeline struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IngestPipelineSpec   `json:"spec,omitempty"`
+	Status IngestPipelineStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IngestPipelineList contains a list of IngestPipeline
+type IngestPipelineList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IngestPipeline `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IngestPipeline{}, &IngestPipelineList{})
+}
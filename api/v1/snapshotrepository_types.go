@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	coreV1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SnapshotRepositoryType is the Elasticsearch repository plugin storing the snapshots
+// +kubebuilder:validation:Enum=fs;s3
+type SnapshotRepositoryType string
+
+const (
+	// FSRepository stores the snapshots in a shared file system listed in the path.repo setting of every node
+	FSRepository SnapshotRepositoryType = "fs"
+	// S3Repository stores the snapshots in an S3 bucket or an S3 compatible service like MinIO
+	S3Repository SnapshotRepositoryType = "s3"
+)
+
+// SnapshotRepositorySpec defines the desired state of SnapshotRepository
+type SnapshotRepositorySpec struct {
+
+	// Type of the repository, the settings are taken from fs or s3
+	Type SnapshotRepositoryType `json:"type"`
+
+	// Settings of the fs repositories
+	// +optional
+	FS *FSRepositorySettings `json:"fs,omitempty"`
+
+	// Settings of the s3 repositories
+	// +optional
+	S3 *S3RepositorySettings `json:"s3,omitempty"`
+
+	// Compress the metadata files of the snapshots
+	// +optional
+	Compress bool `json:"compress,omitempty"`
+
+	// Readonly registers the repository to restore snapshots written by another cluster
+	// +optional
+	Readonly bool `json:"readonly,omitempty"`
+}
+
+// FSRepositorySettings is the location of a shared file system repository
+type FSRepositorySettings struct {
+	// Location of the snapshots, absolute or relative to the path.repo setting of the nodes
+	Location string `json:"location"`
+}
+
+// S3RepositorySettings is the bucket of an S3 repository
+type S3RepositorySettings struct {
+	Bucket string `json:"bucket"`
+
+	// Path of the snapshots in the bucket
+	// +optional
+	BasePath string `json:"basePath,omitempty"`
+
+	// S3 client of the Elasticsearch keystore, default when empty
+	// +optional
+	Client string `json:"client,omitempty"`
+
+	// Endpoint of an S3 compatible service like MinIO, for example minio:9000
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// +kubebuilder:validation:Enum=http;https
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+
+	// PathStyleAccess is required by most S3 compatible services
+	// +optional
+	PathStyleAccess bool `json:"pathStyleAccess,omitempty"`
+
+	// Secret with the access_key and secret_key of the bucket, otherwise the credentials of the client are used.
+	// Elasticsearch only accepts them with -Des.allow_insecure_settings=true
+	// +optional
+	CredentialsSecret *coreV1.SecretReference `json:"credentialsSecret,omitempty"`
+}
+
+// SnapshotRepositoryStatus defines the observed state of SnapshotRepository
+type SnapshotRepositoryStatus struct {
+	// Generation of the spec last applied to Elasticsearch
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Resource version of the credentials Secret last applied to Elasticsearch
+	// +optional
+	CredentialsVersion string `json:"credentialsVersion,omitempty"`
+
+	// Nodes that verified the repository
+	// +optional
+	Nodes []string `json:"nodes,omitempty"`
+
+	// +optional
+	LastVerifiedTime *metav1.Time `json:"lastVerifiedTime,omitempty"`
+
+	// Conditions of the repository, see ConditionSynced and ConditionVerified
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ConditionVerified is true when all the nodes can read and write to a repository
+const ConditionVerified = "Verified"
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// SnapshotRepository is the Schema for the snapshotrepositories API, it's registered as the Elasticsearch
+// snapshot repository with the same name
+type SnapshotRepository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SnapshotRepositorySpec   `json:"spec,omitempty"`
+	Status SnapshotRepositoryStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SnapshotRepositoryList contains a list of SnapshotRepository
+type SnapshotRepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SnapshotRepository `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SnapshotRepository{}, &SnapshotRepositoryList{})
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SnapshotScheduleSpec defines the desired state of SnapshotSchedule
+type SnapshotScheduleSpec struct {
+
+	// Index of the namespace to take snapshots of, the snapshots follow the alias of the Index
+	Index string `json:"index"`
+
+	// Snapshot repository, the operator SNAPSHOT_REPOSITORY when empty
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Schedule in the Elasticsearch cron syntax, for example "0 30 1 * * ?"
+	Schedule string `json:"schedule"`
+
+	// Retention of the snapshots taken by the schedule, they're kept until deleted when not set
+	// +optional
+	Retention *SnapshotRetention `json:"retention,omitempty"`
+}
+
+// SnapshotRetention deletes the snapshots older than ExpireAfter keeping at least MinCount and at most MaxCount
+type SnapshotRetention struct {
+	// Time to keep the snapshots, for example 30d
+	// +optional
+	ExpireAfter string `json:"expireAfter,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinCount int32 `json:"minCount,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxCount int32 `json:"maxCount,omitempty"`
+}
+
+// SnapshotScheduleStatus defines the observed state of SnapshotSchedule
+type SnapshotScheduleStatus struct {
+	// Generation of the spec last applied to Elasticsearch
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Id of the snapshot lifecycle policy in Elasticsearch
+	// +optional
+	PolicyID string `json:"policyId,omitempty"`
+
+	// Repository the snapshots are taken in
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Name of the last snapshot taken successfully, it can be restored with an IndexRestore
+	// +optional
+	LastSuccess string `json:"lastSuccess,omitempty"`
+
+	// +optional
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+
+	// Name of the last snapshot that failed
+	// +optional
+	LastFailure string `json:"lastFailure,omitempty"`
+
+	// +optional
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+
+	// +optional
+	NextExecution *metav1.Time `json:"nextExecution,omitempty"`
+
+	// Conditions of the schedule, see ConditionSynced
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// SnapshotSchedule is the Schema for the snapshotschedules API, it's synced to an Elasticsearch snapshot
+// lifecycle (SLM) policy taking snapshots of an Index
+type SnapshotSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SnapshotScheduleSpec   `json:"spec,omitempty"`
+	Status SnapshotScheduleStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SnapshotScheduleList contains a list of SnapshotSchedule
+type SnapshotScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SnapshotSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SnapshotSchedule{}, &SnapshotScheduleList{})
+}
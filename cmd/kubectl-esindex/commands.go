@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// list prints the Indices with the documents and size of the index when Elasticsearch is reachable
+func list(ctx context.Context, ops *options, args []string) error {
+	var indices esv1.IndexList
+	listOps := []client.ListOption{}
+	if !ops.allNamespaces {
+		listOps = append(listOps, client.InNamespace(ops.namespace))
+	}
+	if err := ops.client.List(ctx, &indices, listOps...); err != nil {
+		return err
+	}
+
+	es, err := connect(ctx, ops)
+	if err != nil {
+		return err
+	}
+	if es != nil {
+		defer es.close()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tSTATUS\tALIAS\tINDEX\tDOCS\tSIZE\tDRIFTED\tAGE")
+	for _, index := range indices.Items {
+		docs, size := "-", "-"
+		if es != nil && index.Status.IndexStatus == esv1.Ready {
+			docs, size = stats(ctx, ops, es, &index)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			index.Namespace, index.Name, valueOr(string(index.Status.IndexStatus), "Pending"),
+			valueOr(index.Status.Elasticsearch.Alias, "-"), valueOr(index.Status.Elasticsearch.Index, "-"),
+			docs, size, conditionStatus(&index, esv1.ConditionDrifted),
+			duration.HumanDuration(time.Since(index.CreationTimestamp.Time)))
+	}
+	return w.Flush()
+}
+
+// stats returns the documents and store size, the size needs the monitor privilege
+func stats(ctx context.Context, ops *options, es *esClient, index *esv1.Index) (string, string) {
+	secret, err := indexSecret(ctx, ops, index)
+	if err != nil || secret == nil {
+		return "?", "?"
+	}
+	alias := string(secret.Data["index"])
+
+	docs, size := "?", "?"
+	var count struct {
+		Count int64 `json:"count"`
+	}
+	if err := es.get(secret, "/"+alias+"/_count", &count); err == nil {
+		docs = fmt.Sprint(count.Count)
+	}
+	var indexStats struct {
+		All struct {
+			Total struct {
+				Store struct {
+					SizeInBytes int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"total"`
+		} `json:"_all"`
+	}
+	if err := es.get(secret, "/"+alias+"/_stats/store", &indexStats); err == nil {
+		size = byteSize(indexStats.All.Total.Store.SizeInBytes)
+	}
+	return docs, size
+}
+
+// show prints the Index status and events and the effective settings and mappings of the index
+func show(ctx context.Context, ops *options, args []string) error {
+	name, err := indexArg(args)
+	if err != nil {
+		return err
+	}
+	// the settings and mappings need the Secret, the status is shown without it
+	index, secret, secretErr := getIndex(ctx, ops, name)
+	if index == nil {
+		return secretErr
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", index.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", index.Namespace)
+	fmt.Fprintf(w, "Status:\t%s\n", valueOr(string(index.Status.IndexStatus), "Pending"))
+	fmt.Fprintf(w, "Provisioning Step:\t%s\n", valueOr(index.Status.ProvisioningStep, "-"))
+	fmt.Fprintf(w, "Alias:\t%s\n", valueOr(index.Status.Elasticsearch.Alias, "-"))
+	fmt.Fprintf(w, "Index:\t%s\n", valueOr(index.Status.Elasticsearch.Index, "-"))
+	fmt.Fprintf(w, "Role:\t%s\n", valueOr(index.Status.Elasticsearch.Role, "-"))
+	fmt.Fprintf(w, "User:\t%s\n", valueOr(index.Status.Elasticsearch.User, "-"))
+	fmt.Fprintf(w, "Adopted:\t%t\n", index.Status.Elasticsearch.Adopted)
+	fmt.Fprintf(w, "Config Map:\t%s\n", valueOr(index.Spec.ConfigMap, "-"))
+	for _, key := range sortedKeys(index.Status.Operations) {
+		fmt.Fprintf(w, "Operation %s:\t%s\n", key, index.Status.Operations[key])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nConditions:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tMESSAGE")
+	for _, c := range index.Status.Conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", c.Type, c.Status, c.Reason, c.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var events coreV1.EventList
+	err = ops.client.List(ctx, &events, client.InNamespace(index.Namespace),
+		client.MatchingFields{"involvedObject.name": index.Name, "involvedObject.kind": "Index"})
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nEvents:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "  TYPE\tREASON\tAGE\tMESSAGE")
+	for _, e := range events.Items {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", e.Type, e.Reason,
+			duration.HumanDuration(time.Since(e.LastTimestamp.Time)), e.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if secret == nil {
+		return secretErr
+	}
+	es, err := connect(ctx, ops)
+	if err != nil || es == nil {
+		fmt.Println("\nUse --es-url or --es-service to show the settings and mappings.")
+		return err
+	}
+	defer es.close()
+
+	alias := string(secret.Data["index"])
+	for _, part := range []struct{ title, path string }{
+		{"Settings", "_settings?flat_settings=true"},
+		{"Mappings", "_mapping"},
+	} {
+		status, body, err := es.do(secret, "GET", "/"+alias+"/"+part.path, "")
+		if err != nil {
+			return err
+		}
+		if status > 299 {
+			fmt.Printf("\n%s: [%d] %s\n", part.title, status, body)
+			continue
+		}
+		fmt.Printf("\n%s:\n%s\n", part.title, pretty(body))
+	}
+	return nil
+}
+
+// connection prints the connection info from the Index Secret
+func connection(ctx context.Context, ops *options, args []string) error {
+	name, err := indexArg(args)
+	if err != nil {
+		return err
+	}
+	index, secret, err := getIndex(ctx, ops, name)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("the Secret %s doesn't exist yet, the Index is %s", esv1.SecretName,
+			valueOr(string(index.Status.IndexStatus), "Pending"))
+	}
+
+	password := "********"
+	if ops.showPassword {
+		password = string(secret.Data["password"])
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Secret:\t%s/%s\n", secret.Namespace, secret.Name)
+	fmt.Fprintf(w, "Index (alias):\t%s\n", secret.Data["index"])
+	fmt.Fprintf(w, "Concrete index:\t%s\n", secret.Data["_index"])
+	fmt.Fprintf(w, "Username:\t%s\n", secret.Data["username"])
+	fmt.Fprintf(w, "Password:\t%s\n", password)
+	fmt.Fprintf(w, "Role:\t%s\n", secret.Data["role"])
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nMount the Secret keys as environment variables, for example:\n\n"+
+		"  env:\n  - name: ES_PASSWORD\n    valueFrom:\n      secretKeyRef:\n        name: %s\n        key: password\n", secret.Name)
+	return nil
+}
+
+// annotate returns a command that requests the operation setting the annotation to the current time
+func annotate(annotation string) func(ctx context.Context, ops *options, args []string) error {
+	return func(ctx context.Context, ops *options, args []string) error {
+		return request(ctx, ops, args, map[string]string{annotation: time.Now().UTC().Format(time.RFC3339)})
+	}
+}
+
+// snapshot requests a snapshot, in the --repository when set
+func snapshot(ctx context.Context, ops *options, args []string) error {
+	annotations := map[string]string{esv1.SnapshotAnnotation: time.Now().UTC().Format(time.RFC3339)}
+	if ops.repository != "" {
+		annotations[esv1.SnapshotRepositoryAnnotation] = ops.repository
+	}
+	return request(ctx, ops, args, annotations)
+}
+
+func request(ctx context.Context, ops *options, args []string, annotations map[string]string) error {
+	name, err := indexArg(args)
+	if err != nil {
+		return err
+	}
+	index := &esv1.Index{}
+	if err := ops.client.Get(ctx, client.ObjectKey{Namespace: ops.namespace, Name: name}, index); err != nil {
+		return err
+	}
+	if index.Status.IndexStatus != esv1.Ready {
+		return fmt.Errorf("the Index is %s, operations run once it's Ready", valueOr(string(index.Status.IndexStatus), "Pending"))
+	}
+
+	patch := client.MergeFrom(index.DeepCopy())
+	if index.Annotations == nil {
+		index.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		index.Annotations[k] = v
+	}
+	if err := ops.client.Patch(ctx, index, patch); err != nil {
+		return err
+	}
+	fmt.Printf("Requested, follow the progress with: kubectl esindex show %s -n %s\n", index.Name, index.Namespace)
+	return nil
+}
+
+func conditionStatus(index *esv1.Index, conditionType string) string {
+	for _, c := range index.Status.Conditions {
+		if c.Type == conditionType {
+			return string(c.Status)
+		}
+	}
+	return "-"
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func byteSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
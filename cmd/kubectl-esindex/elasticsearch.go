@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	coreV1 "k8s.io/api/core/v1"
+)
+
+var forwardingFrom = regexp.MustCompile(`Forwarding from 127\.0\.0\.1:(\d+)`)
+
+// esClient sends requests to Elasticsearch with the credentials of an Index Secret
+type esClient struct {
+	url   string
+	http  *http.Client
+	close func()
+}
+
+// connect returns a client to the --es-url or the port forwarded --es-service, nil when none is set
+func connect(ctx context.Context, ops *options) (*esClient, error) {
+	c := &esClient{
+		url:   strings.TrimSuffix(ops.esUrl, "/"),
+		close: func() {},
+		http: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: ops.insecure},
+			},
+		},
+	}
+
+	if ops.esService != "" {
+		port, stop, err := portForward(ctx, ops)
+		if err != nil {
+			return nil, err
+		}
+		c.url = ops.esScheme + "://127.0.0.1:" + port
+		c.close = stop
+	}
+
+	if c.url == "" {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// portForward runs kubectl port-forward to the Elasticsearch service and returns the local port
+func portForward(ctx context.Context, ops *options) (string, func(), error) {
+	namespace, service, found := strings.Cut(ops.esService, "/")
+	if !found {
+		return "", nil, fmt.Errorf("invalid --es-service %q, expected namespace/name:port", ops.esService)
+	}
+	name, port, found := strings.Cut(service, ":")
+	if !found {
+		port = "9200"
+	}
+
+	args := []string{"port-forward", "-n", namespace, "svc/" + name, ":" + port}
+	if ops.kubeconfig != "" {
+		args = append(args, "--kubeconfig", ops.kubeconfig)
+	}
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", nil, fmt.Errorf("cannot run kubectl port-forward: %s", err)
+	}
+	stop := func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if match := forwardingFrom.FindStringSubmatch(scanner.Text()); match != nil {
+			// keep reading so kubectl doesn't block writing to the pipe
+			go func() { _, _ = io.Copy(io.Discard, stdout) }()
+			return match[1], stop, nil
+		}
+	}
+	stop()
+	return "", nil, fmt.Errorf("cannot port forward %s: %s", ops.esService, strings.TrimSpace(stderr.String()))
+}
+
+// do sends the request with the Index credentials and returns the status and the response body
+func (c *esClient) do(secret *coreV1.Secret, method string, path string, body string) (int, []byte, error) {
+	req, err := http.NewRequest(method, c.url+path, strings.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(string(secret.Data["username"]), string(secret.Data["password"]))
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	return res.StatusCode, b, err
+}
+
+// get sends a GET request and decodes the JSON response into out
+func (c *esClient) get(secret *coreV1.Secret, path string, out interface{}) error {
+	status, body, err := c.do(secret, http.MethodGet, path, "")
+	if err != nil {
+		return err
+	}
+	if status > 299 {
+		return fmt.Errorf("GET %s: [%d] %s", path, status, body)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// pretty indents the JSON body, other bodies are returned as they are
+func pretty(body []byte) string {
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return string(body)
+	}
+	return out.String()
+}
@@ -0,0 +1,226 @@
+// kubectl-esindex is a kubectl plugin to inspect and operate the Indices managed by the operator.
+// Install it in the PATH and run it as kubectl esindex <command>.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	coreV1 "k8s.io/api/core/v1"
+	k8Runtime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `Inspect and operate the Indices managed by the es-provisioner operator.
+
+Usage:
+  kubectl esindex <command> [flags] [index]
+
+Commands:
+  list             List the Indices with their status and live stats
+  show             Show the status, events and effective settings and mappings of an Index
+  connection       Print the connection info from the Index Secret
+  rotate-password  Request a new password for the Index user
+  reindex          Request a migration of the data to a new index
+  snapshot         Request a snapshot of the index
+  shell            Open a query shell with the Index credentials
+
+Elasticsearch is reached with --es-url or port forwarding the --es-service, for example:
+  kubectl esindex shell products -n shop --es-service elastic/elasticsearch-master:9200
+
+Use "kubectl esindex <command> -h" for the flags of a command.
+`
+
+var scheme = k8Runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(esv1.AddToScheme(scheme))
+}
+
+// options common to all the commands
+type options struct {
+	kubeconfig    string
+	namespace     string
+	allNamespaces bool
+	esUrl         string
+	esService     string
+	esScheme      string
+	insecure      bool
+	showPassword  bool
+	repository    string
+	client        client.Client
+}
+
+type command struct {
+	run func(ctx context.Context, ops *options, args []string) error
+	// flags registers the flags of the command
+	flags func(flags *flag.FlagSet, ops *options)
+}
+
+var commands = map[string]command{
+	"list": {list, func(flags *flag.FlagSet, ops *options) {
+		flags.BoolVar(&ops.allNamespaces, "A", false, "List the Indices in all namespaces.")
+	}},
+	"show": {show, nil},
+	"connection": {connection, func(flags *flag.FlagSet, ops *options) {
+		flags.BoolVar(&ops.showPassword, "show-password", false, "Print the password instead of masking it.")
+	}},
+	"rotate-password": {annotate(esv1.RotatePasswordAnnotation), nil},
+	"reindex":         {annotate(esv1.ReindexAnnotation), nil},
+	"snapshot": {snapshot, func(flags *flag.FlagSet, ops *options) {
+		flags.StringVar(&ops.repository, "repository", "", "Snapshot repository, the operator default when empty.")
+	}},
+	"shell": {shell, nil},
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Print(usage)
+		return
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(1)
+	}
+
+	if err := execute(os.Args[1], &run, os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func execute(name string, cmd *command, args []string) error {
+	ops := &options{}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&ops.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	flags.StringVar(&ops.namespace, "n", "", "Namespace of the Index, the current namespace by default.")
+	flags.StringVar(&ops.namespace, "namespace", "", "Namespace of the Index, the current namespace by default.")
+	flags.StringVar(&ops.esUrl, "es-url", os.Getenv("ES_URL"), "Elasticsearch URL reachable from this machine.")
+	flags.StringVar(&ops.esService, "es-service", os.Getenv("ES_SERVICE"),
+		"Elasticsearch service to port forward, as namespace/name:port.")
+	flags.StringVar(&ops.esScheme, "es-scheme", "https", "Scheme of the port forwarded Elasticsearch service.")
+	flags.BoolVar(&ops.insecure, "insecure-skip-tls-verify", false,
+		"Don't verify the Elasticsearch certificate, needed when port forwarding a service with TLS.")
+
+	if cmd.flags != nil {
+		cmd.flags(flags, ops)
+	}
+	if err := flags.Parse(interspersed(flags, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = ops.kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+	if ops.namespace == "" {
+		namespace, _, err := config.Namespace()
+		if err != nil {
+			return err
+		}
+		ops.namespace = namespace
+	}
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return err
+	}
+	ops.client, err = client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	return cmd.run(context.Background(), ops, flags.Args())
+}
+
+// interspersed moves the flags after the positional arguments to the front, the flag package stops at the first argument
+func interspersed(flags *flag.FlagSet, args []string) []string {
+	positional := []string{}
+	named := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		named = append(named, arg)
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if f := flags.Lookup(name); f != nil && !strings.Contains(arg, "=") && !isBool(f) && i+1 < len(args) {
+			i++
+			named = append(named, args[i])
+		}
+	}
+	return append(named, positional...)
+}
+
+func isBool(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// indexArg returns the Index name argument
+func indexArg(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected the Index name")
+	}
+	return args[0], nil
+}
+
+// getIndex returns the Index and its Secret, the Secret is nil while the Index is provisioned. The Secret
+// is shared by the Indices of the namespace, an error is returned when it belongs to another Index.
+func getIndex(ctx context.Context, ops *options, name string) (*esv1.Index, *coreV1.Secret, error) {
+	var index esv1.Index
+	if err := ops.client.Get(ctx, client.ObjectKey{Namespace: ops.namespace, Name: name}, &index); err != nil {
+		return nil, nil, err
+	}
+
+	secret, err := indexSecret(ctx, ops, &index)
+	return &index, secret, err
+}
+
+// indexSecret returns the Secret of the namespace when it was written for the Index, nil when it
+// doesn't exist or the Index didn't create it yet
+func indexSecret(ctx context.Context, ops *options, index *esv1.Index) (*coreV1.Secret, error) {
+	var secret coreV1.Secret
+	err := ops.client.Get(ctx, client.ObjectKey{Namespace: index.Namespace, Name: esv1.SecretName}, &secret)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+
+	resources := index.Status.Elasticsearch
+	if resources.Index == "" && resources.Alias == "" && index.Status.IndexStatus != esv1.Ready {
+		return nil, nil
+	}
+	if owner, ok := secret.Annotations[esv1.SecretOwnerAnnotation]; ok && owner != index.Name {
+		return nil, fmt.Errorf("the Secret %s belongs to Index %s, not %s: the Indices of a namespace share the Secret",
+			esv1.SecretName, owner, index.Name)
+	}
+	// the Indices provisioned before the status recorded the resources are only checked by the owner
+	if (resources.Index != "" || resources.Alias != "") &&
+		string(secret.Data["_index"]) != resources.Index && string(secret.Data["index"]) != resources.Alias {
+		return nil, fmt.Errorf("the Secret %s is for index %s, not %s: the Indices of a namespace share the Secret",
+			esv1.SecretName, secret.Data["index"], valueOr(resources.Alias, resources.Index))
+	}
+	return &secret, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
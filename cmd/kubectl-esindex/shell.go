@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const shellHelp = `Send requests as METHOD PATH [BODY], relative paths are sent to the index:
+
+  GET _search {"query": {"match": {"name": "shoes"}}}
+  GET _doc/1
+  POST _doc {"name": "boots"}
+  GET /_security/_authenticate
+
+Type exit to quit.
+`
+
+// shell reads requests from stdin and sends them to Elasticsearch with the Index credentials,
+// so only the operations allowed to the application can be run
+func shell(ctx context.Context, ops *options, args []string) error {
+	name, err := indexArg(args)
+	if err != nil {
+		return err
+	}
+	_, secret, err := getIndex(ctx, ops, name)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("the Index is not provisioned yet")
+	}
+
+	es, err := connect(ctx, ops)
+	if err != nil {
+		return err
+	}
+	if es == nil {
+		return fmt.Errorf("set --es-url or --es-service to reach Elasticsearch")
+	}
+	defer es.close()
+
+	alias := string(secret.Data["index"])
+	fmt.Printf("Connected to %s as %s\n%s\n", es.url, secret.Data["username"], shellHelp)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for {
+		fmt.Print(alias + "> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "exit", "quit":
+			return nil
+		case "help":
+			fmt.Print(shellHelp)
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) < 2 {
+			fmt.Println("expected METHOD PATH [BODY], type help for examples")
+			continue
+		}
+		method, path, body := strings.ToUpper(parts[0]), parts[1], ""
+		if len(parts) == 3 {
+			body = parts[2]
+		}
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + alias + "/" + path
+		}
+
+		status, response, err := es.do(secret, method, path, body)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		fmt.Printf("[%d]\n%s\n", status, pretty(response))
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const componentTemplateFinalizer = "componenttemplate.es-provisioner.com.ramos/finalizer"
+
+// ComponentTemplateReconciler syncs a ComponentTemplate to the Elasticsearch component template with the same name
+type ComponentTemplateReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	EsService es.EsService
+	Recorder  record.EventRecorder
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=componenttemplates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=componenttemplates/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=componenttemplates/finalizers,verbs=update
+
+// Reconcile puts the component template when the spec changes and deletes it with the resource.
+// Terminal errors are reported in the Synced condition until the spec changes, transient ones are retried.
+func (r *ComponentTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var template esv1.ComponentTemplate
+	if err := r.Get(ctx, req.NamespacedName, &template); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !template.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&template, componentTemplateFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.EsService.DeleteComponentTemplate(template.Name); err != nil {
+			log.Error(err, "unable to delete component template")
+			r.Recorder.Event(&template, coreV1.EventTypeWarning, reasonDeletionFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&template, coreV1.EventTypeNormal, reasonTemplateDeleted, "Component template %s deleted", template.Name)
+		controllerutil.RemoveFinalizer(&template, componentTemplateFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &template)
+	}
+
+	if !controllerutil.ContainsFinalizer(&template, componentTemplateFinalizer) {
+		controllerutil.AddFinalizer(&template, componentTemplateFinalizer)
+		if err := r.Update(ctx, &template); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if template.Status.ObservedGeneration == template.Generation {
+		return ctrl.Result{}, nil
+	}
+
+	body, err := templateJSON(template.Spec.Template)
+	if err == nil {
+		err = r.EsService.PutComponentTemplate(&es.EsComponentTemplateOptions{
+			Name:     template.Name,
+			Template: body,
+			Version:  template.Spec.Version,
+		})
+	}
+	if err != nil {
+		log.Error(err, "unable to sync component template")
+		r.Recorder.Event(&template, coreV1.EventTypeWarning, reasonSyncFailed, err.Error())
+		if es.IsTransient(err) {
+			if setSyncedCondition(&template.Status.Conditions, template.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error()) {
+				r.updateStatus(ctx, &template)
+			}
+			return ctrl.Result{}, err
+		}
+		// retried when the spec changes
+		template.Status.ObservedGeneration = template.Generation
+		setSyncedCondition(&template.Status.Conditions, template.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error())
+		r.updateStatus(ctx, &template)
+		return ctrl.Result{}, nil
+	}
+
+	template.Status.ObservedGeneration = template.Generation
+	setSyncedCondition(&template.Status.Conditions, template.Generation, v1.ConditionTrue, reasonSynced, "Component template applied to Elasticsearch")
+	r.updateStatus(ctx, &template)
+	r.Recorder.Eventf(&template, coreV1.EventTypeNormal, reasonSynced, "Component template %s applied", template.Name)
+	return ctrl.Result{}, nil
+}
+
+func (r *ComponentTemplateReconciler) updateStatus(ctx context.Context, template *esv1.ComponentTemplate) {
+	if err := r.Status().Update(ctx, template); err != nil {
+		log.FromContext(ctx).Error(err, "Error updating status")
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ComponentTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&esv1.ComponentTemplate{}).
+		WithOptions(r.Controller.options()).
+		Complete(r)
+}
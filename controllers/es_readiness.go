@@ -0,0 +1,92 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// EsReadiness periodically checks the operator can reach and authenticate to an Elasticsearch cluster, the
+// readiness probe reports the last result so it doesn't wait for Elasticsearch
+type EsReadiness struct {
+	EsService es.EsService
+	// Cluster labels the connectivity metric
+	Cluster  string
+	Interval time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// Start checks the cluster every Interval until the context is cancelled
+func (r *EsReadiness) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.check(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection is false, every replica reports its own readiness
+func (r *EsReadiness) NeedLeaderElection() bool {
+	return false
+}
+
+func (r *EsReadiness) check(ctx context.Context) {
+	err := r.EsService.Ping()
+	if err != nil {
+		log.FromContext(ctx).WithName("es-readiness").Error(err, "unable to reach Elasticsearch", "cluster", r.Cluster)
+		metrics.EsConnected.WithLabelValues(r.Cluster).Set(0)
+	} else {
+		metrics.EsConnected.WithLabelValues(r.Cluster).Set(1)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checked = time.Now()
+	r.err = err
+}
+
+// Check is the readiness check, it fails until the cluster was reached and when the last check is stale
+func (r *EsReadiness) Check(_ *http.Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.checked.IsZero():
+		return fmt.Errorf("Elasticsearch cluster %s not checked yet", r.Cluster)
+	case r.err != nil:
+		return fmt.Errorf("Elasticsearch cluster %s unreachable: %s", r.Cluster, r.err)
+	case time.Since(r.checked) > 3*r.Interval:
+		return fmt.Errorf("Elasticsearch cluster %s last checked %s ago", r.Cluster, time.Since(r.checked).Round(time.Second))
+	}
+	return nil
+}
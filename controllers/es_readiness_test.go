@@ -0,0 +1,88 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEsReadiness(t *testing.T) {
+	tests := []struct {
+		name string
+		fail error
+		// checkedAgo is the age of the last check, not checked when zero
+		checkedAgo time.Duration
+		message    string
+		connected  float64
+	}{
+		{
+			name:       "is ready when the cluster was reached",
+			checkedAgo: time.Second,
+			connected:  1,
+		},
+		{
+			name:    "isn't ready before the first check",
+			message: "Elasticsearch cluster es:9200 not checked yet",
+		},
+		{
+			name:       "isn't ready when the cluster can't be reached",
+			fail:       &es.EsError{Action: "authenticate", Status: http.StatusUnauthorized, Reason: "unable to authenticate user"},
+			checkedAgo: time.Second,
+			message:    "Elasticsearch cluster es:9200 unreachable: ",
+		},
+		{
+			name:       "isn't ready when the last check is stale",
+			checkedAgo: time.Minute,
+			message:    "Elasticsearch cluster es:9200 last checked 1m0s ago",
+			connected:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			service := esfake.NewService()
+			service.Fail("Ping", tt.fail)
+			r := &EsReadiness{EsService: service, Cluster: "es:9200", Interval: 10 * time.Second}
+			if tt.checkedAgo > 0 {
+				r.check(context.Background())
+				g.Expect(service.Methods()).To(Equal([]string{"Ping"}))
+				g.Expect(testutil.ToFloat64(metrics.EsConnected.WithLabelValues("es:9200"))).To(Equal(tt.connected))
+				r.checked = time.Now().Add(-tt.checkedAgo)
+			}
+
+			err := r.Check(nil)
+			if tt.message == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(err.Error()).To(HavePrefix(tt.message))
+		})
+	}
+}
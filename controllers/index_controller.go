@@ -18,39 +18,98 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	esv1 "com.ramos/es-provisioner/api/v1"
 	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	"com.ramos/es-provisioner/pkg/metrics"
+	"com.ramos/es-provisioner/pkg/naming"
 	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
-	indexOwnerKey = ".metadata.controller"
-	finalizerName = "index.es-provisioner.com.ramos/finalizer"
-	secretName    = "es-provisioner-index-secret"
-	configMapKey  = "mapping.json"
+	indexOwnerKey           = ".metadata.controller"
+	configMapField          = ".spec.configMap"
+	synonymsConfigMapField  = ".spec.synonyms.configMap"
+	componentTemplatesField = ".spec.componentTemplates"
+	pipelinesField          = ".spec.pipelines"
+	finalizerName           = "index.es-provisioner.com.ramos/finalizer"
+	secretName              = esv1.SecretName
+
+	retryBaseDelay = 5 * time.Second
+	retryMaxDelay  = 10 * time.Minute
+
+	// migrationWait is how often the reindex task of a migration is checked
+	migrationWait = 10 * time.Second
+)
+
+// Event reasons, the steps completed in Elasticsearch use the es.Step names
+const (
+	reasonProvisioned        = "Provisioned"
+	reasonProvisioningFailed = "ProvisioningFailed"
+	reasonSecretCreated      = "SecretCreated"
+	reasonSecretUpdated      = "SecretUpdated"
+	reasonSecretDeleted      = "SecretDeleted"
+	reasonUpdateFailed       = "UpdateFailed"
+	reasonSynonymsUpdated    = "SynonymsUpdated"
+	reasonSynonymsFailed     = "SynonymsUpdateFailed"
+	reasonDeletionFailed     = "DeletionFailed"
+	reasonDriftRepaired      = "DriftRepaired"
+	reasonDriftDetected      = "DriftDetected"
+	reasonDriftCheckFailed   = "DriftCheckFailed"
+	reasonInSync             = "InSync"
+	reasonProvisioning       = "Provisioning"
+	reasonRetrying           = "Retrying"
+	reasonFailed             = "Failed"
+	reasonRolledBack         = "RolledBack"
+	reasonRollbackFailed     = "RollbackFailed"
 )
 
 // IndexReconciler reconciles a Index object
 type IndexReconciler struct {
 	client.Client
-	Scheme    *runtime.Scheme
-	EsService *es.EsService
-	K8sClient *kubernetes.Clientset
+	Scheme      *runtime.Scheme
+	EsService   es.EsService
+	Recorder    record.EventRecorder
+	ClusterName string
+	// SnapshotRepository is used by the snapshot annotation when the Index doesn't set the repository
+	SnapshotRepository string
+	// ResyncPeriod is how often a Ready index is checked for drift against Elasticsearch, 0 disables it
+	ResyncPeriod time.Duration
+	// Naming names the aliases of the new Indices, the default names when nil
+	Naming *naming.Policy
+	// HealthGate defers the provisioning while the cluster is unhealthy or over capacity, not checked when nil
+	HealthGate *HealthGate
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
 }
 
 //+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indices,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indices/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indices/finalizers,verbs=update
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=componenttemplates,verbs=get;list;watch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=ingestpipelines,verbs=get;list;watch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexquotas,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -76,81 +135,556 @@ func (r *IndexReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	err := r.setFinalizer(ctx, &index)
 	if err != nil {
 		log.Error(err, "Error Setting Finalizer")
-		r.updateStatus(&index, ctx, esv1.Error)
 		return ctrl.Result{}, err
 	}
 
-	if index.Status.IndexStatus == "" { // if no status then we know it has just being created
+	if !index.ObjectMeta.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if index.Status.IndexStatus == esv1.Error && index.Generation != index.Status.ObservedGeneration {
+		log.V(1).Info("Spec changed, retrying provisioning")
+		index.Status.IndexStatus = ""
+	}
+
+	if isDryRun(&index) {
+		switch index.Status.IndexStatus {
+		case "", esv1.DryRun, esv1.Ready: // spec changes are validated instead of applied
+			return r.dryRun(ctx, &index)
+		}
+	} else if index.Status.IndexStatus == esv1.DryRun {
+		log.V(1).Info("Dry run disabled, provisioning")
+		index.Status.IndexStatus = ""
+	}
+
+	switch index.Status.IndexStatus {
+	case "", esv1.Creating, esv1.Created: // provisioning not completed yet
 		return r.provisionIndex(index, ctx, req)
 	}
 
+	if index.Status.IndexStatus == esv1.Ready {
+		if index.Status.Migration != nil {
+			// the other changes are applied to the new index once the migration completes
+			migrating, err := r.pollMigration(ctx, &index)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if migrating {
+				return ctrl.Result{RequeueAfter: migrationWait}, nil
+			}
+		}
+		err = r.syncSynonyms(ctx, &index)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		err = r.syncIndex(ctx, &index)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		err = r.runOperations(ctx, &index)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if index.Status.Migration != nil {
+			return ctrl.Result{RequeueAfter: migrationWait}, nil
+		}
+		if r.ResyncPeriod == 0 {
+			return ctrl.Result{}, nil
+		}
+		err = r.checkDrift(ctx, &index)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+	}
+
 	return ctrl.Result{}, nil
 }
 
+// provisionIndex runs the provisioning steps persisting the progress in the status after each one, so it resumes
+// from the last step completed. Transient errors are retried with exponential backoff, on terminal errors the
+// resources already created are rolled back and the status set to Error until the spec changes.
 func (r *IndexReconciler) provisionIndex(index esv1.Index, ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	r.updateStatus(&index, ctx, esv1.Creating)
 	log := log.FromContext(ctx)
 
-	ns, err := r.K8sClient.CoreV1().Namespaces().Get(ctx, req.Namespace, v1.GetOptions{})
+	if next := index.Status.NextRetry; next != nil && time.Until(next.Time) > 0 {
+		log.V(1).Info("Waiting to retry provisioning", "nextRetry", next)
+		return ctrl.Result{RequeueAfter: time.Until(next.Time)}, nil
+	}
+	if index.Status.ProvisioningStep == "" {
+		exceeded, err := r.checkQuotas(ctx, &index)
+		if err != nil {
+			log.Error(err, "unable to check IndexQuotas")
+			return ctrl.Result{}, err
+		}
+		if exceeded != "" {
+			log.V(1).Info("Waiting for quota", "reason", exceeded)
+			if r.setCondition(&index, esv1.ConditionProvisioned, v1.ConditionFalse, reasonQuotaExceeded, exceeded) {
+				r.Recorder.Event(&index, coreV1.EventTypeWarning, reasonQuotaExceeded, exceeded)
+				r.updateStatus(&index, ctx, index.Status.IndexStatus)
+			}
+			return ctrl.Result{RequeueAfter: quotaWait}, nil
+		}
+
+		waiting, err := r.checkClusterHealth(ctx, &index)
+		if err != nil {
+			log.Error(err, "unable to check the cluster health")
+			return ctrl.Result{}, err
+		}
+		if waiting != "" {
+			log.V(1).Info("Waiting for the cluster", "reason", waiting)
+			if r.setCondition(&index, esv1.ConditionWaiting, v1.ConditionTrue, reasonClusterUnavailable, waiting) {
+				r.Recorder.Event(&index, coreV1.EventTypeWarning, reasonClusterUnavailable, waiting)
+				r.updateStatus(&index, ctx, index.Status.IndexStatus)
+			}
+			return ctrl.Result{RequeueAfter: healthWait}, nil
+		}
+		if meta.IsStatusConditionTrue(index.Status.Conditions, esv1.ConditionWaiting) {
+			r.setCondition(&index, esv1.ConditionWaiting, v1.ConditionFalse, reasonClusterAvailable, "The cluster can provision the index")
+		}
+	}
+	if index.Status.IndexStatus == "" {
+		r.setCondition(&index, esv1.ConditionProvisioned, v1.ConditionFalse, reasonProvisioning, "Provisioning started")
+		r.updateStatus(&index, ctx, esv1.Creating)
+	}
+
+	state := &es.EsProvisionState{
+		Step:         index.Status.ProvisioningStep,
+		Index:        index.Status.Elasticsearch.Index,
+		Alias:        index.Status.Elasticsearch.Alias,
+		Role:         index.Status.Elasticsearch.Role,
+		User:         index.Status.Elasticsearch.User,
+		Adopted:      index.Status.Elasticsearch.Adopted,
+		AliasAdopted: index.Status.Elasticsearch.AliasAdopted,
+		Created:      index.Status.Elasticsearch.Created,
+		Owner:        indexspec.Owner(&index, r.ClusterName),
+	}
+
+	var ns coreV1.Namespace
+	err := r.Get(ctx, client.ObjectKey{Name: req.Namespace}, &ns)
 	if err != nil {
 		log.Error(err, "unable to get Namespace")
-		r.updateStatus(&index, ctx, esv1.Error)
-		return ctrl.Result{}, err
+		return r.provisioningFailed(ctx, &index, state, err)
 	}
 	log.V(1).Info("Retrieved Namespace", "namespace", &ns)
 
-	spec, err := r.getConfigMap(ctx, &index, req.Namespace)
+	spec, configMapVersion, err := r.getConfigMap(ctx, &index, req.Namespace)
 	if err != nil {
-		r.updateStatus(&index, ctx, esv1.Error)
-		return ctrl.Result{}, err
+		return r.provisioningFailed(ctx, &index, state, err)
 	}
 
-	ops := es.EsSetupOptions{
-		Shards:          index.Spec.NumberOfShards,
-		RefreshInterval: index.Spec.RefreshInterval,
-		Replicas:        index.Spec.NumberOfReplicas,
-		IndexName:       index.Spec.Name,
-		App:             index.Spec.Application,
-		Namespace:       ns.Name,
-		Spec:            spec,
-		Analyzers:       index.Spec.Analyzers,
-		Properties:      index.Spec.Properties,
-		Source:          index.Spec.SourceEnabled,
+	synonyms, synonymVersions, err := r.getSynonyms(ctx, &index)
+	if err != nil {
+		return r.provisioningFailed(ctx, &index, state, err)
 	}
 
-	log.V(1).Info("Provisioning Tenant in ElasticSearch", "options", &ns)
-
-	esResult, err := (*r.EsService).InitializeIndex(&ops)
+	templateVersions, err := r.getComponentTemplates(ctx, &index)
 	if err != nil {
-		r.updateStatus(&index, ctx, esv1.Error)
-		log.Error(err, "unable setup Index")
-		return ctrl.Result{}, err
+		return r.provisioningFailed(ctx, &index, state, err)
 	}
 
-	r.updateStatus(&index, ctx, esv1.Created)
+	if err := r.checkPipelines(ctx, &index); err != nil {
+		return r.provisioningFailed(ctx, &index, state, err)
+	}
 
-	log.V(1).Info("Tenant Provisioned. Creating Secret...", "result", esResult)
+	ops := indexspec.SetupOptions(&index, ns.Name, spec, synonyms)
+	ops.Owner = state.Owner
+	ops.OnStep = r.stepRecorder(&index)
+	if state.Step == "" {
+		ops.IndexName, err = r.indexName(ctx, &index, &ns)
+		if err != nil {
+			return r.provisioningFailed(ctx, &index, state, err)
+		}
+	}
 
-	err = r.createSecret(ctx, esResult, req)
-	if err != nil {
-		r.updateStatus(&index, ctx, esv1.Error)
-		log.Error(err, "Error Creating Secret")
-		return ctrl.Result{}, err
+	if state.Step == es.StepUserCreated {
+		// the password is only kept in the secret, the user is created again if it's gone
+		secret, err := r.getSecret(ctx, req.Namespace)
+		if err != nil {
+			log.V(1).Info("Secret not found, creating the user again", "error", err.Error())
+			state.Step = es.StepRoleCreated
+		} else {
+			state.Password = string(secret.Data["password"])
+		}
 	}
-	log.V(1).Info("Secret Created, Provisoned Completed.")
+
+	log.V(1).Info("Provisioning Tenant in ElasticSearch", "step", state.Step)
+
+	for state.Step != es.StepCredentialsVerified {
+		err = r.EsService.ProvisionStep(&ops, state)
+		if err == nil && state.Step == es.StepUserCreated {
+			err = r.createSecret(ctx, &es.EsResult{
+				UserName: state.User,
+				Password: state.Password,
+				Role:     state.Role,
+				Index:    state.Index,
+				Alias:    state.Alias,
+			}, req)
+			if err != nil {
+				log.Error(err, "Error Creating Secret")
+				state.Step = es.StepRoleCreated
+			} else {
+				r.Recorder.Eventf(&index, coreV1.EventTypeNormal, reasonSecretCreated, "Secret %s created", secretName)
+			}
+		}
+		if err != nil {
+			return r.provisioningFailed(ctx, &index, state, err)
+		}
+
+		status := esv1.Creating
+		if state.Step == es.StepUserCreated {
+			status = esv1.Created
+		}
+		r.saveProvisioningState(&index, state)
+		index.Status.Attempts = 0
+		index.Status.NextRetry = nil
+		r.updateStatus(&index, ctx, status)
+	}
+
+	log.V(1).Info("Provisoned Completed.")
+	metrics.Provisions.WithLabelValues(metrics.OutcomeSuccess).Inc()
+	index.Status.Synonyms = synonymVersions
+	index.Status.ComponentTemplates = templateVersions
+	index.Status.ConfigMapVersion = configMapVersion
+	index.Status.ObservedGeneration = index.Generation
+	r.setCondition(&index, esv1.ConditionProvisioned, v1.ConditionTrue, reasonProvisioned, "All provisioning steps completed")
 	r.updateStatus(&index, ctx, esv1.Ready)
+	r.Recorder.Eventf(&index, coreV1.EventTypeNormal, reasonProvisioned, "Index %s is ready", state.Alias)
 	return reconcile.Result{}, nil
 }
 
+// provisioningFailed schedules a retry for transient errors, otherwise rolls back the provisioning
+func (r *IndexReconciler) provisioningFailed(ctx context.Context, index *esv1.Index, state *es.EsProvisionState, err error) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	log.Error(err, "unable to provision Index", "step", state.Step)
+	metrics.Provisions.WithLabelValues(metrics.OutcomeError).Inc()
+	r.recordError(index, reasonProvisioningFailed, err)
+	r.saveProvisioningState(index, state)
+
+	if es.IsTransient(err) {
+		index.Status.Attempts++
+		delay := retryDelay(index.Status.Attempts)
+		next := v1.NewTime(time.Now().Add(delay))
+		index.Status.NextRetry = &next
+		r.setCondition(index, esv1.ConditionProvisioned, v1.ConditionFalse, reasonRetrying,
+			fmt.Sprintf("Attempt %d failed, retrying in %s: %s", index.Status.Attempts, delay, err))
+		r.updateStatus(index, ctx, index.Status.IndexStatus)
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	log.Info("Rolling back provisioning", "step", state.Step)
+	message := err.Error()
+	rollbackErr := r.EsService.RollbackIndex(state)
+	if rollbackErr == nil && state.Step == es.StepUserCreated {
+		rollbackErr = client.IgnoreNotFound(r.Delete(ctx, &coreV1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: secretName, Namespace: index.Namespace},
+		}))
+	}
+	if rollbackErr != nil {
+		log.Error(rollbackErr, "unable to roll back provisioning")
+		r.recordError(index, reasonRollbackFailed, rollbackErr)
+		message = fmt.Sprintf("%s, rollback failed: %s", message, rollbackErr)
+	} else {
+		r.Recorder.Event(index, coreV1.EventTypeNormal, reasonRolledBack, "Resources created in Elasticsearch deleted")
+		index.Status.ProvisioningStep = ""
+		index.Status.Elasticsearch = esv1.ElasticsearchResources{}
+	}
+
+	// the provisioning is retried when the spec changes
+	index.Status.Attempts = 0
+	index.Status.NextRetry = nil
+	index.Status.ObservedGeneration = index.Generation
+	r.setCondition(index, esv1.ConditionProvisioned, v1.ConditionFalse, reasonFailed, message)
+	r.updateStatus(index, ctx, esv1.Error)
+	return ctrl.Result{}, nil
+}
+
+func (r *IndexReconciler) saveProvisioningState(index *esv1.Index, state *es.EsProvisionState) {
+	index.Status.ProvisioningStep = state.Step
+	index.Status.Elasticsearch = esv1.ElasticsearchResources{
+		Index:        state.Index,
+		Alias:        state.Alias,
+		Role:         state.Role,
+		User:         state.User,
+		Adopted:      state.Adopted,
+		AliasAdopted: state.AliasAdopted,
+		Created:      state.Created,
+	}
+}
+
+// retryDelay is the exponential backoff for the given number of failed attempts
+func retryDelay(attempts int32) time.Duration {
+	delay := retryBaseDelay
+	for i := int32(1); i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
+}
+
+// syncIndex applies spec and Config Map changes to a provisioned index, migrating
+// the data to a new index when the change cannot be applied in place
+func (r *IndexReconciler) syncIndex(ctx context.Context, index *esv1.Index) error {
+	log := log.FromContext(ctx)
+
+	spec, configMapVersion, err := r.getConfigMap(ctx, index, index.Namespace)
+	if err != nil {
+		r.recordError(index, reasonUpdateFailed, err)
+		return err
+	}
+	templateVersions, err := r.getComponentTemplates(ctx, index)
+	if err != nil {
+		r.recordError(index, reasonUpdateFailed, err)
+		return err
+	}
+	if index.Generation == index.Status.ObservedGeneration && configMapVersion == index.Status.ConfigMapVersion &&
+		sameVersions(templateVersions, index.Status.ComponentTemplates) {
+		return nil
+	}
+	if index.Status.ObservedGeneration == 0 {
+		// provisioned before the versions applied were recorded, the index is up to date with the current ones
+		log.V(1).Info("Recording the versions applied", "generation", index.Generation, "configMapVersion", configMapVersion)
+		index.Status.ComponentTemplates = templateVersions
+		index.Status.ConfigMapVersion = configMapVersion
+		index.Status.ObservedGeneration = index.Generation
+		r.updateStatus(index, ctx, esv1.Ready)
+		return nil
+	}
+
+	synonyms, synonymVersions, err := r.getSynonyms(ctx, index)
+	if err != nil {
+		r.recordError(index, reasonUpdateFailed, err)
+		return err
+	}
+	if err := r.checkPipelines(ctx, index); err != nil {
+		r.recordError(index, reasonUpdateFailed, err)
+		return err
+	}
+
+	log.V(1).Info("Updating index", "generation", index.Generation, "configMapVersion", configMapVersion,
+		"componentTemplates", templateVersions)
+	err = r.updateIndex(ctx, index, spec, synonyms, false)
+	if err != nil {
+		r.recordError(index, reasonUpdateFailed, err)
+		return err
+	}
+
+	setApplied(index, configMapVersion, synonymVersions, templateVersions)
+	r.updateStatus(index, ctx, esv1.Ready)
+	return nil
+}
+
+// setApplied records the versions applied to the index. The versions applied to the new index of a migration in
+// progress are recorded in the migration, until it completes.
+func setApplied(index *esv1.Index, configMapVersion string, synonymVersions map[string]string, templateVersions map[string]string) {
+	if m := index.Status.Migration; m != nil {
+		m.ObservedGeneration = index.Generation
+		m.ConfigMapVersion = configMapVersion
+		m.Synonyms = synonymVersions
+		m.ComponentTemplates = templateVersions
+		return
+	}
+	index.Status.ObservedGeneration = index.Generation
+	index.Status.ConfigMapVersion = configMapVersion
+	index.Status.Synonyms = synonymVersions
+	index.Status.ComponentTemplates = templateVersions
+}
+
+// updateIndex applies the payload to the index, the status and the Secret written by the Index are updated when the
+// index is migrated
+func (r *IndexReconciler) updateIndex(ctx context.Context, index *esv1.Index, spec string, synonyms []es.EsSynonymSet, reindex bool) error {
+	log := log.FromContext(ctx)
+
+	resources, secret, owned, err := r.indexResources(ctx, index)
+	if err != nil {
+		log.Error(err, "unable to get Secret", "secret", secretName)
+		return err
+	}
+	if resources.Index == "" {
+		return fmt.Errorf("cannot update Index, its resources aren't recorded in the status or Secret %s", secretName)
+	}
+
+	ops := es.EsUpdateOptions{
+		EsSetupOptions: indexspec.SetupOptions(index, index.Namespace, spec, synonyms),
+		Index:          resources.Index,
+		Alias:          resources.Alias,
+		Role:           resources.Role,
+		Reindex:        reindex,
+		Adopted:        resources.Adopted,
+	}
+	if m := index.Status.Migration; m != nil {
+		ops.Migration = &es.EsMigration{Index: m.Index, Task: m.Task}
+	}
+	ops.Owner = indexspec.Owner(index, r.ClusterName)
+	ops.OnStep = r.stepRecorder(index)
+	esResult, err := r.EsService.UpdateIndex(&ops)
+	if err != nil {
+		log.Error(err, "unable to update Index")
+	}
+	if esResult == nil {
+		return err
+	}
+
+	migrating := index.Status.Migration != nil
+	if m := esResult.Migration; m == nil {
+		index.Status.Migration = nil
+	} else if !migrating || index.Status.Migration.Task != m.Task {
+		log.V(1).Info("Index migrating", "index", m.Index, "task", m.Task)
+		index.Status.Migration = &esv1.IndexMigration{Index: m.Index, Task: m.Task}
+	}
+
+	// a failed migration may have moved the alias already
+	if esResult.Index != ops.Index {
+		log.V(1).Info("Index migrated", "index", esResult.Index)
+		index.Status.Elasticsearch = resources
+		index.Status.Elasticsearch.Index = esResult.Index
+		if owned {
+			secret.Data["_index"] = []byte(esResult.Index)
+			if updateErr := r.Update(ctx, secret); updateErr != nil {
+				log.Error(updateErr, "Error Updating Secret")
+				return updateErr
+			}
+			r.Recorder.Eventf(index, coreV1.EventTypeNormal, reasonSecretUpdated, "Secret %s updated with Index %s", secretName, esResult.Index)
+		}
+	}
+	if err != nil && (esResult.Index != ops.Index || migrating != (index.Status.Migration != nil)) {
+		r.updateStatus(index, ctx, index.Status.IndexStatus)
+	}
+	return err
+}
+
+// pollMigration completes the migration in progress once its reindex task is done, returning true while it runs.
+// The versions migrated are recorded as applied, the changes made since are applied next. A failed migration is
+// rolled back and started again by the next update.
+func (r *IndexReconciler) pollMigration(ctx context.Context, index *esv1.Index) (bool, error) {
+	migration := index.Status.Migration
+	// the payload is only used to start a migration
+	err := r.updateIndex(ctx, index, "", nil, false)
+	if err != nil {
+		r.recordError(index, reasonUpdateFailed, err)
+		return false, err
+	}
+	if index.Status.Migration != nil {
+		return true, nil
+	}
+	index.Status.ObservedGeneration = migration.ObservedGeneration
+	index.Status.ConfigMapVersion = migration.ConfigMapVersion
+	index.Status.Synonyms = migration.Synonyms
+	index.Status.ComponentTemplates = migration.ComponentTemplates
+	r.updateStatus(index, ctx, esv1.Ready)
+	return false, nil
+}
+
+// checkDrift verifies Elasticsearch still matches the Index, repairing what it can and
+// setting the Drifted condition with the differences that need manual intervention
+func (r *IndexReconciler) checkDrift(ctx context.Context, index *esv1.Index) error {
+	log := log.FromContext(ctx)
+
+	spec, _, err := r.getConfigMap(ctx, index, index.Namespace)
+	if err != nil {
+		r.recordError(index, reasonDriftCheckFailed, err)
+		return err
+	}
+	synonyms, _, err := r.getSynonyms(ctx, index)
+	if err != nil {
+		r.recordError(index, reasonDriftCheckFailed, err)
+		return err
+	}
+
+	resources, secret, owned, err := r.indexResources(ctx, index)
+	if err != nil {
+		log.Error(err, "unable to get Secret", "secret", secretName)
+		r.recordError(index, reasonDriftCheckFailed, err)
+		return err
+	}
+	if resources.Index == "" {
+		log.V(1).Info("Index resources unknown, skipping drift check")
+		return nil
+	}
+
+	ops := es.EsCheckOptions{
+		EsSetupOptions: indexspec.SetupOptions(index, index.Namespace, spec, synonyms),
+		Index:          resources.Index,
+		Alias:          resources.Alias,
+		Role:           resources.Role,
+		User:           resources.User,
+		Adopted:        resources.Adopted,
+	}
+	// the password is only known from the Secret, a new one is set when it was deleted
+	switch {
+	case owned:
+		ops.Password = string(secret.Data["password"])
+	case secret == nil:
+		ops.ResetPassword = true
+	}
+	ops.Owner = indexspec.Owner(index, r.ClusterName)
+	report, err := r.EsService.CheckIndex(&ops)
+	if err != nil {
+		log.Error(err, "unable to check Index")
+		r.recordError(index, reasonDriftCheckFailed, err)
+		return err
+	}
+
+	if report.Password != "" {
+		err = r.createSecret(ctx, &es.EsResult{UserName: resources.User, Password: report.Password, Role: resources.Role,
+			Index: resources.Index, Alias: resources.Alias}, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(index)})
+		if err != nil {
+			log.Error(err, "unable to create Secret", "secret", secretName)
+			r.recordError(index, reasonDriftCheckFailed, err)
+			return err
+		}
+		r.Recorder.Eventf(index, coreV1.EventTypeNormal, reasonSecretCreated, "Secret %s created", secretName)
+	}
+	if secret != nil && !owned {
+		report.Drifted = append(report.Drifted, fmt.Sprintf("secret %s was written for another Index", secretName))
+	}
+
+	if len(report.Repaired) > 0 {
+		log.Info("Drift repaired", "changes", report.Repaired)
+		r.Recorder.Eventf(index, coreV1.EventTypeNormal, reasonDriftRepaired, "Repaired: %s", strings.Join(report.Repaired, "; "))
+	}
+
+	status, reason, message := v1.ConditionFalse, reasonInSync, "Elasticsearch matches the Index"
+	if len(report.Drifted) > 0 {
+		status, reason, message = v1.ConditionTrue, reasonDriftDetected, strings.Join(report.Drifted, "; ")
+		log.Info("Drift detected", "changes", report.Drifted)
+		r.Recorder.Event(index, coreV1.EventTypeWarning, reasonDriftDetected, message)
+	}
+
+	if r.setCondition(index, esv1.ConditionDrifted, status, reason, message) {
+		r.updateStatus(index, ctx, index.Status.IndexStatus)
+	}
+	return nil
+}
+
+// setCondition sets the condition in the status and returns true when it changed
+func (r *IndexReconciler) setCondition(index *esv1.Index, conditionType string, status v1.ConditionStatus, reason string, message string) bool {
+	current := meta.FindStatusCondition(index.Status.Conditions, conditionType)
+	if current != nil && current.Status == status && current.Reason == reason && current.Message == message &&
+		current.ObservedGeneration == index.Generation {
+		return false
+	}
+	meta.SetStatusCondition(&index.Status.Conditions, v1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: index.Generation,
+	})
+	return true
+}
+
 func (r *IndexReconciler) createSecret(ctx context.Context, esResult *es.EsResult, req ctrl.Request) error {
 
 	// delete exiting
-	_ = r.K8sClient.CoreV1().Secrets(req.Namespace).Delete(ctx, secretName, v1.DeleteOptions{})
+	_ = r.Delete(ctx, &coreV1.Secret{ObjectMeta: v1.ObjectMeta{Name: secretName, Namespace: req.Namespace}})
 
 	secretMeta := v1.ObjectMeta{
 		Name:        secretName,
 		Namespace:   req.Namespace,
-		Annotations: map[string]string{"owner": "es-provisioner"},
+		Annotations: map[string]string{"owner": "es-provisioner", esv1.SecretOwnerAnnotation: req.Name},
 	}
 
 	secretData := map[string][]byte{}
@@ -164,9 +698,19 @@ func (r *IndexReconciler) createSecret(ctx context.Context, esResult *es.EsResul
 		ObjectMeta: secretMeta,
 		Data:       secretData,
 	}
-	_, err := r.K8sClient.CoreV1().Secrets(req.Namespace).Create(ctx, &secret, v1.CreateOptions{})
+	return r.Create(ctx, &secret)
+}
+
+// stepRecorder emits a Normal event for every step completed in Elasticsearch
+func (r *IndexReconciler) stepRecorder(index *esv1.Index) es.EsStepFunc {
+	return func(step string, message string) {
+		r.Recorder.Event(index, coreV1.EventTypeNormal, step, message)
+	}
+}
 
-	return err
+// recordError emits a Warning event with the error, Elasticsearch errors include the reason
+func (r *IndexReconciler) recordError(index *esv1.Index, reason string, err error) {
+	r.Recorder.Event(index, coreV1.EventTypeWarning, reason, err.Error())
 }
 
 func (r *IndexReconciler) updateStatus(index *esv1.Index, ctx context.Context, status esv1.IndexStatusEnum) {
@@ -178,24 +722,158 @@ func (r *IndexReconciler) updateStatus(index *esv1.Index, ctx context.Context, s
 	}
 }
 
-func (r *IndexReconciler) getConfigMap(ctx context.Context, index *esv1.Index, namespace string) (string, error) {
+// getConfigMap returns the index payload rendered from the Config Map templates and the resource version
+// of the Config Map it was read from
+func (r *IndexReconciler) getConfigMap(ctx context.Context, index *esv1.Index, namespace string) (string, string, error) {
+	log := log.FromContext(ctx)
+	spec, version, err := indexspec.Payload(index, r.ClusterName, r.configMapGetter(ctx, namespace))
+	if err != nil {
+		log.Error(err, "unable to get ConfigMap", "cm name", index.Spec.ConfigMap)
+		return "", "", err
+	}
+	return spec, version, nil
+}
+
+// getSynonyms reads the synonym sets from their Config Maps, returning the resource version read for each set
+func (r *IndexReconciler) getSynonyms(ctx context.Context, index *esv1.Index) ([]es.EsSynonymSet, map[string]string, error) {
 	log := log.FromContext(ctx)
-	var spec string
-	if index.Spec.ConfigMap != "" {
-		cm, err := r.K8sClient.CoreV1().ConfigMaps(namespace).Get(ctx, index.Spec.ConfigMap, v1.GetOptions{})
+	sets, versions, err := indexspec.Synonyms(index, r.configMapGetter(ctx, index.Namespace))
+	if err != nil {
+		log.Error(err, "unable to get synonyms ConfigMap")
+		return nil, nil, err
+	}
+	return sets, versions, nil
+}
+
+// getComponentTemplates returns the generation of the ComponentTemplate resources the Index is composed of,
+// it fails while one of them isn't synced to Elasticsearch. Templates without a resource are managed outside
+// the operator and have an empty version.
+func (r *IndexReconciler) getComponentTemplates(ctx context.Context, index *esv1.Index) (map[string]string, error) {
+	versions := map[string]string{}
+	for _, name := range index.Spec.ComponentTemplates {
+		var template esv1.ComponentTemplate
+		err := r.Get(ctx, client.ObjectKey{Name: name}, &template)
+		if errors.IsNotFound(err) {
+			versions[name] = ""
+			continue
+		}
 		if err != nil {
-			log.Error(err, "unable to get ConfigMap", "cm name", index.Spec.ConfigMap)
-			return "", err
+			return nil, err
+		}
+		if !componentTemplateSynced(&template) {
+			return nil, fmt.Errorf("ComponentTemplate %s is not synced to Elasticsearch yet", name)
+		}
+		versions[name] = strconv.FormatInt(template.Generation, 10)
+	}
+	return versions, nil
+}
+
+// checkPipelines fails while the default or final IngestPipeline of the Index doesn't exist
+// or isn't synced to Elasticsearch
+func (r *IndexReconciler) checkPipelines(ctx context.Context, index *esv1.Index) error {
+	for _, name := range []string{index.Spec.DefaultPipeline, index.Spec.FinalPipeline} {
+		if name == "" || name == es.NoPipeline {
+			continue
 		}
-		cmData := cm.Data[configMapKey]
-		if cmData == "" {
-			log.Error(err, "unable to get ConfigMap. Missing Key", "key", configMapKey)
-			return "", err
+		var pipeline esv1.IngestPipeline
+		err := r.Get(ctx, client.ObjectKey{Namespace: index.Namespace, Name: name}, &pipeline)
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("IngestPipeline %s not found", name)
+		}
+		if err != nil {
+			return err
+		}
+		condition := meta.FindStatusCondition(pipeline.Status.Conditions, esv1.ConditionSynced)
+		if condition == nil || condition.Status != v1.ConditionTrue || condition.ObservedGeneration != pipeline.Generation {
+			return fmt.Errorf("IngestPipeline %s is not synced to Elasticsearch yet", name)
+		}
+	}
+	return nil
+}
+
+// sameVersions compares the versions applied, nil and empty are the same
+func sameVersions(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, version := range a {
+		if applied, ok := b[key]; !ok || applied != version {
+			return false
 		}
-		spec = cmData
+	}
+	return true
+}
+
+// getSecret returns the Index Secret of the namespace
+func (r *IndexReconciler) getSecret(ctx context.Context, namespace string) (*coreV1.Secret, error) {
+	var secret coreV1.Secret
+	err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: secretName}, &secret)
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func (r *IndexReconciler) configMapGetter(ctx context.Context, namespace string) indexspec.ConfigMapGetter {
+	return func(name string) (*coreV1.ConfigMap, error) {
+		var cm coreV1.ConfigMap
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &cm)
+		return &cm, err
+	}
+}
+
+// syncSynonyms updates the synonym sets whose Config Map changed since they were applied
+func (r *IndexReconciler) syncSynonyms(ctx context.Context, index *esv1.Index) error {
+	log := log.FromContext(ctx)
+	if len(index.Spec.Synonyms) == 0 {
+		return nil
+	}
+
+	sets, versions, err := r.getSynonyms(ctx, index)
+	if err != nil {
+		r.recordError(index, reasonSynonymsFailed, err)
+		return err
+	}
+	changed := []es.EsSynonymSet{}
+	for _, set := range sets {
+		if index.Status.Synonyms[set.Name] != versions[set.Name] {
+			changed = append(changed, set)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
 	}
 
-	return spec, nil
+	resources, _, _, err := r.indexResources(ctx, index)
+	if err == nil && resources.Index == "" {
+		err = fmt.Errorf("cannot update synonyms, the resources of the Index aren't recorded in the status or Secret %s", secretName)
+	}
+	if err != nil {
+		log.Error(err, "unable to get Secret", "secret", secretName)
+		r.recordError(index, reasonSynonymsFailed, err)
+		return err
+	}
+
+	log.V(1).Info("Updating synonyms", "sets", len(changed))
+	err = r.EsService.UpdateSynonyms(&es.EsSynonymOptions{
+		Index:    resources.Index,
+		Alias:    resources.Alias,
+		Synonyms: changed,
+	})
+	if err != nil {
+		log.Error(err, "unable to update synonyms")
+		r.recordError(index, reasonSynonymsFailed, err)
+		return err
+	}
+	names := []string{}
+	for _, set := range changed {
+		names = append(names, set.Name)
+	}
+	r.Recorder.Eventf(index, coreV1.EventTypeNormal, reasonSynonymsUpdated, "Synonym sets updated: %s", strings.Join(names, ", "))
+
+	index.Status.Synonyms = versions
+	r.updateStatus(index, ctx, esv1.Ready)
+	return nil
 }
 
 func (r *IndexReconciler) setFinalizer(ctx context.Context, index *esv1.Index) error {
@@ -215,8 +893,13 @@ func (r *IndexReconciler) setFinalizer(ctx context.Context, index *esv1.Index) e
 		if controllerutil.ContainsFinalizer(index, finalizerName) {
 			// our finalizer is present, so lets handle any external dependency
 			err := r.deleteIndex(ctx, index)
+			metrics.Deletions.WithLabelValues(metrics.Outcome(err)).Inc()
 
-			if !strings.Contains(err.Error(), "not found") {
+			if err != nil {
+				r.recordError(index, reasonDeletionFailed, err)
+				if r.setCondition(index, esv1.ConditionCleanupBlocked, v1.ConditionTrue, reasonDeletionFailed, err.Error()) {
+					r.updateStatus(index, ctx, index.Status.IndexStatus)
+				}
 				return err
 			}
 			// remove our finalizer from the list and update it.
@@ -231,40 +914,212 @@ func (r *IndexReconciler) setFinalizer(ctx context.Context, index *esv1.Index) e
 	return nil
 }
 
+// deleteIndex removes the resources recorded in the status, so the deletion doesn't depend on the Secret
+// the applications can change. The Secret is deleted when the Index wrote it.
 func (r *IndexReconciler) deleteIndex(ctx context.Context, index *esv1.Index) error {
 	log := log.FromContext(ctx)
 
-	secret, err := r.K8sClient.CoreV1().Secrets(index.Namespace).Get(ctx, secretName, v1.GetOptions{})
+	resources, secret, owned, err := r.indexResources(ctx, index)
 	if err != nil {
 		return err
 	}
-	indx := string(secret.Data["index"])
-	log.V(1).Info("Deleting index..", "index", indx)
-	ops := &es.EsRemoveOptions{
-		Index: string(secret.Data["_index"]),
-		Alias: indx,
-		Role:  string(secret.Data["role"]),
-		User:  string(secret.Data["username"]),
+
+	if resources.Index != "" || resources.Alias != "" || resources.Role != "" || resources.User != "" {
+		log.V(1).Info("Deleting index..", "index", resources.Alias)
+		ops := &es.EsRemoveOptions{
+			Index: resources.Index,
+			Alias: resources.Alias,
+			Role:  resources.Role,
+			User:  resources.User,
+			Owner: indexspec.Owner(index, r.ClusterName),
+		}
+		if m := index.Status.Migration; m != nil {
+			ops.Migration = &es.EsMigration{Index: m.Index, Task: m.Task}
+		}
+		ops.OnStep = r.stepRecorder(index)
+		if err := r.EsService.RemoveIndex(ops); err != nil {
+			return err
+		}
 	}
 
-	err = (*r.EsService).RemoveIndex(ops)
+	if owned {
+		log.V(1).Info("Index removed, deleting secret", "secret", secretName)
+		err := r.Delete(ctx, secret)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err == nil {
+			r.Recorder.Eventf(index, coreV1.EventTypeNormal, reasonSecretDeleted, "Secret %s deleted", secretName)
+		}
+	}
+	log.V(1).Info("Clean up completed")
+	return nil
+}
+
+// indexResources returns the Elasticsearch resources recorded in the status, the Secret of the namespace, nil when
+// it's missing, and whether the Index wrote it. The Secret is shared by the Indices of the namespace, it's only used
+// for the Ready Indices provisioned before the status recorded the resources.
+func (r *IndexReconciler) indexResources(ctx context.Context, index *esv1.Index) (esv1.ElasticsearchResources, *coreV1.Secret, bool, error) {
+	resources := index.Status.Elasticsearch
+	secret, err := r.getSecret(ctx, index.Namespace)
+	if errors.IsNotFound(err) {
+		return resources, nil, false, nil
+	}
 	if err != nil {
-		return err
+		return resources, nil, false, err
+	}
+	owned, err := r.secretOwnedBy(ctx, secret, index)
+	if err != nil {
+		return resources, nil, false, err
+	}
+
+	if resources.Index == "" && resources.Alias == "" && resources.Role == "" && resources.User == "" &&
+		index.Status.IndexStatus == esv1.Ready && owned {
+		resources = esv1.ElasticsearchResources{
+			Index: string(secret.Data["_index"]),
+			Alias: string(secret.Data["index"]),
+			Role:  string(secret.Data["role"]),
+			User:  string(secret.Data["username"]),
+		}
+	}
+	return resources, secret, owned, nil
+}
+
+// secretOwnedBy is true when the Index wrote the Secret of the namespace. The Secrets written before the owner
+// was recorded belong to the Index whose index they name, or to the only Index of the namespace.
+func (r *IndexReconciler) secretOwnedBy(ctx context.Context, secret *coreV1.Secret, index *esv1.Index) (bool, error) {
+	if owner, ok := secret.Annotations[esv1.SecretOwnerAnnotation]; ok {
+		return owner == index.Name, nil
+	}
+	if index.Status.Elasticsearch.Index != "" {
+		return string(secret.Data["_index"]) == index.Status.Elasticsearch.Index, nil
+	}
+	if len(secret.Data["_index"]) == 0 {
+		return false, nil
+	}
+
+	var indices esv1.IndexList
+	if err := r.List(ctx, &indices, client.InNamespace(index.Namespace)); err != nil {
+		return false, err
+	}
+	for _, other := range indices.Items {
+		if other.Name != index.Name {
+			return false, nil
+		}
 	}
+	return true, nil
+}
 
-	log.V(1).Info("Index removed, deleting secret", "secret", secretName)
+// findIndicesForComponentTemplate maps a ComponentTemplate to the Indices composed of it in all the namespaces
+func (r *IndexReconciler) findIndicesForComponentTemplate(template client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+	var indices esv1.IndexList
+	err := r.List(context.Background(), &indices, client.MatchingFields{componentTemplatesField: template.GetName()})
+	if err != nil {
+		return requests
+	}
+	for _, item := range indices.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+	}
+	return requests
+}
 
-	err = r.K8sClient.CoreV1().Secrets(index.Namespace).Delete(ctx, secretName, v1.DeleteOptions{})
+// findIndicesForIngestPipeline maps an IngestPipeline to the Indices of the namespace using it
+func (r *IndexReconciler) findIndicesForIngestPipeline(pipeline client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+	var indices esv1.IndexList
+	err := r.List(context.Background(), &indices,
+		client.InNamespace(pipeline.GetNamespace()),
+		client.MatchingFields{pipelinesField: pipeline.GetName()})
 	if err != nil {
-		return err
+		return requests
 	}
-	log.V(1).Info("Clean up completed")
-	return nil
+	for _, item := range indices.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+	}
+	return requests
+}
+
+// findIndicesForConfigMap maps a Config Map to the Indices using it for their payload or synonyms
+func (r *IndexReconciler) findIndicesForConfigMap(cm client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+	seen := map[client.ObjectKey]bool{}
+
+	for _, field := range []string{configMapField, synonymsConfigMapField} {
+		var indices esv1.IndexList
+		err := r.List(context.Background(), &indices,
+			client.InNamespace(cm.GetNamespace()),
+			client.MatchingFields{field: cm.GetName()})
+		if err != nil {
+			continue
+		}
+		for _, item := range indices.Items {
+			key := client.ObjectKeyFromObject(&item)
+			if !seen[key] {
+				seen[key] = true
+				requests = append(requests, reconcile.Request{NamespacedName: key})
+			}
+		}
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *IndexReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &esv1.Index{}, synonymsConfigMapField,
+		func(o client.Object) []string {
+			index := o.(*esv1.Index)
+			names := []string{}
+			for _, s := range index.Spec.Synonyms {
+				names = append(names, s.ConfigMap)
+			}
+			return names
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &esv1.Index{}, configMapField,
+		func(o client.Object) []string {
+			index := o.(*esv1.Index)
+			if index.Spec.ConfigMap == "" {
+				return nil
+			}
+			return []string{index.Spec.ConfigMap}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &esv1.Index{}, componentTemplatesField,
+		func(o client.Object) []string {
+			return o.(*esv1.Index).Spec.ComponentTemplates
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &esv1.Index{}, pipelinesField,
+		func(o client.Object) []string {
+			index := o.(*esv1.Index)
+			names := []string{}
+			for _, name := range []string{index.Spec.DefaultPipeline, index.Spec.FinalPipeline} {
+				if name != "" && name != es.NoPipeline {
+					names = append(names, name)
+				}
+			}
+			return names
+		})
+	if err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&esv1.Index{}).
+		WithOptions(r.Controller.options()).
+		Watches(&source.Kind{Type: &coreV1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.findIndicesForConfigMap)).
+		Watches(&source.Kind{Type: &esv1.ComponentTemplate{}}, handler.EnqueueRequestsFromMapFunc(r.findIndicesForComponentTemplate)).
+		Watches(&source.Kind{Type: &esv1.IngestPipeline{}}, handler.EnqueueRequestsFromMapFunc(r.findIndicesForIngestPipeline)).
+		Watches(&source.Kind{Type: &esv1.IndexQuota{}}, handler.EnqueueRequestsFromMapFunc(r.findIndicesForIndexQuota)).
 		Complete(r)
 }
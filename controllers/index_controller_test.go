@@ -0,0 +1,308 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	timeout  = 30 * time.Second
+	interval = 250 * time.Millisecond
+)
+
+var _ = Describe("Index controller", func() {
+	ctx := context.Background()
+	var namespace string
+
+	BeforeEach(func() {
+		esServer.ClearFaults()
+		namespace = "test-" + rand.String(6)
+		Expect(k8sClient.Create(ctx, &coreV1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(Succeed())
+	})
+
+	It("creates the index, alias, role, user and Secret", func() {
+		index := createIndex(ctx, namespace)
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Ready))
+
+		resources := index.Status.Elasticsearch
+		Expect(resources.Alias).To(Equal("es-provisioner-test-" + namespace))
+		Expect(esStatus(http.MethodGet, "/"+resources.Index)).To(Equal(http.StatusOK))
+		Expect(esStatus(http.MethodGet, "/_alias/"+resources.Alias)).To(Equal(http.StatusOK))
+		Expect(esStatus(http.MethodGet, "/_security/role/"+resources.Role)).To(Equal(http.StatusOK))
+		Expect(esStatus(http.MethodGet, "/_security/user/"+resources.User)).To(Equal(http.StatusOK))
+
+		secret := getSecret(ctx, namespace)
+		Expect(string(secret.Data["index"])).To(Equal(resources.Alias))
+		Expect(string(secret.Data["_index"])).To(Equal(resources.Index))
+		Expect(string(secret.Data["username"])).To(Equal(resources.User))
+		Expect(secret.Data["password"]).NotTo(BeEmpty())
+
+		condition := meta.FindStatusCondition(index.Status.Conditions, esv1.ConditionProvisioned)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+	})
+
+	It("applies changes in place and migrates the index when needed", func() {
+		index := createIndex(ctx, namespace)
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Ready))
+		created := index.Status.Elasticsearch.Index
+
+		By("updating a dynamic setting")
+		updateIndex(ctx, index, func(spec *esv1.IndexSpec) { spec.NumberOfReplicas = 2 })
+		Eventually(esSetting(created, "index.number_of_replicas"), timeout, interval).Should(Equal("2"))
+		Expect(string(getSecret(ctx, namespace).Data["_index"])).To(Equal(created))
+
+		By("changing the number of shards")
+		updateIndex(ctx, index, func(spec *esv1.IndexSpec) { spec.NumberOfShards = 3 })
+		Eventually(func() string {
+			return string(getSecret(ctx, namespace).Data["_index"])
+		}, timeout, interval).ShouldNot(Equal(created))
+
+		migrated := string(getSecret(ctx, namespace).Data["_index"])
+		Expect(esSetting(migrated, "index.number_of_shards")()).To(Equal("3"))
+		Expect(esStatus(http.MethodGet, "/"+created)).To(Equal(http.StatusNotFound))
+		Expect(esStatus(http.MethodGet, "/"+index.Status.Elasticsearch.Alias+"/_count")).To(Equal(http.StatusOK))
+	})
+
+	It("deletes the resources in Elasticsearch and the Secret", func() {
+		index := createIndex(ctx, namespace)
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Ready))
+		resources := index.Status.Elasticsearch
+
+		Expect(k8sClient.Delete(ctx, index)).To(Succeed())
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(index), index))
+		}, timeout, interval).Should(BeTrue())
+
+		Expect(esStatus(http.MethodGet, "/"+resources.Index)).To(Equal(http.StatusNotFound))
+		Expect(esStatus(http.MethodGet, "/_security/role/"+resources.Role)).To(Equal(http.StatusNotFound))
+		Expect(esStatus(http.MethodGet, "/_security/user/"+resources.User)).To(Equal(http.StatusNotFound))
+		err := k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: secretName}, &coreV1.Secret{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("blocks the writes while migrating and removes the block when the migration fails", func() {
+		index := createIndex(ctx, namespace)
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Ready))
+		created := index.Status.Elasticsearch.Index
+
+		esServer.Inject(esfake.Fault{Method: http.MethodPost, Path: "^/_reindex$", Status: http.StatusBadRequest, Times: 1})
+		updateIndex(ctx, index, func(spec *esv1.IndexSpec) { spec.NumberOfShards = 3 })
+		Eventually(func() string {
+			return string(getSecret(ctx, namespace).Data["_index"])
+		}, timeout, interval).ShouldNot(Equal(created))
+
+		// block, failed reindex, unblock, block and reindex again
+		var settings []string
+		for _, r := range esServer.Requests() {
+			if r.Path == "/"+created+"/_settings" && r.Method == http.MethodPut {
+				settings = append(settings, strings.Join(strings.Fields(r.Body), ""))
+			}
+		}
+		Expect(settings).To(Equal([]string{
+			`{"index.blocks.write":true}`, `{"index.blocks.write":null}`, `{"index.blocks.write":true}`,
+		}))
+	})
+
+	It("keeps the settings of an adopted index and never migrates it", func() {
+		legacy := "legacy-" + namespace
+		status, _ := esServer.Do(http.MethodPut, "/"+legacy,
+			`{"settings": {"index.number_of_replicas": 1, "index.refresh_interval": "5s"}}`)
+		Expect(status).To(Equal(http.StatusOK))
+
+		index := &esv1.Index{
+			ObjectMeta: metav1.ObjectMeta{Name: "index", Namespace: namespace},
+			Spec: esv1.IndexSpec{
+				Application: "test",
+				Name:        legacy,
+				Adopt:       true,
+				Properties:  `"name": {"type": "text"}`,
+			},
+		}
+		Expect(k8sClient.Create(ctx, index)).To(Succeed())
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Ready))
+
+		By("adding a field")
+		updateIndex(ctx, index, func(spec *esv1.IndexSpec) { spec.Properties = `"name": {"type": "text"}, "age": {"type": "long"}` })
+		Eventually(func() int64 {
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(index), index)).To(Succeed())
+			return index.Status.ObservedGeneration
+		}, timeout, interval).Should(Equal(index.Generation))
+		Expect(esSetting(legacy, "index.number_of_replicas")()).To(Equal("1"))
+		Expect(esSetting(legacy, "index.refresh_interval")()).To(Equal("5s"))
+
+		By("changing the number of shards")
+		updateIndex(ctx, index, func(spec *esv1.IndexSpec) { spec.NumberOfShards = 3 })
+		Consistently(func() int {
+			return esStatus(http.MethodGet, "/"+legacy)
+		}, 2*time.Second, interval).Should(Equal(http.StatusOK))
+		Expect(string(getSecret(ctx, namespace).Data["_index"])).To(Equal(legacy))
+	})
+
+	It("rolls back a failed adoption leaving the index and its alias", func() {
+		legacy := "legacy-" + namespace
+		Expect(esStatus(http.MethodPut, "/"+legacy)).To(Equal(http.StatusOK))
+		Expect(esStatus(http.MethodPut, "/"+legacy+"/_alias/products-"+namespace)).To(Equal(http.StatusOK))
+		esServer.Inject(esfake.Fault{
+			Method: http.MethodPut,
+			Path:   "^/_security/user/.*" + namespace,
+			Status: http.StatusBadRequest,
+		})
+
+		index := &esv1.Index{
+			ObjectMeta: metav1.ObjectMeta{Name: "index", Namespace: namespace},
+			Spec:       esv1.IndexSpec{Application: "test", Name: "products-" + namespace, Adopt: true},
+		}
+		Expect(k8sClient.Create(ctx, index)).To(Succeed())
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Error))
+		Expect(esStatus(http.MethodGet, "/"+legacy)).To(Equal(http.StatusOK))
+		Expect(esStatus(http.MethodGet, "/"+legacy+"/_alias/products-"+namespace)).To(Equal(http.StatusOK))
+		Expect(esStatus(http.MethodGet, "/_security/role/test-"+namespace+"-role")).To(Equal(http.StatusNotFound))
+	})
+
+	It("retries transient errors", func() {
+		esServer.Inject(esfake.Fault{
+			Method: http.MethodPut,
+			Path:   "^/_security/user/.*" + namespace,
+			Status: http.StatusServiceUnavailable,
+			Times:  2,
+		})
+
+		index := createIndex(ctx, namespace)
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Ready))
+		Expect(index.Status.Attempts).To(BeZero())
+		Expect(index.Status.NextRetry).To(BeNil())
+		Expect(failedRequests(namespace)).To(BeNumerically(">=", 2))
+	})
+
+	It("resumes after a partial failure", func() {
+		// the index is created but the response is lost
+		esServer.Inject(esfake.Fault{
+			Method:  http.MethodPut,
+			Path:    "^/es-provisioner-test-" + namespace + "-[0-9-]+$",
+			Status:  http.StatusGatewayTimeout,
+			Applied: true,
+			Times:   2,
+		})
+
+		index := createIndex(ctx, namespace)
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Ready))
+		Expect(esStatus(http.MethodGet, "/_alias/"+index.Status.Elasticsearch.Alias)).To(Equal(http.StatusOK))
+	})
+
+	It("rolls back terminal errors and retries when the spec changes", func() {
+		esServer.Inject(esfake.Fault{
+			Method: http.MethodPut,
+			Path:   "^/_security/user/.*" + namespace,
+			Status: http.StatusBadRequest,
+		})
+
+		index := createIndex(ctx, namespace)
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Error))
+		Expect(index.Status.ProvisioningStep).To(BeEmpty())
+		Expect(esStatus(http.MethodGet, "/_alias/es-provisioner-test-"+namespace)).To(Equal(http.StatusNotFound))
+		Expect(esStatus(http.MethodGet, "/_security/role/test-"+namespace+"-role")).To(Equal(http.StatusNotFound))
+
+		esServer.ClearFaults()
+		updateIndex(ctx, index, func(spec *esv1.IndexSpec) { spec.NumberOfReplicas = 1 })
+		Eventually(indexStatus(ctx, index), timeout, interval).Should(Equal(esv1.Ready))
+	})
+})
+
+func createIndex(ctx context.Context, namespace string) *esv1.Index {
+	index := &esv1.Index{
+		ObjectMeta: metav1.ObjectMeta{Name: "index", Namespace: namespace},
+		Spec: esv1.IndexSpec{
+			Application: "test",
+			Properties:  `"name": {"type": "text"}`,
+		},
+	}
+	Expect(k8sClient.Create(ctx, index)).To(Succeed())
+	return index
+}
+
+// updateIndex changes the spec, retrying on conflicts with the status updates of the controller
+func updateIndex(ctx context.Context, index *esv1.Index, update func(spec *esv1.IndexSpec)) {
+	Eventually(func() error {
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(index), index); err != nil {
+			return err
+		}
+		update(&index.Spec)
+		return k8sClient.Update(ctx, index)
+	}, timeout, interval).Should(Succeed())
+}
+
+// indexStatus reads the Index into index and returns its status
+func indexStatus(ctx context.Context, index *esv1.Index) func() esv1.IndexStatusEnum {
+	return func() esv1.IndexStatusEnum {
+		if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(index), index); err != nil {
+			return ""
+		}
+		return index.Status.IndexStatus
+	}
+}
+
+func getSecret(ctx context.Context, namespace string) *coreV1.Secret {
+	var secret coreV1.Secret
+	Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: secretName}, &secret)).To(Succeed())
+	return &secret
+}
+
+func esStatus(method string, path string) int {
+	status, _ := esServer.Do(method, path, "")
+	return status
+}
+
+func esSetting(index string, key string) func() string {
+	return func() string {
+		_, body := esServer.Do(http.MethodGet, "/"+index+"/_settings?flat_settings=true", "")
+		var settings map[string]struct {
+			Settings map[string]interface{} `json:"settings"`
+		}
+		Expect(json.Unmarshal([]byte(body), &settings)).To(Succeed())
+		value, _ := settings[index].Settings[key].(string)
+		return value
+	}
+}
+
+// failedRequests counts the requests of the namespace failed by the fake Elasticsearch
+func failedRequests(namespace string) int {
+	failed := 0
+	for _, r := range esServer.Requests() {
+		if r.Status > 299 && strings.Contains(r.Path, namespace) {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Event reasons of the dry run
+const (
+	reasonValidated        = "Validated"
+	reasonValidationFailed = "ValidationFailed"
+	reasonDryRunFailed     = "DryRunFailed"
+)
+
+// isDryRun is true when the Index is in dry run mode with the spec or the annotation
+func isDryRun(index *esv1.Index) bool {
+	return index.Spec.DryRun || index.Annotations[esv1.DryRunAnnotation] == "true"
+}
+
+// dryRun validates the provisioning requests of the Index against Elasticsearch without creating
+// anything and reports them in the status. It runs again when the spec or the Config Map change,
+// a Ready Index is left as it is until the dry run is disabled.
+func (r *IndexReconciler) dryRun(ctx context.Context, index *esv1.Index) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	spec, configMapVersion, err := r.getConfigMap(ctx, index, index.Namespace)
+	if err != nil {
+		r.recordError(index, reasonDryRunFailed, err)
+		return ctrl.Result{}, err
+	}
+	if last := index.Status.DryRun; last != nil && last.ObservedGeneration == index.Generation &&
+		last.ConfigMapVersion == configMapVersion {
+		return ctrl.Result{}, nil
+	}
+
+	synonyms, _, err := r.getSynonyms(ctx, index)
+	if err != nil {
+		r.recordError(index, reasonDryRunFailed, err)
+		return ctrl.Result{}, err
+	}
+
+	log.V(1).Info("Dry run", "generation", index.Generation, "configMapVersion", configMapVersion)
+	var ns coreV1.Namespace
+	if err := r.Get(ctx, client.ObjectKey{Name: index.Namespace}, &ns); err != nil {
+		r.recordError(index, reasonDryRunFailed, err)
+		return ctrl.Result{}, err
+	}
+	// the names refused by the naming policy are reported with the validation errors
+	name, nameErr := r.indexName(ctx, index, &ns)
+	var validationErr *es.ValidationError
+	if nameErr != nil && !errors.As(nameErr, &validationErr) {
+		r.recordError(index, reasonDryRunFailed, nameErr)
+		return ctrl.Result{}, nameErr
+	}
+
+	ops := indexspec.SetupOptions(index, index.Namespace, spec, synonyms)
+	ops.Owner = indexspec.Owner(index, r.ClusterName)
+	if nameErr == nil {
+		ops.IndexName = name
+	}
+	result, err := r.EsService.DryRunIndex(&ops)
+	if err != nil {
+		log.Error(err, "unable to dry run Index")
+		r.recordError(index, reasonDryRunFailed, err)
+		return ctrl.Result{}, err
+	}
+	if nameErr != nil {
+		result.Errors = append([]string{nameErr.Error()}, result.Errors...)
+	}
+
+	index.Status.DryRun = dryRunStatus(result)
+	index.Status.DryRun.ObservedGeneration = index.Generation
+	index.Status.DryRun.ConfigMapVersion = configMapVersion
+
+	if len(result.Errors) > 0 {
+		message := strings.Join(result.Errors, "; ")
+		r.setCondition(index, esv1.ConditionValidated, v1.ConditionFalse, reasonValidationFailed, message)
+		r.Recorder.Event(index, coreV1.EventTypeWarning, reasonValidationFailed, message)
+	} else {
+		message := fmt.Sprintf("%d requests validated", len(result.Requests))
+		if len(result.Warnings) > 0 {
+			message = fmt.Sprintf("%s: %s", message, strings.Join(result.Warnings, "; "))
+		}
+		r.setCondition(index, esv1.ConditionValidated, v1.ConditionTrue, reasonValidated, message)
+		r.Recorder.Event(index, coreV1.EventTypeNormal, reasonValidated, message)
+	}
+
+	status := index.Status.IndexStatus
+	if status == "" {
+		status = esv1.DryRun
+	}
+	r.updateStatus(index, ctx, status)
+	return ctrl.Result{}, nil
+}
+
+func dryRunStatus(result *es.EsDryRunResult) *esv1.DryRunStatus {
+	status := &esv1.DryRunStatus{
+		Errors:   result.Errors,
+		Warnings: result.Warnings,
+	}
+	for _, req := range result.Requests {
+		status.Requests = append(status.Requests, esv1.DryRunRequest{
+			Method: req.Method,
+			Path:   req.Path,
+			Body:   req.Body,
+		})
+	}
+	return status
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// healthWait is how often an Index waiting for the cluster checks it again
+	healthWait = time.Minute
+
+	reasonClusterUnavailable = "ClusterUnavailable"
+	reasonClusterAvailable   = "ClusterAvailable"
+)
+
+// HealthGate are the limits of the cluster checked before provisioning an Index, the zero values aren't checked.
+// A red cluster is never provisioned.
+type HealthGate struct {
+	// MaxPendingTasks is the maximum number of cluster tasks waiting for the master
+	MaxPendingTasks int
+	// DiskWatermark waits while a data node is over the low disk watermark of the cluster
+	DiskWatermark bool
+	// MaxShardsPercent is the maximum percentage of the shard limit of the cluster used with the new index
+	MaxShardsPercent int
+}
+
+// checkClusterHealth returns why the Index can't be provisioned in the cluster now, empty when it can
+func (r *IndexReconciler) checkClusterHealth(ctx context.Context, index *esv1.Index) (string, error) {
+	gate := r.HealthGate
+	if gate == nil {
+		return "", nil
+	}
+	health, err := r.EsService.ClusterHealth()
+	if err != nil {
+		return "", err
+	}
+
+	reasons := []string{}
+	if health.Status == es.HealthRed {
+		reasons = append(reasons, "cluster health is red")
+	}
+	if gate.MaxPendingTasks > 0 && health.PendingTasks > gate.MaxPendingTasks {
+		reasons = append(reasons, fmt.Sprintf("%d pending tasks exceed %d", health.PendingTasks, gate.MaxPendingTasks))
+	}
+	if gate.DiskWatermark && health.DiskWatermarkReached {
+		reasons = append(reasons, fmt.Sprintf("disk usage %d%% reached the low watermark %s", health.DiskPercent, health.DiskWatermark))
+	}
+	if gate.MaxShardsPercent > 0 && health.MaxShards > 0 {
+		shards, err := r.newShards(ctx, index)
+		if err != nil {
+			return "", err
+		}
+		shards += health.Shards
+		if shards*100 > health.MaxShards*gate.MaxShardsPercent {
+			reasons = append(reasons, fmt.Sprintf("%d shards with the index exceed %d%% of the limit of %d shards",
+				shards, gate.MaxShardsPercent, health.MaxShards))
+		}
+	}
+	if len(reasons) == 0 {
+		return "", nil
+	}
+	return "Waiting for the cluster: " + strings.Join(reasons, ", "), nil
+}
+
+// newShards is the number of shards the Index creates, including the replicas. They are read from the settings
+// rendered from the spec, the Config Map and the component templates, like the index is created. The errors of
+// the Config Map aren't returned, the provisioning reports them.
+func (r *IndexReconciler) newShards(ctx context.Context, index *esv1.Index) (int, error) {
+	if index.Spec.Adopt {
+		return 0, nil
+	}
+	spec, _, err := indexspec.Payload(index, r.ClusterName, r.configMapGetter(ctx, index.Namespace))
+	if err != nil {
+		log.FromContext(ctx).V(1).Info("Not counting the shards of the Index, its ConfigMap can't be rendered", "error", err.Error())
+		return 0, nil
+	}
+	ops := indexspec.SetupOptions(index, index.Namespace, spec, nil)
+	return r.EsService.IndexShards(&ops)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/metrics"
+	coreV1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// pendingStatus labels the Indices that haven't been processed yet
+const pendingStatus = "Pending"
+
+// IndexMetricsSampler periodically samples the Index status and the Elasticsearch index stats
+type IndexMetricsSampler struct {
+	client.Client
+	EsService es.EsService
+	Interval  time.Duration
+}
+
+// Start samples the metrics every Interval until the context is cancelled
+func (s *IndexMetricsSampler) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.sample(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection only samples on the leader, like the controller
+func (s *IndexMetricsSampler) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *IndexMetricsSampler) sample(ctx context.Context) {
+	log := log.FromContext(ctx).WithName("index-metrics")
+
+	var indices esv1.IndexList
+	if err := s.List(ctx, &indices); err != nil {
+		log.Error(err, "unable to list Indices")
+		return
+	}
+
+	counts := map[string]float64{
+		pendingStatus:         0,
+		string(esv1.Creating): 0,
+		string(esv1.Created):  0,
+		string(esv1.Ready):    0,
+		string(esv1.Error):    0,
+		string(esv1.DryRun):   0,
+	}
+	metrics.IndexDocs.Reset()
+	metrics.IndexStoreSize.Reset()
+
+	for _, index := range indices.Items {
+		status := string(index.Status.IndexStatus)
+		if status == "" {
+			status = pendingStatus
+		}
+		counts[status]++
+
+		if index.Status.IndexStatus != esv1.Ready {
+			continue
+		}
+		var secret coreV1.Secret
+		err := s.Get(ctx, client.ObjectKey{Namespace: index.Namespace, Name: secretName}, &secret)
+		if err != nil {
+			log.V(1).Info("unable to get Secret", "namespace", index.Namespace, "error", err.Error())
+			continue
+		}
+		alias := string(secret.Data["index"])
+		stats, err := s.EsService.GetIndexStats(alias)
+		if err != nil {
+			log.V(1).Info("unable to get index stats", "index", alias, "error", err.Error())
+			continue
+		}
+		metrics.IndexDocs.WithLabelValues(index.Namespace, index.Name, alias).Set(float64(stats.Docs))
+		metrics.IndexStoreSize.WithLabelValues(index.Namespace, index.Name, alias).Set(float64(stats.StoreSize))
+	}
+
+	for status, count := range counts {
+		metrics.Indices.WithLabelValues(status).Set(count)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+// indexName returns the alias of the Index, named with the naming policy until it's provisioned. The names
+// used by another Index of any namespace are refused, so a tenant can't bind to the index of another one.
+func (r *IndexReconciler) indexName(ctx context.Context, index *esv1.Index, ns *coreV1.Namespace) (string, error) {
+	if alias := index.Status.Elasticsearch.Alias; alias != "" {
+		return alias, nil
+	}
+	name, err := r.Naming.IndexName(index, ns, r.ClusterName)
+	if err != nil {
+		return "", &es.ValidationError{Reason: err.Error()}
+	}
+
+	var indices esv1.IndexList
+	if err := r.List(ctx, &indices); err != nil {
+		return "", err
+	}
+	for _, other := range indices.Items {
+		if other.Namespace == index.Namespace && other.Name == index.Name {
+			continue
+		}
+		if other.Status.Elasticsearch.Alias == name || other.Status.Elasticsearch.Index == name {
+			return "", &es.ValidationError{
+				Reason: fmt.Sprintf("index or alias %s is owned by Index %s/%s", name, other.Namespace, other.Name),
+			}
+		}
+	}
+	return name, nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	coreV1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Event reasons of the operations requested with annotations
+const (
+	reasonOperationFailed = "OperationFailed"
+)
+
+// indexOperation is an operation requested with an annotation
+type indexOperation struct {
+	annotation string
+	run        func(ctx context.Context, index *esv1.Index) error
+}
+
+// runOperations runs the operations whose annotation changed since they last ran. Operations failing
+// with a transient error are retried, otherwise the failure is recorded and the token marked as done
+func (r *IndexReconciler) runOperations(ctx context.Context, index *esv1.Index) error {
+	log := log.FromContext(ctx)
+
+	operations := []indexOperation{
+		{esv1.RotatePasswordAnnotation, r.rotatePassword},
+		{esv1.ReindexAnnotation, r.reindex},
+		{esv1.SnapshotAnnotation, r.snapshot},
+	}
+	for _, op := range operations {
+		token := index.Annotations[op.annotation]
+		if token == "" || index.Status.Operations[op.annotation] == token {
+			continue
+		}
+
+		log.Info("Running operation", "annotation", op.annotation, "token", token)
+		err := op.run(ctx, index)
+		if err != nil {
+			log.Error(err, "operation failed", "annotation", op.annotation)
+			r.recordError(index, reasonOperationFailed, err)
+			if es.IsTransient(err) {
+				return err
+			}
+		}
+
+		if index.Status.Operations == nil {
+			index.Status.Operations = map[string]string{}
+		}
+		index.Status.Operations[op.annotation] = token
+		r.updateStatus(index, ctx, index.Status.IndexStatus)
+	}
+	return nil
+}
+
+// rotatePassword sets a new password for the index user and updates the Secret, only when the Index wrote it
+func (r *IndexReconciler) rotatePassword(ctx context.Context, index *esv1.Index) error {
+	resources, secret, owned, err := r.indexResources(ctx, index)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return &es.ValidationError{Reason: fmt.Sprintf("Cannot rotate password: Secret %s wasn't written by Index %s",
+			secretName, index.Name)}
+	}
+
+	pw, err := r.EsService.RotatePassword(resources.User, resources.Role, indexspec.Owner(index, r.ClusterName))
+	if err != nil {
+		return err
+	}
+
+	secret.Data["password"] = []byte(pw)
+	err = r.Update(ctx, secret)
+	if err != nil {
+		return err
+	}
+	r.Recorder.Eventf(index, coreV1.EventTypeNormal, es.StepPasswordRotated, "Password of User %s rotated, Secret %s updated", resources.User, secretName)
+	return nil
+}
+
+// reindex starts a migration of the data to a new index with the current spec and payload
+func (r *IndexReconciler) reindex(ctx context.Context, index *esv1.Index) error {
+	spec, configMapVersion, err := r.getConfigMap(ctx, index, index.Namespace)
+	if err != nil {
+		return err
+	}
+	synonyms, synonymVersions, err := r.getSynonyms(ctx, index)
+	if err != nil {
+		return err
+	}
+	templateVersions, err := r.getComponentTemplates(ctx, index)
+	if err != nil {
+		return err
+	}
+	err = r.updateIndex(ctx, index, spec, synonyms, true)
+	if err != nil {
+		return err
+	}
+	setApplied(index, configMapVersion, synonymVersions, templateVersions)
+	return nil
+}
+
+// snapshot starts a snapshot of the index
+func (r *IndexReconciler) snapshot(ctx context.Context, index *esv1.Index) error {
+	repository := index.Annotations[esv1.SnapshotRepositoryAnnotation]
+	if repository == "" {
+		repository = r.SnapshotRepository
+	}
+	if repository == "" {
+		return &es.ValidationError{Reason: "Cannot create snapshot: no repository, set the " +
+			esv1.SnapshotRepositoryAnnotation + " annotation"}
+	}
+
+	resources, _, _, err := r.indexResources(ctx, index)
+	if err != nil {
+		return err
+	}
+	if resources.Alias == "" {
+		return &es.ValidationError{Reason: fmt.Sprintf("Cannot create snapshot: the index isn't recorded in the status or Secret %s",
+			secretName)}
+	}
+
+	alias := resources.Alias
+	snapshot, err := r.EsService.SnapshotIndex(repository, alias)
+	if err != nil {
+		return err
+	}
+	r.Recorder.Eventf(index, coreV1.EventTypeNormal, es.StepSnapshotStarted, "Snapshot %s of Index %s started in Repository %s", snapshot, alias, repository)
+	return nil
+}
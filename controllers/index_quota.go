@@ -0,0 +1,113 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// quotaWait is how often an Index waiting for quota checks it again, quota changes are watched
+	quotaWait = 5 * time.Minute
+
+	reasonQuotaExceeded = "QuotaExceeded"
+	reasonWithinQuota   = "WithinQuota"
+)
+
+// namespaceUsage measures the Ready Indices of the namespace in Elasticsearch with _cat/indices, the other ones
+// count with the shards and replicas of their spec. The excluded Index and the Indices being deleted are skipped.
+func namespaceUsage(ctx context.Context, c client.Client, service es.EsService, namespace string, exclude string) (*esv1.QuotaUsage, error) {
+	var indices esv1.IndexList
+	if err := c.List(ctx, &indices, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	usage := &esv1.QuotaUsage{}
+	aliases := []string{}
+	for i := range indices.Items {
+		index := &indices.Items[i]
+		if index.Name == exclude || !index.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if index.Status.IndexStatus == esv1.Ready && index.Status.Elasticsearch.Alias != "" {
+			usage.Indices++
+			aliases = append(aliases, index.Status.Elasticsearch.Alias)
+			continue
+		}
+		usage.Add(&index.Spec)
+	}
+
+	measured, err := service.CatIndices(aliases)
+	if err != nil {
+		return nil, err
+	}
+	store := int64(0)
+	for _, u := range measured {
+		usage.PrimaryShards += int32(u.Primaries)
+		if int32(u.Replicas) > usage.Replicas {
+			usage.Replicas = int32(u.Replicas)
+		}
+		store += u.StoreSize
+	}
+	usage.StoreSize = *resource.NewQuantity(store, resource.BinarySI)
+	return usage, nil
+}
+
+// checkQuotas returns why the Index can't be provisioned within the IndexQuotas of its namespace,
+// empty when it can
+func (r *IndexReconciler) checkQuotas(ctx context.Context, index *esv1.Index) (string, error) {
+	var quotas esv1.IndexQuotaList
+	if err := r.List(ctx, &quotas, client.InNamespace(index.Namespace)); err != nil {
+		return "", err
+	}
+	if len(quotas.Items) == 0 {
+		return "", nil
+	}
+	usage, err := namespaceUsage(ctx, r.Client, r.EsService, index.Namespace, index.Name)
+	if err != nil {
+		return "", err
+	}
+	usage.Add(&index.Spec)
+	for _, quota := range quotas.Items {
+		if exceeded := quota.Spec.Exceeded(usage, nil); len(exceeded) > 0 {
+			return fmt.Sprintf("IndexQuota %s exceeded: %s", quota.Name, strings.Join(exceeded, ", ")), nil
+		}
+	}
+	return "", nil
+}
+
+// findIndicesForIndexQuota maps an IndexQuota to the Indices of its namespace
+func (r *IndexReconciler) findIndicesForIndexQuota(quota client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+	var indices esv1.IndexList
+	if err := r.List(context.Background(), &indices, client.InNamespace(quota.GetNamespace())); err != nil {
+		return requests
+	}
+	for _, item := range indices.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+	}
+	return requests
+}
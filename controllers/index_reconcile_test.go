@@ -0,0 +1,889 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	"com.ramos/es-provisioner/pkg/naming"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testNamespace = "test"
+
+// TestReconcile runs the reconciler against a fake client and a recording EsService, without
+// Kubernetes or Elasticsearch
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name string
+		// index is changed from a new Index in the test namespace
+		index   func(index *esv1.Index)
+		objects []client.Object
+		// annotations of the test namespace
+		annotations map[string]string
+		naming      *naming.Policy
+		// health of the cluster, the HealthGate is only checked when it's set
+		health *es.EsClusterHealth
+		// updatedIndex and migration are returned by UpdateIndex
+		updatedIndex string
+		migration    *es.EsMigration
+		// resync enables the drift check returning the driftReport
+		resync      time.Duration
+		driftReport *es.EsDriftReport
+		fail        map[string]error
+		// methods of the EsService expected to be called
+		methods []string
+		// removed is the index, alias, role and user passed to RemoveIndex
+		removed []string
+		// checked are the resources passed to CheckIndex (index, alias, role, user and password), UpdateIndex
+		// (index, alias and role), UpdateSynonyms (index and alias) or the name passed to the operations
+		checked []string
+		// requeueAfter is the expected delay of the result, within a second
+		requeueAfter time.Duration
+		err          bool
+		verify       func(g *WithT, c client.Client, index *esv1.Index)
+	}{
+		{
+			name:    "provisions a new Index",
+			methods: []string{"ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Ready))
+				g.Expect(index.Finalizers).To(ContainElement(finalizerName))
+				g.Expect(index.Status.ProvisioningStep).To(Equal(es.StepCredentialsVerified))
+				g.Expect(meta.IsStatusConditionTrue(index.Status.Conditions, esv1.ConditionProvisioned)).To(BeTrue())
+
+				secret := testSecret(g, c)
+				g.Expect(string(secret.Data["index"])).To(Equal("es-provisioner-app-test"))
+				g.Expect(string(secret.Data["_index"])).To(Equal(index.Status.Elasticsearch.Index))
+				g.Expect(string(secret.Data["username"])).To(Equal("app-test-role-user"))
+				g.Expect(string(secret.Data["password"])).To(Equal("password"))
+			},
+		},
+		{
+			name:         "waits for the IndexQuota before provisioning",
+			objects:      []client.Object{readyIndex(), indexQuota(esv1.IndexQuotaSpec{MaxIndices: limit(1)})},
+			methods:      []string{"CatIndices"},
+			requeueAfter: quotaWait,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(BeEmpty())
+				condition := meta.FindStatusCondition(index.Status.Conditions, esv1.ConditionProvisioned)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonQuotaExceeded))
+				g.Expect(condition.Message).To(Equal("IndexQuota tenant exceeded: maximum 1 Indices exceeded: 2"))
+			},
+		},
+		{
+			name:    "provisions an Index within the IndexQuota",
+			objects: []client.Object{readyIndex(), indexQuota(esv1.IndexQuotaSpec{MaxIndices: limit(2)})},
+			methods: []string{"CatIndices", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Ready))
+			},
+		},
+		{
+			name:         "waits for a red cluster before provisioning",
+			health:       &es.EsClusterHealth{Status: es.HealthRed},
+			methods:      []string{"ClusterHealth"},
+			requeueAfter: healthWait,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(BeEmpty())
+				condition := meta.FindStatusCondition(index.Status.Conditions, esv1.ConditionWaiting)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+				g.Expect(condition.Message).To(Equal("Waiting for the cluster: cluster health is red"))
+			},
+		},
+		{
+			name:  "waits until the cluster has capacity for the shards of the Index",
+			index: func(index *esv1.Index) { index.Spec.NumberOfReplicas = 1 },
+			health: &es.EsClusterHealth{Status: es.HealthGreen, PendingTasks: 20, Shards: 895, MaxShards: 1000, DiskPercent: 90,
+				DiskWatermark: "85%", DiskWatermarkReached: true},
+			methods:      []string{"ClusterHealth", "IndexShards"},
+			requeueAfter: healthWait,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				condition := meta.FindStatusCondition(index.Status.Conditions, esv1.ConditionWaiting)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonClusterUnavailable))
+				g.Expect(condition.Message).To(Equal("Waiting for the cluster: 20 pending tasks exceed 10, disk usage 90% reached the low watermark 85%, " +
+					"903 shards with the index exceed 90% of the limit of 1000 shards"))
+			},
+		},
+		{
+			name: "provisions the Index once the cluster is healthy",
+			index: func(index *esv1.Index) {
+				index.Status.Conditions = []metav1.Condition{{Type: esv1.ConditionWaiting, Status: metav1.ConditionTrue,
+					Reason: reasonClusterUnavailable, Message: "Waiting for the cluster: cluster health is red", LastTransitionTime: metav1.Now()}}
+			},
+			health: &es.EsClusterHealth{Status: es.HealthYellow, PendingTasks: 2, Shards: 10, MaxShards: 1000, DiskPercent: 50,
+				DiskWatermark: "85%"},
+			methods: []string{"ClusterHealth", "IndexShards", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Ready))
+				g.Expect(meta.IsStatusConditionFalse(index.Status.Conditions, esv1.ConditionWaiting)).To(BeTrue())
+			},
+		},
+		{
+			name:    "names the Index with the naming policy",
+			index:   func(index *esv1.Index) { index.Labels = map[string]string{"env": "prod"} },
+			naming:  mustPolicy(`{{ .Application }}-{{ index .Labels "env" }}`, true),
+			methods: []string{"ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Ready))
+				g.Expect(index.Status.Elasticsearch.Alias).To(Equal("test_app-prod"))
+			},
+		},
+		{
+			name:        "forces the prefix of the namespace on the Index name",
+			index:       func(index *esv1.Index) { index.Spec.Name = "shared" },
+			annotations: map[string]string{naming.PrefixAnnotation: "tenant-a-"},
+			methods:     []string{"ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.Elasticsearch.Alias).To(Equal("tenant-a-shared"))
+			},
+		},
+		{
+			name:    "refuses the name of an index owned by another Index",
+			index:   func(index *esv1.Index) { index.Spec.Name = "es-provisioner-other" },
+			objects: []client.Object{otherIndex("es-provisioner-other")},
+			methods: []string{"RollbackIndex"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Error))
+				condition := meta.FindStatusCondition(index.Status.Conditions, esv1.ConditionProvisioned)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Message).To(Equal("index or alias es-provisioner-other is owned by Index other/index-sample"))
+			},
+		},
+		{
+			name: "resumes from the last step completed",
+			index: func(index *esv1.Index) {
+				index.Status.IndexStatus = esv1.Creating
+				index.Status.ProvisioningStep = es.StepAliasAdded
+				index.Status.Elasticsearch = esv1.ElasticsearchResources{Index: "es-provisioner-app-test-1", Alias: "es-provisioner-app-test"}
+			},
+			methods: []string{"ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Ready))
+				g.Expect(index.Status.Elasticsearch.Index).To(Equal("es-provisioner-app-test-1"))
+				testSecret(g, c)
+			},
+		},
+		{
+			name:         "retries transient errors",
+			fail:         map[string]error{es.StepRoleCreated: &es.EsError{Action: "create role", Status: http.StatusServiceUnavailable}},
+			methods:      []string{"ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			requeueAfter: retryBaseDelay,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Creating))
+				g.Expect(index.Status.ProvisioningStep).To(Equal(es.StepAliasAdded))
+				g.Expect(index.Status.Attempts).To(Equal(int32(1)))
+				g.Expect(index.Status.NextRetry).NotTo(BeNil())
+				condition := meta.FindStatusCondition(index.Status.Conditions, esv1.ConditionProvisioned)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonRetrying))
+			},
+		},
+		{
+			name:    "rolls back terminal errors",
+			fail:    map[string]error{es.StepUserCreated: &es.EsError{Action: "create user", Status: http.StatusBadRequest}},
+			methods: []string{"ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep", "RollbackIndex"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Error))
+				g.Expect(index.Status.ProvisioningStep).To(BeEmpty())
+				g.Expect(index.Status.Elasticsearch).To(Equal(esv1.ElasticsearchResources{}))
+				g.Expect(index.Status.ObservedGeneration).To(Equal(index.Generation))
+			},
+		},
+		{
+			name: "waits until the next retry",
+			index: func(index *esv1.Index) {
+				next := metav1.NewTime(time.Now().Add(time.Hour))
+				index.Status.IndexStatus = esv1.Creating
+				index.Status.Attempts = 3
+				index.Status.NextRetry = &next
+			},
+			methods:      []string{},
+			requeueAfter: time.Hour,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Creating))
+				g.Expect(index.Status.Attempts).To(Equal(int32(3)))
+			},
+		},
+		{
+			name: "updates a Ready Index when the spec changes",
+			index: func(index *esv1.Index) {
+				index.Generation = 2
+				index.Status.IndexStatus = esv1.Ready
+				index.Status.ObservedGeneration = 1
+			},
+			objects: []client.Object{readySecret()},
+			methods: []string{"UpdateIndex"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Ready))
+				g.Expect(index.Status.ObservedGeneration).To(Equal(int64(2)))
+			},
+		},
+		{
+			name: "records the new index when a migration fails after moving the alias",
+			index: func(index *esv1.Index) {
+				index.Generation = 2
+				index.Status.IndexStatus = esv1.Ready
+				index.Status.ObservedGeneration = 1
+				index.Status.Elasticsearch = esv1.ElasticsearchResources{Index: "es-provisioner-app-test-1", Alias: "es-provisioner-app-test"}
+			},
+			objects:      []client.Object{readySecret()},
+			updatedIndex: "es-provisioner-app-test-2",
+			fail:         map[string]error{"UpdateIndex": &es.EsError{Action: "create role", Status: http.StatusForbidden}},
+			methods:      []string{"UpdateIndex"},
+			err:          true,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.Elasticsearch.Index).To(Equal("es-provisioner-app-test-2"))
+				g.Expect(index.Status.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(string(testSecret(g, c).Data["_index"])).To(Equal("es-provisioner-app-test-2"))
+			},
+		},
+		{
+			name: "starts a migration without recording the versions applied",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Generation = 2
+				index.Status.ObservedGeneration = 1
+			},
+			objects:      []client.Object{ownedSecret()},
+			migration:    &es.EsMigration{Index: "es-provisioner-app-test-3", Task: "node:1"},
+			methods:      []string{"UpdateIndex"},
+			requeueAfter: migrationWait,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.Migration).To(Equal(&esv1.IndexMigration{Index: "es-provisioner-app-test-3", Task: "node:1",
+					ObservedGeneration: 2}))
+				g.Expect(index.Status.Elasticsearch.Index).To(Equal("es-provisioner-app-test-2"))
+				g.Expect(index.Status.ObservedGeneration).To(Equal(int64(1)))
+			},
+		},
+		{
+			name: "waits for the reindex task of the migration",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Generation = 3
+				index.Status.ObservedGeneration = 1
+				index.Status.Migration = &esv1.IndexMigration{Index: "es-provisioner-app-test-3", Task: "node:1", ObservedGeneration: 2}
+			},
+			objects:      []client.Object{ownedSecret()},
+			migration:    &es.EsMigration{Index: "es-provisioner-app-test-3", Task: "node:1"},
+			resync:       time.Hour,
+			methods:      []string{"UpdateIndex"},
+			requeueAfter: migrationWait,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.Migration).To(Equal(&esv1.IndexMigration{Index: "es-provisioner-app-test-3", Task: "node:1",
+					ObservedGeneration: 2}))
+				g.Expect(index.Status.ObservedGeneration).To(Equal(int64(1)))
+			},
+		},
+		{
+			name: "completes the migration and applies the changes made since",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Generation = 3
+				index.Status.ObservedGeneration = 1
+				index.Status.Migration = &esv1.IndexMigration{Index: "es-provisioner-app-test-3", Task: "node:1", ObservedGeneration: 2}
+			},
+			objects: []client.Object{ownedSecret()},
+			methods: []string{"UpdateIndex", "UpdateIndex"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.Migration).To(BeNil())
+				g.Expect(index.Status.Elasticsearch.Index).To(Equal("es-provisioner-app-test-3"))
+				g.Expect(index.Status.ObservedGeneration).To(Equal(int64(3)))
+				g.Expect(string(testSecret(g, c).Data["_index"])).To(Equal("es-provisioner-app-test-3"))
+			},
+		},
+		{
+			name: "keeps the versions applied when the migration fails",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Generation = 2
+				index.Status.ObservedGeneration = 1
+				index.Status.Migration = &esv1.IndexMigration{Index: "es-provisioner-app-test-3", Task: "node:1", ObservedGeneration: 2}
+			},
+			objects: []client.Object{ownedSecret()},
+			fail:    map[string]error{"UpdateIndex": &es.EsError{Action: "reindex", Status: http.StatusInternalServerError}},
+			methods: []string{"UpdateIndex"},
+			err:     true,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.Migration).To(BeNil())
+				g.Expect(index.Status.Elasticsearch.Index).To(Equal("es-provisioner-app-test-2"))
+				g.Expect(index.Status.ObservedGeneration).To(Equal(int64(1)))
+			},
+		},
+		{
+			name: "records the versions applied to an Index provisioned before they were recorded",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Generation = 2
+				index.Status.ObservedGeneration = 0
+			},
+			objects: []client.Object{ownedSecret()},
+			methods: []string{},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.ObservedGeneration).To(Equal(int64(2)))
+			},
+		},
+		{
+			name: "leaves a Ready Index without changes alone",
+			index: func(index *esv1.Index) {
+				index.Status.IndexStatus = esv1.Ready
+				index.Status.ObservedGeneration = index.Generation
+			},
+			objects: []client.Object{readySecret()},
+			methods: []string{},
+		},
+		{
+			name: "updates the index recorded in the status when the Secret was written for another Index",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Generation = 2
+			},
+			objects:      []client.Object{siblingSecret()},
+			updatedIndex: "es-provisioner-app-test-3",
+			methods:      []string{"UpdateIndex"},
+			checked:      []string{"es-provisioner-app-test-2", "es-provisioner-app-test", "app-test-role"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.Elasticsearch.Index).To(Equal("es-provisioner-app-test-3"))
+				g.Expect(index.Status.ObservedGeneration).To(Equal(int64(2)))
+				g.Expect(string(testSecret(g, c).Data["_index"])).To(Equal("es-provisioner-app-test-1"))
+			},
+		},
+		{
+			name: "updates the synonyms of the index recorded in the status",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Spec.Synonyms = []esv1.SynonymSet{{Name: "products", ConfigMap: "synonyms"}}
+				index.Status.Synonyms = map[string]string{"products": "1"}
+			},
+			objects: []client.Object{siblingSecret(), &coreV1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "synonyms", Namespace: testNamespace},
+				Data:       map[string]string{indexspec.SynonymsKey: "tv, television"},
+			}},
+			methods: []string{"UpdateSynonyms"},
+			checked: []string{"es-provisioner-app-test-2", "es-provisioner-app-test"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.Synonyms).NotTo(HaveKeyWithValue("products", "1"))
+			},
+		},
+		{
+			name: "rotates the password of the user recorded in the status",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Annotations = map[string]string{esv1.RotatePasswordAnnotation: "1"}
+			},
+			objects: []client.Object{ownedSecret()},
+			methods: []string{"RotatePassword"},
+			checked: []string{"app-test-role-user"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(string(testSecret(g, c).Data["password"])).To(Equal("rotated"))
+				g.Expect(index.Status.Operations).To(HaveKeyWithValue(esv1.RotatePasswordAnnotation, "1"))
+			},
+		},
+		{
+			name: "refuses to rotate the password in the Secret of another Index",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Annotations = map[string]string{esv1.RotatePasswordAnnotation: "1"}
+			},
+			objects: []client.Object{siblingSecret()},
+			methods: []string{},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(string(testSecret(g, c).Data["password"])).To(Equal("password"))
+				g.Expect(index.Status.Operations).To(HaveKeyWithValue(esv1.RotatePasswordAnnotation, "1"))
+			},
+		},
+		{
+			name: "snapshots the alias recorded in the status",
+			index: func(index *esv1.Index) {
+				driftedIndex(index)
+				index.Status.Elasticsearch.Alias = "logs"
+				index.Annotations = map[string]string{esv1.SnapshotAnnotation: "1", esv1.SnapshotRepositoryAnnotation: "backups"}
+			},
+			objects: []client.Object{siblingSecret()},
+			methods: []string{"SnapshotIndex"},
+			checked: []string{"logs"},
+		},
+		{
+			name:         "checks the drift of the resources recorded in the status",
+			index:        driftedIndex,
+			objects:      []client.Object{ownedSecret()},
+			resync:       time.Hour,
+			methods:      []string{"CheckIndex"},
+			checked:      []string{"es-provisioner-app-test-2", "es-provisioner-app-test", "app-test-role", "app-test-role-user", "password"},
+			requeueAfter: time.Hour,
+		},
+		{
+			name:  "writes the Secret again with a new password when it was deleted",
+			index: driftedIndex,
+			driftReport: &es.EsDriftReport{Password: "new-password",
+				Repaired: []string{"user app-test-role-user password was reset, its secret was missing"}},
+			resync:       time.Hour,
+			methods:      []string{"CheckIndex"},
+			checked:      []string{"es-provisioner-app-test-2", "es-provisioner-app-test", "app-test-role", "app-test-role-user", ""},
+			requeueAfter: time.Hour,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				secret := testSecret(g, c)
+				g.Expect(secret.Annotations).To(HaveKeyWithValue(esv1.SecretOwnerAnnotation, "index"))
+				g.Expect(string(secret.Data["password"])).To(Equal("new-password"))
+				g.Expect(string(secret.Data["_index"])).To(Equal("es-provisioner-app-test-2"))
+				g.Expect(meta.IsStatusConditionTrue(index.Status.Conditions, esv1.ConditionDrifted)).To(BeFalse())
+			},
+		},
+		{
+			name:         "reports the Secret of another Index without using its password",
+			index:        driftedIndex,
+			objects:      []client.Object{siblingSecret()},
+			resync:       time.Hour,
+			methods:      []string{"CheckIndex"},
+			checked:      []string{"es-provisioner-app-test-2", "es-provisioner-app-test", "app-test-role", "app-test-role-user", ""},
+			requeueAfter: time.Hour,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(meta.IsStatusConditionTrue(index.Status.Conditions, esv1.ConditionDrifted)).To(BeTrue())
+				g.Expect(string(testSecret(g, c).Data["password"])).To(Equal("password"))
+			},
+		},
+		{
+			name:         "waits for the component templates to be synced",
+			index:        func(index *esv1.Index) { index.Spec.ComponentTemplates = []string{"defaults"} },
+			objects:      []client.Object{componentTemplate("defaults", false)},
+			methods:      []string{},
+			requeueAfter: retryBaseDelay,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Creating))
+				g.Expect(index.Status.Attempts).To(Equal(int32(1)))
+			},
+		},
+		{
+			name: "records the component templates applied",
+			index: func(index *esv1.Index) {
+				index.Spec.ComponentTemplates = []string{"defaults", "external"}
+			},
+			objects: []client.Object{componentTemplate("defaults", true)},
+			methods: []string{"ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Ready))
+				g.Expect(index.Status.ComponentTemplates).To(Equal(map[string]string{"defaults": "2", "external": ""}))
+			},
+		},
+		{
+			name: "updates a Ready Index when a component template changes",
+			index: func(index *esv1.Index) {
+				index.Spec.ComponentTemplates = []string{"defaults"}
+				index.Status.IndexStatus = esv1.Ready
+				index.Status.ObservedGeneration = index.Generation
+				index.Status.ComponentTemplates = map[string]string{"defaults": "1"}
+			},
+			objects: []client.Object{readySecret(), componentTemplate("defaults", true)},
+			methods: []string{"UpdateIndex"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.ComponentTemplates).To(Equal(map[string]string{"defaults": "2"}))
+			},
+		},
+		{
+			name:         "waits for the ingest pipelines to be synced",
+			index:        func(index *esv1.Index) { index.Spec.DefaultPipeline = "normalize" },
+			objects:      []client.Object{ingestPipeline("normalize", false)},
+			methods:      []string{},
+			requeueAfter: retryBaseDelay,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Creating))
+				g.Expect(index.Status.Attempts).To(Equal(int32(1)))
+			},
+		},
+		{
+			name:         "waits for a missing ingest pipeline",
+			index:        func(index *esv1.Index) { index.Spec.FinalPipeline = "normalize" },
+			methods:      []string{},
+			requeueAfter: retryBaseDelay,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				condition := meta.FindStatusCondition(index.Status.Conditions, esv1.ConditionProvisioned)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Message).To(ContainSubstring("IngestPipeline normalize not found"))
+			},
+		},
+		{
+			name: "provisions an Index with ingest pipelines",
+			index: func(index *esv1.Index) {
+				index.Spec.DefaultPipeline = "normalize"
+				index.Spec.FinalPipeline = "_none"
+			},
+			objects: []client.Object{ingestPipeline("normalize", true)},
+			methods: []string{"ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep", "ProvisionStep"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.Ready))
+			},
+		},
+		{
+			name:    "validates the Index in dry run",
+			index:   func(index *esv1.Index) { index.Spec.DryRun = true },
+			methods: []string{"DryRunIndex"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Status.IndexStatus).To(Equal(esv1.DryRun))
+				g.Expect(index.Status.DryRun).NotTo(BeNil())
+				g.Expect(meta.IsStatusConditionTrue(index.Status.Conditions, esv1.ConditionValidated)).To(BeTrue())
+				err := c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: secretName}, &coreV1.Secret{})
+				g.Expect(errors.IsNotFound(err)).To(BeTrue())
+			},
+		},
+		{
+			name: "removes the resources when the Index is deleted",
+			index: func(index *esv1.Index) {
+				now := metav1.Now()
+				index.DeletionTimestamp = &now
+				index.Finalizers = []string{finalizerName}
+				index.Status.IndexStatus = esv1.Ready
+			},
+			objects: []client.Object{readySecret()},
+			methods: []string{"RemoveIndex"},
+			removed: []string{"es-provisioner-app-test-1", "es-provisioner-app-test", "app-test-role", "app-test-role-user"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Finalizers).NotTo(ContainElement(finalizerName))
+				err := c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: secretName}, &coreV1.Secret{})
+				g.Expect(errors.IsNotFound(err)).To(BeTrue())
+			},
+		},
+		{
+			name: "removes the resources recorded in the status when the Secret was changed",
+			index: func(index *esv1.Index) {
+				now := metav1.Now()
+				index.DeletionTimestamp = &now
+				index.Finalizers = []string{finalizerName}
+				index.Status.IndexStatus = esv1.Ready
+				index.Status.Elasticsearch = esv1.ElasticsearchResources{
+					Index: "es-provisioner-app-test-2", Alias: "es-provisioner-app-test", Role: "app-test-role", User: "app-test-role-user",
+				}
+			},
+			objects: []client.Object{&coreV1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: testNamespace,
+					Annotations: map[string]string{esv1.SecretOwnerAnnotation: "index"}},
+				Data: map[string][]byte{"index": []byte("other-index"), "role": []byte("other-role")},
+			}},
+			methods: []string{"RemoveIndex"},
+			removed: []string{"es-provisioner-app-test-2", "es-provisioner-app-test", "app-test-role", "app-test-role-user"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Finalizers).NotTo(ContainElement(finalizerName))
+				err := c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: secretName}, &coreV1.Secret{})
+				g.Expect(errors.IsNotFound(err)).To(BeTrue())
+			},
+		},
+		{
+			name: "removes the resources recorded in the status when the Secret was deleted",
+			index: func(index *esv1.Index) {
+				now := metav1.Now()
+				index.DeletionTimestamp = &now
+				index.Finalizers = []string{finalizerName}
+				index.Status.IndexStatus = esv1.Error
+				index.Status.Elasticsearch = esv1.ElasticsearchResources{Index: "es-provisioner-app-test-1", Alias: "es-provisioner-app-test"}
+			},
+			methods: []string{"RemoveIndex"},
+			removed: []string{"es-provisioner-app-test-1", "es-provisioner-app-test", "", ""},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Finalizers).NotTo(ContainElement(finalizerName))
+			},
+		},
+		{
+			name: "keeps the Secret and the resources of a sibling Index when a pending Index is deleted",
+			index: func(index *esv1.Index) {
+				now := metav1.Now()
+				index.DeletionTimestamp = &now
+				index.Finalizers = []string{finalizerName}
+				index.Status.IndexStatus = esv1.Creating
+			},
+			objects: []client.Object{readyIndex(), siblingSecret()},
+			methods: []string{},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Finalizers).NotTo(ContainElement(finalizerName))
+				g.Expect(string(testSecret(g, c).Data["_index"])).To(Equal("es-provisioner-app-test-1"))
+			},
+		},
+		{
+			name: "keeps the Secret of a sibling Index when a Ready Index is deleted",
+			index: func(index *esv1.Index) {
+				now := metav1.Now()
+				index.DeletionTimestamp = &now
+				index.Finalizers = []string{finalizerName}
+				index.Status.IndexStatus = esv1.Ready
+				index.Status.Elasticsearch = esv1.ElasticsearchResources{
+					Index: "es-provisioner-other-test-1", Alias: "es-provisioner-other-test", Role: "other-test-role", User: "other-test-role-user",
+				}
+			},
+			objects: []client.Object{readyIndex(), siblingSecret()},
+			methods: []string{"RemoveIndex"},
+			removed: []string{"es-provisioner-other-test-1", "es-provisioner-other-test", "other-test-role", "other-test-role-user"},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Finalizers).NotTo(ContainElement(finalizerName))
+				g.Expect(string(testSecret(g, c).Data["_index"])).To(Equal("es-provisioner-app-test-1"))
+			},
+		},
+		{
+			name: "removes the finalizer of an Index never provisioned",
+			index: func(index *esv1.Index) {
+				now := metav1.Now()
+				index.DeletionTimestamp = &now
+				index.Finalizers = []string{finalizerName}
+				index.Status.IndexStatus = esv1.Error
+			},
+			methods: []string{},
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Finalizers).NotTo(ContainElement(finalizerName))
+			},
+		},
+		{
+			name: "keeps the finalizer when the resources cannot be removed",
+			index: func(index *esv1.Index) {
+				now := metav1.Now()
+				index.DeletionTimestamp = &now
+				index.Finalizers = []string{finalizerName}
+				index.Status.IndexStatus = esv1.Ready
+			},
+			objects: []client.Object{readySecret()},
+			fail:    map[string]error{"RemoveIndex": &es.EsError{Action: "delete role", Status: http.StatusForbidden}},
+			methods: []string{"RemoveIndex"},
+			err:     true,
+			verify: func(g *WithT, c client.Client, index *esv1.Index) {
+				g.Expect(index.Finalizers).To(ContainElement(finalizerName))
+				g.Expect(meta.IsStatusConditionTrue(index.Status.Conditions, esv1.ConditionCleanupBlocked)).To(BeTrue())
+				testSecret(g, c)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			index := &esv1.Index{
+				ObjectMeta: metav1.ObjectMeta{Name: "index", Namespace: testNamespace, Generation: 1},
+				Spec: esv1.IndexSpec{
+					Application: "app",
+					Properties:  `"name": {"type": "text"}`,
+				},
+			}
+			if tt.index != nil {
+				tt.index(index)
+			}
+			objects := append([]client.Object{
+				&coreV1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: testNamespace, Annotations: tt.annotations}},
+				index,
+			}, tt.objects...)
+
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(objects...).Build()
+			service := esfake.NewService()
+			service.Health = tt.health
+			service.UpdatedIndex = tt.updatedIndex
+			service.Migration = tt.migration
+			service.DriftReport = tt.driftReport
+			for method, err := range tt.fail {
+				service.Fail(method, err)
+			}
+			r := &IndexReconciler{
+				Client:       c,
+				Scheme:       c.Scheme(),
+				EsService:    service,
+				Recorder:     record.NewFakeRecorder(100),
+				Naming:       tt.naming,
+				ResyncPeriod: tt.resync,
+			}
+			if tt.health != nil {
+				r.HealthGate = &HealthGate{MaxPendingTasks: 10, DiskWatermark: true, MaxShardsPercent: 90}
+			}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(index)})
+			if tt.err {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			g.Expect(result.RequeueAfter).To(BeNumerically("~", tt.requeueAfter, time.Second))
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+			for _, call := range service.Calls() {
+				if ops, ok := call.Args.(es.EsRemoveOptions); ok && tt.removed != nil {
+					g.Expect([]string{ops.Index, ops.Alias, ops.Role, ops.User}).To(Equal(tt.removed))
+				}
+				if tt.checked == nil {
+					continue
+				}
+				switch ops := call.Args.(type) {
+				case es.EsCheckOptions:
+					g.Expect([]string{ops.Index, ops.Alias, ops.Role, ops.User, ops.Password}).To(Equal(tt.checked))
+				case es.EsUpdateOptions:
+					g.Expect([]string{ops.Index, ops.Alias, ops.Role}).To(Equal(tt.checked))
+				case es.EsSynonymOptions:
+					g.Expect([]string{ops.Index, ops.Alias}).To(Equal(tt.checked))
+				case string:
+					g.Expect([]string{ops}).To(Equal(tt.checked))
+				}
+			}
+
+			if tt.verify != nil {
+				var updated esv1.Index
+				err := c.Get(ctx, client.ObjectKeyFromObject(index), &updated)
+				if errors.IsNotFound(err) {
+					// deleted once the finalizer was removed
+					updated = *index
+					updated.Finalizers = nil
+				} else {
+					g.Expect(err).NotTo(HaveOccurred())
+				}
+				tt.verify(g, c, &updated)
+			}
+		})
+	}
+}
+
+func testScheme(g *WithT) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	g.Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	g.Expect(esv1.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
+
+// readySecret is the Secret of a provisioned Index in the test namespace
+func readySecret() *coreV1.Secret {
+	return &coreV1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: testNamespace},
+		Data: map[string][]byte{
+			"username": []byte("app-test-role-user"),
+			"password": []byte("password"),
+			"index":    []byte("es-provisioner-app-test"),
+			"_index":   []byte("es-provisioner-app-test-1"),
+			"role":     []byte("app-test-role"),
+		},
+	}
+}
+
+// siblingSecret is the readySecret written by the readyIndex
+func siblingSecret() *coreV1.Secret {
+	secret := readySecret()
+	secret.Annotations = map[string]string{esv1.SecretOwnerAnnotation: "index-sample"}
+	return secret
+}
+
+// ownedSecret is the Secret written by the test Index, naming an index older than the one in its status
+func ownedSecret() *coreV1.Secret {
+	secret := readySecret()
+	secret.Annotations = map[string]string{esv1.SecretOwnerAnnotation: "index"}
+	return secret
+}
+
+// driftedIndex makes the test Index Ready with its resources recorded in the status
+func driftedIndex(index *esv1.Index) {
+	index.Status.IndexStatus = esv1.Ready
+	index.Status.ObservedGeneration = index.Generation
+	index.Status.Elasticsearch = esv1.ElasticsearchResources{Index: "es-provisioner-app-test-2", Alias: "es-provisioner-app-test",
+		Role: "app-test-role", User: "app-test-role-user"}
+}
+
+// readyIndex is a provisioned Index of the test namespace, with the readySecret
+func readyIndex() *esv1.Index {
+	return &esv1.Index{
+		ObjectMeta: metav1.ObjectMeta{Name: "index-sample", Namespace: testNamespace, Generation: 1},
+		Spec:       esv1.IndexSpec{Application: "app"},
+		Status:     esv1.IndexStatus{IndexStatus: esv1.Ready, ObservedGeneration: 1},
+	}
+}
+
+// componentTemplate is a ComponentTemplate at generation 2, synced to Elasticsearch or not
+func componentTemplate(name string, synced bool) *esv1.ComponentTemplate {
+	template := &esv1.ComponentTemplate{ObjectMeta: metav1.ObjectMeta{Name: name, Generation: 2}}
+	if synced {
+		template.Status.ObservedGeneration = 2
+		template.Status.Conditions = []metav1.Condition{{
+			Type:               esv1.ConditionSynced,
+			Status:             metav1.ConditionTrue,
+			Reason:             reasonSynced,
+			ObservedGeneration: 2,
+			LastTransitionTime: metav1.Now(),
+		}}
+	}
+	return template
+}
+
+// ingestPipeline is an IngestPipeline of the test namespace at generation 2, synced to Elasticsearch or not
+func ingestPipeline(name string, synced bool) *esv1.IngestPipeline {
+	pipeline := &esv1.IngestPipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Generation: 2},
+		Spec:       esv1.IngestPipelineSpec{Processors: "[]"},
+	}
+	if synced {
+		pipeline.Status.ObservedGeneration = 2
+		pipeline.Status.Conditions = []metav1.Condition{{
+			Type:               esv1.ConditionSynced,
+			Status:             metav1.ConditionTrue,
+			Reason:             reasonSynced,
+			ObservedGeneration: 2,
+			LastTransitionTime: metav1.Now(),
+		}}
+	}
+	return pipeline
+}
+
+// indexQuota is an IndexQuota of the test namespace
+func indexQuota(spec esv1.IndexQuotaSpec) *esv1.IndexQuota {
+	return &esv1.IndexQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: testNamespace, Generation: 1},
+		Spec:       spec,
+	}
+}
+
+// otherIndex is a Ready Index of another namespace using the alias
+func otherIndex(alias string) *esv1.Index {
+	index := readyIndex()
+	index.Namespace = "other"
+	index.Status.Elasticsearch = esv1.ElasticsearchResources{Index: alias + "-1", Alias: alias}
+	return index
+}
+
+func mustPolicy(template string, forceNamespacePrefix bool) *naming.Policy {
+	policy, err := naming.NewPolicy(template, forceNamespacePrefix)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func limit(n int32) *int32 {
+	return &n
+}
+
+func testSecret(g *WithT, c client.Client) *coreV1.Secret {
+	var secret coreV1.Secret
+	g.Expect(c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: secretName}, &secret)).To(Succeed())
+	return &secret
+}
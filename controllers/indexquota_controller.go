@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// quotaRefresh is how often the usage of an IndexQuota is measured, the store size grows without Index changes
+const quotaRefresh = 5 * time.Minute
+
+// IndexQuotaReconciler measures the usage of the namespace of an IndexQuota
+type IndexQuotaReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	EsService es.EsService
+	Recorder  record.EventRecorder
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexquotas,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexquotas/status,verbs=get;update;patch
+
+// Reconcile updates the usage of the namespace in the status and reports the exceeded limits in the
+// WithinQuota condition. Exceeding a quota doesn't change the existing Indices, it blocks the new ones.
+func (r *IndexQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var quota esv1.IndexQuota
+	if err := r.Get(ctx, req.NamespacedName, &quota); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	usage, err := namespaceUsage(ctx, r.Client, r.EsService, quota.Namespace, "")
+	if err != nil {
+		log.Error(err, "unable to measure namespace usage")
+		return ctrl.Result{}, err
+	}
+	quota.Status.Used = *usage
+	now := v1.Now()
+	quota.Status.LastCheckedTime = &now
+
+	if exceeded := quota.Spec.Exceeded(usage, nil); len(exceeded) > 0 {
+		message := strings.Join(exceeded, ", ")
+		if setResourceCondition(&quota.Status.Conditions, esv1.ConditionWithinQuota, quota.Generation, v1.ConditionFalse,
+			reasonQuotaExceeded, message) {
+			r.Recorder.Event(&quota, coreV1.EventTypeWarning, reasonQuotaExceeded, message)
+		}
+	} else {
+		setResourceCondition(&quota.Status.Conditions, esv1.ConditionWithinQuota, quota.Generation, v1.ConditionTrue,
+			reasonWithinQuota, "Usage within the quota limits")
+	}
+	if err := r.Status().Update(ctx, &quota); err != nil {
+		log.Error(err, "Error updating status")
+	}
+	return ctrl.Result{RequeueAfter: quotaRefresh}, nil
+}
+
+// findQuotasForIndex maps an Index to the IndexQuotas of its namespace
+func (r *IndexQuotaReconciler) findQuotasForIndex(index client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+	var quotas esv1.IndexQuotaList
+	if err := r.List(context.Background(), &quotas, client.InNamespace(index.GetNamespace())); err != nil {
+		return requests
+	}
+	for _, item := range quotas.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IndexQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&esv1.IndexQuota{}).
+		WithOptions(r.Controller.options()).
+		Watches(&source.Kind{Type: &esv1.Index{}}, handler.EnqueueRequestsFromMapFunc(r.findQuotasForIndex)).
+		Complete(r)
+}
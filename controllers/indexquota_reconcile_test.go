@@ -0,0 +1,138 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIndexQuotaReconcile(t *testing.T) {
+	provisioned := readyIndex()
+	provisioned.Status.Elasticsearch = esv1.ElasticsearchResources{Index: "es-provisioner-app-test-1", Alias: "es-provisioner-app-test"}
+	pending := &esv1.Index{
+		ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: testNamespace},
+		Spec:       esv1.IndexSpec{Application: "other", NumberOfShards: 2, NumberOfReplicas: 2},
+	}
+	measured := []es.EsIndexUsage{{Name: "es-provisioner-app-test-1", Primaries: 4, Replicas: 1, StoreSize: 3 << 30}}
+	maxStore := resource.MustParse("2Gi")
+
+	tests := []struct {
+		name    string
+		spec    esv1.IndexQuotaSpec
+		objects []client.Object
+		usage   []es.EsIndexUsage
+		fail    error
+		// names of the indices measured in Elasticsearch
+		names  []string
+		err    bool
+		verify func(g *WithT, quota *esv1.IndexQuota, recorder *record.FakeRecorder)
+	}{
+		{
+			name:    "measures the provisioned Indices and counts the other ones with their spec",
+			spec:    esv1.IndexQuotaSpec{MaxIndices: limit(2), MaxPrimaryShards: limit(8)},
+			objects: []client.Object{provisioned, pending},
+			usage:   measured,
+			names:   []string{"es-provisioner-app-test"},
+			verify: func(g *WithT, quota *esv1.IndexQuota, recorder *record.FakeRecorder) {
+				g.Expect(quota.Status.Used.Indices).To(Equal(int32(2)))
+				g.Expect(quota.Status.Used.PrimaryShards).To(Equal(int32(6)))
+				g.Expect(quota.Status.Used.Replicas).To(Equal(int32(2)))
+				g.Expect(quota.Status.Used.StoreSize.String()).To(Equal("3Gi"))
+				g.Expect(quota.Status.LastCheckedTime).NotTo(BeNil())
+				g.Expect(meta.IsStatusConditionTrue(quota.Status.Conditions, esv1.ConditionWithinQuota)).To(BeTrue())
+			},
+		},
+		{
+			name:    "reports the limits exceeded",
+			spec:    esv1.IndexQuotaSpec{MaxReplicas: limit(1), MaxStoreSize: &maxStore},
+			objects: []client.Object{provisioned, pending},
+			usage:   measured,
+			names:   []string{"es-provisioner-app-test"},
+			verify: func(g *WithT, quota *esv1.IndexQuota, recorder *record.FakeRecorder) {
+				condition := meta.FindStatusCondition(quota.Status.Conditions, esv1.ConditionWithinQuota)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+				g.Expect(condition.Reason).To(Equal(reasonQuotaExceeded))
+				g.Expect(condition.Message).To(Equal("maximum 1 replicas exceeded: 2, maximum store size 2Gi exceeded: 3Gi"))
+				g.Expect(recorder.Events).To(Receive(ContainSubstring(reasonQuotaExceeded)))
+			},
+		},
+		{
+			name:  "reports an empty namespace within the quota",
+			spec:  esv1.IndexQuotaSpec{MaxIndices: limit(0)},
+			names: []string{},
+			verify: func(g *WithT, quota *esv1.IndexQuota, recorder *record.FakeRecorder) {
+				g.Expect(quota.Status.Used.Indices).To(BeZero())
+				g.Expect(meta.IsStatusConditionTrue(quota.Status.Conditions, esv1.ConditionWithinQuota)).To(BeTrue())
+			},
+		},
+		{
+			name:    "retries when the usage can't be measured",
+			objects: []client.Object{provisioned},
+			fail:    &es.EsError{Action: "get indices usage", Status: http.StatusServiceUnavailable},
+			names:   []string{"es-provisioner-app-test"},
+			err:     true,
+			verify: func(g *WithT, quota *esv1.IndexQuota, recorder *record.FakeRecorder) {
+				g.Expect(quota.Status.LastCheckedTime).To(BeNil())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			quota := indexQuota(tt.spec)
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(append(tt.objects, quota)...).Build()
+			service := esfake.NewService()
+			service.IndexUsage = tt.usage
+			service.Fail("CatIndices", tt.fail)
+			recorder := record.NewFakeRecorder(100)
+			r := &IndexQuotaReconciler{Client: c, Scheme: c.Scheme(), EsService: service, Recorder: recorder}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(quota)})
+			if tt.err {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.RequeueAfter).To(Equal(quotaRefresh))
+			}
+			g.Expect(service.Methods()).To(Equal([]string{"CatIndices"}))
+			g.Expect(service.Calls()[0].Args).To(Equal(tt.names))
+
+			var updated esv1.IndexQuota
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(quota), &updated)).To(Succeed())
+			tt.verify(g, &updated, recorder)
+		})
+	}
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// IndexRestoreReconciler restores a snapshot of an Index into a new index and moves the alias to it
+type IndexRestoreReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	EsService es.EsService
+	Recorder  record.EventRecorder
+	// ClusterName is recorded as the owner of the role with the Index
+	ClusterName string
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
+}
+
+// restoreSource is the index of a snapshot to restore
+type restoreSource struct {
+	repository string
+	snapshot   string
+	index      string
+}
+
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexrestores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexrestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexrestores/finalizers,verbs=update
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexsnapshots,verbs=get;list;watch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=snapshotschedules,verbs=get;list;watch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indices/status,verbs=get;update;patch
+
+// Reconcile restores the snapshot as a new index once the Index is Ready, and when it's recovered moves the alias
+// and the role to it and updates the Secret and the Index status. Only snapshots of the Index namespace can be
+// restored.
+func (r *IndexRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var restore esv1.IndexRestore
+	if err := r.Get(ctx, req.NamespacedName, &restore); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	switch restore.Status.Phase {
+	case esv1.RestoreCompleted, esv1.RestoreFailed:
+		return ctrl.Result{}, nil
+	case esv1.Restoring:
+		return r.checkRestore(ctx, &restore)
+	}
+
+	secret, waiting, err := readyIndexSecret(ctx, r.Client, restore.Namespace, restore.Spec.Index)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	alias := ""
+	var source *restoreSource
+	if waiting == "" {
+		alias = string(secret.Data["index"])
+		source, waiting, err = r.source(ctx, &restore, alias)
+		if err != nil {
+			return r.restoreError(ctx, &restore, err)
+		}
+	}
+	if waiting != "" {
+		log.V(1).Info("Waiting to restore", "reason", waiting)
+		if restore.Status.Phase != esv1.RestorePending || restore.Status.Message != waiting {
+			restore.Status.Phase = esv1.RestorePending
+			restore.Status.Message = waiting
+			r.updateStatus(ctx, &restore)
+		}
+		return ctrl.Result{RequeueAfter: indexReadyWait}, nil
+	}
+
+	// the new index is stored before the restore so a retry doesn't restore it twice
+	started := false
+	if restore.Status.RestoredIndex == "" {
+		restore.Status.Phase = esv1.RestorePending
+		restore.Status.Repository = source.repository
+		restore.Status.Snapshot = source.snapshot
+		restore.Status.SourceIndex = source.index
+		restore.Status.RestoredIndex = alias + "-" + time.Now().Format("2006-01-02-150405")
+		restore.Status.PreviousIndex = string(secret.Data["_index"])
+		if err := r.Status().Update(ctx, &restore); err != nil {
+			return ctrl.Result{}, err
+		}
+	} else {
+		indices, err := r.EsService.GetIndices(restore.Status.RestoredIndex + "*")
+		if err != nil {
+			return r.restoreError(ctx, &restore, err)
+		}
+		for _, index := range indices {
+			started = started || index.Name == restore.Status.RestoredIndex
+		}
+	}
+
+	if !started {
+		err = r.EsService.RestoreSnapshot(&es.EsRestoreOptions{
+			Repository: restore.Status.Repository,
+			Snapshot:   restore.Status.Snapshot,
+			Index:      restore.Status.SourceIndex,
+			Target:     restore.Status.RestoredIndex,
+		})
+		if err != nil {
+			return r.restoreError(ctx, &restore, err)
+		}
+	}
+
+	restore.Status.Phase = esv1.Restoring
+	restore.Status.Message = ""
+	r.updateStatus(ctx, &restore)
+	r.Recorder.Eventf(&restore, coreV1.EventTypeNormal, reasonRestoreStarted, "Restoring Index %s from Snapshot %s as %s",
+		restore.Status.SourceIndex, restore.Status.Snapshot, restore.Status.RestoredIndex)
+	return ctrl.Result{RequeueAfter: snapshotWait}, nil
+}
+
+// source resolves the snapshot to restore, the message explains what it's waiting for
+func (r *IndexRestoreReconciler) source(ctx context.Context, restore *esv1.IndexRestore, alias string) (*restoreSource, string, error) {
+	switch {
+	case restore.Spec.IndexSnapshot != "" && restore.Spec.SnapshotSchedule == "":
+		var snapshot esv1.IndexSnapshot
+		if err := r.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.IndexSnapshot}, &snapshot); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, fmt.Sprintf("IndexSnapshot %s not found", restore.Spec.IndexSnapshot), nil
+			}
+			return nil, "", err
+		}
+		if snapshot.Spec.Index != restore.Spec.Index {
+			return nil, "", &es.ValidationError{Reason: fmt.Sprintf("IndexSnapshot %s is a snapshot of Index %s",
+				snapshot.Name, snapshot.Spec.Index)}
+		}
+		switch snapshot.Status.Phase {
+		case esv1.SnapshotCompleted:
+			return &restoreSource{repository: snapshot.Status.Repository, snapshot: snapshot.Status.Snapshot,
+				index: snapshot.Status.Index}, "", nil
+		case esv1.SnapshotFailed:
+			return nil, "", &es.ValidationError{Reason: fmt.Sprintf("IndexSnapshot %s failed", snapshot.Name)}
+		}
+		return nil, fmt.Sprintf("IndexSnapshot %s is not Completed", snapshot.Name), nil
+
+	case restore.Spec.SnapshotSchedule != "" && restore.Spec.IndexSnapshot == "":
+		if restore.Spec.SnapshotName == "" {
+			return nil, "", &es.ValidationError{Reason: "spec.snapshotName is required with spec.snapshotSchedule"}
+		}
+		var schedule esv1.SnapshotSchedule
+		if err := r.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.SnapshotSchedule}, &schedule); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, fmt.Sprintf("SnapshotSchedule %s not found", restore.Spec.SnapshotSchedule), nil
+			}
+			return nil, "", err
+		}
+		if schedule.Spec.Index != restore.Spec.Index {
+			return nil, "", &es.ValidationError{Reason: fmt.Sprintf("SnapshotSchedule %s takes snapshots of Index %s",
+				schedule.Name, schedule.Spec.Index)}
+		}
+		if schedule.Status.Repository == "" {
+			return nil, fmt.Sprintf("SnapshotSchedule %s is not synced to Elasticsearch yet", schedule.Name), nil
+		}
+		info, err := r.EsService.GetSnapshot(schedule.Status.Repository, restore.Spec.SnapshotName)
+		if err != nil {
+			return nil, "", err
+		}
+		if info == nil {
+			return nil, "", &es.ValidationError{Reason: fmt.Sprintf("snapshot %s not found in Repository %s",
+				restore.Spec.SnapshotName, schedule.Status.Repository)}
+		}
+		if info.State != es.SnapshotSuccess {
+			return nil, "", &es.ValidationError{Reason: fmt.Sprintf("snapshot %s is %s", info.Snapshot, info.State)}
+		}
+		// the repository is shared by the namespaces, only the snapshots of the schedule and the indices of the
+		// Index can be restored
+		if info.Policy != schedulePolicyID(&schedule) {
+			return nil, "", &es.ValidationError{Reason: fmt.Sprintf("snapshot %s wasn't taken by SnapshotSchedule %s",
+				info.Snapshot, schedule.Name)}
+		}
+		for _, index := range info.Indices {
+			if strings.HasPrefix(index, alias+"-") {
+				return &restoreSource{repository: schedule.Status.Repository, snapshot: info.Snapshot, index: index}, "", nil
+			}
+		}
+		return nil, "", &es.ValidationError{Reason: fmt.Sprintf("snapshot %s doesn't contain an index of Index %s",
+			info.Snapshot, restore.Spec.Index)}
+	}
+	return nil, "", &es.ValidationError{Reason: "set either spec.indexSnapshot or spec.snapshotSchedule"}
+}
+
+// checkRestore moves the alias and the role to the restored index once it's recovered
+func (r *IndexRestoreReconciler) checkRestore(ctx context.Context, restore *esv1.IndexRestore) (ctrl.Result, error) {
+	recovered, err := r.EsService.IndexRecovered(restore.Status.RestoredIndex)
+	if err != nil {
+		return r.restoreError(ctx, restore, err)
+	}
+	if !recovered {
+		return ctrl.Result{RequeueAfter: snapshotWait}, nil
+	}
+
+	secret, waiting, err := readyIndexSecret(ctx, r.Client, restore.Namespace, restore.Spec.Index)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if waiting != "" {
+		if restore.Status.Message != waiting {
+			restore.Status.Message = waiting
+			r.updateStatus(ctx, restore)
+		}
+		return ctrl.Result{RequeueAfter: indexReadyWait}, nil
+	}
+
+	var index esv1.Index
+	if err := r.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.Index}, &index); err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.EsService.SwapIndex(&es.EsSwapOptions{
+		From:       restore.Status.PreviousIndex,
+		To:         restore.Status.RestoredIndex,
+		Alias:      string(secret.Data["index"]),
+		Role:       string(secret.Data["role"]),
+		DeleteFrom: restore.Spec.DeletePreviousIndex,
+		Owner:      indexspec.Owner(&index, r.ClusterName),
+	})
+	if err != nil {
+		return r.restoreError(ctx, restore, err)
+	}
+
+	secret.Data["_index"] = []byte(restore.Status.RestoredIndex)
+	if err := r.Update(ctx, secret); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Eventf(restore, coreV1.EventTypeNormal, reasonSecretUpdated, "Secret %s updated with Index %s",
+		secretName, restore.Status.RestoredIndex)
+	// the Index removes the restored index when it's deleted
+	index.Status.Elasticsearch.Index = restore.Status.RestoredIndex
+	if err := r.Status().Update(ctx, &index); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := v1.Now()
+	restore.Status.Phase = esv1.RestoreCompleted
+	restore.Status.CompletionTime = &now
+	restore.Status.Message = ""
+	r.updateStatus(ctx, restore)
+	r.Recorder.Eventf(restore, coreV1.EventTypeNormal, reasonRestoreCompleted, "Index %s restored, alias moved from %s",
+		restore.Status.RestoredIndex, restore.Status.PreviousIndex)
+	return ctrl.Result{}, nil
+}
+
+// restoreError retries transient errors, the other ones fail the IndexRestore
+func (r *IndexRestoreReconciler) restoreError(ctx context.Context, restore *esv1.IndexRestore, err error) (ctrl.Result, error) {
+	if es.IsTransient(err) {
+		log.FromContext(ctx).Error(err, "unable to restore index")
+		if restore.Status.Message != err.Error() {
+			restore.Status.Message = err.Error()
+			r.updateStatus(ctx, restore)
+		}
+		return ctrl.Result{}, err
+	}
+	log.FromContext(ctx).Info("Restore failed", "reason", err.Error())
+	r.Recorder.Event(restore, coreV1.EventTypeWarning, reasonRestoreFailed, err.Error())
+	restore.Status.Phase = esv1.RestoreFailed
+	restore.Status.Message = err.Error()
+	r.updateStatus(ctx, restore)
+	return ctrl.Result{}, nil
+}
+
+func (r *IndexRestoreReconciler) updateStatus(ctx context.Context, restore *esv1.IndexRestore) {
+	if err := r.Status().Update(ctx, restore); err != nil {
+		log.FromContext(ctx).Error(err, "Error updating status")
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IndexRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&esv1.IndexRestore{}).
+		WithOptions(r.Controller.options()).
+		Complete(r)
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIndexRestoreReconcile(t *testing.T) {
+	fromSchedule := func(restore *esv1.IndexRestore) {
+		restore.Spec.IndexSnapshot = ""
+		restore.Spec.SnapshotSchedule = "nightly"
+		restore.Spec.SnapshotName = "nightly-2026.10.18-abc"
+	}
+	nightlyPolicy := "es-provisioner-" + testNamespace + "-nightly"
+	restoring := func(restore *esv1.IndexRestore) {
+		restore.Status = esv1.IndexRestoreStatus{
+			Phase:         esv1.Restoring,
+			Repository:    "backups",
+			Snapshot:      "test-before-upgrade",
+			SourceIndex:   "es-provisioner-app-test-1",
+			RestoredIndex: "es-provisioner-app-test-restored",
+			PreviousIndex: "es-provisioner-app-test-1",
+		}
+	}
+
+	tests := []struct {
+		name string
+		// restore is changed from a new IndexRestore of the before-upgrade IndexSnapshot
+		restore func(restore *esv1.IndexRestore)
+		// snapshot is changed from a Completed IndexSnapshot
+		snapshot     func(snapshot *esv1.IndexSnapshot)
+		info         *es.EsSnapshotInfo
+		recovering   bool
+		fail         map[string]error
+		methods      []string
+		requeueAfter time.Duration
+		err          bool
+		verify       func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service)
+	}{
+		{
+			name:         "restores an IndexSnapshot into a new index",
+			methods:      []string{"RestoreSnapshot"},
+			requeueAfter: snapshotWait,
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.Restoring))
+				g.Expect(restore.Status.RestoredIndex).To(HavePrefix("es-provisioner-app-test-"))
+				g.Expect(restore.Status.PreviousIndex).To(Equal("es-provisioner-app-test-1"))
+				ops := service.Calls()[0].Args.(es.EsRestoreOptions)
+				g.Expect(ops).To(Equal(es.EsRestoreOptions{
+					Repository: "backups",
+					Snapshot:   "test-before-upgrade",
+					Index:      "es-provisioner-app-test-1",
+					Target:     restore.Status.RestoredIndex,
+				}))
+			},
+		},
+		{
+			name:         "waits for the IndexSnapshot to complete",
+			snapshot:     func(snapshot *esv1.IndexSnapshot) { snapshot.Status.Phase = esv1.SnapshotInProgress },
+			methods:      []string{},
+			requeueAfter: indexReadyWait,
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.RestorePending))
+				g.Expect(restore.Status.Message).To(Equal("IndexSnapshot before-upgrade is not Completed"))
+			},
+		},
+		{
+			name:     "rejects an IndexSnapshot of another Index",
+			snapshot: func(snapshot *esv1.IndexSnapshot) { snapshot.Spec.Index = "other" },
+			methods:  []string{},
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.RestoreFailed))
+				g.Expect(restore.Status.Message).To(Equal("IndexSnapshot before-upgrade is a snapshot of Index other"))
+			},
+		},
+		{
+			name:    "requires a single snapshot source",
+			restore: func(restore *esv1.IndexRestore) { restore.Spec.SnapshotSchedule = "nightly" },
+			methods: []string{},
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.RestoreFailed))
+				g.Expect(restore.Status.Message).To(Equal("set either spec.indexSnapshot or spec.snapshotSchedule"))
+			},
+		},
+		{
+			name:    "restores the index of the Index from a scheduled snapshot",
+			restore: fromSchedule,
+			info: &es.EsSnapshotInfo{Snapshot: "nightly-2026.10.18-abc", State: es.SnapshotSuccess, Policy: nightlyPolicy,
+				Indices: []string{"es-provisioner-app-other-1", "es-provisioner-app-test-2"}},
+			methods:      []string{"GetSnapshot", "RestoreSnapshot"},
+			requeueAfter: snapshotWait,
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.Restoring))
+				g.Expect(restore.Status.Snapshot).To(Equal("nightly-2026.10.18-abc"))
+				g.Expect(restore.Status.SourceIndex).To(Equal("es-provisioner-app-test-2"))
+			},
+		},
+		{
+			name:    "rejects a scheduled snapshot without an index of the Index",
+			restore: fromSchedule,
+			info: &es.EsSnapshotInfo{Snapshot: "nightly-2026.10.18-abc", State: es.SnapshotSuccess, Policy: nightlyPolicy,
+				Indices: []string{"es-provisioner-app-other-1"}},
+			methods: []string{"GetSnapshot"},
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.RestoreFailed))
+				g.Expect(restore.Status.Message).To(ContainSubstring("doesn't contain an index of Index index-sample"))
+			},
+		},
+		{
+			name:    "rejects a snapshot of the repository not taken by the schedule",
+			restore: fromSchedule,
+			info: &es.EsSnapshotInfo{Snapshot: "nightly-2026.10.18-abc", State: es.SnapshotSuccess, Policy: "es-provisioner-other-nightly",
+				Indices: []string{"es-provisioner-app-test-2"}},
+			methods: []string{"GetSnapshot"},
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.RestoreFailed))
+				g.Expect(restore.Status.Message).To(Equal("snapshot nightly-2026.10.18-abc wasn't taken by SnapshotSchedule nightly"))
+			},
+		},
+		{
+			name: "restores into the stored index when a previous attempt didn't start it",
+			restore: func(restore *esv1.IndexRestore) {
+				restoring(restore)
+				restore.Status.Phase = esv1.RestorePending
+			},
+			methods:      []string{"GetIndices", "RestoreSnapshot"},
+			requeueAfter: snapshotWait,
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.RestoredIndex).To(Equal("es-provisioner-app-test-restored"))
+				g.Expect(service.Calls()[1].Args.(es.EsRestoreOptions).Target).To(Equal("es-provisioner-app-test-restored"))
+			},
+		},
+		{
+			name:    "retries transient errors",
+			fail:    map[string]error{"RestoreSnapshot": &es.EsError{Action: "restore snapshot", Status: http.StatusServiceUnavailable}},
+			methods: []string{"RestoreSnapshot"},
+			err:     true,
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.RestorePending))
+				g.Expect(restore.Status.RestoredIndex).NotTo(BeEmpty())
+				g.Expect(restore.Status.Message).To(ContainSubstring("restore snapshot"))
+			},
+		},
+		{
+			name:         "waits for the restored index to recover",
+			restore:      restoring,
+			recovering:   true,
+			methods:      []string{"IndexRecovered"},
+			requeueAfter: snapshotWait,
+		},
+		{
+			name: "moves the alias and the role to the recovered index",
+			restore: func(restore *esv1.IndexRestore) {
+				restoring(restore)
+				restore.Spec.DeletePreviousIndex = true
+			},
+			methods: []string{"IndexRecovered", "SwapIndex"},
+			verify: func(g *WithT, c client.Client, restore *esv1.IndexRestore, service *esfake.Service) {
+				g.Expect(restore.Status.Phase).To(Equal(esv1.RestoreCompleted))
+				g.Expect(restore.Status.CompletionTime).NotTo(BeNil())
+				g.Expect(service.Calls()[1].Args).To(Equal(es.EsSwapOptions{
+					From:       "es-provisioner-app-test-1",
+					To:         "es-provisioner-app-test-restored",
+					Alias:      "es-provisioner-app-test",
+					Role:       "app-test-role",
+					DeleteFrom: true,
+					Owner:      &es.EsOwner{Namespace: testNamespace, Name: "index-sample"},
+				}))
+				g.Expect(string(testSecret(g, c).Data["_index"])).To(Equal("es-provisioner-app-test-restored"))
+				var index esv1.Index
+				g.Expect(c.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: "index-sample"}, &index)).To(Succeed())
+				g.Expect(index.Status.Elasticsearch.Index).To(Equal("es-provisioner-app-test-restored"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			restore := &esv1.IndexRestore{
+				ObjectMeta: metav1.ObjectMeta{Name: "rollback", Namespace: testNamespace, Generation: 1},
+				Spec:       esv1.IndexRestoreSpec{Index: "index-sample", IndexSnapshot: "before-upgrade"},
+			}
+			if tt.restore != nil {
+				tt.restore(restore)
+			}
+			snapshot := &esv1.IndexSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Name: "before-upgrade", Namespace: testNamespace},
+				Spec:       esv1.IndexSnapshotSpec{Index: "index-sample"},
+				Status: esv1.IndexSnapshotStatus{
+					Phase:      esv1.SnapshotCompleted,
+					Repository: "backups",
+					Snapshot:   "test-before-upgrade",
+					Index:      "es-provisioner-app-test-1",
+				},
+			}
+			if tt.snapshot != nil {
+				tt.snapshot(snapshot)
+			}
+			schedule := &esv1.SnapshotSchedule{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: testNamespace},
+				Spec:       esv1.SnapshotScheduleSpec{Index: "index-sample", Schedule: "0 30 1 * * ?"},
+				Status:     esv1.SnapshotScheduleStatus{Repository: "backups"},
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).
+				WithObjects(readyIndex(), readySecret(), snapshot, schedule, restore).Build()
+			service := esfake.NewService()
+			service.Snapshot = tt.info
+			service.Recovering = tt.recovering
+			for method, err := range tt.fail {
+				service.Fail(method, err)
+			}
+			r := &IndexRestoreReconciler{Client: c, Scheme: c.Scheme(), EsService: service, Recorder: record.NewFakeRecorder(100)}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)})
+			if tt.err {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			g.Expect(result.RequeueAfter).To(Equal(tt.requeueAfter))
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+
+			var updated esv1.IndexRestore
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(restore), &updated)).To(Succeed())
+			if tt.verify != nil {
+				tt.verify(g, c, &updated, service)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	coreV1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const indexSnapshotFinalizer = "indexsnapshot.es-provisioner.com.ramos/finalizer"
+
+// IndexSnapshotReconciler takes a snapshot of the backing index of an Index
+type IndexSnapshotReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	EsService es.EsService
+	Recorder  record.EventRecorder
+	// SnapshotRepository is used when the IndexSnapshot doesn't set the repository
+	SnapshotRepository string
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexsnapshots,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexsnapshots/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indexsnapshots/finalizers,verbs=update
+
+// Reconcile starts the snapshot once the Index is Ready and polls it until it completes. With the Delete
+// deletion policy the snapshot is deleted from the repository with the resource.
+func (r *IndexSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var snapshot esv1.IndexSnapshot
+	if err := r.Get(ctx, req.NamespacedName, &snapshot); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !snapshot.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&snapshot, indexSnapshotFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if snapshot.Spec.DeletionPolicy == esv1.SnapshotDelete && snapshot.Status.Snapshot != "" {
+			if err := r.EsService.DeleteSnapshot(snapshot.Status.Repository, snapshot.Status.Snapshot); err != nil {
+				log.Error(err, "unable to delete snapshot")
+				r.Recorder.Event(&snapshot, coreV1.EventTypeWarning, reasonDeletionFailed, err.Error())
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Eventf(&snapshot, coreV1.EventTypeNormal, reasonSnapshotDeleted, "Snapshot %s deleted from Repository %s",
+				snapshot.Status.Snapshot, snapshot.Status.Repository)
+		}
+		controllerutil.RemoveFinalizer(&snapshot, indexSnapshotFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &snapshot)
+	}
+
+	if !controllerutil.ContainsFinalizer(&snapshot, indexSnapshotFinalizer) {
+		controllerutil.AddFinalizer(&snapshot, indexSnapshotFinalizer)
+		if err := r.Update(ctx, &snapshot); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	switch snapshot.Status.Phase {
+	case esv1.SnapshotCompleted, esv1.SnapshotFailed:
+		return ctrl.Result{}, nil
+	case esv1.SnapshotInProgress:
+		return r.checkSnapshot(ctx, &snapshot)
+	}
+
+	repository := snapshotRepository(snapshot.Spec.Repository, r.SnapshotRepository)
+	if repository == "" {
+		return r.failed(ctx, &snapshot, "no snapshot repository, set spec.repository")
+	}
+
+	secret, waiting, err := readyIndexSecret(ctx, r.Client, snapshot.Namespace, snapshot.Spec.Index)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if waiting != "" {
+		log.V(1).Info("Waiting for Index", "reason", waiting)
+		if snapshot.Status.Phase != esv1.SnapshotPending || snapshot.Status.Message != waiting {
+			snapshot.Status.Phase = esv1.SnapshotPending
+			snapshot.Status.Message = waiting
+			r.updateStatus(ctx, &snapshot)
+		}
+		return ctrl.Result{RequeueAfter: indexReadyWait}, nil
+	}
+
+	name := snapshotName(&snapshot)
+	index := string(secret.Data["_index"])
+	if err := r.EsService.CreateSnapshot(repository, name, []string{index}); err != nil {
+		// started by a previous attempt whose status wasn't updated
+		if info, e := r.EsService.GetSnapshot(repository, name); e != nil || info == nil {
+			return r.snapshotError(ctx, &snapshot, err)
+		}
+	}
+
+	now := v1.Now()
+	snapshot.Status.Phase = esv1.SnapshotInProgress
+	snapshot.Status.Repository = repository
+	snapshot.Status.Snapshot = name
+	snapshot.Status.Index = index
+	snapshot.Status.StartTime = &now
+	snapshot.Status.Message = ""
+	r.updateStatus(ctx, &snapshot)
+	r.Recorder.Eventf(&snapshot, coreV1.EventTypeNormal, reasonSnapshotStarted, "Snapshot %s of Index %s started in Repository %s",
+		name, index, repository)
+	return ctrl.Result{RequeueAfter: snapshotWait}, nil
+}
+
+// checkSnapshot completes the IndexSnapshot when the snapshot finished
+func (r *IndexSnapshotReconciler) checkSnapshot(ctx context.Context, snapshot *esv1.IndexSnapshot) (ctrl.Result, error) {
+	info, err := r.EsService.GetSnapshot(snapshot.Status.Repository, snapshot.Status.Snapshot)
+	if err != nil {
+		return r.snapshotError(ctx, snapshot, err)
+	}
+	if info == nil {
+		return r.failed(ctx, snapshot, fmt.Sprintf("snapshot %s not found in Repository %s", snapshot.Status.Snapshot,
+			snapshot.Status.Repository))
+	}
+
+	switch info.State {
+	case es.SnapshotSuccess:
+		completion := v1.Now()
+		if !info.EndTime.IsZero() {
+			completion = v1.NewTime(info.EndTime)
+		}
+		snapshot.Status.Phase = esv1.SnapshotCompleted
+		snapshot.Status.CompletionTime = &completion
+		snapshot.Status.Message = ""
+		r.updateStatus(ctx, snapshot)
+		r.Recorder.Eventf(snapshot, coreV1.EventTypeNormal, reasonSnapshotCompleted, "Snapshot %s completed", snapshot.Status.Snapshot)
+		return ctrl.Result{}, nil
+	case es.SnapshotPartial, es.SnapshotFailed:
+		message := fmt.Sprintf("snapshot %s is %s", snapshot.Status.Snapshot, info.State)
+		if info.Reason != "" {
+			message += ": " + info.Reason
+		}
+		return r.failed(ctx, snapshot, message)
+	}
+	return ctrl.Result{RequeueAfter: snapshotWait}, nil
+}
+
+// snapshotError retries transient errors, the other ones fail the IndexSnapshot
+func (r *IndexSnapshotReconciler) snapshotError(ctx context.Context, snapshot *esv1.IndexSnapshot, err error) (ctrl.Result, error) {
+	if !es.IsTransient(err) {
+		return r.failed(ctx, snapshot, err.Error())
+	}
+	log.FromContext(ctx).Error(err, "unable to snapshot index")
+	if snapshot.Status.Message != err.Error() {
+		snapshot.Status.Message = err.Error()
+		r.updateStatus(ctx, snapshot)
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *IndexSnapshotReconciler) failed(ctx context.Context, snapshot *esv1.IndexSnapshot, message string) (ctrl.Result, error) {
+	log.FromContext(ctx).Info("Snapshot failed", "reason", message)
+	r.Recorder.Event(snapshot, coreV1.EventTypeWarning, reasonSnapshotFailed, message)
+	snapshot.Status.Phase = esv1.SnapshotFailed
+	snapshot.Status.Message = message
+	r.updateStatus(ctx, snapshot)
+	return ctrl.Result{}, nil
+}
+
+func (r *IndexSnapshotReconciler) updateStatus(ctx context.Context, snapshot *esv1.IndexSnapshot) {
+	if err := r.Status().Update(ctx, snapshot); err != nil {
+		log.FromContext(ctx).Error(err, "Error updating status")
+	}
+}
+
+// snapshotName is the name of the snapshot in the repository, unique for each IndexSnapshot resource
+func snapshotName(snapshot *esv1.IndexSnapshot) string {
+	name := snapshot.Namespace + "-" + snapshot.Name
+	if uid := string(snapshot.UID); len(uid) >= 8 {
+		name += "-" + uid[:8]
+	}
+	return name
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IndexSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&esv1.IndexSnapshot{}).
+		WithOptions(r.Controller.options()).
+		Complete(r)
+}
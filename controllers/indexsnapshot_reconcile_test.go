@@ -0,0 +1,225 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIndexSnapshotReconcile(t *testing.T) {
+	started := func(snapshot *esv1.IndexSnapshot) {
+		now := metav1.Now()
+		snapshot.Finalizers = []string{indexSnapshotFinalizer}
+		snapshot.Status = esv1.IndexSnapshotStatus{
+			Phase:      esv1.SnapshotInProgress,
+			Repository: "backups",
+			Snapshot:   "test-before-upgrade",
+			Index:      "es-provisioner-app-test-1",
+			StartTime:  &now,
+		}
+	}
+	deleted := func(policy esv1.SnapshotDeletionPolicy) func(snapshot *esv1.IndexSnapshot) {
+		return func(snapshot *esv1.IndexSnapshot) {
+			started(snapshot)
+			now := metav1.Now()
+			snapshot.DeletionTimestamp = &now
+			snapshot.Spec.DeletionPolicy = policy
+		}
+	}
+	unavailable := &es.EsError{Action: "create snapshot", Status: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name string
+		// snapshot is changed from a new IndexSnapshot of the readyIndex
+		snapshot func(snapshot *esv1.IndexSnapshot)
+		// index is changed from the readyIndex
+		index             func(index *esv1.Index)
+		defaultRepository string
+		info              *es.EsSnapshotInfo
+		fail              map[string]error
+		methods           []string
+		requeueAfter      time.Duration
+		err               bool
+		verify            func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service)
+	}{
+		{
+			name:              "starts a snapshot of the backing index in the default repository",
+			defaultRepository: "backups",
+			methods:           []string{"CreateSnapshot"},
+			requeueAfter:      snapshotWait,
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Finalizers).To(ContainElement(indexSnapshotFinalizer))
+				g.Expect(snapshot.Status.Phase).To(Equal(esv1.SnapshotInProgress))
+				g.Expect(snapshot.Status.Repository).To(Equal("backups"))
+				g.Expect(snapshot.Status.Snapshot).To(Equal("test-before-upgrade"))
+				g.Expect(snapshot.Status.Index).To(Equal("es-provisioner-app-test-1"))
+				g.Expect(snapshot.Status.StartTime).NotTo(BeNil())
+			},
+		},
+		{
+			name:              "uses the repository of the spec",
+			snapshot:          func(snapshot *esv1.IndexSnapshot) { snapshot.Spec.Repository = "archive" },
+			defaultRepository: "backups",
+			methods:           []string{"CreateSnapshot"},
+			requeueAfter:      snapshotWait,
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Status.Repository).To(Equal("archive"))
+			},
+		},
+		{
+			name:    "fails without a repository",
+			methods: []string{},
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Status.Phase).To(Equal(esv1.SnapshotFailed))
+				g.Expect(snapshot.Status.Message).To(Equal("no snapshot repository, set spec.repository"))
+			},
+		},
+		{
+			name:              "waits for the Index to be Ready",
+			index:             func(index *esv1.Index) { index.Status.IndexStatus = esv1.Creating },
+			defaultRepository: "backups",
+			methods:           []string{},
+			requeueAfter:      indexReadyWait,
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Status.Phase).To(Equal(esv1.SnapshotPending))
+				g.Expect(snapshot.Status.Message).To(Equal("Index index-sample is not Ready"))
+			},
+		},
+		{
+			name:              "continues a snapshot started by a previous attempt",
+			defaultRepository: "backups",
+			fail: map[string]error{"CreateSnapshot": &es.EsError{Action: "create snapshot", Status: http.StatusBadRequest,
+				Type: "invalid_snapshot_name_exception"}},
+			methods:      []string{"CreateSnapshot", "GetSnapshot"},
+			requeueAfter: snapshotWait,
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Status.Phase).To(Equal(esv1.SnapshotInProgress))
+			},
+		},
+		{
+			name:              "retries transient errors",
+			defaultRepository: "backups",
+			fail:              map[string]error{"CreateSnapshot": unavailable, "GetSnapshot": unavailable},
+			methods:           []string{"CreateSnapshot", "GetSnapshot"},
+			err:               true,
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Status.Phase).To(BeEmpty())
+				g.Expect(snapshot.Status.Message).To(ContainSubstring("create snapshot"))
+			},
+		},
+		{
+			name:     "completes the snapshot",
+			snapshot: started,
+			methods:  []string{"GetSnapshot"},
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Status.Phase).To(Equal(esv1.SnapshotCompleted))
+				g.Expect(snapshot.Status.CompletionTime).NotTo(BeNil())
+			},
+		},
+		{
+			name:         "polls a snapshot in progress",
+			snapshot:     started,
+			info:         &es.EsSnapshotInfo{Snapshot: "test-before-upgrade", State: es.SnapshotInProgress},
+			methods:      []string{"GetSnapshot"},
+			requeueAfter: snapshotWait,
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Status.Phase).To(Equal(esv1.SnapshotInProgress))
+			},
+		},
+		{
+			name:     "fails a partial snapshot",
+			snapshot: started,
+			info:     &es.EsSnapshotInfo{Snapshot: "test-before-upgrade", State: es.SnapshotPartial, Reason: "shard failed"},
+			methods:  []string{"GetSnapshot"},
+			verify: func(g *WithT, snapshot *esv1.IndexSnapshot, service *esfake.Service) {
+				g.Expect(snapshot.Status.Phase).To(Equal(esv1.SnapshotFailed))
+				g.Expect(snapshot.Status.Message).To(Equal("snapshot test-before-upgrade is PARTIAL: shard failed"))
+			},
+		},
+		{
+			name:     "deletes the snapshot with the Delete policy",
+			snapshot: deleted(esv1.SnapshotDelete),
+			methods:  []string{"DeleteSnapshot"},
+		},
+		{
+			name:     "keeps the snapshot with the Retain policy",
+			snapshot: deleted(esv1.SnapshotRetain),
+			methods:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			snapshot := &esv1.IndexSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Name: "before-upgrade", Namespace: testNamespace, Generation: 1},
+				Spec:       esv1.IndexSnapshotSpec{Index: "index-sample", DeletionPolicy: esv1.SnapshotRetain},
+			}
+			if tt.snapshot != nil {
+				tt.snapshot(snapshot)
+			}
+			index := readyIndex()
+			if tt.index != nil {
+				tt.index(index)
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(index, readySecret(), snapshot).Build()
+			service := esfake.NewService()
+			service.Snapshot = tt.info
+			for method, err := range tt.fail {
+				service.Fail(method, err)
+			}
+			r := &IndexSnapshotReconciler{Client: c, Scheme: c.Scheme(), EsService: service, Recorder: record.NewFakeRecorder(100),
+				SnapshotRepository: tt.defaultRepository}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(snapshot)})
+			if tt.err {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			g.Expect(result.RequeueAfter).To(Equal(tt.requeueAfter))
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+
+			var updated esv1.IndexSnapshot
+			err = c.Get(ctx, client.ObjectKeyFromObject(snapshot), &updated)
+			if errors.IsNotFound(err) {
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			if tt.verify != nil {
+				tt.verify(g, &updated, service)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	indexTemplateFinalizer = "indextemplate.es-provisioner.com.ramos/finalizer"
+
+	// componentTemplateWait is how often an IndexTemplate checks the ComponentTemplates it's composed of are synced
+	componentTemplateWait = 10 * time.Second
+)
+
+// IndexTemplateReconciler syncs an IndexTemplate to the Elasticsearch index template with the same name
+type IndexTemplateReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	EsService es.EsService
+	Recorder  record.EventRecorder
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indextemplates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indextemplates/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=indextemplates/finalizers,verbs=update
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=componenttemplates,verbs=get;list;watch
+
+// Reconcile puts the index template when the spec changes and deletes it with the resource. The ComponentTemplate
+// resources it's composed of are synced first, component templates without a resource must exist in Elasticsearch.
+func (r *IndexTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var template esv1.IndexTemplate
+	if err := r.Get(ctx, req.NamespacedName, &template); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !template.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&template, indexTemplateFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.EsService.DeleteIndexTemplate(template.Name); err != nil {
+			log.Error(err, "unable to delete index template")
+			r.Recorder.Event(&template, coreV1.EventTypeWarning, reasonDeletionFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&template, coreV1.EventTypeNormal, reasonTemplateDeleted, "Index template %s deleted", template.Name)
+		controllerutil.RemoveFinalizer(&template, indexTemplateFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &template)
+	}
+
+	if !controllerutil.ContainsFinalizer(&template, indexTemplateFinalizer) {
+		controllerutil.AddFinalizer(&template, indexTemplateFinalizer)
+		if err := r.Update(ctx, &template); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if template.Status.ObservedGeneration == template.Generation {
+		return ctrl.Result{}, nil
+	}
+
+	pending, err := r.pendingComponentTemplates(ctx, &template)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if pending != "" {
+		log.V(1).Info("Waiting for component template", "componentTemplate", pending)
+		message := fmt.Sprintf("Waiting for ComponentTemplate %s to be synced", pending)
+		if setSyncedCondition(&template.Status.Conditions, template.Generation, v1.ConditionFalse, reasonWaiting, message) {
+			r.updateStatus(ctx, &template)
+		}
+		return ctrl.Result{RequeueAfter: componentTemplateWait}, nil
+	}
+
+	body, err := templateJSON(template.Spec.Template)
+	if err == nil {
+		err = r.EsService.PutIndexTemplate(&es.EsIndexTemplateOptions{
+			Name:          template.Name,
+			IndexPatterns: template.Spec.IndexPatterns,
+			ComposedOf:    template.Spec.ComposedOf,
+			Priority:      template.Spec.Priority,
+			Template:      body,
+			Version:       template.Spec.Version,
+		})
+	}
+	if err != nil {
+		log.Error(err, "unable to sync index template")
+		r.Recorder.Event(&template, coreV1.EventTypeWarning, reasonSyncFailed, err.Error())
+		if es.IsTransient(err) {
+			if setSyncedCondition(&template.Status.Conditions, template.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error()) {
+				r.updateStatus(ctx, &template)
+			}
+			return ctrl.Result{}, err
+		}
+		// retried when the spec changes
+		template.Status.ObservedGeneration = template.Generation
+		setSyncedCondition(&template.Status.Conditions, template.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error())
+		r.updateStatus(ctx, &template)
+		return ctrl.Result{}, nil
+	}
+
+	template.Status.ObservedGeneration = template.Generation
+	setSyncedCondition(&template.Status.Conditions, template.Generation, v1.ConditionTrue, reasonSynced, "Index template applied to Elasticsearch")
+	r.updateStatus(ctx, &template)
+	r.Recorder.Eventf(&template, coreV1.EventTypeNormal, reasonSynced, "Index template %s applied", template.Name)
+	return ctrl.Result{}, nil
+}
+
+// pendingComponentTemplates returns the first ComponentTemplate the index template is composed of
+// that isn't synced to Elasticsearch yet
+func (r *IndexTemplateReconciler) pendingComponentTemplates(ctx context.Context, template *esv1.IndexTemplate) (string, error) {
+	for _, name := range template.Spec.ComposedOf {
+		var component esv1.ComponentTemplate
+		err := r.Get(ctx, client.ObjectKey{Name: name}, &component)
+		if errors.IsNotFound(err) {
+			// managed outside the operator
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !componentTemplateSynced(&component) {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// componentTemplateSynced is true when the current spec of the ComponentTemplate was applied to Elasticsearch
+func componentTemplateSynced(template *esv1.ComponentTemplate) bool {
+	condition := meta.FindStatusCondition(template.Status.Conditions, esv1.ConditionSynced)
+	return condition != nil && condition.Status == v1.ConditionTrue && condition.ObservedGeneration == template.Generation
+}
+
+func (r *IndexTemplateReconciler) updateStatus(ctx context.Context, template *esv1.IndexTemplate) {
+	if err := r.Status().Update(ctx, template); err != nil {
+		log.FromContext(ctx).Error(err, "Error updating status")
+	}
+}
+
+// findIndexTemplatesForComponentTemplate maps a ComponentTemplate to the IndexTemplates composed of it
+func (r *IndexTemplateReconciler) findIndexTemplatesForComponentTemplate(component client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+	var templates esv1.IndexTemplateList
+	if err := r.List(context.Background(), &templates); err != nil {
+		return requests
+	}
+	for _, template := range templates.Items {
+		for _, name := range template.Spec.ComposedOf {
+			if name == component.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&template)})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IndexTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&esv1.IndexTemplate{}).
+		WithOptions(r.Controller.options()).
+		Watches(&source.Kind{Type: &esv1.ComponentTemplate{}}, handler.EnqueueRequestsFromMapFunc(r.findIndexTemplatesForComponentTemplate)).
+		Complete(r)
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ingestPipelineFinalizer = "ingestpipeline.es-provisioner.com.ramos/finalizer"
+
+	// pipelineInUseWait is how often a deleted IngestPipeline checks it's no longer used by an Index
+	pipelineInUseWait = 30 * time.Second
+
+	reasonSimulated        = "Simulated"
+	reasonSimulationFailed = "SimulationFailed"
+	reasonPipelineInUse    = "PipelineInUse"
+	reasonPipelineDeleted  = "PipelineDeleted"
+)
+
+// IngestPipelineReconciler syncs an IngestPipeline to an Elasticsearch ingest pipeline
+type IngestPipelineReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	EsService es.EsService
+	Recorder  record.EventRecorder
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=ingestpipelines,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=ingestpipelines/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=ingestpipelines/finalizers,verbs=update
+
+// Reconcile runs the sample documents through the pipeline and puts it when they all succeed. The pipeline is
+// deleted with the resource once no Index of the namespace uses it.
+func (r *IngestPipelineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var pipeline esv1.IngestPipeline
+	if err := r.Get(ctx, req.NamespacedName, &pipeline); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	id := indexspec.PipelineID(pipeline.Namespace, pipeline.Name)
+
+	if !pipeline.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&pipeline, ingestPipelineFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		users, err := r.indicesUsing(ctx, &pipeline)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(users) > 0 {
+			log.V(1).Info("Pipeline in use, waiting to delete it", "indices", users)
+			r.Recorder.Eventf(&pipeline, coreV1.EventTypeWarning, reasonPipelineInUse,
+				"Pipeline used by Index %s", strings.Join(users, ", "))
+			return ctrl.Result{RequeueAfter: pipelineInUseWait}, nil
+		}
+		if err := r.EsService.DeletePipeline(id); err != nil {
+			log.Error(err, "unable to delete ingest pipeline")
+			r.Recorder.Event(&pipeline, coreV1.EventTypeWarning, reasonDeletionFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&pipeline, coreV1.EventTypeNormal, reasonPipelineDeleted, "Ingest pipeline %s deleted", id)
+		controllerutil.RemoveFinalizer(&pipeline, ingestPipelineFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &pipeline)
+	}
+
+	if !controllerutil.ContainsFinalizer(&pipeline, ingestPipelineFinalizer) {
+		controllerutil.AddFinalizer(&pipeline, ingestPipelineFinalizer)
+		if err := r.Update(ctx, &pipeline); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if pipeline.Status.ObservedGeneration == pipeline.Generation {
+		return ctrl.Result{}, nil
+	}
+
+	ops, docs, err := pipelineOptions(id, &pipeline)
+	if err != nil {
+		return r.syncFailed(ctx, &pipeline, err)
+	}
+
+	if len(docs) == 0 {
+		pipeline.Status.Samples = nil
+		meta.RemoveStatusCondition(&pipeline.Status.Conditions, esv1.ConditionSimulated)
+	} else {
+		simulated, err := r.EsService.SimulatePipeline(ops, docs)
+		if err != nil {
+			return r.syncFailed(ctx, &pipeline, err)
+		}
+		failed := 0
+		pipeline.Status.Samples = []esv1.PipelineSample{}
+		for _, doc := range simulated {
+			if doc.Error != "" {
+				failed++
+			}
+			pipeline.Status.Samples = append(pipeline.Status.Samples, esv1.PipelineSample{Document: doc.Source, Error: doc.Error})
+		}
+		if failed > 0 {
+			message := fmt.Sprintf("%d of %d sample documents failed", failed, len(simulated))
+			log.Info("Pipeline simulation failed", "failed", failed)
+			r.Recorder.Event(&pipeline, coreV1.EventTypeWarning, reasonSimulationFailed, message)
+			// retried when the spec changes
+			pipeline.Status.ObservedGeneration = pipeline.Generation
+			setResourceCondition(&pipeline.Status.Conditions, esv1.ConditionSimulated, pipeline.Generation, v1.ConditionFalse,
+				reasonSimulationFailed, message)
+			setSyncedCondition(&pipeline.Status.Conditions, pipeline.Generation, v1.ConditionFalse, reasonSimulationFailed,
+				"Pipeline not applied: "+message)
+			r.updateStatus(ctx, &pipeline)
+			return ctrl.Result{}, nil
+		}
+		setResourceCondition(&pipeline.Status.Conditions, esv1.ConditionSimulated, pipeline.Generation, v1.ConditionTrue,
+			reasonSimulated, fmt.Sprintf("%d sample documents processed", len(simulated)))
+	}
+
+	if err := r.EsService.PutPipeline(ops); err != nil {
+		return r.syncFailed(ctx, &pipeline, err)
+	}
+
+	pipeline.Status.ObservedGeneration = pipeline.Generation
+	pipeline.Status.PipelineID = id
+	setSyncedCondition(&pipeline.Status.Conditions, pipeline.Generation, v1.ConditionTrue, reasonSynced, "Pipeline applied to Elasticsearch")
+	r.updateStatus(ctx, &pipeline)
+	r.Recorder.Eventf(&pipeline, coreV1.EventTypeNormal, reasonSynced, "Ingest pipeline %s applied", id)
+	return ctrl.Result{}, nil
+}
+
+// syncFailed retries transient errors, the other ones are reported in the Synced condition until the spec changes
+func (r *IngestPipelineReconciler) syncFailed(ctx context.Context, pipeline *esv1.IngestPipeline, err error) (ctrl.Result, error) {
+	log.FromContext(ctx).Error(err, "unable to sync ingest pipeline")
+	r.Recorder.Event(pipeline, coreV1.EventTypeWarning, reasonSyncFailed, err.Error())
+	if es.IsTransient(err) {
+		if setSyncedCondition(&pipeline.Status.Conditions, pipeline.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error()) {
+			r.updateStatus(ctx, pipeline)
+		}
+		return ctrl.Result{}, err
+	}
+	pipeline.Status.ObservedGeneration = pipeline.Generation
+	setSyncedCondition(&pipeline.Status.Conditions, pipeline.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error())
+	r.updateStatus(ctx, pipeline)
+	return ctrl.Result{}, nil
+}
+
+// indicesUsing returns the Indices of the namespace with the pipeline as default or final pipeline
+func (r *IngestPipelineReconciler) indicesUsing(ctx context.Context, pipeline *esv1.IngestPipeline) ([]string, error) {
+	var indices esv1.IndexList
+	if err := r.List(ctx, &indices, client.InNamespace(pipeline.Namespace)); err != nil {
+		return nil, err
+	}
+	users := []string{}
+	for _, index := range indices.Items {
+		if index.Spec.DefaultPipeline == pipeline.Name || index.Spec.FinalPipeline == pipeline.Name {
+			users = append(users, index.Name)
+		}
+	}
+	return users, nil
+}
+
+func (r *IngestPipelineReconciler) updateStatus(ctx context.Context, pipeline *esv1.IngestPipeline) {
+	if err := r.Status().Update(ctx, pipeline); err != nil {
+		log.FromContext(ctx).Error(err, "Error updating status")
+	}
+}
+
+// pipelineOptions converts the processors and sample documents of the IngestPipeline from JSON or YAML to JSON
+func pipelineOptions(id string, pipeline *esv1.IngestPipeline) (*es.EsPipelineOptions, []string, error) {
+	processors, err := yamlJSON("processors", pipeline.Spec.Processors, "[")
+	if err != nil {
+		return nil, nil, err
+	}
+	onFailure := ""
+	if strings.TrimSpace(pipeline.Spec.OnFailure) != "" {
+		onFailure, err = yamlJSON("onFailure", pipeline.Spec.OnFailure, "[")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	docs := []string{}
+	for i, doc := range pipeline.Spec.SampleDocuments {
+		d, err := yamlJSON(fmt.Sprintf("sample document %d", i+1), doc, "{")
+		if err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, d)
+	}
+	return &es.EsPipelineOptions{
+		Name:        id,
+		Description: pipeline.Spec.Description,
+		Processors:  processors,
+		OnFailure:   onFailure,
+		Version:     pipeline.Spec.Version,
+	}, docs, nil
+}
+
+// yamlJSON converts a JSON or YAML value to JSON, the value must start with the delimiter of a list or object
+func yamlJSON(field string, value string, delimiter string) (string, error) {
+	b, err := yaml.YAMLToJSON([]byte(value))
+	if err != nil {
+		return "", &es.ValidationError{Reason: fmt.Sprintf("invalid %s: %s", field, err)}
+	}
+	if !json.Valid(b) || !strings.HasPrefix(string(b), delimiter) {
+		expected := "a list"
+		if delimiter == "{" {
+			expected = "an object"
+		}
+		return "", &es.ValidationError{Reason: fmt.Sprintf("invalid %s, expected %s", field, expected)}
+	}
+	return string(b), nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IngestPipelineReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&esv1.IngestPipeline{}).
+		WithOptions(r.Controller.options()).
+		Complete(r)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIngestPipelineReconcile(t *testing.T) {
+	deleted := func(pipeline *esv1.IngestPipeline) {
+		now := metav1.Now()
+		pipeline.DeletionTimestamp = &now
+		pipeline.Finalizers = []string{ingestPipelineFinalizer}
+	}
+
+	tests := []struct {
+		name         string
+		pipeline     func(pipeline *esv1.IngestPipeline)
+		objects      []client.Object
+		simulated    []es.EsSimulatedDocument
+		fail         error
+		methods      []string
+		requeueAfter time.Duration
+		err          bool
+		verify       func(g *WithT, pipeline *esv1.IngestPipeline, service *esfake.Service)
+	}{
+		{
+			name:    "simulates the sample documents and applies the pipeline",
+			methods: []string{"SimulatePipeline", "PutPipeline"},
+			verify: func(g *WithT, pipeline *esv1.IngestPipeline, service *esfake.Service) {
+				g.Expect(pipeline.Finalizers).To(ContainElement(ingestPipelineFinalizer))
+				g.Expect(pipeline.Status.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(pipeline.Status.PipelineID).To(Equal("es-provisioner-test-normalize"))
+				g.Expect(pipeline.Status.Samples).To(HaveLen(1))
+				g.Expect(pipeline.Status.Samples[0].Document).To(MatchJSON(`{"team": "Search"}`))
+				g.Expect(meta.IsStatusConditionTrue(pipeline.Status.Conditions, esv1.ConditionSimulated)).To(BeTrue())
+				g.Expect(meta.IsStatusConditionTrue(pipeline.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+				ops := service.Calls()[1].Args.(es.EsPipelineOptions)
+				g.Expect(ops.Name).To(Equal("es-provisioner-test-normalize"))
+				g.Expect(ops.Processors).To(MatchJSON(`[{"lowercase": {"field": "team"}}]`))
+			},
+		},
+		{
+			name:     "applies a pipeline without sample documents",
+			pipeline: func(pipeline *esv1.IngestPipeline) { pipeline.Spec.SampleDocuments = nil },
+			methods:  []string{"PutPipeline"},
+			verify: func(g *WithT, pipeline *esv1.IngestPipeline, service *esfake.Service) {
+				g.Expect(meta.FindStatusCondition(pipeline.Status.Conditions, esv1.ConditionSimulated)).To(BeNil())
+				g.Expect(meta.IsStatusConditionTrue(pipeline.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+			},
+		},
+		{
+			name:      "doesn't apply the pipeline when a sample document fails",
+			simulated: []es.EsSimulatedDocument{{Error: "illegal_argument_exception: field [team] not present"}},
+			methods:   []string{"SimulatePipeline"},
+			verify: func(g *WithT, pipeline *esv1.IngestPipeline, service *esfake.Service) {
+				g.Expect(pipeline.Status.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(pipeline.Status.Samples[0].Error).To(ContainSubstring("not present"))
+				g.Expect(meta.IsStatusConditionFalse(pipeline.Status.Conditions, esv1.ConditionSimulated)).To(BeTrue())
+				condition := meta.FindStatusCondition(pipeline.Status.Conditions, esv1.ConditionSynced)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonSimulationFailed))
+			},
+		},
+		{
+			name:     "reports invalid processors until the spec changes",
+			pipeline: func(pipeline *esv1.IngestPipeline) { pipeline.Spec.Processors = "lowercase: {field: team}" },
+			methods:  []string{},
+			verify: func(g *WithT, pipeline *esv1.IngestPipeline, service *esfake.Service) {
+				g.Expect(pipeline.Status.ObservedGeneration).To(Equal(int64(1)))
+				condition := meta.FindStatusCondition(pipeline.Status.Conditions, esv1.ConditionSynced)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Message).To(Equal("invalid processors, expected a list"))
+			},
+		},
+		{
+			name:    "retries transient errors",
+			fail:    &es.EsError{Action: "update pipeline", Status: http.StatusServiceUnavailable},
+			methods: []string{"SimulatePipeline"},
+			err:     true,
+			verify: func(g *WithT, pipeline *esv1.IngestPipeline, service *esfake.Service) {
+				g.Expect(pipeline.Status.ObservedGeneration).To(BeZero())
+				g.Expect(meta.IsStatusConditionFalse(pipeline.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+			},
+		},
+		{
+			name:     "keeps a pipeline used by an Index",
+			pipeline: deleted,
+			objects: []client.Object{&esv1.Index{
+				ObjectMeta: metav1.ObjectMeta{Name: "index-sample", Namespace: testNamespace},
+				Spec:       esv1.IndexSpec{Application: "app", DefaultPipeline: "normalize"},
+			}},
+			methods:      []string{},
+			requeueAfter: pipelineInUseWait,
+			verify: func(g *WithT, pipeline *esv1.IngestPipeline, service *esfake.Service) {
+				g.Expect(pipeline.Finalizers).To(ContainElement(ingestPipelineFinalizer))
+			},
+		},
+		{
+			name:     "deletes the pipeline with the resource",
+			pipeline: deleted,
+			methods:  []string{"DeletePipeline"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			pipeline := &esv1.IngestPipeline{
+				ObjectMeta: metav1.ObjectMeta{Name: "normalize", Namespace: testNamespace, Generation: 1},
+				Spec: esv1.IngestPipelineSpec{
+					Processors:      "- lowercase:\n    field: team\n",
+					SampleDocuments: []string{"team: Search"},
+				},
+			}
+			if tt.pipeline != nil {
+				tt.pipeline(pipeline)
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(append(tt.objects, pipeline)...).Build()
+			service := esfake.NewService()
+			service.SimulatedDocuments = tt.simulated
+			service.Fail("SimulatePipeline", tt.fail)
+			service.Fail("PutPipeline", tt.fail)
+			r := &IngestPipelineReconciler{Client: c, Scheme: c.Scheme(), EsService: service, Recorder: record.NewFakeRecorder(100)}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pipeline)})
+			if tt.err {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			g.Expect(result.RequeueAfter).To(Equal(tt.requeueAfter))
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+
+			var updated esv1.IngestPipeline
+			err = c.Get(ctx, client.ObjectKeyFromObject(pipeline), &updated)
+			if errors.IsNotFound(err) {
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			if tt.verify != nil {
+				tt.verify(g, &updated, service)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+// ControllerOptions are the concurrency and the rate limits of the work queue of a controller, the zero
+// values are the defaults of controller-runtime
+type ControllerOptions struct {
+	// MaxConcurrentReconciles is the number of resources reconciled at the same time
+	MaxConcurrentReconciles int
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff of the failed reconciles
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// QPS and Burst limit the reconciles of all the resources of the controller
+	QPS   float64
+	Burst int
+}
+
+// options returns the controller options, with a new rate limiter as each controller has its own work queue
+func (o ControllerOptions) options() controller.Options {
+	base, max := o.RetryBaseDelay, o.RetryMaxDelay
+	if base == 0 {
+		base = 5 * time.Millisecond
+	}
+	if max == 0 {
+		max = 1000 * time.Second
+	}
+	qps, burst := o.QPS, o.Burst
+	if qps == 0 {
+		qps = 10
+	}
+	if burst == 0 {
+		burst = 100
+	}
+	return controller.Options{
+		MaxConcurrentReconciles: o.MaxConcurrentReconciles,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(base, max),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+		),
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestControllerOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ControllerOptions
+		// delays are the delays of the consecutive failures of a resource
+		delays []time.Duration
+		// throttled is true when the failures above exhaust the burst of the controller
+		throttled bool
+	}{
+		{
+			name:   "defaults to the limits of controller-runtime",
+			delays: []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond},
+		},
+		{
+			name: "caps the backoff at the max delay",
+			options: ControllerOptions{
+				MaxConcurrentReconciles: 4,
+				RetryBaseDelay:          time.Second,
+				RetryMaxDelay:           2 * time.Second,
+			},
+			delays: []time.Duration{time.Second, 2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:      "throttles the reconciles over the burst",
+			options:   ControllerOptions{QPS: 1, Burst: 2},
+			delays:    []time.Duration{5 * time.Millisecond, 10 * time.Millisecond},
+			throttled: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			options := tt.options.options()
+			g.Expect(options.MaxConcurrentReconciles).To(Equal(tt.options.MaxConcurrentReconciles))
+
+			// a new rate limiter is built for each controller
+			g.Expect(tt.options.options().RateLimiter).NotTo(BeIdenticalTo(options.RateLimiter))
+
+			for i, delay := range tt.delays {
+				g.Expect(options.RateLimiter.When("index")).To(Equal(delay), "failure %d", i+1)
+			}
+			options.RateLimiter.Forget("index")
+			g.Expect(options.RateLimiter.NumRequeues("index")).To(Equal(0))
+
+			// the bucket is shared by all the resources of the controller
+			if tt.throttled {
+				g.Expect(options.RateLimiter.When("other")).To(BeNumerically(">", tt.delays[0]))
+			} else {
+				g.Expect(options.RateLimiter.When("other")).To(Equal(tt.delays[0]))
+			}
+		})
+	}
+}
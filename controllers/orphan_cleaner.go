@@ -0,0 +1,125 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/gc"
+	"com.ramos/es-provisioner/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// OrphanCleaner periodically looks for the indices, roles and users created by the operator whose Index
+// doesn't exist anymore, left behind by failed provisionings or deletions. The orphans are deleted once
+// they have been orphaned for GracePeriod, or only reported in DryRun mode. Without ClusterName they are
+// always only reported, as the objects of every cluster without name sharing Elasticsearch would match.
+type OrphanCleaner struct {
+	client.Client
+	EsService   es.EsService
+	ClusterName string
+	Interval    time.Duration
+	GracePeriod time.Duration
+	DryRun      bool
+
+	// seen is when each orphan was found first
+	seen map[string]time.Time
+}
+
+// Start looks for orphans every Interval until the context is cancelled
+func (c *OrphanCleaner) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection only cleans on the leader, like the controller
+func (c *OrphanCleaner) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *OrphanCleaner) sweep(ctx context.Context) {
+	log := log.FromContext(ctx).WithName("orphan-cleaner")
+
+	// the objects are listed before the Indices, so an Index created meanwhile isn't missed
+	owned, err := c.EsService.ListOwned()
+	if err != nil {
+		log.Error(err, "unable to list the Elasticsearch objects")
+		return
+	}
+	var indices esv1.IndexList
+	if err := c.List(ctx, &indices); err != nil {
+		log.Error(err, "unable to list Indices")
+		return
+	}
+
+	dryRun := c.DryRun
+	if !dryRun && c.ClusterName == "" {
+		log.Info("not deleting the orphaned Elasticsearch objects, the cluster name is not set")
+		dryRun = true
+	}
+
+	if c.seen == nil {
+		c.seen = map[string]time.Time{}
+	}
+	now := time.Now()
+	seen := map[string]time.Time{}
+	counts := map[string]float64{es.KindIndex: 0, es.KindRole: 0, es.KindUser: 0}
+
+	for _, orphan := range gc.Orphans(owned, indices.Items, c.ClusterName) {
+		key := orphan.Kind + "/" + orphan.Name
+		first, ok := c.seen[key]
+		if !ok {
+			first = now
+		}
+		seen[key] = first
+		counts[orphan.Kind]++
+
+		if dryRun || now.Sub(first) < c.GracePeriod {
+			log.Info("orphaned Elasticsearch object", "kind", orphan.Kind, "name", orphan.Name,
+				"owner", orphan.Owner.String(), "since", first, "dryRun", dryRun)
+			continue
+		}
+		err := c.EsService.DeleteOwned(&orphan)
+		metrics.OrphanDeletions.WithLabelValues(orphan.Kind, metrics.Outcome(err)).Inc()
+		if err != nil {
+			log.Error(err, "unable to delete orphaned Elasticsearch object", "kind", orphan.Kind, "name", orphan.Name)
+			continue
+		}
+		log.Info("deleted orphaned Elasticsearch object", "kind", orphan.Kind, "name", orphan.Name, "owner", orphan.Owner.String())
+		delete(seen, key)
+		counts[orphan.Kind]--
+	}
+
+	// objects no longer orphaned, or deleted, start their grace period again
+	c.seen = seen
+	for kind, count := range counts {
+		metrics.OrphanedResources.WithLabelValues(kind).Set(count)
+	}
+}
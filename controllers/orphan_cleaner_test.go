@@ -0,0 +1,143 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOrphanCleaner(t *testing.T) {
+	live := readyIndex()
+	live.UID = types.UID("live-uid")
+	owner := func(name string, uid string) es.EsOwner {
+		return es.EsOwner{Cluster: "test-cluster", Namespace: testNamespace, Name: name, UID: uid}
+	}
+	owned := []es.EsOwnedResource{
+		{Kind: es.KindIndex, Name: "es-provisioner-app-test-1", Owner: owner("index-sample", "live-uid")},
+		{Kind: es.KindRole, Name: "app-test-role", Owner: owner("index-sample", "live-uid")},
+		// the Index was deleted and created again with the same name
+		{Kind: es.KindRole, Name: "old-test-role", Owner: owner("index-sample", "old-uid")},
+		{Kind: es.KindUser, Name: "gone-test-role-user", Owner: owner("gone", "gone-uid")},
+		// another cluster shares Elasticsearch
+		{Kind: es.KindIndex, Name: "other-cluster-1", Owner: es.EsOwner{Cluster: "other", Namespace: testNamespace, Name: "gone"}},
+		// and another one without CLUSTER_NAME
+		{Kind: es.KindIndex, Name: "unnamed-cluster-1", Owner: es.EsOwner{Namespace: testNamespace, Name: "gone"}},
+	}
+	orphans := []interface{}{owned[2], owned[3]}
+
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		dryRun      bool
+		// clusterName is the name of the cluster, test-cluster by default and empty with -
+		clusterName string
+		// age of the orphans found by a previous sweep
+		seenAgo time.Duration
+		fail    error
+		methods []string
+		deleted []interface{}
+		// orphans waiting for deletion after the sweep
+		pending int
+	}{
+		{
+			name:    "deletes the orphans of the cluster without grace period",
+			methods: []string{"ListOwned", "DeleteOwned", "DeleteOwned"},
+			deleted: orphans,
+		},
+		{
+			name:        "waits for the grace period before deleting the orphans",
+			gracePeriod: time.Hour,
+			methods:     []string{"ListOwned"},
+			pending:     2,
+		},
+		{
+			name:        "deletes the orphans found before the grace period",
+			gracePeriod: time.Hour,
+			seenAgo:     2 * time.Hour,
+			methods:     []string{"ListOwned", "DeleteOwned", "DeleteOwned"},
+			deleted:     orphans,
+		},
+		{
+			name:    "only reports the orphans in dry run mode",
+			dryRun:  true,
+			seenAgo: 2 * time.Hour,
+			methods: []string{"ListOwned"},
+			pending: 2,
+		},
+		{
+			name:        "only reports the orphans without cluster name",
+			clusterName: "-",
+			seenAgo:     2 * time.Hour,
+			methods:     []string{"ListOwned"},
+			pending:     1,
+		},
+		{
+			name:    "keeps the orphans that can't be deleted",
+			fail:    errors.New("connection refused"),
+			methods: []string{"ListOwned", "DeleteOwned", "DeleteOwned"},
+			deleted: orphans,
+			pending: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(live).Build()
+			service := esfake.NewService()
+			service.Owned = owned
+			service.Fail("DeleteOwned", tt.fail)
+			clusterName := "test-cluster"
+			if tt.clusterName == "-" {
+				clusterName = ""
+			}
+			cleaner := &OrphanCleaner{Client: c, EsService: service, ClusterName: clusterName,
+				GracePeriod: tt.gracePeriod, DryRun: tt.dryRun}
+			if tt.seenAgo > 0 {
+				cleaner.seen = map[string]time.Time{}
+				for _, o := range orphans {
+					orphan := o.(es.EsOwnedResource)
+					cleaner.seen[orphan.Kind+"/"+orphan.Name] = time.Now().Add(-tt.seenAgo)
+				}
+			}
+
+			cleaner.sweep(context.Background())
+
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+			deleted := []interface{}{}
+			for _, call := range service.Calls()[1:] {
+				deleted = append(deleted, call.Args)
+			}
+			if tt.deleted == nil {
+				tt.deleted = []interface{}{}
+			}
+			g.Expect(deleted).To(Equal(tt.deleted))
+			g.Expect(cleaner.seen).To(HaveLen(tt.pending))
+		})
+	}
+}
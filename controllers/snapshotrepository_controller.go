@@ -0,0 +1,269 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	snapshotRepositoryFinalizer = "snapshotrepository.es-provisioner.com.ramos/finalizer"
+
+	reasonVerified           = "Verified"
+	reasonVerificationFailed = "VerificationFailed"
+	reasonRepositoryDeleted  = "RepositoryDeleted"
+)
+
+// SnapshotRepositoryReconciler registers a SnapshotRepository in Elasticsearch and verifies it
+type SnapshotRepositoryReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	EsService es.EsService
+	Recorder  record.EventRecorder
+	// VerifyPeriod is how often a synced repository is verified again, 0 disables it
+	VerifyPeriod time.Duration
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=snapshotrepositories,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=snapshotrepositories/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=snapshotrepositories/finalizers,verbs=update
+
+// Reconcile puts the snapshot repository when the spec or the credentials Secret change and verifies it
+// every VerifyPeriod. The repository is unregistered with the resource, its snapshots are kept.
+func (r *SnapshotRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var repository esv1.SnapshotRepository
+	if err := r.Get(ctx, req.NamespacedName, &repository); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !repository.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&repository, snapshotRepositoryFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.EsService.DeleteSnapshotRepository(repository.Name); err != nil {
+			log.Error(err, "unable to delete snapshot repository")
+			r.Recorder.Event(&repository, coreV1.EventTypeWarning, reasonDeletionFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&repository, coreV1.EventTypeNormal, reasonRepositoryDeleted, "Snapshot repository %s deleted", repository.Name)
+		controllerutil.RemoveFinalizer(&repository, snapshotRepositoryFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &repository)
+	}
+
+	if !controllerutil.ContainsFinalizer(&repository, snapshotRepositoryFinalizer) {
+		controllerutil.AddFinalizer(&repository, snapshotRepositoryFinalizer)
+		if err := r.Update(ctx, &repository); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	credentials, waiting, err := r.credentials(ctx, &repository)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if waiting != "" {
+		// the Secret watch reconciles the repository again when it's created
+		log.V(1).Info("Waiting for credentials", "reason", waiting)
+		if setSyncedCondition(&repository.Status.Conditions, repository.Generation, v1.ConditionFalse, reasonWaiting, waiting) {
+			r.updateStatus(ctx, &repository)
+		}
+		return ctrl.Result{}, nil
+	}
+	version := ""
+	if credentials != nil {
+		version = credentials.ResourceVersion
+	}
+
+	if repository.Status.ObservedGeneration != repository.Generation || repository.Status.CredentialsVersion != version {
+		ops, err := repositoryOptions(&repository, credentials)
+		if err == nil {
+			err = r.EsService.PutSnapshotRepository(ops)
+		}
+		if err != nil {
+			return r.syncFailed(ctx, &repository, version, err)
+		}
+		repository.Status.ObservedGeneration = repository.Generation
+		repository.Status.CredentialsVersion = version
+		setSyncedCondition(&repository.Status.Conditions, repository.Generation, v1.ConditionTrue, reasonSynced,
+			"Snapshot repository registered in Elasticsearch")
+		r.Recorder.Eventf(&repository, coreV1.EventTypeNormal, reasonSynced, "Snapshot repository %s registered", repository.Name)
+	}
+
+	if !meta.IsStatusConditionTrue(repository.Status.Conditions, esv1.ConditionSynced) {
+		return ctrl.Result{}, nil
+	}
+
+	nodes, err := r.EsService.VerifySnapshotRepository(repository.Name)
+	now := v1.Now()
+	repository.Status.LastVerifiedTime = &now
+	if err != nil {
+		log.Error(err, "unable to verify snapshot repository")
+		repository.Status.Nodes = nil
+		if setResourceCondition(&repository.Status.Conditions, esv1.ConditionVerified, repository.Generation, v1.ConditionFalse,
+			reasonVerificationFailed, err.Error()) {
+			r.Recorder.Event(&repository, coreV1.EventTypeWarning, reasonVerificationFailed, err.Error())
+		}
+	} else {
+		repository.Status.Nodes = nodes
+		setResourceCondition(&repository.Status.Conditions, esv1.ConditionVerified, repository.Generation, v1.ConditionTrue,
+			reasonVerified, fmt.Sprintf("Repository verified by %d nodes", len(nodes)))
+	}
+	r.updateStatus(ctx, &repository)
+
+	if r.VerifyPeriod == 0 {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: r.VerifyPeriod}, nil
+}
+
+// credentials returns the Secret of an s3 repository with credentials, otherwise the message explains what's missing
+func (r *SnapshotRepositoryReconciler) credentials(ctx context.Context, repository *esv1.SnapshotRepository) (*coreV1.Secret, string, error) {
+	if repository.Spec.Type != esv1.S3Repository || repository.Spec.S3 == nil || repository.Spec.S3.CredentialsSecret == nil {
+		return nil, "", nil
+	}
+	ref := repository.Spec.S3.CredentialsSecret
+	var secret coreV1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Secret %s/%s not found", ref.Namespace, ref.Name), nil
+		}
+		return nil, "", err
+	}
+	return &secret, "", nil
+}
+
+// syncFailed retries transient errors, the other ones are reported in the Synced condition until the spec
+// or the credentials change
+func (r *SnapshotRepositoryReconciler) syncFailed(ctx context.Context, repository *esv1.SnapshotRepository, version string,
+	err error) (ctrl.Result, error) {
+	log.FromContext(ctx).Error(err, "unable to sync snapshot repository")
+	r.Recorder.Event(repository, coreV1.EventTypeWarning, reasonSyncFailed, err.Error())
+	if es.IsTransient(err) {
+		if setSyncedCondition(&repository.Status.Conditions, repository.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error()) {
+			r.updateStatus(ctx, repository)
+		}
+		return ctrl.Result{}, err
+	}
+	repository.Status.ObservedGeneration = repository.Generation
+	repository.Status.CredentialsVersion = version
+	setSyncedCondition(&repository.Status.Conditions, repository.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error())
+	r.updateStatus(ctx, repository)
+	return ctrl.Result{}, nil
+}
+
+func (r *SnapshotRepositoryReconciler) updateStatus(ctx context.Context, repository *esv1.SnapshotRepository) {
+	if err := r.Status().Update(ctx, repository); err != nil {
+		log.FromContext(ctx).Error(err, "Error updating status")
+	}
+}
+
+// repositoryOptions are the Elasticsearch type and settings of the SnapshotRepository
+func repositoryOptions(repository *esv1.SnapshotRepository, credentials *coreV1.Secret) (*es.EsSnapshotRepositoryOptions, error) {
+	spec := repository.Spec
+	settings := map[string]interface{}{}
+	switch spec.Type {
+	case esv1.FSRepository:
+		if spec.FS == nil || spec.FS.Location == "" {
+			return nil, &es.ValidationError{Reason: "fs repositories require spec.fs.location"}
+		}
+		settings["location"] = spec.FS.Location
+	case esv1.S3Repository:
+		if spec.S3 == nil || spec.S3.Bucket == "" {
+			return nil, &es.ValidationError{Reason: "s3 repositories require spec.s3.bucket"}
+		}
+		settings["bucket"] = spec.S3.Bucket
+		optional := map[string]string{
+			"base_path": spec.S3.BasePath,
+			"client":    spec.S3.Client,
+			"endpoint":  spec.S3.Endpoint,
+			"protocol":  spec.S3.Protocol,
+		}
+		for key, value := range optional {
+			if value != "" {
+				settings[key] = value
+			}
+		}
+		if spec.S3.PathStyleAccess {
+			settings["path_style_access"] = true
+		}
+		if credentials != nil {
+			for _, key := range []string{"access_key", "secret_key"} {
+				value := string(credentials.Data[key])
+				if value == "" {
+					return nil, &es.ValidationError{Reason: fmt.Sprintf("Secret %s/%s has no %s", credentials.Namespace,
+						credentials.Name, key)}
+				}
+				settings[key] = value
+			}
+		}
+	default:
+		return nil, &es.ValidationError{Reason: fmt.Sprintf("unsupported repository type %s", spec.Type)}
+	}
+	if spec.Compress {
+		settings["compress"] = true
+	}
+	if spec.Readonly {
+		settings["readonly"] = true
+	}
+	return &es.EsSnapshotRepositoryOptions{Name: repository.Name, Type: string(spec.Type), Settings: settings}, nil
+}
+
+func (r *SnapshotRepositoryReconciler) findRepositoriesForSecret(secret client.Object) []reconcile.Request {
+	requests := []reconcile.Request{}
+	var repositories esv1.SnapshotRepositoryList
+	if err := r.List(context.Background(), &repositories); err != nil {
+		return requests
+	}
+	for _, repository := range repositories.Items {
+		if s3 := repository.Spec.S3; s3 != nil && s3.CredentialsSecret != nil &&
+			s3.CredentialsSecret.Namespace == secret.GetNamespace() && s3.CredentialsSecret.Name == secret.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&repository)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SnapshotRepositoryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&esv1.SnapshotRepository{}).
+		WithOptions(r.Controller.options()).
+		Watches(&source.Kind{Type: &coreV1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.findRepositoriesForSecret)).
+		Complete(r)
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSnapshotRepositoryReconcile(t *testing.T) {
+	s3 := func(repository *esv1.SnapshotRepository) {
+		repository.Spec = esv1.SnapshotRepositorySpec{
+			Type: esv1.S3Repository,
+			S3: &esv1.S3RepositorySettings{
+				Bucket:            "es-snapshots",
+				Endpoint:          "minio:9000",
+				Protocol:          "http",
+				PathStyleAccess:   true,
+				CredentialsSecret: &coreV1.SecretReference{Name: "minio-credentials", Namespace: testNamespace},
+			},
+		}
+	}
+	synced := func(repository *esv1.SnapshotRepository) {
+		repository.Finalizers = []string{snapshotRepositoryFinalizer}
+		repository.Status.ObservedGeneration = 1
+		repository.Status.Conditions = []metav1.Condition{{
+			Type:               esv1.ConditionSynced,
+			Status:             metav1.ConditionTrue,
+			Reason:             reasonSynced,
+			ObservedGeneration: 1,
+			LastTransitionTime: metav1.Now(),
+		}}
+	}
+	credentials := &coreV1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "minio-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"access_key": []byte("minio"), "secret_key": []byte("minio-password")},
+	}
+
+	tests := []struct {
+		name string
+		// repository is changed from a new fs repository
+		repository   func(repository *esv1.SnapshotRepository)
+		objects      []client.Object
+		fail         map[string]error
+		methods      []string
+		requeueAfter time.Duration
+		err          bool
+		verify       func(g *WithT, repository *esv1.SnapshotRepository, service *esfake.Service)
+	}{
+		{
+			name:         "registers and verifies an fs repository",
+			methods:      []string{"PutSnapshotRepository", "VerifySnapshotRepository"},
+			requeueAfter: 10 * time.Minute,
+			verify: func(g *WithT, repository *esv1.SnapshotRepository, service *esfake.Service) {
+				g.Expect(repository.Finalizers).To(ContainElement(snapshotRepositoryFinalizer))
+				g.Expect(repository.Status.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(meta.IsStatusConditionTrue(repository.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+				g.Expect(meta.IsStatusConditionTrue(repository.Status.Conditions, esv1.ConditionVerified)).To(BeTrue())
+				g.Expect(repository.Status.Nodes).To(Equal([]string{"esfake"}))
+				g.Expect(repository.Status.LastVerifiedTime).NotTo(BeNil())
+				g.Expect(service.Calls()[0].Args).To(Equal(es.EsSnapshotRepositoryOptions{
+					Name:     "backups",
+					Type:     "fs",
+					Settings: map[string]interface{}{"location": "backups", "compress": true},
+				}))
+			},
+		},
+		{
+			name:         "registers an s3 repository with the credentials of the Secret",
+			repository:   s3,
+			objects:      []client.Object{credentials},
+			methods:      []string{"PutSnapshotRepository", "VerifySnapshotRepository"},
+			requeueAfter: 10 * time.Minute,
+			verify: func(g *WithT, repository *esv1.SnapshotRepository, service *esfake.Service) {
+				g.Expect(repository.Status.CredentialsVersion).NotTo(BeEmpty())
+				g.Expect(service.Calls()[0].Args).To(Equal(es.EsSnapshotRepositoryOptions{
+					Name: "backups",
+					Type: "s3",
+					Settings: map[string]interface{}{
+						"bucket":            "es-snapshots",
+						"endpoint":          "minio:9000",
+						"protocol":          "http",
+						"path_style_access": true,
+						"access_key":        "minio",
+						"secret_key":        "minio-password",
+					},
+				}))
+			},
+		},
+		{
+			name:       "waits for the credentials Secret",
+			repository: s3,
+			methods:    []string{},
+			verify: func(g *WithT, repository *esv1.SnapshotRepository, service *esfake.Service) {
+				condition := meta.FindStatusCondition(repository.Status.Conditions, esv1.ConditionSynced)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonWaiting))
+				g.Expect(condition.Message).To(Equal("Secret test/minio-credentials not found"))
+			},
+		},
+		{
+			name: "registers the repository again when the credentials change",
+			repository: func(repository *esv1.SnapshotRepository) {
+				s3(repository)
+				synced(repository)
+				repository.Status.CredentialsVersion = "previous"
+			},
+			objects:      []client.Object{credentials},
+			methods:      []string{"PutSnapshotRepository", "VerifySnapshotRepository"},
+			requeueAfter: 10 * time.Minute,
+		},
+		{
+			name: "only verifies a synced repository",
+			repository: func(repository *esv1.SnapshotRepository) {
+				synced(repository)
+			},
+			methods:      []string{"VerifySnapshotRepository"},
+			requeueAfter: 10 * time.Minute,
+		},
+		{
+			name:       "rejects an fs repository without location",
+			repository: func(repository *esv1.SnapshotRepository) { repository.Spec.FS = nil },
+			methods:    []string{},
+			verify: func(g *WithT, repository *esv1.SnapshotRepository, service *esfake.Service) {
+				g.Expect(repository.Status.ObservedGeneration).To(Equal(int64(1)))
+				condition := meta.FindStatusCondition(repository.Status.Conditions, esv1.ConditionSynced)
+				g.Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+				g.Expect(condition.Message).To(Equal("fs repositories require spec.fs.location"))
+			},
+		},
+		{
+			name: "reports a repository that can't be verified",
+			fail: map[string]error{"VerifySnapshotRepository": &es.EsError{Action: "verify snapshot repository",
+				Status: http.StatusInternalServerError, Type: "repository_verification_exception"}},
+			methods: []string{"PutSnapshotRepository", "VerifySnapshotRepository"},
+			// verified again with the next resync
+			requeueAfter: 10 * time.Minute,
+			verify: func(g *WithT, repository *esv1.SnapshotRepository, service *esfake.Service) {
+				g.Expect(meta.IsStatusConditionTrue(repository.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+				condition := meta.FindStatusCondition(repository.Status.Conditions, esv1.ConditionVerified)
+				g.Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+				g.Expect(condition.Reason).To(Equal(reasonVerificationFailed))
+				g.Expect(repository.Status.Nodes).To(BeEmpty())
+			},
+		},
+		{
+			name:    "retries transient errors",
+			fail:    map[string]error{"PutSnapshotRepository": &es.EsError{Action: "update snapshot repository", Status: http.StatusServiceUnavailable}},
+			methods: []string{"PutSnapshotRepository"},
+			err:     true,
+			verify: func(g *WithT, repository *esv1.SnapshotRepository, service *esfake.Service) {
+				g.Expect(repository.Status.ObservedGeneration).To(BeZero())
+			},
+		},
+		{
+			name: "unregisters the repository with the resource",
+			repository: func(repository *esv1.SnapshotRepository) {
+				synced(repository)
+				now := metav1.Now()
+				repository.DeletionTimestamp = &now
+			},
+			methods: []string{"DeleteSnapshotRepository"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			repository := &esv1.SnapshotRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "backups", Generation: 1},
+				Spec: esv1.SnapshotRepositorySpec{
+					Type:     esv1.FSRepository,
+					FS:       &esv1.FSRepositorySettings{Location: "backups"},
+					Compress: true,
+				},
+			}
+			if tt.repository != nil {
+				tt.repository(repository)
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(append(tt.objects, repository)...).Build()
+			service := esfake.NewService()
+			for method, err := range tt.fail {
+				service.Fail(method, err)
+			}
+			r := &SnapshotRepositoryReconciler{Client: c, Scheme: c.Scheme(), EsService: service, Recorder: record.NewFakeRecorder(100),
+				VerifyPeriod: 10 * time.Minute}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(repository)})
+			if tt.err {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			g.Expect(result.RequeueAfter).To(Equal(tt.requeueAfter))
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+
+			var updated esv1.SnapshotRepository
+			err = c.Get(ctx, client.ObjectKeyFromObject(repository), &updated)
+			if errors.IsNotFound(err) {
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			if tt.verify != nil {
+				tt.verify(g, &updated, service)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// snapshotWait is how often a snapshot or restore in progress is checked
+	snapshotWait = 10 * time.Second
+	// indexReadyWait is how often a snapshot or restore waiting for its Index checks it again
+	indexReadyWait = 30 * time.Second
+)
+
+// Event reasons of the snapshot controllers
+const (
+	reasonSnapshotStarted   = "SnapshotStarted"
+	reasonSnapshotCompleted = "SnapshotCompleted"
+	reasonSnapshotFailed    = "SnapshotFailed"
+	reasonSnapshotDeleted   = "SnapshotDeleted"
+	reasonRestoreStarted    = "RestoreStarted"
+	reasonRestoreCompleted  = "RestoreCompleted"
+	reasonRestoreFailed     = "RestoreFailed"
+	reasonScheduleDeleted   = "ScheduleDeleted"
+)
+
+// readyIndexSecret returns the Secret of the Index when it's Ready, otherwise the message explains what's missing
+func readyIndexSecret(ctx context.Context, c client.Client, namespace string, name string) (*coreV1.Secret, string, error) {
+	var index esv1.Index
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &index); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Index %s not found", name), nil
+		}
+		return nil, "", err
+	}
+	if index.Status.IndexStatus != esv1.Ready {
+		return nil, fmt.Sprintf("Index %s is not Ready", name), nil
+	}
+
+	var secret coreV1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: secretName}, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Sprintf("Secret %s of Index %s not found", secretName, name), nil
+		}
+		return nil, "", err
+	}
+	return &secret, "", nil
+}
+
+// snapshotRepository is the repository of the resource, the operator default when empty
+func snapshotRepository(repository string, defaultRepository string) string {
+	if repository != "" {
+		return repository
+	}
+	return defaultRepository
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	snapshotScheduleFinalizer = "snapshotschedule.es-provisioner.com.ramos/finalizer"
+
+	// scheduleRefresh is how often the last executions of a synced SnapshotSchedule are read
+	scheduleRefresh = 5 * time.Minute
+)
+
+// SnapshotScheduleReconciler syncs a SnapshotSchedule to an Elasticsearch snapshot lifecycle policy
+type SnapshotScheduleReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	EsService es.EsService
+	Recorder  record.EventRecorder
+	// SnapshotRepository is used when the SnapshotSchedule doesn't set the repository
+	SnapshotRepository string
+	// Controller sets the concurrency and rate limits of the controller
+	Controller ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=snapshotschedules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=snapshotschedules/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=es-provisioner.com.ramos,resources=snapshotschedules/finalizers,verbs=update
+
+// Reconcile puts the snapshot lifecycle policy of the Index alias when the spec changes and refreshes
+// the last executions in the status. The policy is deleted with the resource, its snapshots are kept.
+func (r *SnapshotScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var schedule esv1.SnapshotSchedule
+	if err := r.Get(ctx, req.NamespacedName, &schedule); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	current := schedule.DeepCopy()
+	id := schedulePolicyID(&schedule)
+
+	if !schedule.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(&schedule, snapshotScheduleFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.EsService.DeleteSnapshotLifecycle(id); err != nil {
+			log.Error(err, "unable to delete snapshot lifecycle policy")
+			r.Recorder.Event(&schedule, coreV1.EventTypeWarning, reasonDeletionFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+		r.Recorder.Eventf(&schedule, coreV1.EventTypeNormal, reasonScheduleDeleted, "Snapshot lifecycle policy %s deleted", id)
+		controllerutil.RemoveFinalizer(&schedule, snapshotScheduleFinalizer)
+		return ctrl.Result{}, r.Update(ctx, &schedule)
+	}
+
+	if !controllerutil.ContainsFinalizer(&schedule, snapshotScheduleFinalizer) {
+		controllerutil.AddFinalizer(&schedule, snapshotScheduleFinalizer)
+		if err := r.Update(ctx, &schedule); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if schedule.Status.ObservedGeneration != schedule.Generation {
+		repository := snapshotRepository(schedule.Spec.Repository, r.SnapshotRepository)
+		if repository == "" {
+			return r.syncFailed(ctx, &schedule, &es.ValidationError{Reason: "no snapshot repository, set spec.repository"})
+		}
+		secret, waiting, err := readyIndexSecret(ctx, r.Client, schedule.Namespace, schedule.Spec.Index)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if waiting != "" {
+			log.V(1).Info("Waiting for Index", "reason", waiting)
+			if setSyncedCondition(&schedule.Status.Conditions, schedule.Generation, v1.ConditionFalse, reasonWaiting, waiting) {
+				r.updateStatus(ctx, &schedule)
+			}
+			return ctrl.Result{RequeueAfter: indexReadyWait}, nil
+		}
+
+		ops := &es.EsSnapshotLifecycleOptions{
+			Name:       id,
+			Schedule:   schedule.Spec.Schedule,
+			Repository: repository,
+			// the alias follows the migrations and restores of the Index
+			Indices: []string{string(secret.Data["index"])},
+		}
+		if retention := schedule.Spec.Retention; retention != nil {
+			ops.ExpireAfter = retention.ExpireAfter
+			ops.MinCount = retention.MinCount
+			ops.MaxCount = retention.MaxCount
+		}
+		if err := r.EsService.PutSnapshotLifecycle(ops); err != nil {
+			return r.syncFailed(ctx, &schedule, err)
+		}
+
+		schedule.Status.ObservedGeneration = schedule.Generation
+		schedule.Status.PolicyID = id
+		schedule.Status.Repository = repository
+		setSyncedCondition(&schedule.Status.Conditions, schedule.Generation, v1.ConditionTrue, reasonSynced,
+			"Snapshot lifecycle policy applied to Elasticsearch")
+		r.Recorder.Eventf(&schedule, coreV1.EventTypeNormal, reasonSynced, "Snapshot lifecycle policy %s applied", id)
+	}
+
+	if !meta.IsStatusConditionTrue(schedule.Status.Conditions, esv1.ConditionSynced) {
+		return ctrl.Result{}, nil
+	}
+	status, err := r.EsService.GetSnapshotLifecycle(id)
+	if err != nil {
+		log.Error(err, "unable to get snapshot lifecycle policy")
+	} else if status != nil {
+		schedule.Status.LastSuccess = status.LastSuccess
+		schedule.Status.LastSuccessTime = optionalTime(status.LastSuccessAt)
+		schedule.Status.LastFailure = status.LastFailure
+		schedule.Status.LastFailureTime = optionalTime(status.LastFailureAt)
+		schedule.Status.NextExecution = optionalTime(status.NextExecution)
+	}
+	if !equality.Semantic.DeepEqual(current.Status, schedule.Status) {
+		r.updateStatus(ctx, &schedule)
+	}
+	return ctrl.Result{RequeueAfter: scheduleRefresh}, nil
+}
+
+// syncFailed retries transient errors, the other ones are reported in the Synced condition until the spec changes
+func (r *SnapshotScheduleReconciler) syncFailed(ctx context.Context, schedule *esv1.SnapshotSchedule, err error) (ctrl.Result, error) {
+	log.FromContext(ctx).Error(err, "unable to sync snapshot schedule")
+	r.Recorder.Event(schedule, coreV1.EventTypeWarning, reasonSyncFailed, err.Error())
+	if es.IsTransient(err) {
+		if setSyncedCondition(&schedule.Status.Conditions, schedule.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error()) {
+			r.updateStatus(ctx, schedule)
+		}
+		return ctrl.Result{}, err
+	}
+	schedule.Status.ObservedGeneration = schedule.Generation
+	setSyncedCondition(&schedule.Status.Conditions, schedule.Generation, v1.ConditionFalse, reasonSyncFailed, err.Error())
+	r.updateStatus(ctx, schedule)
+	return ctrl.Result{}, nil
+}
+
+func (r *SnapshotScheduleReconciler) updateStatus(ctx context.Context, schedule *esv1.SnapshotSchedule) {
+	if err := r.Status().Update(ctx, schedule); err != nil {
+		log.FromContext(ctx).Error(err, "Error updating status")
+	}
+}
+
+// schedulePolicyID is the id of the snapshot lifecycle policy of the SnapshotSchedule
+func schedulePolicyID(schedule *esv1.SnapshotSchedule) string {
+	return "es-provisioner-" + schedule.Namespace + "-" + schedule.Name
+}
+
+// optionalTime is nil for the zero time
+func optionalTime(t time.Time) *v1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	mt := v1.NewTime(t)
+	return &mt
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SnapshotScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&esv1.SnapshotSchedule{}).
+		WithOptions(r.Controller.options()).
+		Complete(r)
+}
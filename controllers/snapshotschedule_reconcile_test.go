@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSnapshotScheduleReconcile(t *testing.T) {
+	synced := func(schedule *esv1.SnapshotSchedule) {
+		schedule.Finalizers = []string{snapshotScheduleFinalizer}
+		schedule.Status.ObservedGeneration = 1
+		schedule.Status.PolicyID = "es-provisioner-test-nightly"
+		schedule.Status.Repository = "backups"
+		schedule.Status.Conditions = []metav1.Condition{{
+			Type:               esv1.ConditionSynced,
+			Status:             metav1.ConditionTrue,
+			Reason:             reasonSynced,
+			ObservedGeneration: 1,
+			LastTransitionTime: metav1.Now(),
+		}}
+	}
+	lastSuccess := time.Date(2026, 10, 18, 1, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		schedule     func(schedule *esv1.SnapshotSchedule)
+		objects      []client.Object
+		lifecycle    *es.EsSnapshotLifecycleStatus
+		fail         error
+		methods      []string
+		requeueAfter time.Duration
+		err          bool
+		verify       func(g *WithT, schedule *esv1.SnapshotSchedule, service *esfake.Service)
+	}{
+		{
+			name:         "applies a policy taking snapshots of the Index alias",
+			objects:      []client.Object{readyIndex(), readySecret()},
+			methods:      []string{"PutSnapshotLifecycle", "GetSnapshotLifecycle"},
+			requeueAfter: scheduleRefresh,
+			verify: func(g *WithT, schedule *esv1.SnapshotSchedule, service *esfake.Service) {
+				g.Expect(schedule.Finalizers).To(ContainElement(snapshotScheduleFinalizer))
+				g.Expect(schedule.Status.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(schedule.Status.PolicyID).To(Equal("es-provisioner-test-nightly"))
+				g.Expect(schedule.Status.Repository).To(Equal("backups"))
+				g.Expect(meta.IsStatusConditionTrue(schedule.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+				g.Expect(service.Calls()[0].Args).To(Equal(es.EsSnapshotLifecycleOptions{
+					Name:        "es-provisioner-test-nightly",
+					Schedule:    "0 30 1 * * ?",
+					Repository:  "backups",
+					Indices:     []string{"es-provisioner-app-test"},
+					ExpireAfter: "30d",
+					MaxCount:    10,
+				}))
+			},
+		},
+		{
+			name:         "refreshes the last executions of a synced policy",
+			schedule:     synced,
+			lifecycle:    &es.EsSnapshotLifecycleStatus{LastSuccess: "nightly-2026.10.18-abc", LastSuccessAt: lastSuccess},
+			methods:      []string{"GetSnapshotLifecycle"},
+			requeueAfter: scheduleRefresh,
+			verify: func(g *WithT, schedule *esv1.SnapshotSchedule, service *esfake.Service) {
+				g.Expect(schedule.Status.LastSuccess).To(Equal("nightly-2026.10.18-abc"))
+				g.Expect(schedule.Status.LastSuccessTime.Time.Equal(lastSuccess)).To(BeTrue())
+				g.Expect(schedule.Status.LastFailureTime).To(BeNil())
+			},
+		},
+		{
+			name:         "waits for the Index",
+			methods:      []string{},
+			requeueAfter: indexReadyWait,
+			verify: func(g *WithT, schedule *esv1.SnapshotSchedule, service *esfake.Service) {
+				g.Expect(schedule.Status.ObservedGeneration).To(BeZero())
+				condition := meta.FindStatusCondition(schedule.Status.Conditions, esv1.ConditionSynced)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonWaiting))
+				g.Expect(condition.Message).To(Equal("Index index-sample not found"))
+			},
+		},
+		{
+			name:    "reports a policy rejected by Elasticsearch until the spec changes",
+			objects: []client.Object{readyIndex(), readySecret()},
+			fail:    &es.EsError{Action: "update snapshot lifecycle policy", Status: http.StatusBadRequest, Reason: "invalid schedule"},
+			methods: []string{"PutSnapshotLifecycle"},
+			verify: func(g *WithT, schedule *esv1.SnapshotSchedule, service *esfake.Service) {
+				g.Expect(schedule.Status.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(meta.IsStatusConditionFalse(schedule.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+			},
+		},
+		{
+			name:    "retries transient errors",
+			objects: []client.Object{readyIndex(), readySecret()},
+			fail:    &es.EsError{Action: "update snapshot lifecycle policy", Status: http.StatusServiceUnavailable},
+			methods: []string{"PutSnapshotLifecycle"},
+			err:     true,
+			verify: func(g *WithT, schedule *esv1.SnapshotSchedule, service *esfake.Service) {
+				g.Expect(schedule.Status.ObservedGeneration).To(BeZero())
+			},
+		},
+		{
+			name: "deletes the policy with the resource",
+			schedule: func(schedule *esv1.SnapshotSchedule) {
+				synced(schedule)
+				now := metav1.Now()
+				schedule.DeletionTimestamp = &now
+			},
+			methods: []string{"DeleteSnapshotLifecycle"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			schedule := &esv1.SnapshotSchedule{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: testNamespace, Generation: 1},
+				Spec: esv1.SnapshotScheduleSpec{
+					Index:     "index-sample",
+					Schedule:  "0 30 1 * * ?",
+					Retention: &esv1.SnapshotRetention{ExpireAfter: "30d", MaxCount: 10},
+				},
+			}
+			if tt.schedule != nil {
+				tt.schedule(schedule)
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(append(tt.objects, schedule)...).Build()
+			service := esfake.NewService()
+			service.SnapshotLifecycle = tt.lifecycle
+			service.Fail("PutSnapshotLifecycle", tt.fail)
+			r := &SnapshotScheduleReconciler{Client: c, Scheme: c.Scheme(), EsService: service, Recorder: record.NewFakeRecorder(100),
+				SnapshotRepository: "backups"}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(schedule)})
+			if tt.err {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			g.Expect(result.RequeueAfter).To(Equal(tt.requeueAfter))
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+
+			var updated esv1.SnapshotSchedule
+			err = c.Get(ctx, client.ObjectKeyFromObject(schedule), &updated)
+			if errors.IsNotFound(err) {
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			if tt.verify != nil {
+				tt.verify(g, &updated, service)
+			}
+		})
+	}
+}
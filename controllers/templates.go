@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Event and condition reasons of the template controllers
+const (
+	reasonSynced          = "Synced"
+	reasonSyncFailed      = "SyncFailed"
+	reasonWaiting         = "Waiting"
+	reasonTemplateDeleted = "TemplateDeleted"
+)
+
+// templateJSON converts the template of a ComponentTemplate or IndexTemplate from JSON or YAML to JSON,
+// only the settings, mappings and aliases keys are allowed
+func templateJSON(template string) (string, error) {
+	if strings.TrimSpace(template) == "" {
+		return "", nil
+	}
+	b, err := yaml.YAMLToJSON([]byte(template))
+	if err != nil {
+		return "", &es.ValidationError{Reason: fmt.Sprintf("invalid template: %s", err)}
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return "", &es.ValidationError{Reason: fmt.Sprintf("invalid template, expected an object: %s", err)}
+	}
+	unknown := []string{}
+	for key := range body {
+		switch key {
+		case "settings", "mappings", "aliases":
+		default:
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", &es.ValidationError{Reason: fmt.Sprintf("unknown keys %s in template, expected settings, mappings or aliases",
+			strings.Join(unknown, ", "))}
+	}
+	return string(b), nil
+}
+
+// setSyncedCondition sets the Synced condition of a template and returns true when it changed
+func setSyncedCondition(conditions *[]v1.Condition, generation int64, status v1.ConditionStatus, reason string, message string) bool {
+	return setResourceCondition(conditions, esv1.ConditionSynced, generation, status, reason, message)
+}
+
+// setResourceCondition sets a condition of the resources synced to Elasticsearch and returns true when it changed
+func setResourceCondition(conditions *[]v1.Condition, conditionType string, generation int64, status v1.ConditionStatus,
+	reason string, message string) bool {
+	current := meta.FindStatusCondition(*conditions, conditionType)
+	if current != nil && current.Status == status && current.Reason == reason && current.Message == message &&
+		current.ObservedGeneration == generation {
+		return false
+	}
+	meta.SetStatusCondition(conditions, v1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+	return true
+}
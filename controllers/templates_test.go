@@ -0,0 +1,248 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestComponentTemplateReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		template func(template *esv1.ComponentTemplate)
+		fail     error
+		methods  []string
+		err      bool
+		verify   func(g *WithT, template *esv1.ComponentTemplate, service *esfake.Service)
+	}{
+		{
+			name:    "applies the template converted to JSON",
+			methods: []string{"PutComponentTemplate"},
+			verify: func(g *WithT, template *esv1.ComponentTemplate, service *esfake.Service) {
+				g.Expect(template.Finalizers).To(ContainElement(componentTemplateFinalizer))
+				g.Expect(template.Status.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(meta.IsStatusConditionTrue(template.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+				ops := service.Calls()[0].Args.(es.EsComponentTemplateOptions)
+				g.Expect(ops.Name).To(Equal("defaults"))
+				g.Expect(ops.Template).To(MatchJSON(`{"settings": {"index.codec": "best_compression"}}`))
+			},
+		},
+		{
+			name:     "skips a generation already applied",
+			template: func(template *esv1.ComponentTemplate) { template.Status.ObservedGeneration = 1 },
+			methods:  []string{},
+		},
+		{
+			name:     "reports an invalid template until the spec changes",
+			template: func(template *esv1.ComponentTemplate) { template.Spec.Template = "index_patterns: [logs-*]" },
+			methods:  []string{},
+			verify: func(g *WithT, template *esv1.ComponentTemplate, service *esfake.Service) {
+				g.Expect(template.Status.ObservedGeneration).To(Equal(int64(1)))
+				condition := meta.FindStatusCondition(template.Status.Conditions, esv1.ConditionSynced)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonSyncFailed))
+			},
+		},
+		{
+			name:    "retries transient errors",
+			fail:    &es.EsError{Action: "update component template", Status: http.StatusServiceUnavailable},
+			methods: []string{"PutComponentTemplate"},
+			err:     true,
+			verify: func(g *WithT, template *esv1.ComponentTemplate, service *esfake.Service) {
+				g.Expect(template.Status.ObservedGeneration).To(BeZero())
+				g.Expect(meta.IsStatusConditionFalse(template.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+			},
+		},
+		{
+			name: "deletes the template with the resource",
+			template: func(template *esv1.ComponentTemplate) {
+				now := metav1.Now()
+				template.DeletionTimestamp = &now
+				template.Finalizers = []string{componentTemplateFinalizer}
+			},
+			methods: []string{"DeleteComponentTemplate"},
+		},
+		{
+			name: "keeps the resource while the template is in use",
+			template: func(template *esv1.ComponentTemplate) {
+				now := metav1.Now()
+				template.DeletionTimestamp = &now
+				template.Finalizers = []string{componentTemplateFinalizer}
+			},
+			fail:    &es.EsError{Action: "delete component template", Status: http.StatusBadRequest},
+			methods: []string{"DeleteComponentTemplate"},
+			err:     true,
+			verify: func(g *WithT, template *esv1.ComponentTemplate, service *esfake.Service) {
+				g.Expect(template.Finalizers).To(ContainElement(componentTemplateFinalizer))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			template := &esv1.ComponentTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "defaults", Generation: 1},
+				Spec:       esv1.ComponentTemplateSpec{Template: "settings:\n  index.codec: best_compression\n"},
+			}
+			if tt.template != nil {
+				tt.template(template)
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(template).Build()
+			service := esfake.NewService()
+			service.Fail("PutComponentTemplate", tt.fail)
+			service.Fail("DeleteComponentTemplate", tt.fail)
+			r := &ComponentTemplateReconciler{Client: c, Scheme: c.Scheme(), EsService: service, Recorder: record.NewFakeRecorder(100)}
+
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(template)})
+			if tt.err {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+
+			var updated esv1.ComponentTemplate
+			err = c.Get(ctx, client.ObjectKeyFromObject(template), &updated)
+			if errors.IsNotFound(err) {
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			if tt.verify != nil {
+				tt.verify(g, &updated, service)
+			}
+		})
+	}
+}
+
+func TestIndexTemplateReconcile(t *testing.T) {
+	tests := []struct {
+		name         string
+		objects      []client.Object
+		fail         error
+		methods      []string
+		requeueAfter time.Duration
+		verify       func(g *WithT, template *esv1.IndexTemplate, service *esfake.Service)
+	}{
+		{
+			name:    "applies the template with its component templates",
+			objects: []client.Object{componentTemplate("defaults", true)},
+			methods: []string{"PutIndexTemplate"},
+			verify: func(g *WithT, template *esv1.IndexTemplate, service *esfake.Service) {
+				g.Expect(meta.IsStatusConditionTrue(template.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+				ops := service.Calls()[0].Args.(es.EsIndexTemplateOptions)
+				g.Expect(ops.IndexPatterns).To(Equal([]string{"es-provisioner-*"}))
+				g.Expect(ops.ComposedOf).To(Equal([]string{"defaults"}))
+				g.Expect(ops.Priority).To(Equal(int64(100)))
+			},
+		},
+		{
+			name:    "applies component templates managed outside the operator",
+			methods: []string{"PutIndexTemplate"},
+		},
+		{
+			name:         "waits for the component templates to be synced",
+			objects:      []client.Object{componentTemplate("defaults", false)},
+			methods:      []string{},
+			requeueAfter: componentTemplateWait,
+			verify: func(g *WithT, template *esv1.IndexTemplate, service *esfake.Service) {
+				condition := meta.FindStatusCondition(template.Status.Conditions, esv1.ConditionSynced)
+				g.Expect(condition).NotTo(BeNil())
+				g.Expect(condition.Reason).To(Equal(reasonWaiting))
+				g.Expect(template.Status.ObservedGeneration).To(BeZero())
+			},
+		},
+		{
+			name:    "reports templates rejected by Elasticsearch",
+			fail:    &es.EsError{Action: "update index template", Status: http.StatusBadRequest, Reason: "same priority"},
+			methods: []string{"PutIndexTemplate"},
+			verify: func(g *WithT, template *esv1.IndexTemplate, service *esfake.Service) {
+				g.Expect(template.Status.ObservedGeneration).To(Equal(int64(1)))
+				g.Expect(meta.IsStatusConditionFalse(template.Status.Conditions, esv1.ConditionSynced)).To(BeTrue())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			template := &esv1.IndexTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "es-provisioner", Generation: 1},
+				Spec: esv1.IndexTemplateSpec{
+					IndexPatterns: []string{"es-provisioner-*"},
+					ComposedOf:    []string{"defaults"},
+					Priority:      100,
+				},
+			}
+			c := fake.NewClientBuilder().WithScheme(testScheme(g)).WithObjects(append(tt.objects, template)...).Build()
+			service := esfake.NewService()
+			service.Fail("PutIndexTemplate", tt.fail)
+			r := &IndexTemplateReconciler{Client: c, Scheme: c.Scheme(), EsService: service, Recorder: record.NewFakeRecorder(100)}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(template)})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(result.RequeueAfter).To(Equal(tt.requeueAfter))
+			g.Expect(service.Methods()).To(Equal(tt.methods))
+
+			if tt.verify != nil {
+				var updated esv1.IndexTemplate
+				g.Expect(c.Get(ctx, client.ObjectKeyFromObject(template), &updated)).To(Succeed())
+				tt.verify(g, &updated, service)
+			}
+		})
+	}
+}
+
+func TestTemplateJSON(t *testing.T) {
+	g := NewWithT(t)
+
+	body, err := templateJSON("mappings:\n  properties:\n    team: {type: keyword}\n")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(body).To(MatchJSON(`{"mappings": {"properties": {"team": {"type": "keyword"}}}}`))
+
+	body, err = templateJSON(`{"settings": {"index.codec": "best_compression"}}`)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(body).To(MatchJSON(`{"settings": {"index.codec": "best_compression"}}`))
+
+	body, err = templateJSON("")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(body).To(BeEmpty())
+
+	_, err = templateJSON("settings: {}\ncomposed_of: [defaults]\n")
+	g.Expect(err).To(MatchError(ContainSubstring("unknown keys composed_of")))
+	g.Expect(es.IsTransient(err)).To(BeFalse())
+}
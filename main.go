@@ -17,21 +17,28 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net/url"
 	"os"
 	"runtime"
 	"strconv"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"com.ramos/es-provisioner/pkg/dryrun"
 	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/export"
+	"com.ramos/es-provisioner/pkg/gc"
+	"com.ramos/es-provisioner/pkg/naming"
 	"github.com/joho/godotenv"
 
 	k8Runtime "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -78,11 +85,78 @@ func handleError(err error, fatal ...bool) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := export.Run(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dry-run" {
+		if err := dryrun.Run(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := gc.Run(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var metricsSampleInterval time.Duration
+	var resyncPeriod time.Duration
+	var orphanCleanupInterval time.Duration
+	var orphanGracePeriod time.Duration
+	var orphanCleanupDryRun bool
+	var controllerOps controllers.ControllerOptions
+	var esQPS float64
+	var esBurst int
+	var esMaxInFlight int
+	var healthGate bool
+	var gate controllers.HealthGate
+	var esCheckInterval time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.DurationVar(&metricsSampleInterval, "metrics-sample-interval", time.Minute,
+		"How often the Index status and Elasticsearch index stats are sampled for the metrics.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"How often Ready indices are checked for drift against Elasticsearch and repaired, and snapshot "+
+			"repositories verified, 0 disables it.")
+	flag.DurationVar(&orphanCleanupInterval, "orphan-cleanup-interval", time.Hour,
+		"How often Elasticsearch is checked for indices, roles and users whose Index doesn't exist, 0 disables it.")
+	flag.DurationVar(&orphanGracePeriod, "orphan-grace-period", 24*time.Hour,
+		"How long an Elasticsearch object stays orphaned before it's deleted.")
+	flag.BoolVar(&orphanCleanupDryRun, "orphan-cleanup-dry-run", true,
+		"Only report the orphaned Elasticsearch objects, without deleting them.")
+	flag.IntVar(&controllerOps.MaxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"Number of resources of each kind reconciled at the same time.")
+	flag.DurationVar(&controllerOps.RetryBaseDelay, "reconcile-retry-base-delay", 5*time.Millisecond,
+		"Initial delay of the exponential backoff of failed reconciles.")
+	flag.DurationVar(&controllerOps.RetryMaxDelay, "reconcile-retry-max-delay", 1000*time.Second,
+		"Maximum delay of the exponential backoff of failed reconciles.")
+	flag.Float64Var(&controllerOps.QPS, "reconcile-qps", 10, "Reconciles per second of each controller.")
+	flag.IntVar(&controllerOps.Burst, "reconcile-burst", 100, "Burst of reconciles of each controller.")
+	flag.Float64Var(&esQPS, "es-qps", 0, "Elasticsearch requests per second, 0 doesn't limit them.")
+	flag.IntVar(&esBurst, "es-burst", 10, "Burst of Elasticsearch requests over --es-qps.")
+	flag.IntVar(&esMaxInFlight, "es-max-in-flight", 0,
+		"Elasticsearch requests sent at the same time, 0 doesn't limit them. Deletes are sent first.")
+	flag.BoolVar(&healthGate, "health-gate", true,
+		"Wait for the Elasticsearch cluster to be healthy and have capacity before provisioning an Index.")
+	flag.IntVar(&gate.MaxPendingTasks, "max-pending-tasks", 100,
+		"Pending cluster tasks the provisioning waits for, 0 doesn't check them.")
+	flag.BoolVar(&gate.DiskWatermark, "disk-watermark", true,
+		"Wait while a data node is over the low disk watermark of the cluster.")
+	flag.IntVar(&gate.MaxShardsPercent, "max-shards-percent", 90,
+		"Percentage of the cluster shard limit the new indices can use, 0 doesn't check it.")
+	flag.DurationVar(&esCheckInterval, "es-check-interval", 10*time.Second,
+		"How often the readiness check verifies Elasticsearch can be reached with the operator credentials.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -125,31 +199,160 @@ func main() {
 		Username:   os.Getenv("ES_USERNAME"),
 		Password:   os.Getenv("ES_PASSWORD"),
 		Retries:    retries,
+		Limiter:    es.NewLimiter(esQPS, esBurst, esMaxInFlight),
 	}
 	esService, err := es.NewEsService(&esOps)
 	if err != nil {
 		setupLog.Error(err, "unable to initialize ElasticSearch")
 		os.Exit(1)
 	}
-
-	// creates the clientset
-	k8sClient, err := kubernetes.NewForConfig(ctrl.GetConfigOrDie())
+	namingPolicy, err := naming.NewPolicy(os.Getenv("INDEX_NAME_TEMPLATE"), os.Getenv("FORCE_NAMESPACE_PREFIX") == "true")
 	if err != nil {
-		setupLog.Error(err, "Error Creating K8s Client")
+		setupLog.Error(err, "invalid INDEX_NAME_TEMPLATE")
 		os.Exit(1)
 	}
 
+	var indexHealthGate *controllers.HealthGate
+	if healthGate {
+		indexHealthGate = &gate
+	}
 	if err = (&controllers.IndexReconciler{
-		Client:    mgr.GetClient(),
-		Scheme:    mgr.GetScheme(),
-		EsService: &esService,
-		K8sClient: k8sClient,
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		EsService:          esService,
+		Recorder:           mgr.GetEventRecorderFor("index-controller"),
+		ClusterName:        os.Getenv("CLUSTER_NAME"),
+		SnapshotRepository: os.Getenv("SNAPSHOT_REPOSITORY"),
+		ResyncPeriod:       resyncPeriod,
+		Naming:             namingPolicy,
+		HealthGate:         indexHealthGate,
+		Controller:         controllerOps,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Index")
 		os.Exit(1)
 	}
+	if err = (&controllers.ComponentTemplateReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		EsService:  esService,
+		Recorder:   mgr.GetEventRecorderFor("componenttemplate-controller"),
+		Controller: controllerOps,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ComponentTemplate")
+		os.Exit(1)
+	}
+	if err = (&controllers.IngestPipelineReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		EsService:  esService,
+		Recorder:   mgr.GetEventRecorderFor("ingestpipeline-controller"),
+		Controller: controllerOps,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IngestPipeline")
+		os.Exit(1)
+	}
+	if err = (&controllers.IndexTemplateReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		EsService:  esService,
+		Recorder:   mgr.GetEventRecorderFor("indextemplate-controller"),
+		Controller: controllerOps,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IndexTemplate")
+		os.Exit(1)
+	}
+	if err = (&controllers.IndexSnapshotReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		EsService:          esService,
+		Recorder:           mgr.GetEventRecorderFor("indexsnapshot-controller"),
+		SnapshotRepository: os.Getenv("SNAPSHOT_REPOSITORY"),
+		Controller:         controllerOps,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IndexSnapshot")
+		os.Exit(1)
+	}
+	if err = (&controllers.IndexRestoreReconciler{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		EsService:   esService,
+		Recorder:    mgr.GetEventRecorderFor("indexrestore-controller"),
+		ClusterName: os.Getenv("CLUSTER_NAME"),
+		Controller:  controllerOps,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IndexRestore")
+		os.Exit(1)
+	}
+	if err = (&controllers.SnapshotScheduleReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		EsService:          esService,
+		Recorder:           mgr.GetEventRecorderFor("snapshotschedule-controller"),
+		SnapshotRepository: os.Getenv("SNAPSHOT_REPOSITORY"),
+		Controller:         controllerOps,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SnapshotSchedule")
+		os.Exit(1)
+	}
+	if err = (&controllers.SnapshotRepositoryReconciler{
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		EsService:    esService,
+		Recorder:     mgr.GetEventRecorderFor("snapshotrepository-controller"),
+		VerifyPeriod: resyncPeriod,
+		Controller:   controllerOps,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SnapshotRepository")
+		os.Exit(1)
+	}
+	if err = (&controllers.IndexQuotaReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		EsService:  esService,
+		Recorder:   mgr.GetEventRecorderFor("indexquota-controller"),
+		Controller: controllerOps,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IndexQuota")
+		os.Exit(1)
+	}
+	// the webhooks need the serving certificates, disable them to run the manager locally
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&esprovisionerv1.Index{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Index")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	if err = mgr.Add(&controllers.IndexMetricsSampler{
+		Client:    mgr.GetClient(),
+		EsService: esService,
+		Interval:  metricsSampleInterval,
+	}); err != nil {
+		setupLog.Error(err, "unable to set up index metrics")
+		os.Exit(1)
+	}
+
+	if orphanCleanupInterval > 0 {
+		// the orphans are the objects of the cluster, without its name they would be the objects of every
+		// cluster without CLUSTER_NAME sharing Elasticsearch
+		if !orphanCleanupDryRun && os.Getenv("CLUSTER_NAME") == "" {
+			setupLog.Error(errors.New("CLUSTER_NAME is not set"), "--orphan-cleanup-dry-run=false requires CLUSTER_NAME")
+			os.Exit(1)
+		}
+		if err = mgr.Add(&controllers.OrphanCleaner{
+			Client:      mgr.GetClient(),
+			EsService:   esService,
+			ClusterName: os.Getenv("CLUSTER_NAME"),
+			Interval:    orphanCleanupInterval,
+			GracePeriod: orphanGracePeriod,
+			DryRun:      orphanCleanupDryRun,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up orphan cleanup")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -158,6 +361,20 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	// the liveness check doesn't depend on Elasticsearch, restarting the operator wouldn't reconnect it
+	esReadiness := &controllers.EsReadiness{
+		EsService: esService,
+		Cluster:   esClusterLabel(esOps.Connection),
+		Interval:  esCheckInterval,
+	}
+	if err := mgr.Add(esReadiness); err != nil {
+		setupLog.Error(err, "unable to set up Elasticsearch check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("elasticsearch", esReadiness.Check); err != nil {
+		setupLog.Error(err, "unable to set up Elasticsearch ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -165,3 +382,12 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// esClusterLabel is the host of the Elasticsearch URL, without the credentials it can include
+func esClusterLabel(connection string) string {
+	u, err := url.Parse(connection)
+	if err != nil || u.Host == "" {
+		return connection
+	}
+	return u.Host
+}
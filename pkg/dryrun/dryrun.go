@@ -0,0 +1,224 @@
+package dryrun
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	"github.com/joho/godotenv"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// files is a repeatable flag
+type files []string
+
+func (f *files) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *files) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// manifests are the Indices and Config Maps read from the files
+type manifests struct {
+	indices    []esv1.Index
+	configMaps map[string]*coreV1.ConfigMap
+}
+
+// Run is the dry-run command, it renders the requests that would provision the Indices in the manifests
+// and validates them against Elasticsearch without creating anything. It fails when any Index has errors,
+// so it can be used in CI, for example:
+//
+//	manager dry-run --file index.yaml --file configmap.yaml
+func Run(args []string) error {
+	_ = godotenv.Load()
+
+	var paths files
+	flags := flag.NewFlagSet("dry-run", flag.ContinueOnError)
+	flags.Var(&paths, "file", "Manifests with the Indices and their Config Maps, can be repeated. Use - for stdin.")
+	offline := flags.Bool("offline", false, "Only render the requests, without connecting to Elasticsearch.")
+	synonymsApi := flags.Bool("synonyms-api", false, "Render synonym sets with the synonyms API when offline.")
+	namespace := flags.String("namespace", "default", "Namespace of the manifests without one.")
+	clusterName := flags.String("cluster-name", os.Getenv("CLUSTER_NAME"),
+		"Cluster name used in the Config Map templates, the CLUSTER_NAME variable by default.")
+	connection := flags.String("es-url", os.Getenv("ES_URL"), "Elasticsearch URL, the ES_URL variable by default.")
+	username := flags.String("es-username", os.Getenv("ES_USERNAME"),
+		"Elasticsearch user, the ES_USERNAME variable by default. The password is read from ES_PASSWORD.")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no manifests, use --file")
+	}
+
+	m, err := readManifests(paths, *namespace)
+	if err != nil {
+		return err
+	}
+	if len(m.indices) == 0 {
+		return fmt.Errorf("no Index found in %s", paths.String())
+	}
+
+	var service es.EsService
+	if !*offline {
+		service, err = es.NewEsService(&es.EsOptions{
+			Connection: *connection,
+			Username:   *username,
+			Password:   os.Getenv("ES_PASSWORD"),
+			Retries:    1,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for i := range m.indices {
+		index := &m.indices[i]
+		result, err := dryRun(index, m, *clusterName, service, *synonymsApi)
+		if err != nil {
+			return fmt.Errorf("cannot dry run Index %s/%s: %s", index.Namespace, index.Name, err)
+		}
+		printResult(os.Stdout, index, result)
+		if len(result.Errors) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d Indices failed validation", failed, len(m.indices))
+	}
+	return nil
+}
+
+// dryRun renders the requests of the Index, validating them when the service is set
+func dryRun(index *esv1.Index, m *manifests, clusterName string, service es.EsService, synonymsApi bool) (*es.EsDryRunResult, error) {
+	get := m.getter(index.Namespace)
+	spec, _, err := indexspec.Payload(index, clusterName, get)
+	if err != nil {
+		return nil, err
+	}
+	synonyms, _, err := indexspec.Synonyms(index, get)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := indexspec.SetupOptions(index, index.Namespace, spec, synonyms)
+	ops.Owner = indexspec.Owner(index, clusterName)
+	if service != nil {
+		return service.DryRunIndex(&ops)
+	}
+
+	result := &es.EsDryRunResult{}
+	result.Requests, err = es.RenderIndex(&ops, synonymsApi)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	return result, nil
+}
+
+func printResult(w io.Writer, index *esv1.Index, result *es.EsDryRunResult) {
+	fmt.Fprintf(w, "# Index %s/%s\n", index.Namespace, index.Name)
+	for _, req := range result.Requests {
+		fmt.Fprintf(w, "\n%s %s\n", req.Method, req.Path)
+		if req.Body != "" {
+			fmt.Fprintln(w, req.Body)
+		}
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(w, "\nWARNING: %s\n", warning)
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintf(w, "\nERROR: %s\n", e)
+	}
+	fmt.Fprintln(w)
+}
+
+// readManifests reads the Indices and Config Maps of the multi document YAML or JSON files,
+// other kinds are ignored
+func readManifests(paths []string, namespace string) (*manifests, error) {
+	m := &manifests{configMaps: map[string]*coreV1.ConfigMap{}}
+
+	for _, path := range paths {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+		for {
+			doc, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot read %s: %s", path, err)
+			}
+			if err := m.add(doc, namespace); err != nil {
+				return nil, fmt.Errorf("cannot read %s: %s", path, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *manifests) add(doc []byte, namespace string) error {
+	var meta struct {
+		Kind string `json:"kind"`
+	}
+	if err := k8syaml.Unmarshal(doc, &meta); err != nil {
+		return err
+	}
+
+	switch meta.Kind {
+	case "Index":
+		var index esv1.Index
+		if err := k8syaml.UnmarshalStrict(doc, &index); err != nil {
+			return err
+		}
+		if index.Namespace == "" {
+			index.Namespace = namespace
+		}
+		m.indices = append(m.indices, index)
+	case "ConfigMap":
+		var cm coreV1.ConfigMap
+		if err := k8syaml.Unmarshal(doc, &cm); err != nil {
+			return err
+		}
+		if cm.Namespace == "" {
+			cm.Namespace = namespace
+		}
+		m.configMaps[cm.Namespace+"/"+cm.Name] = &cm
+	}
+	return nil
+}
+
+func (m *manifests) getter(namespace string) indexspec.ConfigMapGetter {
+	return func(name string) (*coreV1.ConfigMap, error) {
+		cm, ok := m.configMaps[namespace+"/"+name]
+		if !ok {
+			return nil, fmt.Errorf("ConfigMap %s/%s not found in the manifests", namespace, name)
+		}
+		return cm, nil
+	}
+}
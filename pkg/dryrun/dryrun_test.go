@@ -0,0 +1,111 @@
+package dryrun
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+)
+
+const testIndex = `apiVersion: es-provisioner.com.ramos/v1
+kind: Index
+metadata:
+  name: logs
+spec:
+  application: app
+  configMap: logs-mapping
+`
+
+const testConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: logs-mapping
+data:
+  mapping.json: '{"mappings": {"_meta": {"cluster": "{{ .ClusterName }}", "namespace": "{{ .Namespace }}"}}}'
+`
+
+func TestDryRun(t *testing.T) {
+	// spec is the index body rendered from the Config Map
+	spec := `{"mappings": {"_meta": {"cluster": "east", "namespace": "test"}}}`
+
+	tests := []struct {
+		name string
+		// files are the manifests read
+		files []string
+		// service validates the requests, they are only rendered when nil
+		service *esfake.Service
+		err     string
+		// body is the rendered body of the index creation
+		body string
+	}{
+		{
+			name:  "renders the index body of the Config Map in another file",
+			files: []string{testIndex, testConfigMap + "---\napiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n"},
+			body: `{"mappings": {"_meta": {"cluster": "east", "namespace": "test",
+				"es-provisioner": {"cluster": "east", "namespace": "test", "index": "logs", "uid": ""}}}}`,
+		},
+		{
+			name:    "validates the index body with Elasticsearch",
+			files:   []string{testIndex + "---\n" + testConfigMap},
+			service: esfake.NewService(),
+		},
+		{
+			name:  "requires the Config Map of the Index",
+			files: []string{testIndex},
+			err:   "ConfigMap test/logs-mapping not found in the manifests",
+		},
+		{
+			name:  "rejects an unknown field of the Index",
+			files: []string{testIndex + "  shards: 2\n"},
+			err:   `unknown field "shards"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			paths := []string{}
+			for _, manifest := range tt.files {
+				path := filepath.Join(t.TempDir(), "manifest.yaml")
+				g.Expect(os.WriteFile(path, []byte(manifest), 0o600)).To(Succeed())
+				paths = append(paths, path)
+			}
+			var service es.EsService
+			if tt.service != nil {
+				service = tt.service
+			}
+
+			m, err := readManifests(paths, "test")
+			var result *es.EsDryRunResult
+			if err == nil {
+				g.Expect(m.indices).To(HaveLen(1))
+				result, err = dryRun(&m.indices[0], m, "east", service, false)
+			}
+			if tt.err != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+
+			if tt.service != nil {
+				calls := tt.service.Calls()
+				g.Expect(calls).To(HaveLen(1))
+				ops := calls[0].Args.(es.EsSetupOptions)
+				g.Expect(ops.Spec).To(MatchJSON(spec))
+				g.Expect(ops.Owner.Cluster).To(Equal("east"))
+				return
+			}
+			g.Expect(result.Errors).To(BeEmpty())
+			g.Expect(result.Requests[0].Body).To(MatchJSON(tt.body))
+			var out bytes.Buffer
+			printResult(&out, &m.indices[0], result)
+			g.Expect(out.String()).To(HavePrefix("# Index test/logs\n\nPUT /"))
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// adoptIndex takes over the existing index or alias with the index name. For an alias the write index
+// is adopted and the alias kept, for an index the index name is used as the alias.
+// The mappings are validated against the index body and the missing fields added.
+func (c *EsClient) adoptIndex(ops *EsSetupOptions, state *EsProvisionState) error {
+
+	if ops.IndexName == "" {
+		return &ValidationError{Reason: "Cannot adopt index: the index name is required"}
+	}
+	log.Infof("Adopting Index: %s", ops.IndexName)
+
+	index, e := c.aliasIndex(ops.IndexName)
+	if e != nil {
+		return e
+	}
+	aliasAdopted := index != ""
+	if index == "" {
+		exists, e := c.exists("index", "/"+ops.IndexName)
+		if e != nil {
+			return e
+		}
+		if !exists {
+			return &ValidationError{Reason: fmt.Sprintf("Cannot adopt index: index or alias %s not found", ops.IndexName)}
+		}
+		index = ops.IndexName
+	}
+	if e := c.checkOwner(KindIndex, index, ops.Owner); e != nil {
+		return e
+	}
+
+	body := indexBody(ops.Spec, ops.Shards, ops.Replicas, ops.RefreshInterval, ops.Analyzers, ops.Source, ops.Properties)
+	body, e = c.composeBody(index, body, ops.ComponentTemplates)
+	if e != nil {
+		return e
+	}
+	diff, e := c.diffMappings(index, body)
+	if e != nil {
+		return e
+	}
+	if len(diff.Conflicts) > 0 {
+		return &ValidationError{Reason: fmt.Sprintf("Cannot adopt index %s: %s", index, strings.Join(diff.Conflicts, "; "))}
+	}
+	if len(diff.Missing) > 0 {
+		updated, e := c.putMapping(index, diff.Mappings)
+		if e != nil {
+			return e
+		}
+		if !updated {
+			return &ValidationError{Reason: fmt.Sprintf("Cannot adopt index %s: fields %s cannot be added",
+				index, strings.Join(diff.Missing, ", "))}
+		}
+	}
+
+	// the pipelines are dynamic settings, the other settings of an adopted index are kept
+	settings := map[string]string{}
+	if ops.DefaultPipeline != "" {
+		settings["index.default_pipeline"] = ops.DefaultPipeline
+	}
+	if ops.FinalPipeline != "" {
+		settings["index.final_pipeline"] = ops.FinalPipeline
+	}
+	if len(settings) > 0 {
+		b, e := json.Marshal(settings)
+		if e != nil {
+			return fmt.Errorf("Cannot adopt index: %s", e)
+		}
+		e = c.putSettings(index, string(b))
+		if e != nil {
+			return e
+		}
+	}
+
+	if e := c.tagIndex(index, ops.Owner); e != nil {
+		return e
+	}
+
+	state.Index = index
+	state.Alias = ops.IndexName
+	state.Adopted = true
+	state.AliasAdopted = aliasAdopted
+	return nil
+}
+
+// adoptedBody removes from the index body the defaults the Index doesn't set, so the settings and
+// mappings options of an adopted index are kept. A payload from the Config Map is used as it is.
+func adoptedBody(body string, ops *EsSetupOptions) (string, error) {
+	if ops.Spec != "" {
+		return body, nil
+	}
+
+	var payload map[string]interface{}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return "", fmt.Errorf("Cannot render adopted index, invalid index body: %s", e)
+	}
+	settings := childMap(payload, "settings")
+	for key, set := range map[string]bool{
+		"index.number_of_shards":   ops.Shards != 0,
+		"index.number_of_replicas": ops.Replicas != 0,
+		"index.refresh_interval":   ops.RefreshInterval != "",
+		"analysis":                 ops.Analyzers != "",
+	} {
+		if !set {
+			delete(settings, key)
+		}
+	}
+	mappings := childMap(payload, "mappings")
+	delete(mappings, "_source")
+	delete(mappings, "dynamic")
+
+	b, e := json.Marshal(payload)
+	if e != nil {
+		return "", fmt.Errorf("Cannot render adopted index: %s", e)
+	}
+	return string(b), nil
+}
+
+// aliasIndex returns the write index of the alias, empty if the alias doesn't exist
+func (c *EsClient) aliasIndex(alias string) (string, error) {
+
+	res, err := c.perform(http.MethodGet, "/_alias/"+alias, "")
+	if err != nil {
+		return "", fmt.Errorf("Cannot get alias: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if res.StatusCode > 299 {
+		return "", fmt.Errorf("Cannot get alias: status %d", res.StatusCode)
+	}
+
+	var indices map[string]struct {
+		Aliases map[string]struct {
+			IsWriteIndex *bool `json:"is_write_index"`
+		} `json:"aliases"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return "", fmt.Errorf("Cannot get alias: %s", err)
+	}
+
+	names := []string{}
+	for name, index := range indices {
+		if w := index.Aliases[alias].IsWriteIndex; w != nil && *w {
+			return name, nil
+		}
+		names = append(names, name)
+	}
+	if len(names) != 1 {
+		return "", &ValidationError{Reason: fmt.Sprintf("Cannot adopt alias %s: it points to %d indices and none is the write index",
+			alias, len(names))}
+	}
+	return names[0], nil
+}
@@ -0,0 +1,112 @@
+package es_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+)
+
+func TestAdoptIndex(t *testing.T) {
+	// spec is the index body of the adopting Index
+	spec := `{"mappings": {"properties": {"name": {"type": "keyword"}}}}`
+
+	tests := []struct {
+		name string
+		// existing requests sent before adopting
+		existing func(g *WithT, server *esfake.Server)
+		err      string
+		// index, alias and aliasAdopted are the provisioning state after adopting
+		index        string
+		alias        string
+		aliasAdopted bool
+		// mappings of the adopted index
+		mappings string
+	}{
+		{
+			name: "uses the name of an index as its alias",
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/logs", `{"mappings": {"properties": {"name": {"type": "keyword"}}}}`)
+			},
+			index:    "logs",
+			alias:    "logs",
+			mappings: `{"properties": {"name": {"type": "keyword"}}, "_meta": {"es-provisioner": {"cluster": "test", "namespace": "ns", "index": "logs", "uid": "uid"}}}`,
+		},
+		{
+			name: "adopts the write index of an alias and adds the missing fields",
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/logs-1", `{"aliases": {"logs": {}}}`)
+				esDo(g, server, http.MethodPut, "/logs-2", `{"aliases": {"logs": {"is_write_index": true}}, "mappings": {"_meta": {"team": "search"}}}`)
+			},
+			index:        "logs-2",
+			alias:        "logs",
+			aliasAdopted: true,
+			mappings:     `{"properties": {"name": {"type": "keyword"}}, "_meta": {"team": "search", "es-provisioner": {"cluster": "test", "namespace": "ns", "index": "logs", "uid": "uid"}}}`,
+		},
+		{
+			name: "refuses an alias without write index",
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/logs-1", `{"aliases": {"logs": {}}}`)
+				esDo(g, server, http.MethodPut, "/logs-2", `{"aliases": {"logs": {}}}`)
+			},
+			err: "it points to 2 indices and none is the write index",
+		},
+		{
+			name: "refuses a missing index",
+			err:  "index or alias logs not found",
+		},
+		{
+			name: "refuses an index owned by another Index",
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/logs",
+					`{"mappings": {"_meta": {"es-provisioner": {"namespace": "other", "index": "logs", "uid": "other"}}}}`)
+			},
+			err: "Index logs is owned by Index other/logs",
+		},
+		{
+			name: "refuses an index with conflicting mappings",
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/logs", `{"mappings": {"properties": {"name": {"type": "long"}}}}`)
+			},
+			err: "Cannot adopt index logs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server, service := newTestService(g)
+			defer server.Close()
+			if tt.existing != nil {
+				tt.existing(g, server)
+			}
+
+			ops := &es.EsSetupOptions{IndexName: "logs", App: "app", Namespace: "ns", Spec: spec, Adopt: true, Owner: testOwner}
+			state := &es.EsProvisionState{Owner: testOwner}
+			err := service.ProvisionStep(ops, state)
+			if tt.err != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+				g.Expect(state.Step).To(BeEmpty())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(state.Step).To(Equal(es.StepIndexAdopted))
+			g.Expect(state.Index).To(Equal(tt.index))
+			g.Expect(state.Alias).To(Equal(tt.alias))
+			g.Expect(state.Adopted).To(BeTrue())
+			g.Expect(state.AliasAdopted).To(Equal(tt.aliasAdopted))
+
+			status, body := server.Do(http.MethodGet, "/"+tt.index+"/_mapping", "")
+			g.Expect(status).To(Equal(http.StatusOK))
+			g.Expect(body).To(MatchJSON(`{"` + tt.index + `": {"mappings": ` + tt.mappings + `}}`))
+
+			// the rollback of an adopted index keeps it
+			g.Expect(service.RollbackIndex(state)).To(Succeed())
+			status, _ = server.Do(http.MethodGet, "/"+tt.index, "")
+			g.Expect(status).To(Equal(http.StatusOK))
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// EsCheckOptions is the expected state of a provisioned index
+type EsCheckOptions struct {
+	EsSetupOptions
+	Index string
+	Alias string
+	Role  string
+	User  string
+	// Password of the user, the credentials aren't checked when it's unknown
+	Password string
+	// ResetPassword sets a new password for the user, returned in the report, when the Secret was lost
+	ResetPassword bool
+	// Adopted indices are only checked against the settings set explicitly
+	Adopted bool
+}
+
+// EsDriftReport lists the differences found between Elasticsearch and the expected state,
+// Repaired were fixed by the check and Drifted need manual intervention. Password is the new
+// password of the user when it was reset
+type EsDriftReport struct {
+	Repaired []string
+	Drifted  []string
+	Password string
+}
+
+func (r *EsDriftReport) repaired(format string, args ...interface{}) {
+	r.Repaired = append(r.Repaired, fmt.Sprintf(format, args...))
+}
+
+func (r *EsDriftReport) drifted(format string, args ...interface{}) {
+	r.Drifted = append(r.Drifted, fmt.Sprintf(format, args...))
+}
+
+// CheckIndex verifies the index, alias, settings, role, user and credentials still match the
+// expected state, repairing them where possible
+func (c *EsClient) CheckIndex(ops *EsCheckOptions) (*EsDriftReport, error) {
+	start := time.Now()
+	report, e := c.checkIndex(ops)
+	metrics.ObserveEsRequest("checkIndex", start, e)
+	return report, e
+}
+
+func (c *EsClient) checkIndex(ops *EsCheckOptions) (*EsDriftReport, error) {
+
+	log.Infof("Checking Index: %s", ops.Index)
+	report := &EsDriftReport{}
+	if e := c.checkOwners(ops.Owner, ops.Index, ops.Role, ops.User); e != nil {
+		return nil, e
+	}
+
+	body := indexBody(ops.Spec, ops.Shards, ops.Replicas, ops.RefreshInterval, ops.Analyzers, ops.Source, ops.Properties)
+	var e error
+	if ops.Adopted {
+		body, e = adoptedBody(body, &ops.EsSetupOptions)
+		if e != nil {
+			return nil, e
+		}
+	}
+	// the synonym sets are only written by UpdateSynonyms, the check doesn't reload the analyzers
+	body, e = synonymFilters(ops.Alias, body, ops.Synonyms, c.synonymsApi)
+	if e != nil {
+		return nil, e
+	}
+	body, e = c.composeBody(ops.Index, body, ops.ComponentTemplates)
+	if e != nil {
+		return nil, e
+	}
+	body, e = pipelineSettings(body, ops.DefaultPipeline, ops.FinalPipeline)
+	if e != nil {
+		return nil, e
+	}
+	body, e = ownerMeta(body, ops.Owner)
+	if e != nil {
+		return nil, e
+	}
+
+	recorded, exists, e := c.getOwner(KindIndex, ops.Index)
+	if e != nil {
+		return nil, e
+	}
+	// recreating the index empty would hide the loss of the data from the applications
+	if !exists {
+		report.drifted("index %s is missing, restore it from a snapshot or delete the Index to provision it again", ops.Index)
+		return report, nil
+	}
+	e = c.checkSettings(ops.Index, body, report)
+	if e != nil {
+		return nil, e
+	}
+	e = c.checkMappings(ops.Index, body, report)
+	if e != nil {
+		return nil, e
+	}
+	// indices provisioned before the owner was recorded
+	if recorded == nil {
+		e = c.tagIndex(ops.Index, ops.Owner)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	// adopted indices without alias are used by their name
+	exists = ops.Alias == ops.Index
+	if !exists {
+		exists, e = c.exists("alias", "/"+ops.Index+"/_alias/"+ops.Alias)
+		if e != nil {
+			return nil, e
+		}
+	}
+	if !exists {
+		_, _, e = c.addAlias(ops.Index, ops.Alias)
+		if e != nil {
+			return nil, e
+		}
+		report.repaired("alias %s was missing", ops.Alias)
+	}
+
+	e = c.checkRole(ops.Role, ops.Index, ops.Alias, ops.Owner, report)
+	if e != nil {
+		return nil, e
+	}
+
+	e = c.checkUser(ops, report)
+	if e != nil {
+		return nil, e
+	}
+
+	return report, nil
+}
+
+// checkSettings repairs dynamic settings changed manually, static ones are reported
+func (c *EsClient) checkSettings(index string, body string, report *EsDriftReport) error {
+
+	var payload struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return fmt.Errorf("Cannot check index, invalid index body: %s", e)
+	}
+
+	current, e := c.getSettings(index)
+	if e != nil {
+		return e
+	}
+
+	changed := map[string]interface{}{}
+	for key, value := range flattenSettings(payload.Settings, "") {
+		if !strings.HasPrefix(key, "index.") {
+			key = "index." + key
+		}
+		if fmt.Sprint(current[key]) == fmt.Sprint(value) {
+			continue
+		}
+		if isStaticSetting(key) {
+			report.drifted("setting %s is %v, expected %v", key, current[key], value)
+			continue
+		}
+		changed[key] = value
+	}
+
+	if len(changed) > 0 {
+		b, e := json.Marshal(changed)
+		if e != nil {
+			return fmt.Errorf("Cannot update settings: %s", e)
+		}
+		e = c.putSettings(index, string(b))
+		if e != nil {
+			return e
+		}
+		for key := range changed {
+			report.repaired("setting %s was %v", key, current[key])
+		}
+	}
+
+	return nil
+}
+
+// checkMappings adds missing fields and reports fields whose type changed
+func (c *EsClient) checkMappings(index string, body string, report *EsDriftReport) error {
+
+	diff, e := c.diffMappings(index, body)
+	if e != nil {
+		return e
+	}
+	for _, conflict := range diff.Conflicts {
+		report.drifted("%s", conflict)
+	}
+
+	if len(diff.Missing) > 0 {
+		updated, e := c.putMapping(index, diff.Mappings)
+		if e != nil {
+			return e
+		}
+		if updated {
+			report.repaired("fields %s were missing", strings.Join(diff.Missing, ", "))
+		} else {
+			report.drifted("fields %s are missing and cannot be added", strings.Join(diff.Missing, ", "))
+		}
+	}
+
+	return nil
+}
+
+// mappingDiff are the differences between the fields in the index body and the index
+type mappingDiff struct {
+	// Missing fields in the index
+	Missing []string
+	// Conflicts describe the fields with a different type
+	Conflicts []string
+	// Mappings in the index body
+	Mappings map[string]interface{}
+}
+
+// diffMappings compares the top level fields of the index body mappings with the index mappings
+func (c *EsClient) diffMappings(index string, body string) (*mappingDiff, error) {
+
+	var payload struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return nil, fmt.Errorf("Cannot check index, invalid index body: %s", e)
+	}
+	diff := &mappingDiff{Mappings: payload.Mappings}
+	properties, _ := payload.Mappings["properties"].(map[string]interface{})
+	if len(properties) == 0 {
+		return diff, nil
+	}
+
+	res, err := c.client.Indices.GetMapping(c.client.Indices.GetMapping.WithIndex(index))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get mapping: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError("get mapping", res)
+	}
+
+	var live map[string]struct {
+		Mappings struct {
+			Properties map[string]struct {
+				Type string `json:"type"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&live); err != nil {
+		return nil, fmt.Errorf("Cannot get mapping: %s", err)
+	}
+	current := live[index].Mappings.Properties
+
+	for name, value := range properties {
+		field, _ := value.(map[string]interface{})
+		expected, _ := field["type"].(string)
+		existing, ok := current[name]
+		if !ok {
+			diff.Missing = append(diff.Missing, name)
+			continue
+		}
+		if existing.Type != expected {
+			diff.Conflicts = append(diff.Conflicts, fmt.Sprintf("field %s is %s, expected %s", name, existing.Type, expected))
+		}
+	}
+	sort.Strings(diff.Missing)
+	sort.Strings(diff.Conflicts)
+
+	return diff, nil
+}
+
+// checkRole recreates the role when it's missing or doesn't grant access to the index, alias and pipelines
+func (c *EsClient) checkRole(role string, index string, alias string, owner *EsOwner, report *EsDriftReport) error {
+
+	res, err := c.client.Security.GetRole(c.client.Security.GetRole.WithName(role))
+	if err != nil {
+		return fmt.Errorf("Cannot get role: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() && res.StatusCode != http.StatusNotFound {
+		return responseError("get role", res)
+	}
+
+	var roles map[string]struct {
+		Cluster []string `json:"cluster"`
+		Indices []struct {
+			Names []string `json:"names"`
+		} `json:"indices"`
+	}
+	if res.StatusCode != http.StatusNotFound {
+		if err := json.NewDecoder(res.Body).Decode(&roles); err != nil {
+			return fmt.Errorf("Cannot get role: %s", err)
+		}
+	}
+
+	current, ok := roles[role]
+	if ok {
+		names := map[string]bool{}
+		for _, i := range current.Indices {
+			for _, n := range i.Names {
+				names[n] = true
+			}
+		}
+		pipelines := false
+		for _, p := range current.Cluster {
+			pipelines = pipelines || p == "read_pipeline"
+		}
+		if names[index] && names[alias] && pipelines {
+			return nil
+		}
+	}
+
+	e := c.putRole(role, index, alias, owner)
+	if e != nil {
+		return e
+	}
+	if ok {
+		report.repaired("role %s didn't grant access to the index or pipelines", role)
+	} else {
+		report.repaired("role %s was missing", role)
+	}
+	return nil
+}
+
+// checkUser recreates the user when it's missing or the credentials in the secret no longer work, and sets
+// a new password when it must be reset
+func (c *EsClient) checkUser(ops *EsCheckOptions, report *EsDriftReport) error {
+
+	user, pw := ops.User, ops.Password
+	exists, e := c.exists("user", "/_security/user/"+user)
+	if e != nil {
+		return e
+	}
+	switch {
+	case ops.ResetPassword:
+		pw = uuid.New().String()
+		e = c.putUser(user, pw, ops.Role, ops.Owner)
+		if e != nil {
+			return e
+		}
+		report.Password = pw
+		report.repaired("user %s password was reset, its secret was missing", user)
+		exists = false
+	case pw == "":
+		if !exists {
+			report.drifted("user %s is missing and its password is unknown", user)
+		}
+		return nil
+	case !exists:
+		e = c.putUser(user, pw, ops.Role, ops.Owner)
+		if e != nil {
+			return e
+		}
+		report.repaired("user %s was missing", user)
+	}
+
+	e = c.testCredentials(user, pw, ops.Index)
+	if e == nil {
+		return nil
+	}
+	if exists {
+		log.Warnf("Credentials for User %s failed, resetting: %s", user, e)
+		e = c.putUser(user, pw, ops.Role, ops.Owner)
+		if e != nil {
+			return e
+		}
+		e = c.testCredentials(user, pw, ops.Index)
+		if e == nil {
+			report.repaired("user %s credentials were reset", user)
+			return nil
+		}
+	}
+	report.drifted("user %s cannot access the index: %s", user, e)
+	return nil
+}
+
+func (c *EsClient) testCredentials(user string, pw string, index string) error {
+	esOps := EsOptions{
+		Connection: c.url,
+		Retries:    1,
+		Username:   user,
+		Password:   pw,
+	}
+	userClient, e := connectEsWithRetry(&esOps, 5*time.Second)
+	if e != nil {
+		return e
+	}
+	return testIndex(userClient, index)
+}
+
+// exists returns false when the resource returns not found
+func (c *EsClient) exists(kind string, path string) (bool, error) {
+	res, err := c.perform(http.MethodGet, path, "")
+	if err != nil {
+		return false, fmt.Errorf("Cannot get %s: %s", kind, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode > 299 {
+		return false, fmt.Errorf("Cannot get %s: status %d", kind, res.StatusCode)
+	}
+	return true, nil
+}
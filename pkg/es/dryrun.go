@@ -0,0 +1,345 @@
+package es
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"com.ramos/es-provisioner/pkg/model"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// maskedPassword replaces the user password in the rendered requests
+const maskedPassword = "********"
+
+// EsRequest is a request sent to Elasticsearch by a provisioning step
+type EsRequest struct {
+	Step   string `json:"step"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   string `json:"body,omitempty"`
+}
+
+// EsDryRunResult are the requests a provisioning would send and the problems found validating them
+type EsDryRunResult struct {
+	Requests []EsRequest
+	// Errors would make the provisioning fail
+	Errors []string
+	// Warnings are existing resources the provisioning would modify
+	Warnings []string
+}
+
+// RenderIndex returns the requests sent to provision the index without connecting to Elasticsearch,
+// the user password is masked. It fails when the index body is not valid JSON.
+func RenderIndex(ops *EsSetupOptions, synonymsApi bool) ([]EsRequest, error) {
+	alias := aliasName(ops)
+	index := indexName(alias)
+	role := roleName(ops.App, ops.Namespace)
+	requests := []EsRequest{}
+
+	if ops.Adopt {
+		// the write index of the alias is only known by the cluster
+		index = alias
+	} else {
+		if synonymsApi {
+			for _, set := range ops.Synonyms {
+				body, e := synonymSetBody(set.Synonyms)
+				if e != nil {
+					return nil, e
+				}
+				requests = append(requests, EsRequest{StepIndexCreated, http.MethodPut, "/_synonyms/" + synonymSetId(alias, set.Name), body})
+			}
+		}
+		body, e := createBody(ops, alias, synonymsApi)
+		if e != nil {
+			return nil, e
+		}
+		requests = append(requests,
+			EsRequest{StepIndexCreated, http.MethodPut, "/" + index, body},
+			EsRequest{StepAliasAdded, http.MethodPut, "/" + index + "/_alias/" + alias, ""})
+	}
+
+	metadata, e := json.Marshal(ops.Owner.metadata())
+	if e != nil {
+		return nil, e
+	}
+	roleBody, e := indent(fmt.Sprintf(model.ROLE_TEMPLATE, index, alias, metadata))
+	if e != nil {
+		return nil, e
+	}
+	userBody, e := indent(fmt.Sprintf(model.USER_TEMPLATE, maskedPassword, role, userName(role), metadata))
+	if e != nil {
+		return nil, e
+	}
+	requests = append(requests,
+		EsRequest{StepRoleCreated, http.MethodPut, "/_security/role/" + role, roleBody},
+		EsRequest{StepUserCreated, http.MethodPut, "/_security/user/" + userName(role), userBody})
+
+	return requests, nil
+}
+
+// DryRunIndex renders the provisioning requests and validates them against the cluster without creating anything:
+// the index body is validated simulating an index template and the alias, role and user checked for conflicts
+func (c *EsClient) DryRunIndex(ops *EsSetupOptions) (*EsDryRunResult, error) {
+	start := time.Now()
+	result, e := c.dryRunIndex(ops)
+	metrics.ObserveEsRequest("dryRunIndex", start, e)
+	return result, e
+}
+
+func (c *EsClient) dryRunIndex(ops *EsSetupOptions) (*EsDryRunResult, error) {
+
+	alias := aliasName(ops)
+	log.Infof("Dry run of Index: %s", alias)
+	result := &EsDryRunResult{}
+
+	requests, e := RenderIndex(ops, c.synonymsApi)
+	if e != nil {
+		result.Errors = append(result.Errors, e.Error())
+		return result, nil
+	}
+	result.Requests = requests
+
+	// synonyms are validated inline, the synonym sets don't exist yet
+	body, e := createBody(ops, alias, false)
+	if e != nil {
+		return nil, e
+	}
+
+	templates, e := c.validateComponentTemplates(ops.ComponentTemplates, result)
+	if e != nil {
+		return nil, e
+	}
+
+	e = c.validatePipelines(ops, result)
+	if e != nil {
+		return nil, e
+	}
+
+	if ops.Adopt {
+		e = c.validateAdoption(ops, body, templates, result)
+	} else {
+		e = c.validateCreation(alias, body, templates, result)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	role := roleName(ops.App, ops.Namespace)
+	exists, e := c.exists("role", "/_security/role/"+role)
+	if e != nil {
+		return nil, e
+	}
+	if exists {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("role %s exists and would be replaced", role))
+	}
+	exists, e = c.exists("user", "/_security/user/"+userName(role))
+	if e != nil {
+		return nil, e
+	}
+	if exists {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("user %s exists and its password would be reset", userName(role)))
+	}
+
+	return result, nil
+}
+
+// validateComponentTemplates reports the component templates not found and returns the ones that exist
+func (c *EsClient) validateComponentTemplates(names []string, result *EsDryRunResult) ([]string, error) {
+	missing, e := c.missingComponentTemplates(names)
+	if e != nil {
+		return nil, e
+	}
+	if len(missing) > 0 {
+		result.Errors = append(result.Errors, fmt.Sprintf("component templates %s not found", strings.Join(missing, ", ")))
+	}
+	isMissing := map[string]bool{}
+	for _, name := range missing {
+		isMissing[name] = true
+	}
+	existing := []string{}
+	for _, name := range names {
+		if !isMissing[name] {
+			existing = append(existing, name)
+		}
+	}
+	return existing, nil
+}
+
+// validatePipelines reports the default and final pipelines not found, documents couldn't be indexed
+func (c *EsClient) validatePipelines(ops *EsSetupOptions, result *EsDryRunResult) error {
+	for _, name := range []string{ops.DefaultPipeline, ops.FinalPipeline} {
+		if name == "" || name == NoPipeline {
+			continue
+		}
+		exists, e := c.exists("pipeline", "/_ingest/pipeline/"+name)
+		if e != nil {
+			return e
+		}
+		if !exists {
+			result.Errors = append(result.Errors, fmt.Sprintf("pipeline %s not found", name))
+		}
+	}
+	return nil
+}
+
+func (c *EsClient) validateCreation(alias string, body string, templates []string, result *EsDryRunResult) error {
+
+	index, e := c.aliasIndex(alias)
+	var validationErr *ValidationError
+	if errors.As(e, &validationErr) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("alias %s exists on several indices, the new index would be added to it", alias))
+	} else if e != nil {
+		return e
+	} else if index != "" {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("alias %s exists on index %s, the new index would be added to it", alias, index))
+	} else {
+		exists, e := c.exists("index", "/"+alias)
+		if e != nil {
+			return e
+		}
+		if exists {
+			result.Errors = append(result.Errors, fmt.Sprintf("an index named %s exists, the alias cannot be created, adopt it instead", alias))
+		}
+	}
+
+	return c.simulateIndex(indexName(alias), body, templates, result)
+}
+
+func (c *EsClient) validateAdoption(ops *EsSetupOptions, body string, templates []string, result *EsDryRunResult) error {
+
+	index, e := c.aliasIndex(ops.IndexName)
+	var validationErr *ValidationError
+	if errors.As(e, &validationErr) {
+		result.Errors = append(result.Errors, e.Error())
+		return nil
+	} else if e != nil {
+		return e
+	}
+	if index == "" {
+		exists, e := c.exists("index", "/"+ops.IndexName)
+		if e != nil {
+			return e
+		}
+		if !exists {
+			result.Errors = append(result.Errors, fmt.Sprintf("index or alias %s not found", ops.IndexName))
+			return nil
+		}
+		index = ops.IndexName
+	}
+
+	body, e = c.composeBody(index, body, templates)
+	if e != nil {
+		if IsTransient(e) {
+			return e
+		}
+		result.Errors = append(result.Errors, e.Error())
+		return nil
+	}
+	diff, e := c.diffMappings(index, body)
+	if e != nil {
+		return e
+	}
+	result.Errors = append(result.Errors, diff.Conflicts...)
+	if len(diff.Missing) > 0 {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("fields %s would be added to index %s", strings.Join(diff.Missing, ", "), index))
+	}
+	return nil
+}
+
+// simulateIndex validates the settings, mappings and aliases of the body composed with the component templates
+// with the simulate index template API
+func (c *EsClient) simulateIndex(index string, body string, templates []string, result *EsDryRunResult) error {
+
+	var payload map[string]interface{}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("invalid index body: %s", e))
+		return nil
+	}
+
+	template := map[string]interface{}{}
+	unknown := []string{}
+	for key, value := range payload {
+		switch key {
+		case "settings", "mappings", "aliases":
+			template[key] = value
+		default:
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		result.Errors = append(result.Errors, fmt.Sprintf("unknown key %s in index body, expected settings, mappings or aliases", key))
+	}
+
+	b, e := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{index},
+		"composed_of":    templates,
+		"priority":       math.MaxInt32,
+		"template":       template,
+	})
+	if e != nil {
+		return fmt.Errorf("Cannot simulate index: %s", e)
+	}
+
+	res, err := c.perform(http.MethodPost, "/_index_template/_simulate", string(b))
+	if err != nil {
+		return fmt.Errorf("Cannot simulate index: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		e := responseError("simulate index", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+		if IsTransient(e) {
+			return e
+		}
+		result.Errors = append(result.Errors, e.Error())
+	}
+	return nil
+}
+
+// createBody is the create index body with the synonym filters and the pipelines
+func createBody(ops *EsSetupOptions, alias string, synonymsApi bool) (string, error) {
+	body := indexBody(ops.Spec, ops.Shards, ops.Replicas, ops.RefreshInterval, ops.Analyzers, ops.Source, ops.Properties)
+	body, e := synonymFilters(alias, body, ops.Synonyms, synonymsApi)
+	if e != nil {
+		return "", e
+	}
+	body, e = pipelineSettings(body, ops.DefaultPipeline, ops.FinalPipeline)
+	if e != nil {
+		return "", e
+	}
+	body, e = ownerMeta(body, ops.Owner)
+	if e != nil {
+		return "", e
+	}
+	return indent(body)
+}
+
+func synonymSetBody(synonyms []string) (string, error) {
+	rules := make([]map[string]string, 0, len(synonyms))
+	for _, s := range synonyms {
+		rules = append(rules, map[string]string{"synonyms": s})
+	}
+	b, e := json.MarshalIndent(map[string]interface{}{"synonyms_set": rules}, "", "  ")
+	if e != nil {
+		return "", fmt.Errorf("Cannot render synonyms set: %s", e)
+	}
+	return string(b), nil
+}
+
+// indent validates and indents the JSON body
+func indent(body string) (string, error) {
+	var out bytes.Buffer
+	if e := json.Indent(&out, []byte(body), "", "  "); e != nil {
+		return "", fmt.Errorf("invalid JSON body: %s", e)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,100 @@
+package es
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// EsError is returned when Elasticsearch rejects a request, it contains the error type and reason
+// from the response body
+type EsError struct {
+	Action string
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *EsError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("Cannot %s: [%d] %s", e.Action, e.Status, e.Reason)
+	}
+	return fmt.Sprintf("Cannot %s: [%d] %s: %s", e.Action, e.Status, e.Type, e.Reason)
+}
+
+// responseError reads the error response body into an EsError
+func responseError(action string, res *esapi.Response) error {
+	e := &EsError{
+		Action: action,
+		Status: res.StatusCode,
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		e.Reason = err.Error()
+		return e
+	}
+
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || len(payload.Error) == 0 {
+		e.Reason = string(body)
+		return e
+	}
+
+	// the error is an object for most APIs but a plain string for some of them
+	var cause struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(payload.Error, &cause); err == nil {
+		e.Type = cause.Type
+		e.Reason = cause.Reason
+	} else {
+		_ = json.Unmarshal(payload.Error, &e.Reason)
+	}
+	return e
+}
+
+// ValidationError is returned when the existing resources in Elasticsearch don't match the spec
+type ValidationError struct {
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Reason
+}
+
+// IsTransient returns false when Elasticsearch rejected the request and retrying it won't help,
+// connection errors, timeouts, throttling and server errors are transient
+func IsTransient(err error) bool {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return false
+	}
+	var esErr *EsError
+	if !errors.As(err, &esErr) {
+		return true
+	}
+	switch {
+	case esErr.Status == http.StatusRequestTimeout, esErr.Status == http.StatusConflict,
+		esErr.Status == http.StatusTooManyRequests, esErr.Status >= http.StatusInternalServerError:
+		return true
+	default:
+		return false
+	}
+}
+
+// ignoreNotFound returns nil when Elasticsearch didn't find the resource
+func ignoreNotFound(err error) error {
+	var esErr *EsError
+	if errors.As(err, &esErr) && esErr.Status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
@@ -2,11 +2,15 @@ package es
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"net/http"
 	"strings"
 	"time"
 
+	"com.ramos/es-provisioner/pkg/metrics"
 	"com.ramos/es-provisioner/pkg/model"
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
@@ -17,14 +21,76 @@ import (
 var ctx = context.Background()
 
 type EsClient struct {
-	client *elasticsearch.Client
-	url    string
-	env    string
+	client      *elasticsearch.Client
+	url         string
+	env         string
+	synonymsApi bool
 }
 
 type EsService interface {
 	InitializeIndex(ops *EsSetupOptions) (*EsResult, error)
+	ProvisionStep(ops *EsSetupOptions, state *EsProvisionState) error
+	RollbackIndex(state *EsProvisionState) error
 	RemoveIndex(ops *EsRemoveOptions) error
+	UpdateIndex(ops *EsUpdateOptions) (*EsResult, error)
+	UpdateSynonyms(ops *EsSynonymOptions) error
+	GetIndexStats(index string) (*EsIndexStats, error)
+	CheckIndex(ops *EsCheckOptions) (*EsDriftReport, error)
+	GetIndices(pattern string) ([]EsIndexDefinition, error)
+	RotatePassword(user string, role string, owner *EsOwner) (string, error)
+	SnapshotIndex(repository string, index string) (string, error)
+	DryRunIndex(ops *EsSetupOptions) (*EsDryRunResult, error)
+	PutComponentTemplate(ops *EsComponentTemplateOptions) error
+	DeleteComponentTemplate(name string) error
+	PutIndexTemplate(ops *EsIndexTemplateOptions) error
+	DeleteIndexTemplate(name string) error
+	PutPipeline(ops *EsPipelineOptions) error
+	DeletePipeline(name string) error
+	SimulatePipeline(ops *EsPipelineOptions, docs []string) ([]EsSimulatedDocument, error)
+	CreateSnapshot(repository string, snapshot string, indices []string) error
+	GetSnapshot(repository string, snapshot string) (*EsSnapshotInfo, error)
+	DeleteSnapshot(repository string, snapshot string) error
+	RestoreSnapshot(ops *EsRestoreOptions) error
+	IndexRecovered(index string) (bool, error)
+	SwapIndex(ops *EsSwapOptions) error
+	PutSnapshotLifecycle(ops *EsSnapshotLifecycleOptions) error
+	GetSnapshotLifecycle(name string) (*EsSnapshotLifecycleStatus, error)
+	DeleteSnapshotLifecycle(name string) error
+	PutSnapshotRepository(ops *EsSnapshotRepositoryOptions) error
+	VerifySnapshotRepository(name string) ([]string, error)
+	DeleteSnapshotRepository(name string) error
+	CatIndices(names []string) ([]EsIndexUsage, error)
+	ListOwned() ([]EsOwnedResource, error)
+	DeleteOwned(resource *EsOwnedResource) error
+	ClusterHealth() (*EsClusterHealth, error)
+	IndexShards(ops *EsSetupOptions) (int, error)
+	Ping() error
+}
+
+// Steps reported to EsStepFunc
+const (
+	StepIndexCreated        = "IndexCreated"
+	StepIndexAdopted        = "IndexAdopted"
+	StepAliasAdded          = "AliasAdded"
+	StepRoleCreated         = "RoleCreated"
+	StepUserCreated         = "UserCreated"
+	StepCredentialsVerified = "CredentialsVerified"
+	StepIndexUpdated        = "IndexUpdated"
+	StepIndexMigrating      = "IndexMigrating"
+	StepIndexMigrated       = "IndexMigrated"
+	StepAliasDeleted        = "AliasDeleted"
+	StepIndexDeleted        = "IndexDeleted"
+	StepUserDeleted         = "UserDeleted"
+	StepRoleDeleted         = "RoleDeleted"
+)
+
+// EsStepFunc is called after each step completed in Elasticsearch
+type EsStepFunc func(step string, message string)
+
+func (f EsStepFunc) notify(step string, format string, args ...interface{}) {
+	if f != nil {
+		f(step, fmt.Sprintf(format, args...))
+	}
 }
 
 type EsResult struct {
@@ -33,6 +99,22 @@ type EsResult struct {
 	Role     string
 	Index    string
 	Alias    string
+	// Migration is the migration started or still running, Index is the current index until it completes
+	Migration *EsMigration
+}
+
+// EsMigration is a migration of the data to a new index. The reindex task runs in Elasticsearch, the
+// migration is completed by the first update after the task.
+type EsMigration struct {
+	// Index is the new index the data is copied to
+	Index string
+	// Task is the id of the reindex task
+	Task string
+}
+
+type EsIndexStats struct {
+	Docs      int64
+	StoreSize int64
 }
 
 type EsOptions struct {
@@ -40,6 +122,8 @@ type EsOptions struct {
 	Retries    int
 	Username   string
 	Password   string
+	// Limiter caps the requests sent to the cluster, unlimited when nil
+	Limiter *Limiter
 }
 
 type EsSetupOptions struct {
@@ -53,6 +137,78 @@ type EsSetupOptions struct {
 	Analyzers       string
 	Properties      string
 	Source          bool
+	Synonyms        []EsSynonymSet
+	// ComponentTemplates are merged into the index body in order, the index body takes precedence
+	ComponentTemplates []string
+	// DefaultPipeline and FinalPipeline are the ingest pipelines set in the index settings
+	DefaultPipeline string
+	FinalPipeline   string
+	// Adopt takes over the existing index or alias with the IndexName instead of creating one
+	Adopt bool
+	// Owner is recorded on the index, role and user, the objects of another owner aren't changed
+	Owner  *EsOwner
+	OnStep EsStepFunc
+}
+
+type EsUpdateOptions struct {
+	EsSetupOptions
+	Index string
+	Alias string
+	Role  string
+	// Reindex migrates the data to a new index even if the changes can be applied in place
+	Reindex bool
+	// Adopted indices are only changed in place, with the settings set explicitly
+	Adopted bool
+	// Migration is the migration in progress, the update only completes it once its task is done
+	Migration *EsMigration
+}
+
+// EsSynonymSet is a synonym token filter added to the index analysis settings
+type EsSynonymSet struct {
+	Name       string
+	Type       string
+	Updateable bool
+	Synonyms   []string
+}
+
+type EsSynonymOptions struct {
+	Index    string
+	Alias    string
+	Synonyms []EsSynonymSet
+}
+
+// EsProvisionState is the progress of a provisioning, Step is the last step completed.
+// Adopted is set when the index existed before and must not be deleted on rollback, AliasAdopted
+// when its alias existed too. Created are the kinds of the objects the provisioning created, the
+// only ones deleted on rollback
+type EsProvisionState struct {
+	Step         string
+	Index        string
+	Alias        string
+	Role         string
+	User         string
+	Password     string
+	Adopted      bool
+	AliasAdopted bool
+	Created      []string
+	Owner        *EsOwner
+}
+
+// created is true when the provisioning created the object of the kind
+func (s *EsProvisionState) created(kind string) bool {
+	for _, k := range s.Created {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// setCreated records the object of the kind as created by the provisioning
+func (s *EsProvisionState) setCreated(kind string, created bool) {
+	if created && !s.created(kind) {
+		s.Created = append(s.Created, kind)
+	}
 }
 
 type EsRemoveOptions struct {
@@ -60,92 +216,458 @@ type EsRemoveOptions struct {
 	Alias string
 	Role  string
 	User  string
+	// Migration is the migration in progress, its task is cancelled and its index deleted
+	Migration *EsMigration
+	Owner     *EsOwner
+	OnStep    EsStepFunc
 }
 
+// InitializeIndex runs all the provisioning steps: creates the index, alias, role and user and verifies
+// the user can access the index
 func (c *EsClient) InitializeIndex(ops *EsSetupOptions) (*EsResult, error) {
 
 	log.Info("Creating Index...")
 
-	var name string
+	state := &EsProvisionState{}
+	for state.Step != StepCredentialsVerified {
+		e := c.ProvisionStep(ops, state)
+		if e != nil {
+			return nil, e
+		}
+	}
 
-	if ops.IndexName == "" {
-		if ops.Namespace == "" {
-			name = "es-provisioner-" + uuid.New().String()[:8]
-		} else {
-			name = "es-provisioner-" + ops.App + "-" + ops.Namespace
+	return &EsResult{
+		UserName: state.User,
+		Password: state.Password,
+		Role:     state.Role,
+		Index:    state.Index,
+		Alias:    state.Alias,
+	}, nil
+}
+
+// ProvisionStep runs the provisioning step following state.Step and records its outcome in the state,
+// every step can be safely retried so a provisioning can be resumed from a persisted state.
+// The password is only set by StepUserCreated and must be provided to verify the credentials.
+func (c *EsClient) ProvisionStep(ops *EsSetupOptions, state *EsProvisionState) error {
+
+	if state.Alias == "" {
+		state.Alias = aliasName(ops)
+	}
+
+	switch state.Step {
+	case "":
+		if ops.Adopt {
+			start := time.Now()
+			e := c.adoptIndex(ops, state)
+			metrics.ObserveEsRequest("adoptIndex", start, e)
+			if e != nil {
+				log.Errorf("Error adopting Index %s. Error: %s", ops.IndexName, e.Error())
+				return e
+			}
+			state.Step = StepIndexAdopted
+			ops.OnStep.notify(StepIndexAdopted, "Index %s adopted with alias %s", state.Index, state.Alias)
+			break
+		}
+		if state.Index == "" {
+			state.Index = indexName(state.Alias)
+		}
+		start := time.Now()
+		created, e := c.isNew(KindIndex, state.Index, ops.Owner)
+		if e == nil {
+			e = c.createIndex(state.Index, state.Alias, ops)
+		}
+		metrics.ObserveEsRequest("createIndex", start, e)
+		if e != nil {
+			log.Errorf("Error creating Index %s. Error: %s", state.Index, e.Error())
+			return e
 		}
+		state.setCreated(KindIndex, created)
+		state.Step = StepIndexCreated
+		ops.OnStep.notify(StepIndexCreated, "Index %s created", state.Index)
+
+	case StepIndexCreated, StepIndexAdopted:
+		if state.Alias == state.Index {
+			// an adopted index without alias keeps being used by its name
+			state.Step = StepAliasAdded
+			break
+		}
+		start := time.Now()
+		_, _, e := c.addAlias(state.Index, state.Alias)
+		metrics.ObserveEsRequest("addAlias", start, e)
+		if e != nil {
+			log.Errorf("Error creating Alias %s. Error: %s", state.Alias, e.Error())
+			return e
+		}
+		state.Step = StepAliasAdded
+		ops.OnStep.notify(StepAliasAdded, "Alias %s added to Index %s", state.Alias, state.Index)
+
+	case StepAliasAdded:
+		log.Info("Creating Role...")
+		start := time.Now()
+		created, e := c.isNew(KindRole, roleName(ops.App, ops.Namespace), ops.Owner)
+		roleName := ""
+		if e == nil {
+			roleName, e = c.createRole(state.Index, state.Alias, ops.App, ops.Namespace, ops.Owner)
+		}
+		metrics.ObserveEsRequest("createRole", start, e)
+		if e != nil {
+			log.Errorf("Error creating Role. ERROR: %s", e.Error())
+			return e
+		}
+		state.setCreated(KindRole, created)
+		state.Role = roleName
+		state.Step = StepRoleCreated
+		ops.OnStep.notify(StepRoleCreated, "Role %s created", roleName)
+
+	case StepRoleCreated:
+		log.Info("Creating User...")
+		start := time.Now()
+		created, e := c.isNew(KindUser, userName(state.Role), ops.Owner)
+		userName, pw := "", ""
+		if e == nil {
+			userName, pw, e = c.createUser(state.Index, state.Role, ops.Owner)
+		}
+		metrics.ObserveEsRequest("createUser", start, e)
+		if e != nil {
+			log.Errorf("Error creating User ERROR: %s", e.Error())
+			return e
+		}
+		state.setCreated(KindUser, created)
+		state.User = userName
+		state.Password = pw
+		state.Step = StepUserCreated
+		ops.OnStep.notify(StepUserCreated, "User %s created", userName)
+
+	case StepUserCreated:
+		log.Info("Testing credentials")
+		start := time.Now()
+		e := c.testCredentials(state.User, state.Password, state.Index)
+		metrics.ObserveEsRequest("testIndex", start, e)
+		if e != nil {
+			log.Errorf("Error testing credentials ERROR: %s", e.Error())
+			return e
+		}
+		state.Step = StepCredentialsVerified
+		ops.OnStep.notify(StepCredentialsVerified, "User %s can access Index %s", state.User, state.Index)
 
-	} else {
-		name = ops.IndexName
+	default:
+		return fmt.Errorf("Cannot provision index, unknown step %s", state.Step)
 	}
 
-	indexName, aliasName, e := c.createIndex(name, ops.Spec, ops.Shards,
-		ops.Replicas, ops.RefreshInterval, ops.Analyzers, ops.Source, ops.Properties)
-	if e != nil {
-		log.Errorf("Error creating Index %s. Error: %s", indexName, e.Error())
-		return nil, e
+	return nil
+}
+
+// RollbackIndex deletes the resources created by a failed provisioning, resources not created yet
+// or already deleted are skipped
+func (c *EsClient) RollbackIndex(state *EsProvisionState) error {
+	start := time.Now()
+	e := c.rollbackIndex(state)
+	metrics.ObserveEsRequest("rollbackIndex", start, e)
+	return e
+}
+
+func (c *EsClient) rollbackIndex(state *EsProvisionState) error {
+	log.Infof("Rolling back Index %s from step %s", state.Index, state.Step)
+
+	// only the objects created by the provisioning are deleted, not the ones it reused like objects
+	// without owner predating the operator, nor the ones another Index took since
+	rollback := func(kind string, name string, delete func(string) error) error {
+		if name == "" {
+			return nil
+		}
+		if !state.created(kind) {
+			log.Warnf("Not rolling back %s %s, it wasn't created by the provisioning", kind, name)
+			return nil
+		}
+		recorded, exists, e := c.getOwner(kind, name)
+		if e != nil || !exists {
+			return e
+		}
+		if recorded != nil && !state.Owner.owns(recorded) {
+			log.Warnf("Not rolling back %s %s owned by Index %s", kind, name, recorded)
+			return nil
+		}
+		return ignoreNotFound(delete(name))
+	}
+
+	if e := rollback(KindUser, state.User, c.deleteUser); e != nil {
+		return e
+	}
+	if e := rollback(KindRole, state.Role, c.deleteRole); e != nil {
+		return e
+	}
+	// the alias is removed with the index, adopted indices are kept without the alias added to them
+	if !state.Adopted {
+		if e := rollback(KindIndex, state.Index, c.deleteIndex); e != nil {
+			return e
+		}
+	} else if !state.AliasAdopted && state.Alias != "" && state.Alias != state.Index {
+		if e := ignoreNotFound(c.deleteAlias(state.Index, state.Alias)); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func roleName(app string, namespace string) string {
+	return app + "-" + namespace + "-role"
+}
+
+func userName(role string) string {
+	return role + "-user"
+}
+
+// indexName is the name of the index created for the alias
+func indexName(alias string) string {
+	return alias + "-" + time.Now().Format(time.RFC3339)[:10]
+}
+
+// aliasName is the name of the alias used by the applications, the index name when provided
+func aliasName(ops *EsSetupOptions) string {
+	if ops.IndexName != "" {
+		return ops.IndexName
+	}
+	if ops.Namespace == "" {
+		return "es-provisioner-" + uuid.New().String()[:8]
+	}
+	return "es-provisioner-" + ops.App + "-" + ops.Namespace
+}
+
+// RemoveIndex deletes the alias, index, user and role of an Index, the resources without name or
+// already deleted are skipped so an interrupted removal can be completed
+func (c *EsClient) RemoveIndex(ops *EsRemoveOptions) error {
+	if e := c.checkOwners(ops.Owner, ops.Index, ops.Role, ops.User); e != nil {
+		return e
+	}
+	remove := func(operation string, name string, step string, message string, delete func() error) error {
+		if name == "" {
+			return nil
+		}
+		start := time.Now()
+		e := ignoreNotFound(delete())
+		metrics.ObserveEsRequest(operation, start, e)
+		if e != nil {
+			return e
+		}
+		ops.OnStep.notify(step, message, name)
+		return nil
 	}
 
-	log.Info("Creating Role...")
-	roleName, e := c.createRole(indexName, aliasName, ops.App, ops.Namespace)
+	if m := ops.Migration; m != nil {
+		if e := remove("deleteIndex", m.Index, StepIndexDeleted, "Index %s deleted", func() error {
+			return c.cancelMigration(m, ops.Owner)
+		}); e != nil {
+			return e
+		}
+	}
+
+	// the alias only exists on its index, an adopted index without alias is deleted by its name
+	alias := ops.Alias
+	if alias == ops.Index || ops.Index == "" {
+		alias = ""
+	}
+	if e := remove("deleteAlias", alias, StepAliasDeleted, "Alias %s deleted", func() error {
+		return c.deleteAlias(ops.Index, alias)
+	}); e != nil {
+		return e
+	}
+	if e := remove("deleteIndex", ops.Index, StepIndexDeleted, "Index %s deleted", func() error {
+		return c.deleteIndex(ops.Index)
+	}); e != nil {
+		return e
+	}
+	if e := remove("deleteUser", ops.User, StepUserDeleted, "User %s deleted", func() error {
+		return c.deleteUser(ops.User)
+	}); e != nil {
+		return e
+	}
+	return remove("deleteRole", ops.Role, StepRoleDeleted, "Role %s deleted", func() error {
+		return c.deleteRole(ops.Role)
+	})
+}
+
+// UpdateIndex applies the payload to an existing index. Dynamic settings and new mappings are
+// updated in place, any other change starts a migration of the data into a new index behind the same
+// alias, returned in the result. Updating an index with a migration in progress completes it once its
+// reindex task is done. A migration failing once the alias was moved returns the new index along with
+// the error.
+func (c *EsClient) UpdateIndex(ops *EsUpdateOptions) (*EsResult, error) {
+	start := time.Now()
+	result, e := c.updateIndex(ops)
+	metrics.ObserveEsRequest("updateIndex", start, e)
+	return result, e
+}
+
+func (c *EsClient) updateIndex(ops *EsUpdateOptions) (*EsResult, error) {
+
+	log.Infof("Updating Index: %s", ops.Index)
+	if e := c.checkOwner(KindIndex, ops.Index, ops.Owner); e != nil {
+		return nil, e
+	}
+	if ops.Adopted && ops.Reindex {
+		return nil, &ValidationError{Reason: fmt.Sprintf("Cannot reindex index %s: it was adopted", ops.Index)}
+	}
+	if ops.Migration != nil {
+		return c.completeMigration(ops)
+	}
+	body := indexBody(ops.Spec, ops.Shards, ops.Replicas, ops.RefreshInterval, ops.Analyzers, ops.Source, ops.Properties)
+	var e error
+	if ops.Adopted {
+		body, e = adoptedBody(body, &ops.EsSetupOptions)
+		if e != nil {
+			return nil, e
+		}
+	}
+	body, e = c.addSynonymFilters(ops.Alias, body, ops.Synonyms)
 	if e != nil {
-		log.Errorf("Error creating Role. ERROR: %s", e.Error())
 		return nil, e
 	}
-
-	log.Info("Creating User...")
-	userName, pw, e := c.createUser(indexName, roleName)
+	body, e = c.composeBody(ops.Index, body, ops.ComponentTemplates)
 	if e != nil {
-		log.Errorf("Error creating User ERROR: %s", e.Error())
 		return nil, e
 	}
-
-	log.Info("Testing credentials")
-	esOps := EsOptions{
-		Connection: c.url,
-		Retries:    1,
-		Username:   userName,
-		Password:   pw,
+	body, e = pipelineSettings(body, ops.DefaultPipeline, ops.FinalPipeline)
+	if e != nil {
+		return nil, e
 	}
-	userClient, e := connectEsWithRetry(&esOps, 5*time.Second)
+	body, e = ownerMeta(body, ops.Owner)
 	if e != nil {
-		log.Errorf("Error testing credentials ERROR: %s", e.Error())
 		return nil, e
 	}
 
-	e = testIndex(userClient, indexName)
+	updated := false
+	if !ops.Reindex {
+		updated, e = c.updateInPlace(ops.Index, body)
+	}
 	if e != nil {
-		log.Errorf("Error testing credentials ERROR: %s", e.Error())
+		log.Errorf("Error updating Index %s. Error: %s", ops.Index, e.Error())
 		return nil, e
 	}
 
+	indexName := ops.Index
+	if updated {
+		ops.OnStep.notify(StepIndexUpdated, "Index %s updated in place", indexName)
+	} else if ops.Adopted {
+		// the data of an adopted index is never moved, migrating would delete it
+		return nil, &ValidationError{Reason: fmt.Sprintf(
+			"Cannot migrate index %s: it was adopted, the change must be applied in place", ops.Index)}
+	} else if ops.Alias == ops.Index {
+		return nil, &ValidationError{Reason: fmt.Sprintf(
+			"Cannot migrate index %s: it was adopted without an alias, the change must be applied in place", ops.Index)}
+	} else {
+		migration, e := c.startMigration(ops.Index, ops.Alias, body, ops.Owner)
+		if e != nil {
+			log.Errorf("Error migrating Index %s. Error: %s", ops.Index, e.Error())
+			return nil, e
+		}
+		ops.OnStep.notify(StepIndexMigrating, "Index %s migrating to %s with reindex task %s", ops.Index, migration.Index, migration.Task)
+		return &EsResult{Role: ops.Role, Index: ops.Index, Alias: ops.Alias, Migration: migration}, nil
+	}
+
 	return &EsResult{
-		UserName: userName,
-		Password: pw,
-		Role:     roleName,
-		Index:    indexName,
-		Alias:    aliasName,
+		Role:  ops.Role,
+		Index: indexName,
+		Alias: ops.Alias,
 	}, nil
 }
 
-func (c *EsClient) RemoveIndex(ops *EsRemoveOptions) error {
-	e := c.deleteAlias(ops.Index, ops.Alias)
-	if e != nil {
-		return e
+// UpdateSynonyms applies new synonym lists to an existing index. Using the synonyms API
+// when the cluster supports it, otherwise the index is closed to update the filters.
+func (c *EsClient) UpdateSynonyms(ops *EsSynonymOptions) error {
+	start := time.Now()
+	e := c.updateSynonyms(ops)
+	metrics.ObserveEsRequest("updateSynonyms", start, e)
+	return e
+}
+
+func (c *EsClient) updateSynonyms(ops *EsSynonymOptions) error {
+
+	log.Infof("Updating Synonyms for Index: %s", ops.Index)
+	if c.synonymsApi {
+		reopen := false
+		for _, set := range ops.Synonyms {
+			e := c.putSynonymSet(synonymSetId(ops.Alias, set.Name), set.Synonyms)
+			if e != nil {
+				return e
+			}
+			// search analyzers are reloaded by Elasticsearch, index analyzers require the index to be reopened
+			if !set.Updateable {
+				reopen = true
+			}
+		}
+		if !reopen {
+			return nil
+		}
+		e := c.closeIndex(ops.Index)
+		if e != nil {
+			return e
+		}
+		return c.openIndex(ops.Index)
 	}
-	e = c.deleteIndex(ops.Index)
-	if e != nil {
-		return e
+
+	filters := map[string]interface{}{}
+	for _, set := range ops.Synonyms {
+		filters[set.Name] = map[string]interface{}{
+			"type":     synonymType(set.Type),
+			"synonyms": set.Synonyms,
+		}
 	}
-	e = c.deleteUser(ops.User)
+	body, e := json.Marshal(map[string]interface{}{
+		"analysis": map[string]interface{}{"filter": filters},
+	})
 	if e != nil {
-		return e
+		return fmt.Errorf("Cannot update synonyms: %s", e)
 	}
-	e = c.deleteRole(ops.Role)
+
+	e = c.closeIndex(ops.Index)
 	if e != nil {
 		return e
 	}
-	return nil
+	e = c.putSettings(ops.Index, string(body))
+	// always reopen the index, even if the settings were rejected
+	if oe := c.openIndex(ops.Index); oe != nil && e == nil {
+		e = oe
+	}
+	return e
+}
+
+// GetIndexStats returns the primary documents and total store size of the index or alias
+func (c *EsClient) GetIndexStats(index string) (*EsIndexStats, error) {
+	start := time.Now()
+	res, err := c.client.Indices.Stats(
+		c.client.Indices.Stats.WithIndex(index),
+		c.client.Indices.Stats.WithMetric("docs", "store"))
+	metrics.ObserveEsRequest("getIndexStats", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get index stats: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError("get index stats", res)
+	}
+
+	var body struct {
+		All struct {
+			Primaries struct {
+				Docs struct {
+					Count int64 `json:"count"`
+				} `json:"docs"`
+			} `json:"primaries"`
+			Total struct {
+				Store struct {
+					SizeInBytes int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"total"`
+		} `json:"_all"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("Cannot get index stats: %s", err)
+	}
+
+	return &EsIndexStats{
+		Docs:      body.All.Primaries.Docs.Count,
+		StoreSize: body.All.Total.Store.SizeInBytes,
+	}, nil
 }
 
 // NewEsService Creates new Service
@@ -158,16 +680,25 @@ func NewEsService(ops *EsOptions) (EsService, error) {
 		return nil, e
 	}
 
-	_, e = client.Ping()
+	res, e := client.Ping()
 	if e != nil {
 		return nil, e
 	}
+	res.Body.Close()
 
 	c := &EsClient{
 		client: client,
 		url:    ops.Connection,
 	}
 
+	version, e := serverVersion(client)
+	if e != nil {
+		log.Warnf("Cannot get Elasticsearch version, synonyms API disabled: %s", e)
+	} else {
+		c.synonymsApi = supportsSynonymsApi(version)
+		log.Infof("Elasticsearch version %s, synonyms API: %t", version, c.synonymsApi)
+	}
+
 	return c, nil
 
 }
@@ -176,13 +707,12 @@ func (c *EsClient) deleteAlias(index string, alias string) error {
 
 	log.Infof("Delete Alias: %s", alias)
 	res, err := c.client.Indices.DeleteAlias([]string{index}, []string{alias})
-
-	defer res.Body.Close()
 	if err != nil {
 		return fmt.Errorf("Cannot delete Alias: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		return fmt.Errorf("Cannot delete Alias: %s", res.String())
+		return responseError("delete Alias", res)
 
 	}
 
@@ -193,13 +723,12 @@ func (c *EsClient) deleteIndex(index string) error {
 
 	log.Infof("Delete Index: %s", index)
 	res, err := c.client.Indices.Delete([]string{index})
-
-	defer res.Body.Close()
 	if err != nil {
 		return fmt.Errorf("Cannot delete index: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		return fmt.Errorf("Cannot delete index: %s", res.String())
+		return responseError("delete index", res)
 
 	}
 
@@ -210,13 +739,12 @@ func (c *EsClient) deleteUser(user string) error {
 
 	log.Infof("Delete User: %s", user)
 	res, err := c.client.Security.DeleteUser(user)
-
-	defer res.Body.Close()
 	if err != nil {
 		return fmt.Errorf("Cannot delete User: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		return fmt.Errorf("Cannot delete User: %s", res.String())
+		return responseError("delete User", res)
 
 	}
 
@@ -227,13 +755,12 @@ func (c *EsClient) deleteRole(role string) error {
 
 	log.Infof("Delete Role: %s", role)
 	res, err := c.client.Security.DeleteRole(role)
-
-	defer res.Body.Close()
 	if err != nil {
 		return fmt.Errorf("Cannot delete Role: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		return fmt.Errorf("Cannot delete Role: %s", res.String())
+		return responseError("delete Role", res)
 
 	}
 
@@ -244,6 +771,7 @@ func connectEsWithRetry(ops *EsOptions, sleep time.Duration) (e *elasticsearch.C
 	for i := 0; i < ops.Retries; i++ {
 		if i > 0 {
 			log.Warnf("retrying after error: %v, Attempt: %v", err, i)
+			metrics.EsRetries.WithLabelValues("connect").Inc()
 			time.Sleep(sleep)
 			sleep *= 2
 		}
@@ -265,6 +793,7 @@ func connectEs(ops *EsOptions) (*elasticsearch.Client, error) {
 		RetryBackoff: func(i int) time.Duration {
 			d := time.Duration(math.Exp2(float64(i))) * time.Second
 			log.Warnf("Attempt: %d | Sleeping for %s...\n", i, d)
+			metrics.EsRetries.WithLabelValues("request").Inc()
 			return d
 		},
 	}
@@ -276,6 +805,9 @@ func connectEs(ops *EsOptions) (*elasticsearch.Client, error) {
 	if ops.Password != "" {
 		cfg.Password = ops.Password
 	}
+	if ops.Limiter != nil {
+		cfg.Transport = &limitedTransport{next: http.DefaultTransport, limiter: ops.Limiter}
+	}
 
 	log.Debugf("ES Conf: %v", cfg)
 	return elasticsearch.NewClient(cfg)
@@ -286,116 +818,689 @@ func testIndex(c *elasticsearch.Client, index string) error {
 
 	log.Infof("Testing index Access: %s", index)
 	res, err := c.Indices.Get([]string{index})
-
-	defer res.Body.Close()
 	if err != nil {
 		return fmt.Errorf("Cannot test index: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		return fmt.Errorf("Cannot test index: %s", res.String())
+		return responseError("test index", res)
 
 	}
 
 	return nil
 }
 
-func (c *EsClient) createRole(index string, aliasName string, app string, namespace string) (string, error) {
+func (c *EsClient) createRole(index string, aliasName string, app string, namespace string, owner *EsOwner) (string, error) {
 
-	roleName := app + "-" + namespace + "-role"
-	body := fmt.Sprintf(model.ROLE_TEMPLATE, index, aliasName)
+	roleName := roleName(app, namespace)
 	log.Infof("Creating Role: %s", roleName)
+	err := c.putRole(roleName, index, aliasName, owner)
+	if err != nil {
+		return "", err
+	}
+
+	return roleName, nil
+}
+
+// putRole creates or replaces the role unless another Index owns it
+func (c *EsClient) putRole(roleName string, index string, aliasName string, owner *EsOwner) error {
+
+	if e := c.checkOwner(KindRole, roleName, owner); e != nil {
+		return e
+	}
+	metadata, e := json.Marshal(owner.metadata())
+	if e != nil {
+		return fmt.Errorf("Cannot create role: %s", e)
+	}
+	body := fmt.Sprintf(model.ROLE_TEMPLATE, index, aliasName, metadata)
 	log.Infof("Sending request: %s", body)
 	res, err := c.client.Security.PutRole(roleName, strings.NewReader(body))
-
-	defer res.Body.Close()
 	if err != nil {
-		return "", fmt.Errorf("Cannot create role: %s", err)
+		return fmt.Errorf("Cannot create role: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		return "", fmt.Errorf("Cannot create role: %s", res.String())
-
+		return responseError("create role", res)
 	}
 
-	return roleName, nil
+	return nil
 }
 
-func (c *EsClient) createUser(index string, role string) (string, string, error) {
+func (c *EsClient) createUser(index string, role string, owner *EsOwner) (string, string, error) {
 
-	userName := role + "-user"
+	userName := userName(role)
 	pw := uuid.New().String()
 	log.Infof("Creating User: %s", userName)
 
-	body := fmt.Sprintf(model.USER_TEMPLATE, pw, role, userName)
-	log.Infof("Sending request: %s", body)
-	res, err := c.client.Security.PutUser(userName, strings.NewReader(body))
+	err := c.putUser(userName, pw, role, owner)
+	if err != nil {
+		return "", "", err
+	}
+
+	return userName, pw, nil
+}
 
+// putUser creates or replaces the user unless another Index owns it
+func (c *EsClient) putUser(userName string, pw string, role string, owner *EsOwner) error {
+
+	if e := c.checkOwner(KindUser, userName, owner); e != nil {
+		return e
+	}
+	metadata, e := json.Marshal(owner.metadata())
+	if e != nil {
+		return fmt.Errorf("Cannot create user: %s", e)
+	}
+	body := fmt.Sprintf(model.USER_TEMPLATE, pw, role, userName, metadata)
+	log.Infof("Sending request: %s", strings.Replace(body, pw, "*****", 1))
+	res, err := c.client.Security.PutUser(userName, strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Cannot create user: %s", err)
+	}
 	defer res.Body.Close()
+	if res.IsError() {
+		return responseError("create user", res)
+	}
+
+	return nil
+}
+
+func (c *EsClient) createIndex(indexName string, alias string, ops *EsSetupOptions) error {
+
+	log.Infof("Creating Index: %s", indexName)
+
+	body := indexBody(ops.Spec, ops.Shards, ops.Replicas, ops.RefreshInterval, ops.Analyzers, ops.Source, ops.Properties)
+	body, e := c.addSynonymFilters(alias, body, ops.Synonyms)
+	if e != nil {
+		return e
+	}
+	body, e = c.composeBody(indexName, body, ops.ComponentTemplates)
+	if e != nil {
+		return e
+	}
+	body, e = pipelineSettings(body, ops.DefaultPipeline, ops.FinalPipeline)
+	if e != nil {
+		return e
+	}
+	body, e = ownerMeta(body, ops.Owner)
+	if e != nil {
+		return e
+	}
+
+	return c.putIndex(indexName, body, ops.Owner)
+}
+
+// putIndex creates the index, an existing index is only reused when it records the owner so a
+// retried creation succeeds but the index of another Index or created outside the operator isn't taken
+func (c *EsClient) putIndex(indexName string, body string, owner *EsOwner) error {
+
+	log.Debugf("Creating Index with Body: %s", body)
+	res, err := c.client.Indices.Create(indexName, func(val *esapi.IndicesCreateRequest) {
+		(*val).Body = strings.NewReader(body)
+	})
 	if err != nil {
-		return "", "", fmt.Errorf("Cannot create user: %s", err)
+		return fmt.Errorf("Cannot create index: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		return "", "", fmt.Errorf("Cannot create user: %s", res.String())
+		if !strings.Contains(res.String(), "resource_already_exists_exception") {
+			return responseError("create index", res)
+		}
+		if owner == nil {
+			return nil
+		}
+		recorded, _, e := c.getOwner(KindIndex, indexName)
+		if e != nil {
+			return e
+		}
+		if recorded == nil || !owner.owns(recorded) {
+			return &ValidationError{Reason: fmt.Sprintf("Cannot create index %s: it already exists and isn't owned by Index %s",
+				indexName, owner)}
+		}
+	}
+
+	return nil
+}
 
+// indexBody returns the rendered schema when provided, otherwise the default index template
+func indexBody(schema string, shards int, replicas int, refresh string, analyzers string, source bool, props string) string {
+	if schema != "" {
+		return schema
 	}
 
-	return userName, pw, nil
+	if analyzers == "" {
+		analyzers = model.DEFAULT_ANALYZER
+	}
+
+	if shards == 0 {
+		shards = model.DEFAULT_SHARDS
+	}
+
+	if refresh == "" {
+		refresh = model.DEFAULT_REFRESH_INTERVAL
+	}
+	return fmt.Sprintf(model.INDEX_TEMPLATE, shards, replicas, refresh, analyzers, source, props)
 }
 
-func (c *EsClient) createIndex(name string, schema string, shards int, replicas int,
-	refresh string, analyzers string, source bool, props string) (string, string, error) {
+// updateInPlace applies changed dynamic settings and mappings, returning false when
+// the change requires a new index
+func (c *EsClient) updateInPlace(index string, body string) (bool, error) {
 
-	indexName := name + "-" + time.Now().Format(time.RFC3339)[:10]
-	log.Infof("Creating Index: %s", indexName)
+	var payload struct {
+		Settings map[string]interface{} `json:"settings"`
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return false, fmt.Errorf("Cannot update index, invalid index body: %s", e)
+	}
+
+	current, e := c.getSettings(index)
+	if e != nil {
+		return false, e
+	}
 
-	var body string
-	if schema == "" {
-		if analyzers == "" {
-			analyzers = "standard"
+	changed := map[string]interface{}{}
+	for key, value := range flattenSettings(payload.Settings, "") {
+		if !strings.HasPrefix(key, "index.") {
+			key = "index." + key
+		}
+		if fmt.Sprint(current[key]) == fmt.Sprint(value) {
+			continue
+		}
+		if isStaticSetting(key) {
+			log.Infof("Static setting %s changed on Index %s", key, index)
+			return false, nil
 		}
+		changed[key] = value
+	}
 
-		if shards == 0 {
-			shards = 4
+	if len(payload.Mappings) > 0 {
+		updated, e := c.putMapping(index, payload.Mappings)
+		if e != nil || !updated {
+			return updated, e
 		}
+	}
 
-		if refresh == "" {
-			refresh = "30s"
+	if len(changed) > 0 {
+		b, e := json.Marshal(changed)
+		if e != nil {
+			return false, fmt.Errorf("Cannot update settings: %s", e)
 		}
-		body = fmt.Sprintf(model.INDEX_TEMPLATE, shards, replicas, refresh, analyzers, source, props)
-	} else {
-		body = fmt.Sprintf(schema, shards, replicas, refresh)
+		return true, c.putSettings(index, string(b))
 	}
 
-	log.Debugf("Creating Index with Body: %s", body)
-	res, err := c.client.Indices.Create(indexName, func(val *esapi.IndicesCreateRequest) {
-		(*val).Body = strings.NewReader(body)
-	})
+	return true, nil
+}
+
+// migrateIndex creates a new index with the body, reindexes the data and moves the alias and role to it.
+// Writes to the index are blocked while the data is copied, so no document is lost when it's deleted.
+// When the migration fails the alias is left on the index and the block removed.
+// startMigration creates the new index and starts the reindex task copying the data. The writes to the index
+// are blocked until the migration completes, so the new index has all the documents.
+func (c *EsClient) startMigration(index string, alias string, body string, owner *EsOwner) (*EsMigration, error) {
+
+	newIndex := alias + "-" + time.Now().Format("2006-01-02-150405")
+	log.Infof("Migrating Index %s to %s", index, newIndex)
+
+	e := c.putIndex(newIndex, body, owner)
+	if e != nil {
+		return nil, e
+	}
+
+	e = c.putSettings(index, `{"index.blocks.write": true}`)
+	if e != nil {
+		c.rollbackMigration(index, newIndex)
+		return nil, e
+	}
+
+	task, e := c.reindex(index, newIndex)
+	if e != nil {
+		c.rollbackMigration(index, newIndex)
+		return nil, e
+	}
+
+	return &EsMigration{Index: newIndex, Task: task}, nil
+}
+
+// completeMigration moves the alias and the role to the new index once the reindex task is done, and deletes
+// the current index. The migration is returned while the task runs, and rolled back when the task failed.
+func (c *EsClient) completeMigration(ops *EsUpdateOptions) (*EsResult, error) {
+
+	m := ops.Migration
+	result := &EsResult{Role: ops.Role, Index: ops.Index, Alias: ops.Alias}
+	failure, e := c.reindexFailure(m.Task)
+	if e == errTaskRunning {
+		log.Infof("Migrating Index %s to %s, reindex task %s running", ops.Index, m.Index, m.Task)
+		result.Migration = m
+		return result, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	if failure != "" {
+		c.rollbackMigration(ops.Index, m.Index)
+		return result, fmt.Errorf("Cannot migrate Index %s to %s: %s", ops.Index, m.Index, failure)
+	}
+
+	// the alias is moved again on the next update when it fails, the task result is kept by Elasticsearch
+	e = c.swapAlias(ops.Index, m.Index, ops.Alias)
+	if e != nil {
+		result.Migration = m
+		return result, e
+	}
+
+	if ops.Role != "" {
+		e = c.putRole(ops.Role, m.Index, ops.Alias, ops.Owner)
+		if e != nil {
+			if re := c.swapAlias(m.Index, ops.Index, ops.Alias); re != nil {
+				// the alias is on the new index, the caller records it
+				log.Warnf("Cannot move Alias %s back to Index %s: %s", ops.Alias, ops.Index, re)
+				result.Index = m.Index
+				return result, e
+			}
+			c.rollbackMigration(ops.Index, m.Index)
+			return result, e
+		}
+	}
+
+	e = c.deleteIndex(ops.Index)
+	if e != nil {
+		log.Warnf("Cannot delete migrated Index %s: %s", ops.Index, e)
+	}
+
+	ops.OnStep.notify(StepIndexMigrated, "Index %s migrated to %s", ops.Index, m.Index)
+	result.Index = m.Index
+	return result, nil
+}
+
+// rollbackMigration deletes the new index and leaves the current index untouched
+func (c *EsClient) rollbackMigration(index string, newIndex string) {
+	_ = c.deleteIndex(newIndex)
+	if e := c.putSettings(index, `{"index.blocks.write": null}`); e != nil {
+		log.Warnf("Cannot remove the write block of Index %s: %s", index, e)
+	}
+}
+
+// cancelMigration cancels the reindex task and deletes the new index of the migration
+func (c *EsClient) cancelMigration(m *EsMigration, owner *EsOwner) error {
+
+	log.Infof("Cancelling reindex task %s", m.Task)
+	res, err := c.client.Tasks.Cancel(c.client.Tasks.Cancel.WithTaskID(m.Task))
+	if err != nil {
+		return fmt.Errorf("Cannot cancel task: %s", err)
+	}
 	defer res.Body.Close()
+	if res.IsError() {
+		if e := ignoreNotFound(responseError("cancel task", res)); e != nil {
+			return e
+		}
+	}
+
+	if e := c.checkOwner(KindIndex, m.Index, owner); e != nil {
+		return e
+	}
+	return c.deleteIndex(m.Index)
+}
+
+func (c *EsClient) getSettings(index string) (map[string]interface{}, error) {
+
+	res, err := c.client.Indices.GetSettings(
+		c.client.Indices.GetSettings.WithIndex(index),
+		c.client.Indices.GetSettings.WithFlatSettings(true))
 	if err != nil {
-		return "", "", fmt.Errorf("Cannot create index: %s", err)
+		return nil, fmt.Errorf("Cannot get settings: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
-		if !strings.Contains(res.String(), "resource_already_exists_exception") {
-			return "", "", fmt.Errorf("Cannot create index: %s", res.String())
+		return nil, responseError("get settings", res)
+	}
+
+	var body map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("Cannot get settings: %s", err)
+	}
+
+	return body[index].Settings, nil
+}
+
+// putMapping returns false when the mappings conflict with the existing ones
+func (c *EsClient) putMapping(index string, mappings map[string]interface{}) (bool, error) {
+
+	body, err := json.Marshal(mappings)
+	if err != nil {
+		return false, fmt.Errorf("Cannot update mapping: %s", err)
+	}
+
+	log.Infof("Update Mapping for Index: %s", index)
+	res, err := c.client.Indices.PutMapping([]string{index}, strings.NewReader(string(body)))
+	if err != nil {
+		return false, fmt.Errorf("Cannot update mapping: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusBadRequest {
+		log.Infof("Mapping conflict on Index %s: %s", index, res.String())
+		return false, nil
+	}
+	if res.IsError() {
+		return false, responseError("update mapping", res)
+	}
+
+	return true, nil
+}
+
+// reindex starts a task copying the documents of the source index to the dest index, returning its id
+func (c *EsClient) reindex(source string, dest string) (string, error) {
+
+	log.Infof("Reindex from %s to %s", source, dest)
+	body := fmt.Sprintf(`{"source": {"index": "%s"}, "dest": {"index": "%s"}}`, source, dest)
+	res, err := c.client.Reindex(strings.NewReader(body),
+		c.client.Reindex.WithWaitForCompletion(false),
+		c.client.Reindex.WithRefresh(true))
+	if err != nil {
+		return "", fmt.Errorf("Cannot reindex: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", responseError("reindex", res)
+	}
+
+	var result struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("Cannot reindex: %s", err)
+	}
+	return result.Task, nil
+}
+
+// errTaskRunning is returned by reindexFailure while the task runs
+var errTaskRunning = errors.New("task running")
+
+// reindexFailure returns why the reindex task failed, empty when it succeeded, or errTaskRunning
+func (c *EsClient) reindexFailure(task string) (string, error) {
+
+	res, err := c.client.Tasks.Get(task)
+	if err != nil {
+		return "", fmt.Errorf("Cannot get task: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		e := responseError("get task", res)
+		if ignoreNotFound(e) == nil {
+			return fmt.Sprintf("reindex task %s not found", task), nil
 		}
+		return "", e
+	}
 
+	var result struct {
+		Completed bool `json:"completed"`
+		Error     *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+		Response struct {
+			Failures []interface{} `json:"failures"`
+		} `json:"response"`
 	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("Cannot get task: %s", err)
+	}
+	switch {
+	case !result.Completed:
+		return "", errTaskRunning
+	case result.Error != nil:
+		return fmt.Sprintf("reindex task %s failed: %s: %s", task, result.Error.Type, result.Error.Reason), nil
+	case len(result.Response.Failures) > 0:
+		return fmt.Sprintf("reindex task %s failed: %d failures, first: %v", task, len(result.Response.Failures),
+			result.Response.Failures[0]), nil
+	}
+	return "", nil
+}
+
+// swapAlias atomically moves the alias from one index to another
+func (c *EsClient) swapAlias(from string, to string, alias string) error {
 
-	return c.addAlias(indexName, name)
+	log.Infof("Moving Alias %s from %s to %s", alias, from, to)
+	body := fmt.Sprintf(`{"actions": [{"remove": {"index": "%s", "alias": "%s"}}, {"add": {"index": "%s", "alias": "%s"}}]}`,
+		from, alias, to, alias)
+	res, err := c.client.Indices.UpdateAliases(strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Cannot update alias: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError("update alias", res)
+	}
+
+	return nil
+}
+
+// staticSettings can only be set when the index is created
+var staticSettings = []string{
+	"index.number_of_shards",
+	"index.number_of_routing_shards",
+	"index.routing_partition_size",
+	"index.codec",
+	"index.soft_deletes.",
+	"index.sort.",
+	"index.store.",
+	"index.analysis.",
+}
+
+func isStaticSetting(key string) bool {
+	for _, s := range staticSettings {
+		if strings.HasPrefix(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenSettings converts nested settings to the flat format returned by Elasticsearch
+func flattenSettings(settings map[string]interface{}, prefix string) map[string]interface{} {
+	flat := map[string]interface{}{}
+	for key, value := range settings {
+		key = prefix + key
+		if nested, ok := value.(map[string]interface{}); ok {
+			for k, v := range flattenSettings(nested, key+".") {
+				flat[k] = v
+			}
+			continue
+		}
+		flat[key] = value
+	}
+	return flat
 }
 
 func (c *EsClient) addAlias(indexName string, aliasName string) (string, string, error) {
 
 	log.Infof("Creating Alias: %s", aliasName)
 	res, err := c.client.Indices.PutAlias([]string{indexName}, aliasName)
-	defer res.Body.Close()
 	if err != nil {
 		return "", "", fmt.Errorf("Cannot create alias: %s", err)
 	}
+	defer res.Body.Close()
 	if res.IsError() {
 		if !strings.Contains(res.String(), "resource_already_exists_exception") {
-			return "", "", fmt.Errorf("Cannot create alias: %s", res.String())
+			return "", "", responseError("create alias", res)
 		}
 
 	}
 	return indexName, aliasName, nil
 }
+
+func (c *EsClient) addSynonymFilters(alias string, body string, sets []EsSynonymSet) (string, error) {
+	if c.synonymsApi {
+		for _, set := range sets {
+			e := c.putSynonymSet(synonymSetId(alias, set.Name), set.Synonyms)
+			if e != nil {
+				return "", e
+			}
+		}
+	}
+	return synonymFilters(alias, body, sets, c.synonymsApi)
+}
+
+// synonymFilters adds the synonym token filters to the index body, referencing the synonym sets
+// when the synonyms API is used
+func synonymFilters(alias string, body string, sets []EsSynonymSet, synonymsApi bool) (string, error) {
+	if len(sets) == 0 {
+		return body, nil
+	}
+
+	var payload map[string]interface{}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return "", fmt.Errorf("Cannot add synonyms, invalid index body: %s", e)
+	}
+	filters := childMap(childMap(childMap(payload, "settings"), "analysis"), "filter")
+
+	for _, set := range sets {
+		filter := map[string]interface{}{"type": synonymType(set.Type)}
+		if synonymsApi {
+			filter["synonyms_set"] = synonymSetId(alias, set.Name)
+			filter["updateable"] = set.Updateable
+		} else {
+			filter["synonyms"] = set.Synonyms
+		}
+		filters[set.Name] = filter
+	}
+
+	b, e := json.Marshal(payload)
+	if e != nil {
+		return "", fmt.Errorf("Cannot add synonyms: %s", e)
+	}
+	return string(b), nil
+}
+
+func (c *EsClient) putSynonymSet(id string, synonyms []string) error {
+
+	log.Infof("Updating Synonyms Set: %s", id)
+	rules := make([]map[string]string, 0, len(synonyms))
+	for _, s := range synonyms {
+		rules = append(rules, map[string]string{"synonyms": s})
+	}
+	body, e := json.Marshal(map[string]interface{}{"synonyms_set": rules})
+	if e != nil {
+		return fmt.Errorf("Cannot update synonyms set: %s", e)
+	}
+
+	res, err := c.perform(http.MethodPut, "/_synonyms/"+id, string(body))
+	if err != nil {
+		return fmt.Errorf("Cannot update synonyms set: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError("update synonyms set", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+
+	return nil
+}
+
+func (c *EsClient) closeIndex(index string) error {
+
+	log.Infof("Close Index: %s", index)
+	res, err := c.client.Indices.Close([]string{index})
+	if err != nil {
+		return fmt.Errorf("Cannot close index: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError("close index", res)
+	}
+
+	return nil
+}
+
+func (c *EsClient) openIndex(index string) error {
+
+	log.Infof("Open Index: %s", index)
+	res, err := c.client.Indices.Open([]string{index})
+	if err != nil {
+		return fmt.Errorf("Cannot open index: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError("open index", res)
+	}
+
+	return nil
+}
+
+func (c *EsClient) putSettings(index string, body string) error {
+
+	log.Infof("Update Settings for Index: %s", index)
+	log.Debugf("Sending request: %s", body)
+	res, err := c.client.Indices.PutSettings(strings.NewReader(body),
+		c.client.Indices.PutSettings.WithIndex(index))
+	if err != nil {
+		return fmt.Errorf("Cannot update settings: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError("update settings", res)
+	}
+
+	return nil
+}
+
+// perform sends a request for APIs not available in the client version we use
+func (c *EsClient) perform(method string, path string, body string) (*http.Response, error) {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.client.Perform(req)
+}
+
+func serverVersion(client *elasticsearch.Client) (string, error) {
+	res, err := client.Info()
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", responseError("get version", res)
+	}
+
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	return info.Version.Number, nil
+}
+
+// supportsSynonymsApi checks for the synonyms management API added in 8.10
+func supportsSynonymsApi(version string) bool {
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		return false
+	}
+	return major > 8 || (major == 8 && minor >= 10)
+}
+
+func synonymSetId(alias string, name string) string {
+	return alias + "-" + name
+}
+
+func synonymType(t string) string {
+	if t == "" {
+		return "synonym_graph"
+	}
+	return t
+}
+
+// childMap returns the nested object for the key, creating it when missing
+func childMap(m map[string]interface{}, key string) map[string]interface{} {
+	child, ok := m[key].(map[string]interface{})
+	if !ok {
+		child = map[string]interface{}{}
+		m[key] = child
+	}
+	return child
+}
@@ -0,0 +1,403 @@
+package es_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+)
+
+var testOwner = &es.EsOwner{Cluster: "test", Namespace: "ns", Name: "logs", UID: "uid"}
+
+// newTestService returns an EsService connected to a new fake Elasticsearch
+func newTestService(g *WithT) (*esfake.Server, es.EsService) {
+	server := esfake.NewServer("")
+	service, err := es.NewEsService(&es.EsOptions{
+		Connection: server.URL,
+		Username:   esfake.Username,
+		Password:   esfake.Password,
+		Retries:    1,
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	return server, service
+}
+
+// esDo sends the request to the fake Elasticsearch expecting it to succeed
+func esDo(g *WithT, server *esfake.Server, method string, path string, body string) {
+	status, response := server.Do(method, path, body)
+	g.Expect(status).To(BeNumerically("<", 300), response)
+}
+
+func TestRollbackIndex(t *testing.T) {
+	// index is the name of the index created for the logs alias today
+	index := "logs-" + time.Now().Format(time.RFC3339)[:10]
+
+	tests := []struct {
+		name string
+		// existing requests sent before provisioning
+		existing func(g *WithT, server *esfake.Server)
+		faults   []esfake.Fault
+		// steps run before the rollback, a failed step is retried by the next one and the last one
+		// fails when err is set
+		steps int
+		err   string
+		// kept and deleted are the paths found or not after the rollback
+		kept    []string
+		deleted []string
+	}{
+		{
+			name:    "deletes the index, alias, role and user created",
+			steps:   4,
+			deleted: []string{"/" + index, "/_alias/logs", "/_security/role/app-ns-role", "/_security/user/app-ns-role-user"},
+		},
+		{
+			name: "keeps an index without owner the creation was refused on",
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/"+index, "")
+			},
+			steps: 1,
+			err:   "already exists and isn't owned",
+			kept:  []string{"/" + index},
+		},
+		{
+			name: "keeps the role of another Index",
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/_security/role/app-ns-role",
+					`{"metadata": {"es-provisioner": {"namespace": "other", "index": "logs", "uid": "other"}}}`)
+			},
+			steps:   3,
+			err:     "is owned by Index",
+			kept:    []string{"/_security/role/app-ns-role"},
+			deleted: []string{"/" + index},
+		},
+		{
+			name: "keeps the role without owner the provisioning reused",
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/_security/role/app-ns-role", `{"indices": []}`)
+			},
+			faults:  []esfake.Fault{{Method: http.MethodPut, Path: "^/_security/user/", Status: http.StatusBadRequest}},
+			steps:   4,
+			err:     "create user",
+			kept:    []string{"/_security/role/app-ns-role"},
+			deleted: []string{"/" + index},
+		},
+		{
+			name:    "deletes the role created by a retried step",
+			faults:  []esfake.Fault{{Method: http.MethodPut, Path: "^/_security/role/", Status: http.StatusBadRequest, Applied: true, Times: 1}},
+			steps:   4,
+			deleted: []string{"/" + index, "/_security/role/app-ns-role"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server, service := newTestService(g)
+			defer server.Close()
+			if tt.existing != nil {
+				tt.existing(g, server)
+			}
+			for _, f := range tt.faults {
+				server.Inject(f)
+			}
+
+			ops := &es.EsSetupOptions{IndexName: "logs", App: "app", Namespace: "ns", Owner: testOwner}
+			state := &es.EsProvisionState{Owner: testOwner}
+			var err error
+			for i := 0; i < tt.steps; i++ {
+				err = service.ProvisionStep(ops, state)
+			}
+			if tt.err == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+			}
+
+			g.Expect(service.RollbackIndex(state)).To(Succeed())
+			for _, path := range tt.kept {
+				status, _ := server.Do(http.MethodGet, path, "")
+				g.Expect(status).To(Equal(http.StatusOK), path)
+			}
+			for _, path := range tt.deleted {
+				status, _ := server.Do(http.MethodGet, path, "")
+				g.Expect(status).To(Equal(http.StatusNotFound), path)
+			}
+		})
+	}
+}
+
+func TestSwapIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		// meta is the _meta of the restored index
+		meta string
+		err  string
+		// aliased is the index with the alias after the swap
+		aliased string
+	}{
+		{
+			name:    "moves the alias to the restored index of the Index",
+			meta:    `{"es-provisioner": {"namespace": "ns", "index": "logs", "uid": "uid"}}`,
+			aliased: "logs-restored",
+		},
+		{
+			name:    "refuses the restored index of another Index",
+			meta:    `{"es-provisioner": {"namespace": "other", "index": "logs", "uid": "other"}}`,
+			err:     "Index logs-restored is owned by Index other/logs",
+			aliased: "logs-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server, service := newTestService(g)
+			defer server.Close()
+			esDo(g, server, http.MethodPut, "/logs-1", `{"aliases": {"logs": {}}}`)
+			esDo(g, server, http.MethodPut, "/logs-restored", `{"mappings": {"_meta": `+tt.meta+`}}`)
+
+			err := service.SwapIndex(&es.EsSwapOptions{From: "logs-1", To: "logs-restored", Alias: "logs", Owner: testOwner})
+			if tt.err == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+			}
+			status, body := server.Do(http.MethodGet, "/_alias/logs", "")
+			g.Expect(status).To(Equal(http.StatusOK))
+			g.Expect(body).To(MatchJSON(`{"` + tt.aliased + `": {"aliases": {"logs": {}}}}`))
+		})
+	}
+}
+
+// provision runs all the provisioning steps of the logs Index
+func provision(g *WithT, service es.EsService) *es.EsProvisionState {
+	ops := &es.EsSetupOptions{IndexName: "logs", App: "app", Namespace: "ns", Owner: testOwner}
+	state := &es.EsProvisionState{Owner: testOwner}
+	for state.Step != es.StepCredentialsVerified {
+		g.Expect(service.ProvisionStep(ops, state)).To(Succeed())
+	}
+	return state
+}
+
+func TestCheckIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is applied to Elasticsearch and the options after provisioning
+		change   func(g *WithT, server *esfake.Server, ops *es.EsCheckOptions)
+		repaired []string
+		drifted  []string
+		// exists are the paths expected to exist after the check, <index> is the provisioned index
+		exists []string
+		// missing are the paths expected not to exist after the check
+		missing []string
+	}{
+		{
+			name: "reports a missing index without recreating it",
+			change: func(g *WithT, server *esfake.Server, ops *es.EsCheckOptions) {
+				esDo(g, server, http.MethodDelete, "/"+ops.Index, "")
+				ops.Index = "logs-1"
+			},
+			drifted: []string{"index logs-1 is missing, restore it from a snapshot or delete the Index to provision it again"},
+			missing: []string{"/<index>"},
+		},
+		{
+			name: "adds a missing alias",
+			change: func(g *WithT, server *esfake.Server, ops *es.EsCheckOptions) {
+				esDo(g, server, http.MethodDelete, "/"+ops.Index+"/_alias/logs", "")
+			},
+			repaired: []string{"alias logs was missing"},
+			exists:   []string{"/<index>/_alias/logs"},
+		},
+		{
+			name: "resets the password of the user when the secret was lost",
+			change: func(g *WithT, server *esfake.Server, ops *es.EsCheckOptions) {
+				ops.Password = ""
+				ops.ResetPassword = true
+			},
+			repaired: []string{"user app-ns-role-user password was reset, its secret was missing"},
+		},
+		{
+			name: "doesn't check the credentials without the password",
+			change: func(g *WithT, server *esfake.Server, ops *es.EsCheckOptions) {
+				ops.Password = ""
+			},
+		},
+		{
+			name: "reports a missing user without the password",
+			change: func(g *WithT, server *esfake.Server, ops *es.EsCheckOptions) {
+				esDo(g, server, http.MethodDelete, "/_security/user/"+ops.User, "")
+				ops.Password = ""
+			},
+			drifted: []string{"user app-ns-role-user is missing and its password is unknown"},
+			missing: []string{"/_security/user/app-ns-role-user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server, service := newTestService(g)
+			defer server.Close()
+			state := provision(g, service)
+
+			ops := &es.EsCheckOptions{
+				EsSetupOptions: es.EsSetupOptions{IndexName: "logs", App: "app", Namespace: "ns", Owner: testOwner},
+				Index:          state.Index,
+				Alias:          state.Alias,
+				Role:           state.Role,
+				User:           state.User,
+				Password:       state.Password,
+			}
+			tt.change(g, server, ops)
+
+			report, err := service.CheckIndex(ops)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(report.Repaired).To(Equal(tt.repaired))
+			g.Expect(report.Drifted).To(Equal(tt.drifted))
+			if ops.ResetPassword {
+				g.Expect(report.Password).NotTo(BeEmpty())
+				g.Expect(report.Password).NotTo(Equal(state.Password))
+			} else {
+				g.Expect(report.Password).To(BeEmpty())
+			}
+			for _, path := range tt.exists {
+				status, _ := server.Do(http.MethodGet, strings.ReplaceAll(path, "<index>", state.Index), "")
+				g.Expect(status).To(Equal(http.StatusOK), path)
+			}
+			for _, path := range tt.missing {
+				status, _ := server.Do(http.MethodGet, strings.ReplaceAll(path, "<index>", state.Index), "")
+				g.Expect(status).To(Equal(http.StatusNotFound), path)
+			}
+		})
+	}
+}
+
+func TestMigrateIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is applied to Elasticsearch while the reindex task runs
+		change func(g *WithT, server *esfake.Server, migration *es.EsMigration)
+		// remove deletes the Index during the migration instead of completing it
+		remove bool
+		err    string
+		// migrating is true when the migration is still in progress after completing it
+		migrating bool
+		// aliased is the index with the alias after the migration, <index> is the provisioned index and
+		// <new> the index of the migration
+		aliased string
+		// deleted are the paths not found after the migration
+		deleted []string
+	}{
+		{
+			name:    "moves the alias to the new index once the task is done",
+			aliased: "<new>",
+			deleted: []string{"/<index>"},
+		},
+		{
+			name: "rolls back the migration when the task failed",
+			change: func(g *WithT, server *esfake.Server, migration *es.EsMigration) {
+				esDo(g, server, http.MethodPost, "/_tasks/"+migration.Task+"/_cancel", "")
+			},
+			err:     "task_cancelled_exception",
+			aliased: "<index>",
+			deleted: []string{"/<new>"},
+		},
+		{
+			name: "keeps the migration when the alias cannot be moved",
+			change: func(g *WithT, server *esfake.Server, migration *es.EsMigration) {
+				server.Inject(esfake.Fault{Method: http.MethodPost, Path: "^/_aliases", Status: http.StatusBadRequest})
+			},
+			err:       "update alias",
+			migrating: true,
+			aliased:   "<index>",
+		},
+		{
+			name: "moves the alias back when the role cannot be updated",
+			change: func(g *WithT, server *esfake.Server, migration *es.EsMigration) {
+				server.Inject(esfake.Fault{Method: http.MethodPut, Path: "^/_security/role/", Status: http.StatusForbidden})
+			},
+			err:     "create role",
+			aliased: "<index>",
+			deleted: []string{"/<new>"},
+		},
+		{
+			name:    "cancels the task and deletes the new index when the Index is deleted",
+			remove:  true,
+			deleted: []string{"/<index>", "/<new>", "/_alias/logs", "/_security/role/app-ns-role"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server, service := newTestService(g)
+			defer server.Close()
+			state := provision(g, service)
+			server.HoldTasks()
+
+			ops := &es.EsUpdateOptions{
+				EsSetupOptions: es.EsSetupOptions{IndexName: "logs", App: "app", Namespace: "ns", Owner: testOwner},
+				Index:          state.Index,
+				Alias:          state.Alias,
+				Role:           state.Role,
+				Reindex:        true,
+			}
+			result, err := service.UpdateIndex(ops)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(result.Index).To(Equal(state.Index))
+			g.Expect(result.Migration).NotTo(BeNil())
+			migration := result.Migration
+			paths := strings.NewReplacer("<index>", state.Index, "<new>", migration.Index)
+
+			// writes are blocked and the alias is kept while the task runs
+			status, body := server.Do(http.MethodGet, "/"+state.Index+"/_settings?flat_settings=true", "")
+			g.Expect(status).To(Equal(http.StatusOK))
+			g.Expect(body).To(ContainSubstring(`"index.blocks.write":"true"`))
+			ops.Migration = migration
+			result, err = service.UpdateIndex(ops)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(result.Migration).To(Equal(migration))
+			status, _ = server.Do(http.MethodGet, "/"+state.Index+"/_alias/logs", "")
+			g.Expect(status).To(Equal(http.StatusOK))
+
+			if tt.change != nil {
+				tt.change(g, server, migration)
+			}
+			if tt.remove {
+				err = service.RemoveIndex(&es.EsRemoveOptions{Index: state.Index, Alias: state.Alias, Role: state.Role,
+					User: state.User, Migration: migration, Owner: testOwner})
+			} else {
+				server.ReleaseTasks()
+				result, err = service.UpdateIndex(ops)
+			}
+			if tt.err == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+			}
+			if !tt.remove {
+				g.Expect(result.Migration != nil).To(Equal(tt.migrating))
+				g.Expect(result.Index).To(Equal(paths.Replace(tt.aliased)))
+			}
+
+			if tt.aliased != "" {
+				status, _ = server.Do(http.MethodGet, "/"+paths.Replace(tt.aliased)+"/_alias/logs", "")
+				g.Expect(status).To(Equal(http.StatusOK))
+			}
+			if tt.aliased == "<index>" && !tt.migrating {
+				// the write block is removed by the rollback
+				_, body = server.Do(http.MethodGet, "/"+state.Index+"/_settings?flat_settings=true", "")
+				g.Expect(body).NotTo(ContainSubstring("index.blocks.write"))
+			}
+			for _, path := range tt.deleted {
+				status, _ = server.Do(http.MethodGet, paths.Replace(path), "")
+				g.Expect(status).To(Equal(http.StatusNotFound), path)
+			}
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package esfake
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// repository is a snapshot repository with its snapshots by name and the copies of their indices to restore
+type repository struct {
+	definition map[string]interface{}
+	snapshots  map[string]map[string]interface{}
+	indices    map[string]map[string]*index
+}
+
+// synonymSet handles the synonyms API, only available from 8.10
+func (s *Server) synonymSet(r *request) (int, interface{}) {
+	var major, minor int
+	if _, err := fmt.Sscanf(s.version, "%d.%d", &major, &minor); err != nil || major < 8 || (major == 8 && minor < 10) {
+		return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception",
+			"no handler found for uri [/"+strings.Join(r.parts, "/")+"] and method ["+r.method+"]")
+	}
+
+	id := r.part(1)
+	switch r.method {
+	case http.MethodPut:
+		_, exists := s.synonyms[id]
+		s.synonyms[id] = r.body["synonyms_set"]
+		result := "created"
+		if exists {
+			result = "updated"
+		}
+		return http.StatusOK, map[string]interface{}{"result": result}
+	case http.MethodGet:
+		set, ok := s.synonyms[id]
+		if !ok {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+				"synonyms set ["+id+"] not found")
+		}
+		rules, _ := set.([]interface{})
+		return http.StatusOK, map[string]interface{}{"count": len(rules), "synonyms_set": set}
+	case http.MethodDelete:
+		if _, ok := s.synonyms[id]; !ok {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+				"synonyms set ["+id+"] not found")
+		}
+		delete(s.synonyms, id)
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+// lifecyclePolicy handles the ILM policy API
+func (s *Server) lifecyclePolicy(r *request) (int, interface{}) {
+	if r.part(1) != "policy" {
+		return methodNotAllowed(r)
+	}
+	name := r.part(2)
+	switch r.method {
+	case http.MethodPut:
+		s.policies[name] = r.body["policy"]
+		return http.StatusOK, acknowledged()
+	case http.MethodGet:
+		response := map[string]interface{}{}
+		for n, policy := range s.policies {
+			if name == "" || n == name {
+				response[n] = map[string]interface{}{"version": 1, "policy": policy}
+			}
+		}
+		if name != "" && len(response) == 0 {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+				"Lifecycle policy not found: "+name)
+		}
+		return http.StatusOK, response
+	case http.MethodDelete:
+		if _, ok := s.policies[name]; !ok {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+				"Lifecycle policy not found: "+name)
+		}
+		delete(s.policies, name)
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+// snapshot handles the snapshot repository and snapshot APIs, snapshots complete immediately
+func (s *Server) snapshot(r *request) (int, interface{}) {
+	name := r.part(1)
+	if name == "" {
+		response := map[string]interface{}{}
+		for n, repo := range s.repos {
+			response[n] = repo.definition
+		}
+		return http.StatusOK, response
+	}
+	repo, ok := s.repos[name]
+	missing := func() (int, interface{}) {
+		return http.StatusNotFound, errorBody(http.StatusNotFound, "repository_missing_exception", "["+name+"] missing")
+	}
+
+	if r.part(2) == "" {
+		switch r.method {
+		case http.MethodPut, http.MethodPost:
+			if status, response := validateRepository(name, r.body); status != http.StatusOK {
+				return status, response
+			}
+			if ok {
+				repo.definition = r.body
+			} else {
+				s.repos[name] = &repository{definition: r.body, snapshots: map[string]map[string]interface{}{},
+					indices: map[string]map[string]*index{}}
+			}
+			return http.StatusOK, acknowledged()
+		case http.MethodGet:
+			if !ok {
+				return missing()
+			}
+			return http.StatusOK, map[string]interface{}{name: repo.definition}
+		case http.MethodDelete:
+			if !ok {
+				return missing()
+			}
+			delete(s.repos, name)
+			return http.StatusOK, acknowledged()
+		}
+		return methodNotAllowed(r)
+	}
+	if !ok {
+		return missing()
+	}
+
+	snapshot := r.part(2)
+	switch {
+	case snapshot == "_verify" && r.method == http.MethodPost:
+		return http.StatusOK, map[string]interface{}{"nodes": map[string]interface{}{"esfake": map[string]interface{}{"name": "esfake"}}}
+	case r.part(3) == "_restore" && r.method == http.MethodPost:
+		return s.restore(r, repo, name, snapshot)
+	case r.method == http.MethodPut || r.method == http.MethodPost:
+		expression, _ := r.body["indices"].(string)
+		return s.takeSnapshot(repo, name, snapshot, expression)
+	case r.method == http.MethodGet:
+		snapshots := []interface{}{}
+		names := []string{}
+		for n := range repo.snapshots {
+			if snapshot == "_all" || snapshot == "*" || n == snapshot {
+				names = append(names, n)
+			}
+		}
+		if len(names) == 0 && snapshot != "_all" && snapshot != "*" {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "snapshot_missing_exception",
+				fmt.Sprintf("[%s:%s] is missing", name, snapshot))
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			snapshots = append(snapshots, repo.snapshots[n])
+		}
+		return http.StatusOK, map[string]interface{}{"snapshots": snapshots}
+	case r.method == http.MethodDelete:
+		if _, exists := repo.snapshots[snapshot]; !exists {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "snapshot_missing_exception",
+				fmt.Sprintf("[%s:%s] is missing", name, snapshot))
+		}
+		delete(repo.snapshots, snapshot)
+		delete(repo.indices, snapshot)
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+// validateRepository checks the type and the required settings of the fs and s3 repositories
+func validateRepository(name string, body map[string]interface{}) (int, interface{}) {
+	repositoryType, ok := body["type"].(string)
+	if !ok {
+		return http.StatusBadRequest, errorBody(http.StatusBadRequest, "action_request_validation_exception",
+			"Validation Failed: 1: type is missing;")
+	}
+	settings, _ := body["settings"].(map[string]interface{})
+	required := ""
+	switch repositoryType {
+	case "fs":
+		required = "location"
+	case "s3":
+		required = "bucket"
+	case "url", "source", "gcs", "azure", "hdfs":
+	default:
+		return http.StatusInternalServerError, errorBody(http.StatusInternalServerError, "repository_exception",
+			fmt.Sprintf("[%s] repository type [%s] does not exist", name, repositoryType))
+	}
+	if value, _ := settings[required].(string); required != "" && value == "" {
+		return http.StatusInternalServerError, errorBody(http.StatusInternalServerError, "repository_exception",
+			fmt.Sprintf("[%s] missing %s setting", name, required))
+	}
+	return http.StatusOK, nil
+}
+
+// takeSnapshot copies the indices matching the expression, all the indices when empty
+func (s *Server) takeSnapshot(repo *repository, name string, snapshot string, expression string) (int, interface{}) {
+	if _, exists := repo.snapshots[snapshot]; exists {
+		return http.StatusBadRequest, errorBody(http.StatusBadRequest, "invalid_snapshot_name_exception",
+			fmt.Sprintf("[%s:%s] Invalid snapshot name [%s], snapshot with the same name already exists", name, snapshot, snapshot))
+	}
+	if expression == "" {
+		expression = "*"
+	}
+	indices, missingIndex := s.resolve(expression)
+	if missingIndex != "" {
+		return indexNotFound(missingIndex)
+	}
+	copies := map[string]*index{}
+	for _, n := range indices {
+		i := s.indices[n]
+		settings, _ := clone(i.settings).(map[string]interface{})
+		mappings, _ := clone(i.mappings).(map[string]interface{})
+		copies[n] = &index{settings: settings, mappings: mappings, docs: i.docs}
+	}
+	now := time.Now().UnixMilli()
+	repo.snapshots[snapshot] = map[string]interface{}{
+		"snapshot":             snapshot,
+		"indices":              indices,
+		"state":                "SUCCESS",
+		"start_time_in_millis": now,
+		"end_time_in_millis":   now,
+	}
+	repo.indices[snapshot] = copies
+	return http.StatusOK, map[string]interface{}{"accepted": true}
+}
+
+// restore recreates the indices of the snapshot renamed with rename_pattern and rename_replacement, without
+// their aliases. Restored indices are recovered immediately.
+func (s *Server) restore(r *request, repo *repository, name string, snapshot string) (int, interface{}) {
+	copies, ok := repo.indices[snapshot]
+	if !ok {
+		return http.StatusNotFound, errorBody(http.StatusNotFound, "snapshot_restore_exception",
+			fmt.Sprintf("[%s:%s] snapshot does not exist", name, snapshot))
+	}
+	expression, _ := r.body["indices"].(string)
+	if expression == "" {
+		expression = "*"
+	}
+	var rename *regexp.Regexp
+	if pattern, _ := r.body["rename_pattern"].(string); pattern != "" {
+		var err error
+		if rename, err = regexp.Compile(pattern); err != nil {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		}
+	}
+	replacement, _ := r.body["rename_replacement"].(string)
+
+	restored := map[string]*index{}
+	for n, i := range copies {
+		if !matchesAny(strings.Split(expression, ","), n) {
+			continue
+		}
+		target := n
+		if rename != nil {
+			target = rename.ReplaceAllString(n, replacement)
+		}
+		if existing, ok := s.indices[target]; ok && !existing.closed {
+			return http.StatusInternalServerError, errorBody(http.StatusInternalServerError, "snapshot_restore_exception",
+				fmt.Sprintf("[%s:%s] cannot restore index [%s] because an open index with same name already exists in the cluster",
+					name, snapshot, target))
+		}
+		settings, _ := clone(i.settings).(map[string]interface{})
+		mappings, _ := clone(i.mappings).(map[string]interface{})
+		settings["index.provided_name"] = target
+		restored[target] = &index{settings: settings, mappings: mappings, aliases: map[string]map[string]interface{}{}, docs: i.docs}
+	}
+	if len(restored) == 0 {
+		return indexNotFound(expression)
+	}
+	indices := []string{}
+	for n, i := range restored {
+		s.indices[n] = i
+		indices = append(indices, n)
+	}
+	sort.Strings(indices)
+	return http.StatusOK, map[string]interface{}{"accepted": true, "snapshot": map[string]interface{}{"snapshot": snapshot, "indices": indices}}
+}
+
+// snapshotLifecycle handles the SLM policy API, executing a policy takes the snapshot immediately
+func (s *Server) snapshotLifecycle(r *request) (int, interface{}) {
+	if r.part(1) != "policy" {
+		return methodNotAllowed(r)
+	}
+	id := r.part(2)
+	notFound := func() (int, interface{}) {
+		return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+			"snapshot lifecycle policy or policies ["+id+"] not found")
+	}
+	policy, exists := s.slmPolicies[id]
+
+	if r.part(3) == "_execute" {
+		if r.method != http.MethodPut && r.method != http.MethodPost {
+			return methodNotAllowed(r)
+		}
+		if !exists {
+			return notFound()
+		}
+		return s.executeLifecycle(id, policy)
+	}
+
+	switch r.method {
+	case http.MethodPut:
+		for _, key := range []string{"schedule", "name", "repository"} {
+			if v, _ := r.body[key].(string); v == "" {
+				return http.StatusBadRequest, errorBody(http.StatusBadRequest, "action_request_validation_exception",
+					fmt.Sprintf("Validation Failed: 1: invalid %s: must not be empty;", key))
+			}
+		}
+		repository := r.body["repository"].(string)
+		if _, ok := s.repos[repository]; !ok {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("no such repository [%s]", repository))
+		}
+		version := 1
+		if exists {
+			version = policy["version"].(int) + 1
+		}
+		s.slmPolicies[id] = map[string]interface{}{
+			"version":               version,
+			"modified_date_millis":  time.Now().UnixMilli(),
+			"policy":                r.body,
+			"next_execution_millis": time.Now().Add(24 * time.Hour).UnixMilli(),
+		}
+		return http.StatusOK, acknowledged()
+	case http.MethodGet:
+		response := map[string]interface{}{}
+		for n, p := range s.slmPolicies {
+			if id == "" || n == id {
+				response[n] = p
+			}
+		}
+		if id != "" && len(response) == 0 {
+			return notFound()
+		}
+		return http.StatusOK, response
+	case http.MethodDelete:
+		if !exists {
+			return notFound()
+		}
+		delete(s.slmPolicies, id)
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+// executeLifecycle takes a snapshot named after the policy and records it as its last success or failure
+func (s *Server) executeLifecycle(id string, policy map[string]interface{}) (int, interface{}) {
+	definition := policy["policy"].(map[string]interface{})
+	snapshot := strings.Trim(definition["name"].(string), "<>")
+	snapshot = strings.ReplaceAll(snapshot, "{now/d}", time.Now().UTC().Format("2006.01.02"))
+	snapshot = strings.ToLower(fmt.Sprintf("%s-%x", snapshot, time.Now().UnixNano()))
+
+	expression := ""
+	if config, ok := definition["config"].(map[string]interface{}); ok {
+		expression = strings.Join(stringList(config["indices"]), ",")
+	}
+	execution := map[string]interface{}{"snapshot_name": snapshot, "time": time.Now().UnixMilli()}
+	repo, ok := s.repos[definition["repository"].(string)]
+	if !ok {
+		policy["last_failure"] = execution
+		return http.StatusInternalServerError, errorBody(http.StatusInternalServerError, "repository_missing_exception",
+			"["+definition["repository"].(string)+"] missing")
+	}
+	status, response := s.takeSnapshot(repo, definition["repository"].(string), snapshot, expression)
+	if status != http.StatusOK {
+		policy["last_failure"] = execution
+		return status, response
+	}
+	repo.snapshots[snapshot]["metadata"] = map[string]interface{}{"policy": id}
+	policy["last_success"] = execution
+	return http.StatusOK, map[string]interface{}{"snapshot_name": snapshot}
+}
+
+// Health is the health of the fake cluster
+type Health struct {
+	Status           string
+	PendingTasks     int
+	DataNodes        int
+	MaxShardsPerNode int
+	DiskPercent      int
+	// DiskAvailable is the free disk of each data node in bytes
+	DiskAvailable int64
+	// DiskWatermark is the persistent low disk watermark, the default 85% when empty
+	DiskWatermark string
+}
+
+// cluster handles _cluster/health and the cluster.max_shards_per_node and disk watermark settings of
+// _cluster/settings
+func (s *Server) cluster(r *request) (int, interface{}) {
+	if r.method != http.MethodGet {
+		return methodNotAllowed(r)
+	}
+	switch r.part(1) {
+	case "health":
+		shards := 0
+		for _, i := range s.indices {
+			primaries, _ := strconv.Atoi(fmt.Sprint(i.settings["index.number_of_shards"]))
+			replicas, _ := strconv.Atoi(fmt.Sprint(i.settings["index.number_of_replicas"]))
+			shards += primaries * (1 + replicas)
+		}
+		return http.StatusOK, map[string]interface{}{
+			"cluster_name":            "esfake",
+			"status":                  s.health.Status,
+			"number_of_nodes":         s.health.DataNodes,
+			"number_of_data_nodes":    s.health.DataNodes,
+			"number_of_pending_tasks": s.health.PendingTasks,
+			"active_shards":           shards,
+			"initializing_shards":     0,
+			"unassigned_shards":       0,
+		}
+	case "settings":
+		persistent := map[string]interface{}{}
+		if s.health.DiskWatermark != "" {
+			persistent["cluster.routing.allocation.disk.watermark.low"] = s.health.DiskWatermark
+		}
+		return http.StatusOK, map[string]interface{}{
+			"persistent": persistent,
+			"defaults": map[string]interface{}{
+				"cluster.max_shards_per_node":                       strconv.Itoa(s.health.MaxShardsPerNode),
+				"cluster.routing.allocation.disk.threshold_enabled": "true",
+				"cluster.routing.allocation.disk.watermark.low":     "85%",
+			},
+		}
+	}
+	return methodNotAllowed(r)
+}
+
+// allocation has a row for each data node with the disk usage of the cluster
+func (s *Server) allocation() (int, interface{}) {
+	rows := []interface{}{}
+	for i := 0; i < s.health.DataNodes; i++ {
+		rows = append(rows, map[string]interface{}{
+			"disk.percent": strconv.Itoa(s.health.DiskPercent),
+			"disk.avail":   strconv.FormatInt(s.health.DiskAvailable, 10),
+		})
+	}
+	return http.StatusOK, rows
+}
@@ -0,0 +1,719 @@
+package esfake
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// index is a concrete index, the settings are kept in the flat format
+type index struct {
+	settings map[string]interface{}
+	mappings map[string]interface{}
+	aliases  map[string]map[string]interface{}
+	docs     int64
+	closed   bool
+}
+
+// fieldTypes are the mapping types accepted for the fields
+var fieldTypes = map[string]bool{
+	"text": true, "match_only_text": true, "keyword": true, "constant_keyword": true, "wildcard": true,
+	"long": true, "integer": true, "short": true, "byte": true, "double": true, "float": true,
+	"half_float": true, "scaled_float": true, "unsigned_long": true, "date": true, "date_nanos": true,
+	"boolean": true, "binary": true, "object": true, "nested": true, "flattened": true, "ip": true,
+	"geo_point": true, "geo_shape": true, "completion": true, "search_as_you_type": true,
+	"dense_vector": true, "rank_feature": true, "rank_features": true, "token_count": true, "alias": true,
+	"version": true, "integer_range": true, "long_range": true, "float_range": true, "double_range": true,
+	"date_range": true, "ip_range": true, "percolator": true, "join": true, "histogram": true,
+}
+
+// dynamicSettings can be updated on an open index
+var dynamicSettings = []string{
+	"index.number_of_replicas",
+	"index.refresh_interval",
+	"index.max_result_window",
+	"index.blocks.",
+	"index.lifecycle.",
+	"index.routing.",
+	"index.auto_expand_replicas",
+	"index.max_inner_result_window",
+	"index.default_pipeline",
+	"index.final_pipeline",
+	"index.search.",
+	"index.translog.",
+	"index.mapping.",
+	"index.hidden",
+}
+
+// resolve returns the concrete indices of the comma separated names, wildcards and aliases,
+// and the first name that isn't a wildcard and doesn't exist
+func (s *Server) resolve(expression string) ([]string, string) {
+	seen := map[string]bool{}
+	names := []string{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	missing := ""
+	for _, target := range strings.Split(expression, ",") {
+		if target == "_all" {
+			target = "*"
+		}
+		if strings.Contains(target, "*") {
+			for name, i := range s.indices {
+				if ok, _ := path.Match(target, name); ok {
+					add(name)
+					continue
+				}
+				for alias := range i.aliases {
+					if ok, _ := path.Match(target, alias); ok {
+						add(name)
+					}
+				}
+			}
+			continue
+		}
+		if _, ok := s.indices[target]; ok {
+			add(target)
+			continue
+		}
+		found := false
+		for name, i := range s.indices {
+			if _, ok := i.aliases[target]; ok {
+				add(name)
+				found = true
+			}
+		}
+		if !found && missing == "" {
+			missing = target
+		}
+	}
+	sort.Strings(names)
+	return names, missing
+}
+
+func indexNotFound(name string) (int, interface{}) {
+	return http.StatusNotFound, errorBody(http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+}
+
+// indexRequest handles the APIs on an index expression
+func (s *Server) indexRequest(r *request) (int, interface{}) {
+	target := r.part(0)
+
+	if r.part(1) == "" && r.method == http.MethodPut {
+		return s.createIndex(target, r.body)
+	}
+
+	names, missing := s.resolve(target)
+	if missing != "" {
+		return indexNotFound(missing)
+	}
+
+	switch r.part(1) {
+	case "":
+		switch r.method {
+		case http.MethodGet, http.MethodHead:
+			return s.getIndices(names, r.param("flat_settings") == "true")
+		case http.MethodDelete:
+			return s.deleteIndices(target, names)
+		}
+	case "_alias", "_aliases":
+		return s.indexAlias(r, names)
+	case "_mapping":
+		return s.mapping(r, names)
+	case "_settings":
+		return s.settings(r, names)
+	case "_stats":
+		return s.stats(names)
+	case "_recovery":
+		response := map[string]interface{}{}
+		for _, name := range names {
+			response[name] = map[string]interface{}{"shards": []interface{}{
+				map[string]interface{}{"id": 0, "type": "SNAPSHOT", "stage": "DONE", "primary": true},
+			}}
+		}
+		return http.StatusOK, response
+	case "_close", "_open":
+		if r.method != http.MethodPost {
+			break
+		}
+		for _, name := range names {
+			s.indices[name].closed = r.part(1) == "_close"
+		}
+		return http.StatusOK, acknowledged()
+	case "_count":
+		count := int64(0)
+		for _, name := range names {
+			if s.indices[name].closed {
+				return http.StatusBadRequest, errorBody(http.StatusBadRequest, "index_closed_exception", "closed ["+name+"]")
+			}
+			count += s.indices[name].docs
+		}
+		return http.StatusOK, map[string]interface{}{"count": count}
+	case "_search":
+		return http.StatusOK, map[string]interface{}{"hits": map[string]interface{}{"hits": []interface{}{}}}
+	case "_doc", "_create":
+		if r.method != http.MethodPost && r.method != http.MethodPut {
+			break
+		}
+		name, err := s.writeIndex(target, names)
+		if err != nil {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		}
+		if pipeline := s.missingPipeline(s.indices[name]); pipeline != "" {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("pipeline with id [%s] does not exist", pipeline))
+		}
+		s.indices[name].docs++
+		return http.StatusCreated, map[string]interface{}{"_index": name, "result": "created"}
+	case "_refresh":
+		return http.StatusOK, map[string]interface{}{}
+	}
+	return methodNotAllowed(r)
+}
+
+// writeIndex returns the index the documents sent to the target are written to
+func (s *Server) writeIndex(target string, names []string) (string, error) {
+	if len(names) == 1 {
+		return names[0], nil
+	}
+	for _, name := range names {
+		if w, _ := s.indices[name].aliases[target]["is_write_index"].(bool); w {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no write index is defined for alias [%s]", target)
+}
+
+func (s *Server) createIndex(name string, body map[string]interface{}) (int, interface{}) {
+	if _, ok := s.indices[name]; ok {
+		return http.StatusBadRequest, errorBody(http.StatusBadRequest, "resource_already_exists_exception",
+			fmt.Sprintf("index [%s] already exists", name))
+	}
+	if name != strings.ToLower(name) || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "_") {
+		return http.StatusBadRequest, errorBody(http.StatusBadRequest, "invalid_index_name_exception",
+			fmt.Sprintf("Invalid index name [%s]", name))
+	}
+	for alias := range s.aliasNames() {
+		if alias == name {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "invalid_index_name_exception",
+				fmt.Sprintf("Invalid index name [%s], already exists as alias", name))
+		}
+	}
+	for key := range body {
+		if key != "settings" && key != "mappings" && key != "aliases" {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "parse_exception",
+				fmt.Sprintf("unknown key [%s] for create index", key))
+		}
+	}
+	body = s.applyIndexTemplate(name, body)
+
+	mappings, _ := body["mappings"].(map[string]interface{})
+	if err := validateMappings(mappings); err != nil {
+		return http.StatusBadRequest, errorBody(http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+	}
+
+	i := &index{
+		settings: map[string]interface{}{
+			"index.number_of_shards":   "1",
+			"index.number_of_replicas": "1",
+			"index.uuid":               fmt.Sprintf("%x", time.Now().UnixNano()),
+			"index.creation_date":      fmt.Sprint(time.Now().UnixMilli()),
+			"index.provided_name":      name,
+			"index.version.created":    "8040099",
+		},
+		mappings: mappings,
+		aliases:  map[string]map[string]interface{}{},
+	}
+	if i.mappings == nil {
+		i.mappings = map[string]interface{}{}
+	}
+	settings, _ := body["settings"].(map[string]interface{})
+	for key, value := range flatSettings(settings) {
+		i.settings[key] = value
+	}
+	aliases, _ := body["aliases"].(map[string]interface{})
+	for alias, definition := range aliases {
+		i.aliases[alias], _ = definition.(map[string]interface{})
+		if i.aliases[alias] == nil {
+			i.aliases[alias] = map[string]interface{}{}
+		}
+	}
+
+	s.indices[name] = i
+	return http.StatusOK, map[string]interface{}{"acknowledged": true, "shards_acknowledged": true, "index": name}
+}
+
+func (s *Server) getIndices(names []string, flat bool) (int, interface{}) {
+	response := map[string]interface{}{}
+	for _, name := range names {
+		i := s.indices[name]
+		response[name] = map[string]interface{}{
+			"aliases":  i.aliases,
+			"mappings": i.mappings,
+			"settings": i.settingsBody(flat),
+		}
+	}
+	return http.StatusOK, response
+}
+
+func (s *Server) deleteIndices(target string, names []string) (int, interface{}) {
+	for _, t := range strings.Split(target, ",") {
+		if _, ok := s.indices[t]; !ok && !strings.Contains(t, "*") {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("The provided expression [%s] matches an alias, specify the corresponding concrete indices instead.", t))
+		}
+	}
+	for _, name := range names {
+		delete(s.indices, name)
+	}
+	return http.StatusOK, acknowledged()
+}
+
+func (s *Server) indexAlias(r *request, names []string) (int, interface{}) {
+	alias := r.part(2)
+	switch r.method {
+	case http.MethodPut, http.MethodPost:
+		if alias == "" {
+			break
+		}
+		if _, ok := s.indices[alias]; ok {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "invalid_alias_name_exception",
+				fmt.Sprintf("Invalid alias name [%s]: an index or data stream exists with the same name as the alias", alias))
+		}
+		for _, name := range names {
+			definition := r.body
+			if definition == nil {
+				definition = map[string]interface{}{}
+			}
+			s.indices[name].aliases[alias] = definition
+		}
+		return http.StatusOK, acknowledged()
+	case http.MethodDelete:
+		found := false
+		for _, name := range names {
+			if _, ok := s.indices[name].aliases[alias]; ok {
+				delete(s.indices[name].aliases, alias)
+				found = true
+			}
+		}
+		if !found {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "aliases_not_found_exception",
+				fmt.Sprintf("aliases [%s] missing", alias))
+		}
+		return http.StatusOK, acknowledged()
+	case http.MethodGet, http.MethodHead:
+		response := map[string]interface{}{}
+		found := false
+		for _, name := range names {
+			matched := map[string]interface{}{}
+			for a, definition := range s.indices[name].aliases {
+				if ok, _ := path.Match(alias, a); ok || alias == "" {
+					matched[a] = definition
+					found = true
+				}
+			}
+			response[name] = map[string]interface{}{"aliases": matched}
+		}
+		if !found && alias != "" {
+			return http.StatusNotFound, map[string]interface{}{"error": "alias [" + alias + "] missing", "status": 404}
+		}
+		return http.StatusOK, response
+	}
+	return methodNotAllowed(r)
+}
+
+// getAlias handles GET /_alias/<name>
+func (s *Server) getAlias(r *request) (int, interface{}) {
+	if r.method != http.MethodGet && r.method != http.MethodHead {
+		return methodNotAllowed(r)
+	}
+	alias := r.part(1)
+	response := map[string]interface{}{}
+	for name, i := range s.indices {
+		matched := map[string]interface{}{}
+		for a, definition := range i.aliases {
+			if ok, _ := path.Match(alias, a); ok || alias == "" {
+				matched[a] = definition
+			}
+		}
+		if len(matched) > 0 {
+			response[name] = map[string]interface{}{"aliases": matched}
+		}
+	}
+	if len(response) == 0 && alias != "" {
+		return http.StatusNotFound, map[string]interface{}{"error": "alias [" + alias + "] missing", "status": 404}
+	}
+	return http.StatusOK, response
+}
+
+// updateAliases handles the add, remove and remove_index actions of POST /_aliases atomically
+func (s *Server) updateAliases(r *request) (int, interface{}) {
+	if r.method != http.MethodPost {
+		return methodNotAllowed(r)
+	}
+	actions, _ := r.body["actions"].([]interface{})
+
+	type change struct {
+		kind, index, alias string
+		definition         map[string]interface{}
+	}
+	changes := []change{}
+	for _, a := range actions {
+		action, _ := a.(map[string]interface{})
+		for kind, value := range action {
+			params, _ := value.(map[string]interface{})
+			indexName, _ := params["index"].(string)
+			alias, _ := params["alias"].(string)
+			i, ok := s.indices[indexName]
+			if !ok {
+				return indexNotFound(indexName)
+			}
+			switch kind {
+			case "remove":
+				if _, ok := i.aliases[alias]; !ok {
+					return http.StatusNotFound, errorBody(http.StatusNotFound, "aliases_not_found_exception",
+						fmt.Sprintf("aliases [%s] missing", alias))
+				}
+			case "add", "remove_index":
+			default:
+				return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception",
+					"unknown alias action ["+kind+"]")
+			}
+			definition := map[string]interface{}{}
+			for k, v := range params {
+				if k != "index" && k != "alias" {
+					definition[k] = v
+				}
+			}
+			changes = append(changes, change{kind, indexName, alias, definition})
+		}
+	}
+
+	for _, c := range changes {
+		switch c.kind {
+		case "add":
+			s.indices[c.index].aliases[c.alias] = c.definition
+		case "remove":
+			delete(s.indices[c.index].aliases, c.alias)
+		case "remove_index":
+			delete(s.indices, c.index)
+		}
+	}
+	return http.StatusOK, acknowledged()
+}
+
+func (s *Server) mapping(r *request, names []string) (int, interface{}) {
+	switch r.method {
+	case http.MethodGet:
+		response := map[string]interface{}{}
+		for _, name := range names {
+			response[name] = map[string]interface{}{"mappings": s.indices[name].mappings}
+		}
+		return http.StatusOK, response
+	case http.MethodPut, http.MethodPost:
+		if err := validateMappings(r.body); err != nil {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+		}
+		for _, name := range names {
+			if err := checkMappingUpdate(s.indices[name].mappings, r.body); err != nil {
+				return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+			}
+		}
+		for _, name := range names {
+			mergeMappings(s.indices[name].mappings, r.body)
+		}
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+func (s *Server) settings(r *request, names []string) (int, interface{}) {
+	switch r.method {
+	case http.MethodGet:
+		response := map[string]interface{}{}
+		for _, name := range names {
+			response[name] = map[string]interface{}{"settings": s.indices[name].settingsBody(r.param("flat_settings") == "true")}
+		}
+		return http.StatusOK, response
+	case http.MethodPut:
+		body := r.body
+		if nested, ok := body["settings"].(map[string]interface{}); ok && len(body) == 1 {
+			body = nested
+		}
+		settings := flatSettings(body)
+		for _, name := range names {
+			if s.indices[name].closed {
+				continue
+			}
+			for key := range settings {
+				if !isDynamic(key) {
+					return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception",
+						fmt.Sprintf("Can't update non dynamic settings [[%s]] for open indices [[%s]]", key, name))
+				}
+			}
+		}
+		for _, name := range names {
+			for key, value := range settings {
+				if value == nil {
+					delete(s.indices[name].settings, key)
+					continue
+				}
+				s.indices[name].settings[key] = value
+			}
+		}
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+func (s *Server) stats(names []string) (int, interface{}) {
+	docs := int64(0)
+	indices := map[string]interface{}{}
+	for _, name := range names {
+		count := s.indices[name].docs
+		docs += count
+		indices[name] = indexStats(count)
+	}
+	all := indexStats(docs)
+	return http.StatusOK, map[string]interface{}{"_all": all, "indices": indices}
+}
+
+// cat handles _cat/indices in JSON format with the index, pri, rep and store.size columns, and
+// _cat/allocation with the disk.percent and disk.avail columns
+func (s *Server) cat(r *request) (int, interface{}) {
+	if r.part(1) == "allocation" && r.method == http.MethodGet {
+		return s.allocation()
+	}
+	if r.part(1) != "indices" || r.method != http.MethodGet {
+		return methodNotAllowed(r)
+	}
+	expression := r.part(2)
+	if expression == "" {
+		expression = "*"
+	}
+	names, missing := s.resolve(expression)
+	if missing != "" {
+		return indexNotFound(missing)
+	}
+	sort.Strings(names)
+	rows := []interface{}{}
+	for _, name := range names {
+		i := s.indices[name]
+		rows = append(rows, map[string]interface{}{
+			"index":      name,
+			"pri":        fmt.Sprint(i.settings["index.number_of_shards"]),
+			"rep":        fmt.Sprint(i.settings["index.number_of_replicas"]),
+			"store.size": fmt.Sprint(i.docs * 100),
+		})
+	}
+	return http.StatusOK, rows
+}
+
+func indexStats(docs int64) map[string]interface{} {
+	return map[string]interface{}{
+		"primaries": map[string]interface{}{"docs": map[string]interface{}{"count": docs}},
+		// a fixed size per document is enough for the tests
+		"total": map[string]interface{}{"store": map[string]interface{}{"size_in_bytes": docs * 100}},
+	}
+}
+
+// reindex copies the document count from the source to the destination index, in a task without
+// wait_for_completion
+func (s *Server) reindex(r *request) (int, interface{}) {
+	if r.method != http.MethodPost {
+		return methodNotAllowed(r)
+	}
+	source, _ := r.body["source"].(map[string]interface{})
+	dest, _ := r.body["dest"].(map[string]interface{})
+	sourceName, _ := source["index"].(string)
+	destName, _ := dest["index"].(string)
+
+	names, missing := s.resolve(sourceName)
+	if missing != "" {
+		return indexNotFound(missing)
+	}
+	if r.param("wait_for_completion") == "false" {
+		return http.StatusOK, map[string]interface{}{"task": s.startTask(names, destName)}
+	}
+	if _, ok := s.indices[destName]; !ok {
+		if status, body := s.createIndex(destName, nil); status > 299 {
+			return status, body
+		}
+	}
+	docs := int64(0)
+	for _, name := range names {
+		docs += s.indices[name].docs
+	}
+	s.indices[destName].docs += docs
+	return http.StatusOK, map[string]interface{}{
+		"took":     1,
+		"total":    docs,
+		"created":  docs,
+		"failures": []interface{}{},
+	}
+}
+
+func (s *Server) aliasNames() map[string]bool {
+	names := map[string]bool{}
+	for _, i := range s.indices {
+		for alias := range i.aliases {
+			names[alias] = true
+		}
+	}
+	return names
+}
+
+func (i *index) settingsBody(flat bool) map[string]interface{} {
+	if flat {
+		settings := map[string]interface{}{}
+		for k, v := range i.settings {
+			settings[k] = v
+		}
+		return settings
+	}
+	return unflattenSettings(i.settings)
+}
+
+func isDynamic(key string) bool {
+	for _, s := range dynamicSettings {
+		if key == s || (strings.HasSuffix(s, ".") && strings.HasPrefix(key, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// flatSettings converts the settings to the flat format with the index prefix and string values
+func flatSettings(settings map[string]interface{}) map[string]interface{} {
+	flat := map[string]interface{}{}
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				walk(prefix+key+".", child)
+			}
+		case nil:
+			flat[strings.TrimSuffix(prefix, ".")] = nil
+		case []interface{}:
+			flat[strings.TrimSuffix(prefix, ".")] = v
+		default:
+			flat[strings.TrimSuffix(prefix, ".")] = fmt.Sprint(v)
+		}
+	}
+	walk("", settings)
+
+	prefixed := map[string]interface{}{}
+	for key, value := range flat {
+		if !strings.HasPrefix(key, "index.") {
+			key = "index." + key
+		}
+		prefixed[key] = value
+	}
+	return prefixed
+}
+
+func unflattenSettings(flat map[string]interface{}) map[string]interface{} {
+	nested := map[string]interface{}{}
+	for key, value := range flat {
+		parts := strings.Split(key, ".")
+		m := nested
+		for _, p := range parts[:len(parts)-1] {
+			child, ok := m[p].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				m[p] = child
+			}
+			m = child
+		}
+		m[parts[len(parts)-1]] = value
+	}
+	return nested
+}
+
+// validateMappings checks the field types of the properties, including sub fields and objects
+func validateMappings(mappings map[string]interface{}) error {
+	properties, _ := mappings["properties"].(map[string]interface{})
+	return validateProperties(properties, "")
+}
+
+func validateProperties(properties map[string]interface{}, prefix string) error {
+	for name, value := range properties {
+		field, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("Expected map for property [fields] on field [%s%s] but got a class java.lang.String", prefix, name)
+		}
+		t, _ := field["type"].(string)
+		if t != "" && !fieldTypes[t] {
+			return fmt.Errorf("No handler for type [%s] declared on field [%s%s]", t, prefix, name)
+		}
+		children, _ := field["properties"].(map[string]interface{})
+		if err := validateProperties(children, prefix+name+"."); err != nil {
+			return err
+		}
+		fields, _ := field["fields"].(map[string]interface{})
+		if err := validateProperties(fields, prefix+name+"."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMappingUpdate returns an error when a field in the update has a different type
+func checkMappingUpdate(current map[string]interface{}, update map[string]interface{}) error {
+	existing, _ := current["properties"].(map[string]interface{})
+	properties, _ := update["properties"].(map[string]interface{})
+	for name, value := range properties {
+		old, ok := existing[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		field, _ := value.(map[string]interface{})
+		if fieldType(old) != fieldType(field) {
+			return fmt.Errorf("mapper [%s] cannot be changed from type [%s] to [%s]", name, fieldType(old), fieldType(field))
+		}
+		if err := checkMappingUpdate(old, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fieldType(field map[string]interface{}) string {
+	if t, ok := field["type"].(string); ok {
+		return t
+	}
+	return "object"
+}
+
+// mergeMappings adds the new fields of the update to the current mappings
+func mergeMappings(current map[string]interface{}, update map[string]interface{}) {
+	for key, value := range update {
+		if key != "properties" {
+			current[key] = value
+			continue
+		}
+		existing, ok := current["properties"].(map[string]interface{})
+		if !ok {
+			existing = map[string]interface{}{}
+			current["properties"] = existing
+		}
+		properties, _ := value.(map[string]interface{})
+		for name, field := range properties {
+			old, ok := existing[name].(map[string]interface{})
+			child, _ := field.(map[string]interface{})
+			if ok && child != nil {
+				mergeMappings(old, child)
+				continue
+			}
+			existing[name] = field
+		}
+	}
+}
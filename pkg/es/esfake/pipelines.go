@@ -0,0 +1,190 @@
+package esfake
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// pipeline handles the ingest pipeline API and the simulate API. Only the set, remove, rename, lowercase,
+// uppercase and fail processors are run by the simulation, the other processor types are rejected.
+func (s *Server) pipeline(r *request) (int, interface{}) {
+	if r.part(1) != "pipeline" {
+		return methodNotAllowed(r)
+	}
+	name := r.part(2)
+	if name == "_simulate" || r.part(3) == "_simulate" {
+		if r.method != http.MethodPost && r.method != http.MethodGet {
+			return methodNotAllowed(r)
+		}
+		definition, ok := r.body["pipeline"].(map[string]interface{})
+		if name != "_simulate" {
+			definition, ok = s.pipelines[name]
+			if !ok {
+				return pipelineNotFound(name)
+			}
+		}
+		if !ok {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "parse_exception", "[pipeline] required property is missing")
+		}
+		if err := validatePipeline(definition); err != nil {
+			return http.StatusBadRequest, err
+		}
+		docs, _ := r.body["docs"].([]interface{})
+		results := []interface{}{}
+		for _, d := range docs {
+			doc, _ := d.(map[string]interface{})
+			source, _ := clone(doc["_source"]).(map[string]interface{})
+			if source == nil {
+				source = map[string]interface{}{}
+			}
+			if err := runPipeline(definition, source); err != nil {
+				results = append(results, map[string]interface{}{"error": err})
+				continue
+			}
+			results = append(results, map[string]interface{}{"doc": map[string]interface{}{"_source": source}})
+		}
+		return http.StatusOK, map[string]interface{}{"docs": results}
+	}
+
+	switch r.method {
+	case http.MethodPut:
+		if err := validatePipeline(r.body); err != nil {
+			return http.StatusBadRequest, err
+		}
+		s.pipelines[name] = r.body
+		return http.StatusOK, acknowledged()
+	case http.MethodGet:
+		pipelines := map[string]interface{}{}
+		for _, n := range sortedKeys(s.pipelines) {
+			if name == "" || match(name, n) {
+				pipelines[n] = s.pipelines[n]
+			}
+		}
+		if name != "" && len(pipelines) == 0 {
+			return http.StatusNotFound, map[string]interface{}{}
+		}
+		return http.StatusOK, pipelines
+	case http.MethodDelete:
+		if _, ok := s.pipelines[name]; !ok {
+			return pipelineNotFound(name)
+		}
+		delete(s.pipelines, name)
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+// missingPipeline returns the default or final pipeline of the index that doesn't exist, documents can't be indexed
+func (s *Server) missingPipeline(i *index) string {
+	for _, key := range []string{"index.default_pipeline", "index.final_pipeline"} {
+		name, _ := i.settings[key].(string)
+		if name == "" || name == "_none" {
+			continue
+		}
+		if _, ok := s.pipelines[name]; !ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func pipelineNotFound(name string) (int, interface{}) {
+	return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+		fmt.Sprintf("pipeline [%s] is missing", name))
+}
+
+var processorTypes = map[string]bool{"set": true, "remove": true, "rename": true, "lowercase": true, "uppercase": true, "fail": true}
+
+// validatePipeline checks the processors are known and have their required field
+func validatePipeline(definition map[string]interface{}) map[string]interface{} {
+	if _, ok := definition["processors"].([]interface{}); !ok {
+		return errorBody(http.StatusBadRequest, "parse_exception", "[processors] required property is missing")
+	}
+	for _, key := range []string{"processors", "on_failure"} {
+		processors, _ := definition[key].([]interface{})
+		for _, p := range processors {
+			processor, _ := p.(map[string]interface{})
+			if len(processor) != 1 {
+				return errorBody(http.StatusBadRequest, "parse_exception", "processor must have a single type")
+			}
+			for processorType, c := range processor {
+				if !processorTypes[processorType] {
+					return errorBody(http.StatusBadRequest, "parse_exception",
+						fmt.Sprintf("No processor type exists with name [%s]", processorType))
+				}
+				config, _ := c.(map[string]interface{})
+				required := "field"
+				if processorType == "fail" {
+					required = "message"
+				}
+				if _, ok := config[required]; !ok {
+					return errorBody(http.StatusBadRequest, "parse_exception",
+						fmt.Sprintf("[%s] required property is missing", required))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// runPipeline applies the processors to the document, the on_failure processors handle the first failure
+func runPipeline(definition map[string]interface{}, source map[string]interface{}) map[string]interface{} {
+	processors, _ := definition["processors"].([]interface{})
+	for _, p := range processors {
+		err := runProcessor(p.(map[string]interface{}), source)
+		if err == nil {
+			continue
+		}
+		handlers, _ := definition["on_failure"].([]interface{})
+		if len(handlers) == 0 {
+			return err
+		}
+		return runPipeline(map[string]interface{}{"processors": handlers}, source)
+	}
+	return nil
+}
+
+func runProcessor(processor map[string]interface{}, source map[string]interface{}) map[string]interface{} {
+	for processorType, c := range processor {
+		config, _ := c.(map[string]interface{})
+		field, _ := config["field"].(string)
+		value, exists := source[field]
+		if !exists && processorType != "set" && processorType != "fail" {
+			if ignore, _ := config["ignore_missing"].(bool); ignore {
+				return nil
+			}
+			return processorError("illegal_argument_exception",
+				fmt.Sprintf("field [%s] not present as part of path [%s]", field, field))
+		}
+		switch processorType {
+		case "set":
+			source[field] = config["value"]
+		case "remove":
+			delete(source, field)
+		case "rename":
+			target, _ := config["target_field"].(string)
+			source[target] = value
+			delete(source, field)
+		case "lowercase", "uppercase":
+			text, ok := value.(string)
+			if !ok {
+				return processorError("illegal_argument_exception",
+					fmt.Sprintf("field [%s] of type [%T] cannot be cast to [java.lang.String]", field, value))
+			}
+			if processorType == "lowercase" {
+				source[field] = strings.ToLower(text)
+			} else {
+				source[field] = strings.ToUpper(text)
+			}
+		case "fail":
+			return processorError("fail_processor_exception", fmt.Sprint(config["message"]))
+		}
+	}
+	return nil
+}
+
+// processorError is the error of a document failing in a simulation
+func processorError(errorType string, reason string) map[string]interface{} {
+	return map[string]interface{}{"type": errorType, "reason": reason}
+}
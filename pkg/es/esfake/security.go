@@ -0,0 +1,208 @@
+package esfake
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// user is a native realm user
+type user struct {
+	name     string
+	password string
+	roles    []string
+	metadata map[string]interface{}
+}
+
+// authenticate returns the user of the basic auth credentials, nil for the superuser
+func (s *Server) authenticate(r *http.Request) (*user, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	if name == Username && password == Password {
+		return nil, true
+	}
+	u, ok := s.users[name]
+	if !ok || u.password != password {
+		return nil, false
+	}
+	return u, true
+}
+
+// allowed is true when the user roles grant access to all the indices in the request,
+// users can't call cluster APIs besides authenticate and reading the pipelines with read_pipeline
+func (u *user) allowed(s *Server, method string, parts []string) bool {
+	if parts[0] == "_security" {
+		return len(parts) == 2 && parts[1] == "_authenticate"
+	}
+	if parts[0] == "_ingest" {
+		simulate := parts[len(parts)-1] == "_simulate"
+		return (method == http.MethodGet || simulate) && u.hasClusterPrivilege(s, "read_pipeline")
+	}
+	if strings.HasPrefix(parts[0], "_") {
+		return false
+	}
+
+	patterns := []string{}
+	for _, role := range u.roles {
+		indices, _ := s.roles[role]["indices"].([]interface{})
+		for _, i := range indices {
+			privileges, _ := i.(map[string]interface{})
+			names, _ := privileges["names"].([]interface{})
+			for _, n := range names {
+				if name, ok := n.(string); ok {
+					patterns = append(patterns, name)
+				}
+			}
+		}
+	}
+
+	targets := strings.Split(parts[0], ",")
+	concrete, _ := s.resolve(parts[0])
+	for _, target := range append(targets, concrete...) {
+		if !matchesAny(patterns, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasClusterPrivilege is true when one of the user roles grants the cluster privilege
+func (u *user) hasClusterPrivilege(s *Server, privilege string) bool {
+	for _, role := range u.roles {
+		cluster, _ := s.roles[role]["cluster"].([]interface{})
+		for _, p := range cluster {
+			if p == privilege || p == "all" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// security handles the role, user and authenticate APIs
+func (s *Server) security(r *request) (int, interface{}) {
+	switch r.part(1) {
+	case "_authenticate":
+		name := Username
+		roles := []string{"superuser"}
+		if r.principal != nil {
+			name, roles = r.principal.name, r.principal.roles
+		}
+		return http.StatusOK, map[string]interface{}{"username": name, "roles": roles, "enabled": true}
+	case "role":
+		return s.role(r)
+	case "user":
+		return s.user(r)
+	}
+	return methodNotAllowed(r)
+}
+
+func (s *Server) role(r *request) (int, interface{}) {
+	name := r.part(2)
+	switch r.method {
+	case http.MethodPut, http.MethodPost:
+		if name == "" {
+			return methodNotAllowed(r)
+		}
+		_, exists := s.roles[name]
+		s.roles[name] = r.body
+		return http.StatusOK, map[string]interface{}{"role": map[string]interface{}{"created": !exists}}
+	case http.MethodGet:
+		if name == "" {
+			roles := map[string]interface{}{}
+			for n, role := range s.roles {
+				roles[n] = role
+			}
+			return http.StatusOK, roles
+		}
+		role, ok := s.roles[name]
+		if !ok {
+			return http.StatusNotFound, map[string]interface{}{}
+		}
+		return http.StatusOK, map[string]interface{}{name: role}
+	case http.MethodDelete:
+		if _, ok := s.roles[name]; !ok {
+			return http.StatusNotFound, map[string]interface{}{"found": false}
+		}
+		delete(s.roles, name)
+		return http.StatusOK, map[string]interface{}{"found": true}
+	}
+	return methodNotAllowed(r)
+}
+
+func (s *Server) user(r *request) (int, interface{}) {
+	name := r.part(2)
+	switch {
+	case r.part(3) == "_password" && (r.method == http.MethodPut || r.method == http.MethodPost):
+		u, ok := s.users[name]
+		if !ok {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception", "user ["+name+"] not found")
+		}
+		u.password, _ = r.body["password"].(string)
+		return http.StatusOK, map[string]interface{}{}
+	case r.method == http.MethodPut || r.method == http.MethodPost:
+		if name == "" {
+			return methodNotAllowed(r)
+		}
+		password, _ := r.body["password"].(string)
+		if len(password) < 6 {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "action_request_validation_exception",
+				"Validation Failed: 1: passwords must be at least [6] characters long;")
+		}
+		u := &user{name: name, password: password}
+		u.metadata, _ = r.body["metadata"].(map[string]interface{})
+		roles, _ := r.body["roles"].([]interface{})
+		for _, role := range roles {
+			if n, ok := role.(string); ok {
+				u.roles = append(u.roles, n)
+			}
+		}
+		_, exists := s.users[name]
+		s.users[name] = u
+		return http.StatusOK, map[string]interface{}{"created": !exists}
+	case r.method == http.MethodGet:
+		if name == "" {
+			users := map[string]interface{}{}
+			for n, u := range s.users {
+				users[n] = u.definition()
+			}
+			return http.StatusOK, users
+		}
+		u, ok := s.users[name]
+		if !ok {
+			return http.StatusNotFound, map[string]interface{}{}
+		}
+		return http.StatusOK, map[string]interface{}{name: u.definition()}
+	case r.method == http.MethodDelete:
+		if _, ok := s.users[name]; !ok {
+			return http.StatusNotFound, map[string]interface{}{"found": false}
+		}
+		delete(s.users, name)
+		return http.StatusOK, map[string]interface{}{"found": true}
+	}
+	return methodNotAllowed(r)
+}
+
+func (u *user) definition() map[string]interface{} {
+	metadata := u.metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"username": u.name,
+		"roles":    u.roles,
+		"metadata": metadata,
+		"enabled":  true,
+	}
+}
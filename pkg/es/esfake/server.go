@@ -0,0 +1,368 @@
+// Package esfake is an in-memory Elasticsearch implementing the subset of the REST API used by the operator:
+// indices, aliases, mappings, settings, _cat/indices, security users and roles, synonym sets, ILM policies,
+// snapshot repositories, snapshots, restores and SLM policies, index and component templates, ingest pipelines and
+// reindex tasks.
+// Faults can be injected to test retries and failure recovery.
+package esfake
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default credentials of the superuser
+const (
+	Username = "elastic"
+	Password = "changeme"
+)
+
+// Fault is injected in the requests matching the method and path
+type Fault struct {
+	// Method of the requests, any method when empty
+	Method string
+	// Path is a regular expression matched against the request path
+	Path string
+	// Status returned instead of handling the request, when 0 the request is only delayed
+	Status int
+	// Type of the error returned, defaults to the usual type for the status
+	Type string
+	// Latency added before responding
+	Latency time.Duration
+	// Applied handles the request before returning the error, like a timeout after the change was made
+	Applied bool
+	// Times the fault is injected, until the faults are cleared when 0
+	Times int
+}
+
+// Request is a request received by the server
+type Request struct {
+	Method string
+	Path   string
+	Body   string
+	Status int
+}
+
+type fault struct {
+	Fault
+	path     *regexp.Regexp
+	injected int
+}
+
+// Server is an Elasticsearch API on an httptest.Server, URL is the address to connect to
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	version  string
+	indices  map[string]*index
+	roles    map[string]map[string]interface{}
+	users    map[string]*user
+	synonyms map[string]interface{}
+	policies map[string]interface{}
+	repos    map[string]*repository
+
+	componentTemplates map[string]map[string]interface{}
+	indexTemplates     map[string]map[string]interface{}
+	pipelines          map[string]map[string]interface{}
+	slmPolicies        map[string]map[string]interface{}
+
+	health Health
+
+	tasks     map[string]*task
+	taskID    int
+	holdTasks bool
+
+	faults   []*fault
+	requests []Request
+}
+
+// NewServer starts a server with the Username superuser and the given version, 8.4.0 when empty
+func NewServer(version string) *Server {
+	if version == "" {
+		version = "8.4.0"
+	}
+	s := &Server{version: version}
+	s.Reset()
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Reset deletes all the data, faults and recorded requests
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indices = map[string]*index{}
+	s.roles = map[string]map[string]interface{}{}
+	s.users = map[string]*user{}
+	s.synonyms = map[string]interface{}{}
+	s.policies = map[string]interface{}{}
+	s.repos = map[string]*repository{}
+	s.componentTemplates = map[string]map[string]interface{}{}
+	s.indexTemplates = map[string]map[string]interface{}{}
+	s.pipelines = map[string]map[string]interface{}{}
+	s.slmPolicies = map[string]map[string]interface{}{}
+	s.health = Health{Status: "green", DataNodes: 1, MaxShardsPerNode: 1000}
+	s.tasks = map[string]*task{}
+	s.holdTasks = false
+	s.faults = nil
+	s.requests = nil
+}
+
+// SetHealth sets the health of the cluster, the shards are counted from the indices
+func (s *Server) SetHealth(h Health) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health = h
+}
+
+// Inject adds a fault, faults are checked in the order they were added
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f, path: regexp.MustCompile(f.Path)})
+}
+
+// ClearFaults removes the injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the requests received, including the ones failed by faults
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Do handles the request as the superuser without faults or recording it, to set up or inspect the data
+// in tests. It returns the status and the response body.
+func (s *Server) Do(method string, path string, body string) (int, string) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, response := s.handle(method, u.Path, u.Query(), []byte(body), nil)
+	b, _ := json.Marshal(response)
+	return status, string(b)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeJSON(w, r, http.StatusBadRequest, errorBody(http.StatusBadRequest, "parse_exception", err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	f := s.fault(r.Method, r.URL.Path)
+	s.mu.Unlock()
+	if f != nil && f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, response := s.serve(r, body, f)
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Body: string(body), Status: status})
+	writeJSON(w, r, status, response)
+}
+
+func (s *Server) serve(r *http.Request, body []byte, f *fault) (int, interface{}) {
+	principal, ok := s.authenticate(r)
+	if !ok {
+		return http.StatusUnauthorized, errorBody(http.StatusUnauthorized, "security_exception",
+			"unable to authenticate user for REST request ["+r.URL.Path+"]")
+	}
+
+	if f != nil && f.Status != 0 && !f.Applied {
+		return f.Status, errorBody(f.Status, f.errorType(), "injected fault")
+	}
+	status, response := s.handle(r.Method, r.URL.Path, r.URL.Query(), body, principal)
+	if f != nil && f.Status != 0 {
+		return f.Status, errorBody(f.Status, f.errorType(), "injected fault")
+	}
+	return status, response
+}
+
+// fault returns the first fault matching the request and counts it
+func (s *Server) fault(method string, path string) *fault {
+	for _, f := range s.faults {
+		if f.Method != "" && f.Method != method {
+			continue
+		}
+		if !f.path.MatchString(path) {
+			continue
+		}
+		if f.Times > 0 && f.injected >= f.Times {
+			continue
+		}
+		f.injected++
+		return f
+	}
+	return nil
+}
+
+func (f *fault) errorType() string {
+	if f.Type != "" {
+		return f.Type
+	}
+	switch f.Status {
+	case http.StatusTooManyRequests:
+		return "es_rejected_execution_exception"
+	case http.StatusServiceUnavailable:
+		return "unavailable_shards_exception"
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return "timeout_exception"
+	case http.StatusNotFound:
+		return "resource_not_found_exception"
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "security_exception"
+	case http.StatusBadRequest:
+		return "illegal_argument_exception"
+	default:
+		return "exception"
+	}
+}
+
+// handle routes the request, the principal is nil for the superuser
+func (s *Server) handle(method string, path string, query map[string][]string, body []byte, principal *user) (int, interface{}) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] == "" {
+		return http.StatusOK, map[string]interface{}{
+			"name":         "esfake",
+			"cluster_name": "esfake",
+			"version":      map[string]interface{}{"number": s.version},
+			"tagline":      "You Know, for Search",
+		}
+	}
+
+	if principal != nil && !principal.allowed(s, method, parts) {
+		return http.StatusForbidden, errorBody(http.StatusForbidden, "security_exception",
+			fmt.Sprintf("action [%s %s] is unauthorized for user [%s]", method, path, principal.name))
+	}
+
+	var req request
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req.body); err != nil {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "parse_exception", err.Error())
+		}
+	}
+	req.method, req.parts, req.query, req.principal = method, parts, query, principal
+
+	switch parts[0] {
+	case "_security":
+		return s.security(&req)
+	case "_alias":
+		return s.getAlias(&req)
+	case "_aliases":
+		return s.updateAliases(&req)
+	case "_reindex":
+		return s.reindex(&req)
+	case "_tasks":
+		return s.task(&req)
+	case "_synonyms":
+		return s.synonymSet(&req)
+	case "_ilm":
+		return s.lifecyclePolicy(&req)
+	case "_snapshot":
+		return s.snapshot(&req)
+	case "_slm":
+		return s.snapshotLifecycle(&req)
+	case "_index_template":
+		return s.indexTemplate(&req)
+	case "_component_template":
+		return s.componentTemplate(&req)
+	case "_ingest":
+		return s.pipeline(&req)
+	case "_cat":
+		return s.cat(&req)
+	case "_cluster":
+		return s.cluster(&req)
+	case "_mapping":
+		names, _ := s.resolve("_all")
+		return s.mapping(&req, names)
+	}
+	if strings.HasPrefix(parts[0], "_") {
+		return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception",
+			"no handler found for uri ["+path+"] and method ["+method+"]")
+	}
+	return s.indexRequest(&req)
+}
+
+// request is a request being handled, the body is decoded JSON
+type request struct {
+	method    string
+	parts     []string
+	query     map[string][]string
+	body      map[string]interface{}
+	principal *user
+}
+
+func (r *request) part(i int) string {
+	if i < len(r.parts) {
+		return r.parts[i]
+	}
+	return ""
+}
+
+func (r *request) param(name string) string {
+	if values := r.query[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	var reader io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return io.ReadAll(reader)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	// checked by the client to verify it's connected to Elasticsearch
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead || body == nil {
+		return
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func errorBody(status int, errorType string, reason string) map[string]interface{} {
+	cause := map[string]interface{}{"type": errorType, "reason": reason}
+	return map[string]interface{}{
+		"error":  map[string]interface{}{"root_cause": []interface{}{cause}, "type": errorType, "reason": reason},
+		"status": status,
+	}
+}
+
+func acknowledged() map[string]interface{} {
+	return map[string]interface{}{"acknowledged": true}
+}
+
+func methodNotAllowed(r *request) (int, interface{}) {
+	return http.StatusMethodNotAllowed, errorBody(http.StatusMethodNotAllowed, "illegal_argument_exception",
+		fmt.Sprintf("Incorrect HTTP method for uri [/%s] and method [%s]", strings.Join(r.parts, "/"), r.method))
+}
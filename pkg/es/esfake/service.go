@@ -0,0 +1,386 @@
+package esfake
+
+import (
+	"fmt"
+	"sync"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/model"
+)
+
+// Call is a method called on the Service with its options
+type Call struct {
+	Method string
+	Args   interface{}
+}
+
+// Service is a recording es.EsService for unit tests, it succeeds without side effects unless
+// an error is set for the method. ProvisionStep advances the state like the real service.
+type Service struct {
+	mu     sync.Mutex
+	calls  []Call
+	errors map[string]error
+
+	// DryRunResult is returned by DryRunIndex, an empty result when nil
+	DryRunResult *es.EsDryRunResult
+	// DriftReport is returned by CheckIndex, an empty report when nil
+	DriftReport *es.EsDriftReport
+	// UpdatedIndex is the index returned by UpdateIndex, the index in the options when empty. It's also
+	// returned with the UpdateIndex error, like a migration failing once the alias was moved
+	UpdatedIndex string
+	// Migration is returned by UpdateIndex as the migration in progress. Without it, UpdateIndex completes
+	// the migration of the options to its index, or rolls it back with the UpdateIndex error
+	Migration *es.EsMigration
+	// SimulatedDocuments are returned by SimulatePipeline, the sample documents unchanged when nil
+	SimulatedDocuments []es.EsSimulatedDocument
+	// Snapshot is returned by GetSnapshot, a successful snapshot with the requested name when nil
+	Snapshot *es.EsSnapshotInfo
+	// Recovering makes IndexRecovered return false
+	Recovering bool
+	// SnapshotLifecycle is returned by GetSnapshotLifecycle, an empty status when nil
+	SnapshotLifecycle *es.EsSnapshotLifecycleStatus
+	// IndexUsage is returned by CatIndices whatever the names requested
+	IndexUsage []es.EsIndexUsage
+	// Owned is returned by ListOwned
+	Owned []es.EsOwnedResource
+	// Health is returned by ClusterHealth, a green cluster without shards when nil
+	Health *es.EsClusterHealth
+}
+
+// fakeNodes are the nodes returned by VerifySnapshotRepository
+var fakeNodes = []string{"esfake"}
+
+var _ es.EsService = &Service{}
+
+// NewService returns a Service without errors
+func NewService() *Service {
+	return &Service{errors: map[string]error{}}
+}
+
+// Fail makes the method return the error, ProvisionStep can also fail only the step to
+// complete using the step name, for example es.StepUserCreated. A nil error clears it.
+func (s *Service) Fail(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errors, method)
+		return
+	}
+	s.errors[method] = err
+}
+
+// Calls returns the methods called
+func (s *Service) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call{}, s.calls...)
+}
+
+// Methods returns the names of the methods called in order
+func (s *Service) Methods() []string {
+	methods := []string{}
+	for _, c := range s.Calls() {
+		methods = append(methods, c.Method)
+	}
+	return methods
+}
+
+func (s *Service) record(method string, args interface{}, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Args: args})
+	for _, key := range append([]string{method}, keys...) {
+		if err := s.errors[key]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) InitializeIndex(ops *es.EsSetupOptions) (*es.EsResult, error) {
+	state := &es.EsProvisionState{}
+	for state.Step != es.StepCredentialsVerified {
+		if err := s.ProvisionStep(ops, state); err != nil {
+			return nil, err
+		}
+	}
+	return &es.EsResult{
+		UserName: state.User,
+		Password: state.Password,
+		Role:     state.Role,
+		Index:    state.Index,
+		Alias:    state.Alias,
+	}, nil
+}
+
+func (s *Service) ProvisionStep(ops *es.EsSetupOptions, state *es.EsProvisionState) error {
+	next := map[string]string{
+		"":                  es.StepIndexCreated,
+		es.StepIndexCreated: es.StepAliasAdded,
+		es.StepIndexAdopted: es.StepAliasAdded,
+		es.StepAliasAdded:   es.StepRoleCreated,
+		es.StepRoleCreated:  es.StepUserCreated,
+		es.StepUserCreated:  es.StepCredentialsVerified,
+	}
+	step, ok := next[state.Step]
+	if !ok {
+		return fmt.Errorf("Cannot provision index, unknown step %s", state.Step)
+	}
+	if step == es.StepIndexCreated && ops.Adopt {
+		step = es.StepIndexAdopted
+	}
+	if err := s.record("ProvisionStep", *state, step); err != nil {
+		return err
+	}
+
+	if state.Alias == "" {
+		state.Alias = ops.IndexName
+		if state.Alias == "" {
+			state.Alias = "es-provisioner-" + ops.App + "-" + ops.Namespace
+		}
+	}
+	switch step {
+	case es.StepIndexCreated:
+		state.Index = state.Alias + "-1"
+		state.Created = append(state.Created, es.KindIndex)
+	case es.StepIndexAdopted:
+		state.Index = state.Alias
+		state.Adopted = true
+	case es.StepRoleCreated:
+		state.Role = ops.App + "-" + ops.Namespace + "-role"
+		state.Created = append(state.Created, es.KindRole)
+	case es.StepUserCreated:
+		state.User = state.Role + "-user"
+		state.Password = "password"
+		state.Created = append(state.Created, es.KindUser)
+	}
+	state.Step = step
+	if ops.OnStep != nil {
+		ops.OnStep(step, step)
+	}
+	return nil
+}
+
+func (s *Service) RollbackIndex(state *es.EsProvisionState) error {
+	return s.record("RollbackIndex", *state)
+}
+
+func (s *Service) RemoveIndex(ops *es.EsRemoveOptions) error {
+	return s.record("RemoveIndex", *ops)
+}
+
+func (s *Service) UpdateIndex(ops *es.EsUpdateOptions) (*es.EsResult, error) {
+	err := s.record("UpdateIndex", *ops)
+	if err != nil && s.UpdatedIndex == "" && ops.Migration == nil {
+		return nil, err
+	}
+	index := ops.Index
+	if ops.Migration != nil && s.Migration == nil && err == nil {
+		index = ops.Migration.Index
+	}
+	if s.UpdatedIndex != "" {
+		index = s.UpdatedIndex
+	}
+	return &es.EsResult{Role: ops.Role, Index: index, Alias: ops.Alias, Migration: s.Migration}, err
+}
+
+func (s *Service) UpdateSynonyms(ops *es.EsSynonymOptions) error {
+	return s.record("UpdateSynonyms", *ops)
+}
+
+func (s *Service) GetIndexStats(index string) (*es.EsIndexStats, error) {
+	if err := s.record("GetIndexStats", index); err != nil {
+		return nil, err
+	}
+	return &es.EsIndexStats{}, nil
+}
+
+func (s *Service) CheckIndex(ops *es.EsCheckOptions) (*es.EsDriftReport, error) {
+	if err := s.record("CheckIndex", *ops); err != nil {
+		return nil, err
+	}
+	if s.DriftReport != nil {
+		return s.DriftReport, nil
+	}
+	return &es.EsDriftReport{}, nil
+}
+
+func (s *Service) GetIndices(pattern string) ([]es.EsIndexDefinition, error) {
+	if err := s.record("GetIndices", pattern); err != nil {
+		return nil, err
+	}
+	return []es.EsIndexDefinition{}, nil
+}
+
+func (s *Service) RotatePassword(user string, role string, owner *es.EsOwner) (string, error) {
+	if err := s.record("RotatePassword", user); err != nil {
+		return "", err
+	}
+	return "rotated", nil
+}
+
+func (s *Service) SnapshotIndex(repository string, index string) (string, error) {
+	if err := s.record("SnapshotIndex", index); err != nil {
+		return "", err
+	}
+	return index + "-snapshot", nil
+}
+
+func (s *Service) DryRunIndex(ops *es.EsSetupOptions) (*es.EsDryRunResult, error) {
+	if err := s.record("DryRunIndex", *ops); err != nil {
+		return nil, err
+	}
+	if s.DryRunResult != nil {
+		return s.DryRunResult, nil
+	}
+	return &es.EsDryRunResult{}, nil
+}
+
+func (s *Service) PutComponentTemplate(ops *es.EsComponentTemplateOptions) error {
+	return s.record("PutComponentTemplate", *ops)
+}
+
+func (s *Service) DeleteComponentTemplate(name string) error {
+	return s.record("DeleteComponentTemplate", name)
+}
+
+func (s *Service) PutIndexTemplate(ops *es.EsIndexTemplateOptions) error {
+	return s.record("PutIndexTemplate", *ops)
+}
+
+func (s *Service) DeleteIndexTemplate(name string) error {
+	return s.record("DeleteIndexTemplate", name)
+}
+
+func (s *Service) PutPipeline(ops *es.EsPipelineOptions) error {
+	return s.record("PutPipeline", *ops)
+}
+
+func (s *Service) DeletePipeline(name string) error {
+	return s.record("DeletePipeline", name)
+}
+
+func (s *Service) SimulatePipeline(ops *es.EsPipelineOptions, docs []string) ([]es.EsSimulatedDocument, error) {
+	if err := s.record("SimulatePipeline", *ops); err != nil {
+		return nil, err
+	}
+	if s.SimulatedDocuments != nil {
+		return s.SimulatedDocuments, nil
+	}
+	result := []es.EsSimulatedDocument{}
+	for _, doc := range docs {
+		result = append(result, es.EsSimulatedDocument{Source: doc})
+	}
+	return result, nil
+}
+
+func (s *Service) CreateSnapshot(repository string, snapshot string, indices []string) error {
+	return s.record("CreateSnapshot", snapshot)
+}
+
+func (s *Service) GetSnapshot(repository string, snapshot string) (*es.EsSnapshotInfo, error) {
+	if err := s.record("GetSnapshot", snapshot); err != nil {
+		return nil, err
+	}
+	if s.Snapshot != nil {
+		return s.Snapshot, nil
+	}
+	return &es.EsSnapshotInfo{Snapshot: snapshot, State: es.SnapshotSuccess}, nil
+}
+
+func (s *Service) DeleteSnapshot(repository string, snapshot string) error {
+	return s.record("DeleteSnapshot", snapshot)
+}
+
+func (s *Service) RestoreSnapshot(ops *es.EsRestoreOptions) error {
+	return s.record("RestoreSnapshot", *ops)
+}
+
+func (s *Service) IndexRecovered(index string) (bool, error) {
+	if err := s.record("IndexRecovered", index); err != nil {
+		return false, err
+	}
+	return !s.Recovering, nil
+}
+
+func (s *Service) SwapIndex(ops *es.EsSwapOptions) error {
+	return s.record("SwapIndex", *ops)
+}
+
+func (s *Service) PutSnapshotLifecycle(ops *es.EsSnapshotLifecycleOptions) error {
+	return s.record("PutSnapshotLifecycle", *ops)
+}
+
+func (s *Service) GetSnapshotLifecycle(name string) (*es.EsSnapshotLifecycleStatus, error) {
+	if err := s.record("GetSnapshotLifecycle", name); err != nil {
+		return nil, err
+	}
+	if s.SnapshotLifecycle != nil {
+		return s.SnapshotLifecycle, nil
+	}
+	return &es.EsSnapshotLifecycleStatus{}, nil
+}
+
+func (s *Service) DeleteSnapshotLifecycle(name string) error {
+	return s.record("DeleteSnapshotLifecycle", name)
+}
+
+func (s *Service) PutSnapshotRepository(ops *es.EsSnapshotRepositoryOptions) error {
+	return s.record("PutSnapshotRepository", *ops)
+}
+
+func (s *Service) VerifySnapshotRepository(name string) ([]string, error) {
+	if err := s.record("VerifySnapshotRepository", name); err != nil {
+		return nil, err
+	}
+	return fakeNodes, nil
+}
+
+func (s *Service) DeleteSnapshotRepository(name string) error {
+	return s.record("DeleteSnapshotRepository", name)
+}
+
+func (s *Service) CatIndices(names []string) ([]es.EsIndexUsage, error) {
+	if err := s.record("CatIndices", names); err != nil {
+		return nil, err
+	}
+	return append([]es.EsIndexUsage{}, s.IndexUsage...), nil
+}
+
+func (s *Service) ListOwned() ([]es.EsOwnedResource, error) {
+	if err := s.record("ListOwned", nil); err != nil {
+		return nil, err
+	}
+	return append([]es.EsOwnedResource{}, s.Owned...), nil
+}
+
+func (s *Service) DeleteOwned(resource *es.EsOwnedResource) error {
+	return s.record("DeleteOwned", *resource)
+}
+
+func (s *Service) ClusterHealth() (*es.EsClusterHealth, error) {
+	if err := s.record("ClusterHealth", nil); err != nil {
+		return nil, err
+	}
+	if s.Health != nil {
+		return s.Health, nil
+	}
+	return &es.EsClusterHealth{Status: es.HealthGreen}, nil
+}
+
+// IndexShards returns the shards of the options, the settings of the Config Map and the component templates
+// aren't read
+func (s *Service) IndexShards(ops *es.EsSetupOptions) (int, error) {
+	if err := s.record("IndexShards", *ops); err != nil {
+		return 0, err
+	}
+	shards := ops.Shards
+	if shards == 0 {
+		shards = model.DEFAULT_SHARDS
+	}
+	return shards * (1 + ops.Replicas), nil
+}
+
+func (s *Service) Ping() error {
+	return s.record("Ping", nil)
+}
@@ -0,0 +1,98 @@
+package esfake
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// task is a reindex task started without waiting for its completion
+type task struct {
+	source    []string
+	dest      string
+	completed bool
+	cancelled bool
+	response  map[string]interface{}
+}
+
+// HoldTasks keeps the reindex tasks started from now on running until ReleaseTasks, they complete
+// immediately otherwise
+func (s *Server) HoldTasks() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holdTasks = true
+}
+
+// ReleaseTasks completes the running tasks, copying their documents, and stops holding the new ones
+func (s *Server) ReleaseTasks() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holdTasks = false
+	for _, t := range s.tasks {
+		if !t.completed {
+			s.completeTask(t)
+		}
+	}
+}
+
+// startTask starts a reindex task of the source indices into the dest index
+func (s *Server) startTask(source []string, dest string) string {
+	s.taskID++
+	id := fmt.Sprintf("esfake:%d", s.taskID)
+	t := &task{source: source, dest: dest}
+	s.tasks[id] = t
+	if !s.holdTasks {
+		s.completeTask(t)
+	}
+	return id
+}
+
+// completeTask copies the documents of the task, the dest index is created again if it was deleted
+func (s *Server) completeTask(t *task) {
+	t.completed = true
+	if _, ok := s.indices[t.dest]; !ok {
+		if status, body := s.createIndex(t.dest, nil); status > 299 {
+			t.response = map[string]interface{}{"failures": []interface{}{body}}
+			return
+		}
+	}
+	docs := int64(0)
+	for _, name := range t.source {
+		if i, ok := s.indices[name]; ok {
+			docs += i.docs
+		}
+	}
+	s.indices[t.dest].docs += docs
+	t.response = map[string]interface{}{"took": 1, "total": docs, "created": docs, "failures": []interface{}{}}
+}
+
+// task handles the get and cancel task APIs
+func (s *Server) task(r *request) (int, interface{}) {
+	id := r.part(1)
+	t, ok := s.tasks[id]
+	if !ok {
+		return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+			fmt.Sprintf("task [%s] isn't running and hasn't stored its results", id))
+	}
+	status := map[string]interface{}{
+		"action":      "indices:data/write/reindex",
+		"description": fmt.Sprintf("reindex from [%s] to [%s]", strings.Join(t.source, ","), t.dest),
+	}
+
+	switch {
+	case r.method == http.MethodPost && r.part(2) == "_cancel":
+		if !t.completed {
+			t.completed, t.cancelled = true, true
+		}
+		return http.StatusOK, map[string]interface{}{"nodes": map[string]interface{}{}}
+	case r.method == http.MethodGet && r.part(2) == "":
+		body := map[string]interface{}{"completed": t.completed, "task": status}
+		if t.cancelled {
+			body["error"] = map[string]interface{}{"type": "task_cancelled_exception", "reason": "by user request"}
+		} else if t.completed {
+			body["response"] = t.response
+		}
+		return http.StatusOK, body
+	}
+	return methodNotAllowed(r)
+}
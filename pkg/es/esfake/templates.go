@@ -0,0 +1,253 @@
+package esfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// componentTemplate handles the component template API, a template can't be deleted while an index template uses it
+func (s *Server) componentTemplate(r *request) (int, interface{}) {
+	name := r.part(1)
+	switch r.method {
+	case http.MethodPut, http.MethodPost:
+		if _, ok := r.body["template"].(map[string]interface{}); !ok {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "x_content_parse_exception",
+				"[component_template] failed to parse field [template], required property is missing")
+		}
+		if err := validateTemplate(r.body); err != nil {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+		}
+		s.componentTemplates[name] = r.body
+		return http.StatusOK, acknowledged()
+	case http.MethodGet:
+		templates := []interface{}{}
+		for _, n := range sortedKeys(s.componentTemplates) {
+			if name == "" || match(name, n) {
+				templates = append(templates, map[string]interface{}{"name": n, "component_template": s.componentTemplates[n]})
+			}
+		}
+		if name != "" && len(templates) == 0 {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+				"component template matching ["+name+"] not found")
+		}
+		return http.StatusOK, map[string]interface{}{"component_templates": templates}
+	case http.MethodDelete:
+		if _, ok := s.componentTemplates[name]; !ok {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+				"component template matching ["+name+"] not found")
+		}
+		users := []string{}
+		for _, n := range sortedKeys(s.indexTemplates) {
+			for _, c := range stringList(s.indexTemplates[n]["composed_of"]) {
+				if c == name {
+					users = append(users, n)
+				}
+			}
+		}
+		if len(users) > 0 {
+			return http.StatusBadRequest, errorBody(http.StatusBadRequest, "illegal_argument_exception",
+				fmt.Sprintf("component templates [%s] cannot be removed as they are still in use by index templates [%s]",
+					name, strings.Join(users, ", ")))
+		}
+		delete(s.componentTemplates, name)
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+// indexTemplate handles the index template API and the simulate API resolving the template of a definition
+func (s *Server) indexTemplate(r *request) (int, interface{}) {
+	name := r.part(1)
+	if name == "_simulate" {
+		if r.method != http.MethodPost {
+			return methodNotAllowed(r)
+		}
+		if err := s.validateIndexTemplate("simulate", r.body, false); err != nil {
+			return http.StatusBadRequest, err
+		}
+		template := s.composeTemplate(r.body)
+		template["settings"] = unflattenSettings(template["settings"].(map[string]interface{}))
+		return http.StatusOK, map[string]interface{}{"template": template, "overlapping": []interface{}{}}
+	}
+
+	switch r.method {
+	case http.MethodPut, http.MethodPost:
+		if err := s.validateIndexTemplate(name, r.body, true); err != nil {
+			return http.StatusBadRequest, err
+		}
+		s.indexTemplates[name] = r.body
+		return http.StatusOK, acknowledged()
+	case http.MethodGet:
+		templates := []interface{}{}
+		for _, n := range sortedKeys(s.indexTemplates) {
+			if name == "" || match(name, n) {
+				templates = append(templates, map[string]interface{}{"name": n, "index_template": s.indexTemplates[n]})
+			}
+		}
+		if name != "" && len(templates) == 0 {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+				"index template matching ["+name+"] not found")
+		}
+		return http.StatusOK, map[string]interface{}{"index_templates": templates}
+	case http.MethodDelete:
+		if _, ok := s.indexTemplates[name]; !ok {
+			return http.StatusNotFound, errorBody(http.StatusNotFound, "resource_not_found_exception",
+				"index_template matching ["+name+"] not found")
+		}
+		delete(s.indexTemplates, name)
+		return http.StatusOK, acknowledged()
+	}
+	return methodNotAllowed(r)
+}
+
+// validateIndexTemplate checks the patterns, the component templates and the template mappings. When stored,
+// templates with the same priority can't match the same indices.
+func (s *Server) validateIndexTemplate(name string, definition map[string]interface{}, stored bool) map[string]interface{} {
+	patterns := stringList(definition["index_patterns"])
+	if len(patterns) == 0 {
+		return errorBody(http.StatusBadRequest, "action_request_validation_exception",
+			"Validation Failed: 1: index patterns are missing;")
+	}
+	missing := []string{}
+	for _, c := range stringList(definition["composed_of"]) {
+		if _, ok := s.componentTemplates[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return errorBody(http.StatusBadRequest, "invalid_index_template_exception",
+			fmt.Sprintf("index_template [%s] invalid, cause [index template [%s] specifies component templates %v that do not exist]",
+				name, name, missing))
+	}
+	if err := validateTemplate(definition); err != nil {
+		return errorBody(http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+	}
+	if !stored {
+		return nil
+	}
+
+	priority := fmt.Sprint(definition["priority"])
+	for _, n := range sortedKeys(s.indexTemplates) {
+		other := s.indexTemplates[n]
+		if n == name || fmt.Sprint(other["priority"]) != priority {
+			continue
+		}
+		for _, p := range patterns {
+			for _, o := range stringList(other["index_patterns"]) {
+				if p == o || match(p, o) || match(o, p) {
+					return errorBody(http.StatusBadRequest, "illegal_argument_exception",
+						fmt.Sprintf("index template [%s] has index patterns %v matching patterns from existing templates [%s] "+
+							"with patterns (%s => %v) that have the same priority [%s], multiple index templates may not "+
+							"match during index creation, please use a different priority",
+							name, patterns, n, n, stringList(other["index_patterns"]), priority))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// composeTemplate merges the component templates and the template of an index template definition,
+// the later ones take precedence. Extra parts are merged last. The settings are returned in the flat format.
+func (s *Server) composeTemplate(definition map[string]interface{}, extra ...interface{}) map[string]interface{} {
+	parts := []interface{}{}
+	for _, c := range stringList(definition["composed_of"]) {
+		if component, ok := s.componentTemplates[c]; ok {
+			parts = append(parts, component["template"])
+		}
+	}
+	parts = append(append(parts, definition["template"]), extra...)
+
+	settings := map[string]interface{}{}
+	mappings := map[string]interface{}{}
+	aliases := map[string]interface{}{}
+	for _, part := range parts {
+		template, _ := clone(part).(map[string]interface{})
+		partSettings, _ := template["settings"].(map[string]interface{})
+		for key, value := range flatSettings(partSettings) {
+			settings[key] = value
+		}
+		partMappings, _ := template["mappings"].(map[string]interface{})
+		mergeMappings(mappings, partMappings)
+		partAliases, _ := template["aliases"].(map[string]interface{})
+		for alias, value := range partAliases {
+			aliases[alias] = value
+		}
+	}
+	return map[string]interface{}{"settings": settings, "mappings": mappings, "aliases": aliases}
+}
+
+// applyIndexTemplate returns the create index body merged on top of the index template with the
+// highest priority matching the index name, like Elasticsearch does when the index is created
+func (s *Server) applyIndexTemplate(name string, body map[string]interface{}) map[string]interface{} {
+	var matched map[string]interface{}
+	var priority float64
+	for _, n := range sortedKeys(s.indexTemplates) {
+		definition := s.indexTemplates[n]
+		p, _ := definition["priority"].(float64)
+		for _, pattern := range stringList(definition["index_patterns"]) {
+			if match(pattern, name) && (matched == nil || p > priority) {
+				matched, priority = definition, p
+			}
+		}
+	}
+	if matched == nil {
+		return body
+	}
+
+	composed := s.composeTemplate(matched, body)
+	composed["settings"] = unflattenSettings(composed["settings"].(map[string]interface{}))
+	return composed
+}
+
+// validateTemplate checks the mappings of the template of a component or index template
+func validateTemplate(definition map[string]interface{}) error {
+	template, _ := definition["template"].(map[string]interface{})
+	mappings, _ := template["mappings"].(map[string]interface{})
+	return validateMappings(mappings)
+}
+
+// match is true when the name matches the pattern with wildcards
+func match(pattern string, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+func stringList(value interface{}) []string {
+	list := []string{}
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+	case string:
+		list = append(list, strings.Split(v, ",")...)
+	}
+	return list
+}
+
+func sortedKeys(m map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// clone deep copies a decoded JSON value so the stored templates aren't modified by the merges
+func clone(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	b, _ := json.Marshal(value)
+	var copied interface{}
+	_ = json.Unmarshal(b, &copied)
+	return copied
+}
@@ -0,0 +1,144 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// EsIndexDefinition is the definition of an existing index, used to export it
+type EsIndexDefinition struct {
+	Name string
+	// Aliases of the index with their definition, filter, routing, is_write_index...
+	Aliases map[string]interface{}
+	// Settings in flat format without the settings managed by Elasticsearch
+	Settings map[string]interface{}
+	Mappings map[string]interface{}
+	// Lifecycle policy name and definition when the index is managed by ILM
+	LifecyclePolicy string
+	Lifecycle       map[string]interface{}
+}
+
+// settings generated by Elasticsearch that cannot be set when creating an index
+var internalSettings = []string{
+	"index.uuid",
+	"index.creation_date",
+	"index.provided_name",
+	"index.version.",
+	"index.history.uuid",
+	"index.resize.",
+	"index.routing.allocation.initial_recovery.",
+	"index.shrink.",
+	"index.frozen",
+	"index.verified_before_close",
+}
+
+// GetIndices returns the definition of the indices matching the pattern, hidden and system indices are skipped
+func (c *EsClient) GetIndices(pattern string) ([]EsIndexDefinition, error) {
+	start := time.Now()
+	indices, e := c.getIndices(pattern)
+	metrics.ObserveEsRequest("getIndices", start, e)
+	return indices, e
+}
+
+func (c *EsClient) getIndices(pattern string) ([]EsIndexDefinition, error) {
+
+	log.Infof("Reading Indices: %s", pattern)
+	res, err := c.client.Indices.Get([]string{pattern}, c.client.Indices.Get.WithFlatSettings(true))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get indices: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError("get indices", res)
+	}
+
+	var indices map[string]struct {
+		Aliases  map[string]interface{} `json:"aliases"`
+		Mappings map[string]interface{} `json:"mappings"`
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return nil, fmt.Errorf("Cannot get indices: %s", err)
+	}
+
+	names := []string{}
+	for name := range indices {
+		if !strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	policies := map[string]map[string]interface{}{}
+	definitions := []EsIndexDefinition{}
+	for _, name := range names {
+		index := indices[name]
+		definition := EsIndexDefinition{
+			Name:     name,
+			Aliases:  index.Aliases,
+			Mappings: index.Mappings,
+			Settings: map[string]interface{}{},
+		}
+		for key, value := range index.Settings {
+			if !isInternalSetting(key) {
+				definition.Settings[key] = value
+			}
+		}
+
+		if policy, ok := index.Settings["index.lifecycle.name"].(string); ok && policy != "" {
+			if _, ok := policies[policy]; !ok {
+				body, e := c.getLifecyclePolicy(policy)
+				if e != nil {
+					return nil, e
+				}
+				policies[policy] = body
+			}
+			definition.LifecyclePolicy = policy
+			definition.Lifecycle = policies[policy]
+		}
+
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, nil
+}
+
+// getLifecyclePolicy returns the ILM policy definition, nil if it doesn't exist
+func (c *EsClient) getLifecyclePolicy(name string) (map[string]interface{}, error) {
+
+	res, err := c.client.ILM.GetLifecycle(c.client.ILM.GetLifecycle.WithPolicy(name))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get lifecycle policy: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, responseError("get lifecycle policy", res)
+	}
+
+	var policies map[string]struct {
+		Policy map[string]interface{} `json:"policy"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&policies); err != nil {
+		return nil, fmt.Errorf("Cannot get lifecycle policy: %s", err)
+	}
+	return policies[name].Policy, nil
+}
+
+func isInternalSetting(key string) bool {
+	for _, s := range internalSettings {
+		if key == s || (strings.HasSuffix(s, ".") && strings.HasPrefix(key, s)) {
+			return true
+		}
+	}
+	return false
+}
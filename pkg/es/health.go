@@ -0,0 +1,286 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Cluster health status
+const (
+	HealthGreen  = "green"
+	HealthYellow = "yellow"
+	HealthRed    = "red"
+)
+
+// defaultMaxShardsPerNode is the default of the cluster.max_shards_per_node setting
+const defaultMaxShardsPerNode = 1000
+
+// Defaults of the index.number_of_shards and index.number_of_replicas settings of Elasticsearch
+const (
+	defaultIndexShards   = 1
+	defaultIndexReplicas = 1
+)
+
+// Cluster settings read with the health of the cluster
+const (
+	maxShardsPerNodeSetting     = "cluster.max_shards_per_node"
+	diskThresholdSetting        = "cluster.routing.allocation.disk.threshold_enabled"
+	lowWatermarkSetting         = "cluster.routing.allocation.disk.watermark.low"
+	lowWatermarkHeadroomSetting = "cluster.routing.allocation.disk.watermark.low.max_headroom"
+)
+
+// EsClusterHealth is the health and capacity of the cluster, checked before creating indices
+type EsClusterHealth struct {
+	Status       string
+	PendingTasks int
+	// Shards is the number of shards of the open indices, including the replicas and the unassigned shards
+	Shards int
+	// MaxShards is the shard limit of the cluster, cluster.max_shards_per_node for each data node
+	MaxShards int
+	// DiskPercent is the highest disk usage of the data nodes
+	DiskPercent int
+	// DiskWatermark is the low disk watermark of the cluster, empty when the disk threshold is disabled
+	DiskWatermark string
+	// DiskWatermarkReached is true when a data node reached the low disk watermark, Elasticsearch doesn't
+	// allocate the replicas of the new indices on it
+	DiskWatermarkReached bool
+}
+
+// ClusterHealth returns the health, the pending tasks, the shards and the disk usage of the cluster
+func (c *EsClient) ClusterHealth() (*EsClusterHealth, error) {
+	start := time.Now()
+	health, e := c.clusterHealth()
+	metrics.ObserveEsRequest("clusterHealth", start, e)
+	return health, e
+}
+
+func (c *EsClient) clusterHealth() (*EsClusterHealth, error) {
+	var status struct {
+		Status             string `json:"status"`
+		DataNodes          int    `json:"number_of_data_nodes"`
+		PendingTasks       int    `json:"number_of_pending_tasks"`
+		ActiveShards       int    `json:"active_shards"`
+		InitializingShards int    `json:"initializing_shards"`
+		UnassignedShards   int    `json:"unassigned_shards"`
+	}
+	if e := c.getJSON("get cluster health", "/_cluster/health", &status); e != nil {
+		return nil, e
+	}
+
+	// the settings are in the transient, persistent or default settings, in this order of precedence
+	var settings map[string]map[string]string
+	filter := strings.Join([]string{maxShardsPerNodeSetting, diskThresholdSetting, lowWatermarkSetting,
+		lowWatermarkHeadroomSetting}, ",*.")
+	if e := c.getJSON("get cluster settings",
+		"/_cluster/settings?include_defaults=true&flat_settings=true&filter_path=*."+filter, &settings); e != nil {
+		return nil, e
+	}
+	setting := func(key string) string {
+		for _, scope := range []string{"transient", "persistent", "defaults"} {
+			if value, ok := settings[scope][key]; ok {
+				return value
+			}
+		}
+		return ""
+	}
+	perNode := defaultMaxShardsPerNode
+	if n, e := strconv.Atoi(setting(maxShardsPerNodeSetting)); e == nil {
+		perNode = n
+	}
+
+	// _cat returns the values as strings, null for the unassigned shards row
+	var nodes []struct {
+		DiskPercent   *string `json:"disk.percent"`
+		DiskAvailable *string `json:"disk.avail"`
+	}
+	if e := c.getJSON("get disk allocation", "/_cat/allocation?format=json&bytes=b&h=disk.percent,disk.avail", &nodes); e != nil {
+		return nil, e
+	}
+	watermark := setting(lowWatermarkSetting)
+	if setting(diskThresholdSetting) == "false" {
+		watermark = ""
+	}
+	reached, e := watermarkReached(watermark, setting(lowWatermarkHeadroomSetting))
+	if e != nil {
+		return nil, e
+	}
+	disk := 0
+	overWatermark := false
+	for _, node := range nodes {
+		if node.DiskPercent == nil {
+			continue
+		}
+		percent, e := strconv.Atoi(*node.DiskPercent)
+		if e != nil {
+			continue
+		}
+		if percent > disk {
+			disk = percent
+		}
+		available := int64(-1)
+		if node.DiskAvailable != nil {
+			if n, e := strconv.ParseInt(*node.DiskAvailable, 10, 64); e == nil {
+				available = n
+			}
+		}
+		overWatermark = overWatermark || reached(percent, available)
+	}
+
+	return &EsClusterHealth{
+		Status:       status.Status,
+		PendingTasks: status.PendingTasks,
+		Shards:       status.ActiveShards + status.InitializingShards + status.UnassignedShards,
+		MaxShards:    perNode * status.DataNodes,
+		DiskPercent:  disk,
+
+		DiskWatermark:        watermark,
+		DiskWatermarkReached: overWatermark,
+	}, nil
+}
+
+// watermarkReached returns whether a node with the disk usage in percent and the available bytes reached
+// the watermark. The watermark is a percentage or a ratio of used disk, capped by the headroom of free disk
+// when it's set, or the free disk in bytes. An empty watermark is never reached.
+func watermarkReached(watermark string, headroom string) (func(percent int, available int64) bool, error) {
+	if watermark == "" {
+		return func(int, int64) bool { return false }, nil
+	}
+
+	isPercent := strings.HasSuffix(watermark, "%")
+	used := strings.TrimSuffix(watermark, "%")
+	if ratio, e := strconv.ParseFloat(used, 64); e == nil {
+		if !isPercent {
+			ratio *= 100
+		}
+		maxFree := int64(-1)
+		if headroom != "" && headroom != "-1" {
+			bytes, e := parseByteSize(headroom)
+			if e != nil {
+				return nil, fmt.Errorf("Cannot parse disk watermark headroom %s: %s", headroom, e)
+			}
+			maxFree = bytes
+		}
+		// the headroom caps the free disk required on large disks, the available disk of unknown nodes isn't
+		return func(percent int, available int64) bool {
+			if float64(percent) < ratio {
+				return false
+			}
+			return maxFree < 0 || available < 0 || available <= maxFree
+		}, nil
+	} else if isPercent {
+		return nil, fmt.Errorf("Cannot parse disk watermark %s: %s", watermark, e)
+	}
+
+	minFree, e := parseByteSize(watermark)
+	if e != nil {
+		return nil, fmt.Errorf("Cannot parse disk watermark %s: %s", watermark, e)
+	}
+	return func(percent int, available int64) bool {
+		return available >= 0 && available <= minFree
+	}, nil
+}
+
+// byteUnits are the units of the byte size values of Elasticsearch, longest suffix first
+var byteUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"pb", 1 << 50}, {"tb", 1 << 40}, {"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1},
+}
+
+// parseByteSize parses a byte size value like 500mb or 1.5gb
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			n, e := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), 64)
+			if e != nil {
+				return 0, e
+			}
+			return int64(n * unit.bytes), nil
+		}
+	}
+	return 0, fmt.Errorf("missing unit in %q", value)
+}
+
+// IndexShards returns the shards of the index created with the options, including the replicas. They are read
+// from the settings of the index body composed with the component templates, as the index is created.
+func (c *EsClient) IndexShards(ops *EsSetupOptions) (int, error) {
+	start := time.Now()
+	shards, e := c.indexShards(ops)
+	metrics.ObserveEsRequest("indexShards", start, e)
+	return shards, e
+}
+
+func (c *EsClient) indexShards(ops *EsSetupOptions) (int, error) {
+	body := indexBody(ops.Spec, ops.Shards, ops.Replicas, ops.RefreshInterval, ops.Analyzers, ops.Source, ops.Properties)
+	body, e := c.composeBody(indexName(aliasName(ops)), body, ops.ComponentTemplates)
+	if e != nil {
+		return 0, e
+	}
+
+	var payload struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return 0, fmt.Errorf("Cannot read index shards, invalid index body: %s", e)
+	}
+	settings := flattenSettings(payload.Settings, "")
+	setting := func(key string, value int) (int, error) {
+		v, ok := settings["index."+key]
+		if !ok {
+			v, ok = settings[key]
+		}
+		if !ok {
+			return value, nil
+		}
+		n, e := strconv.Atoi(fmt.Sprint(v))
+		if e != nil {
+			return 0, fmt.Errorf("Cannot read index shards, invalid %s %v", key, v)
+		}
+		return n, nil
+	}
+	shards, e := setting("number_of_shards", defaultIndexShards)
+	if e != nil {
+		return 0, e
+	}
+	replicas, e := setting("number_of_replicas", defaultIndexReplicas)
+	if e != nil {
+		return 0, e
+	}
+	return shards * (1 + replicas), nil
+}
+
+// Ping verifies the cluster can be reached and the credentials of the operator are valid
+func (c *EsClient) Ping() error {
+	start := time.Now()
+	var user struct {
+		Username string `json:"username"`
+	}
+	e := c.getJSON("authenticate", "/_security/_authenticate", &user)
+	metrics.ObserveEsRequest("ping", start, e)
+	return e
+}
+
+// getJSON decodes the response of a GET request
+func (c *EsClient) getJSON(action string, path string, v interface{}) error {
+	res, err := c.perform(http.MethodGet, path, "")
+	if err != nil {
+		return fmt.Errorf("Cannot %s: %s", action, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError(action, &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("Cannot %s: %s", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package es_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+)
+
+func TestClusterHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		health   esfake.Health
+		expected es.EsClusterHealth
+	}{
+		{
+			name:   "reads the health and the shard limit of the cluster",
+			health: esfake.Health{Status: "yellow", PendingTasks: 3, DataNodes: 2, MaxShardsPerNode: 500, DiskPercent: 50},
+			expected: es.EsClusterHealth{Status: "yellow", PendingTasks: 3, MaxShards: 1000, DiskPercent: 50,
+				DiskWatermark: "85%"},
+		},
+		{
+			name:   "reaches the default low watermark",
+			health: esfake.Health{Status: "green", DataNodes: 1, MaxShardsPerNode: 1000, DiskPercent: 85},
+			expected: es.EsClusterHealth{Status: "green", MaxShards: 1000, DiskPercent: 85,
+				DiskWatermark: "85%", DiskWatermarkReached: true},
+		},
+		{
+			name: "reads the low watermark of the cluster",
+			health: esfake.Health{Status: "green", DataNodes: 1, MaxShardsPerNode: 1000, DiskPercent: 90,
+				DiskWatermark: "0.95"},
+			expected: es.EsClusterHealth{Status: "green", MaxShards: 1000, DiskPercent: 90, DiskWatermark: "0.95"},
+		},
+		{
+			name: "reaches a low watermark of free disk",
+			health: esfake.Health{Status: "green", DataNodes: 1, MaxShardsPerNode: 1000, DiskPercent: 60,
+				DiskAvailable: 100 << 20, DiskWatermark: "500mb"},
+			expected: es.EsClusterHealth{Status: "green", MaxShards: 1000, DiskPercent: 60,
+				DiskWatermark: "500mb", DiskWatermarkReached: true},
+		},
+		{
+			name: "doesn't reach a low watermark of free disk",
+			health: esfake.Health{Status: "green", DataNodes: 1, MaxShardsPerNode: 1000, DiskPercent: 99,
+				DiskAvailable: 1 << 30, DiskWatermark: "500MB"},
+			expected: es.EsClusterHealth{Status: "green", MaxShards: 1000, DiskPercent: 99, DiskWatermark: "500MB"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			server, service := newTestService(g)
+			defer server.Close()
+			server.SetHealth(tt.health)
+
+			health, err := service.ClusterHealth()
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(*health).To(Equal(tt.expected))
+		})
+	}
+}
+
+func TestIndexShards(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  es.EsSetupOptions
+		// existing requests sent before counting the shards
+		existing func(g *WithT, server *esfake.Server)
+		shards   int
+		err      string
+	}{
+		{
+			name:   "counts the shards of the spec",
+			ops:    es.EsSetupOptions{Shards: 2, Replicas: 1},
+			shards: 4,
+		},
+		{
+			name:   "counts the default shards of the operator",
+			shards: 4,
+		},
+		{
+			name:   "counts the shards of the payload",
+			ops:    es.EsSetupOptions{Shards: 2, Spec: `{"settings": {"index": {"number_of_shards": 3, "number_of_replicas": "2"}}}`},
+			shards: 9,
+		},
+		{
+			name:   "counts the default shards of Elasticsearch without settings in the payload",
+			ops:    es.EsSetupOptions{Spec: `{"mappings": {"properties": {"name": {"type": "text"}}}}`},
+			shards: 2,
+		},
+		{
+			name: "counts the shards of the component templates",
+			ops:  es.EsSetupOptions{Spec: `{"settings": {"refresh_interval": "5s"}}`, ComponentTemplates: []string{"shards"}},
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/_component_template/shards",
+					`{"template": {"settings": {"number_of_shards": 6, "number_of_replicas": 0}}}`)
+			},
+			shards: 6,
+		},
+		{
+			name: "keeps the shards of the payload over the component templates",
+			ops:  es.EsSetupOptions{Spec: `{"settings": {"number_of_shards": 2}}`, ComponentTemplates: []string{"shards"}},
+			existing: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/_component_template/shards",
+					`{"template": {"settings": {"number_of_shards": 6, "number_of_replicas": 0}}}`)
+			},
+			shards: 2,
+		},
+		{
+			name: "fails without the component templates",
+			ops:  es.EsSetupOptions{ComponentTemplates: []string{"missing"}},
+			err:  "component templates [missing] not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			server, service := newTestService(g)
+			defer server.Close()
+			if tt.existing != nil {
+				tt.existing(g, server)
+			}
+			tt.ops.App = "app"
+			tt.ops.Namespace = "ns"
+
+			shards, err := service.IndexShards(&tt.ops)
+			if tt.err != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(shards).To(Equal(tt.shards))
+		})
+	}
+}
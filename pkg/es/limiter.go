@@ -0,0 +1,169 @@
+package es
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Priority of a request waiting for the Limiter, the higher priorities are served first
+type Priority int
+
+const (
+	// PriorityNormal is the priority of the requests creating, updating and reading objects
+	PriorityNormal Priority = iota
+	// PriorityHigh is the priority of the deletes, they free resources in the cluster
+	PriorityHigh
+)
+
+// Limiter is a token bucket capping the Elasticsearch requests per second and in flight, shared by the
+// services connecting to the same cluster. Waiting requests are served by priority, so the deletes of a
+// rollback or an Index removal don't queue behind a burst of creates.
+type Limiter struct {
+	qps         float64
+	burst       float64
+	maxInFlight int
+
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	inFlight int
+	waiting  map[Priority]int
+	// released is closed when a request completes or takes a slot, so the waiting requests check again
+	released chan struct{}
+}
+
+// NewLimiter returns a Limiter allowing qps requests per second with bursts of burst requests, and at most
+// maxInFlight requests at a time. A qps or maxInFlight of 0 doesn't limit them.
+func NewLimiter(qps float64, burst int, maxInFlight int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		qps:         qps,
+		burst:       float64(burst),
+		maxInFlight: maxInFlight,
+		tokens:      float64(burst),
+		last:        time.Now(),
+		waiting:     map[Priority]int{},
+		released:    make(chan struct{}),
+	}
+}
+
+// Wait blocks until the request can be sent or the context is cancelled, Done must be called when it completes
+func (l *Limiter) Wait(ctx context.Context, priority Priority) error {
+	l.mu.Lock()
+	l.waiting[priority]++
+	defer func() {
+		l.waiting[priority]--
+		l.mu.Unlock()
+	}()
+
+	for {
+		l.refill(time.Now())
+		inFlight := l.maxInFlight == 0 || l.inFlight < l.maxInFlight
+		tokens := l.qps == 0 || l.tokens >= 1
+		if inFlight && tokens && !l.higherWaiting(priority) {
+			if l.qps > 0 {
+				l.tokens--
+			}
+			l.inFlight++
+			l.notify()
+			return nil
+		}
+
+		// without tokens it waits for the next one, otherwise for a request to complete
+		timer := time.NewTimer(time.Hour)
+		if inFlight && !tokens {
+			timer.Reset(time.Duration((1 - l.tokens) / l.qps * float64(time.Second)))
+		}
+		released := l.released
+		l.mu.Unlock()
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+		l.mu.Lock()
+		if err != nil {
+			// the requests of lower priority waiting for this one check again
+			l.notify()
+			return err
+		}
+	}
+}
+
+// Done releases the slot of a request
+func (l *Limiter) Done() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.notify()
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if l.qps == 0 {
+		return
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.qps
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+func (l *Limiter) higherWaiting(priority Priority) bool {
+	for p, n := range l.waiting {
+		if p > priority && n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Limiter) notify() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// limitedTransport sends the requests through the Limiter, the deletes with high priority
+type limitedTransport struct {
+	next    http.RoundTripper
+	limiter *Limiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	priority := PriorityNormal
+	if req.Method == http.MethodDelete {
+		priority = PriorityHigh
+	}
+	if err := t.limiter.Wait(req.Context(), priority); err != nil {
+		return nil, err
+	}
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.Body == nil {
+		t.limiter.Done()
+		return res, err
+	}
+	// the request is in flight until its body is read, the slot is released when it's closed
+	res.Body = &limitedBody{ReadCloser: res.Body, done: t.limiter.Done}
+	return res, nil
+}
+
+// limitedBody releases the slot of the request once when the response body is closed
+type limitedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
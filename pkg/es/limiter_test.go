@@ -0,0 +1,154 @@
+package es
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// roundTripFunc is a RoundTripper answering with the function
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// waitFor waits for the number of requests of the priority waiting for the Limiter
+func waitFor(g *WithT, l *Limiter, priority Priority, n int) {
+	g.Eventually(func() int {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.waiting[priority]
+	}).Should(Equal(n))
+}
+
+// acquire waits for the Limiter in a goroutine, the error is sent once it returns
+func acquire(ctx context.Context, l *Limiter, priority Priority) chan error {
+	result := make(chan error, 1)
+	go func() { result <- l.Wait(ctx, priority) }()
+	return result
+}
+
+func TestLimiterWait(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	l := NewLimiter(0, 1, 1)
+	g.Expect(l.Wait(ctx, PriorityNormal)).To(Succeed())
+
+	low := acquire(ctx, l, PriorityNormal)
+	waitFor(g, l, PriorityNormal, 1)
+	high := acquire(ctx, l, PriorityHigh)
+	waitFor(g, l, PriorityHigh, 1)
+
+	// the delete is served first when the slot is released
+	l.Done()
+	g.Eventually(high).Should(Receive(BeNil()))
+	g.Consistently(low, 50*time.Millisecond).ShouldNot(Receive())
+	l.Done()
+	g.Eventually(low).Should(Receive(BeNil()))
+}
+
+func TestLimiterWaitCancelled(t *testing.T) {
+	g := NewWithT(t)
+
+	l := NewLimiter(0, 1, 1)
+	g.Expect(l.Wait(context.Background(), PriorityNormal)).To(Succeed())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	high := acquire(ctx, l, PriorityHigh)
+	waitFor(g, l, PriorityHigh, 1)
+	l.mu.Lock()
+	released := l.released
+	l.mu.Unlock()
+
+	// the requests of lower priority waiting behind the cancelled one are notified
+	cancel()
+	g.Eventually(high).Should(Receive(MatchError(context.Canceled)))
+	g.Expect(released).To(BeClosed())
+	waitFor(g, l, PriorityHigh, 0)
+}
+
+func TestLimiterQPS(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	l := NewLimiter(20, 1, 0)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		g.Expect(l.Wait(ctx, PriorityNormal)).To(Succeed())
+		l.Done()
+	}
+	// the burst is sent at once, the other requests wait for a token every 50ms
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+}
+
+func TestLimitedTransport(t *testing.T) {
+	tests := []struct {
+		name string
+		// next answers the requests of the transport
+		next roundTripFunc
+		// close closes the body of the response
+		close bool
+		// released is true when the slot is free after the request
+		released bool
+	}{
+		{
+			name: "keeps the slot until the body is closed",
+			next: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+			},
+		},
+		{
+			name: "releases the slot when the body is closed",
+			next: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+			},
+			close:    true,
+			released: true,
+		},
+		{
+			name: "releases the slot when the request fails",
+			next: func(req *http.Request) (*http.Response, error) {
+				return nil, io.ErrUnexpectedEOF
+			},
+			released: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			l := NewLimiter(0, 1, 1)
+			transport := &limitedTransport{next: tt.next, limiter: l}
+			req, err := http.NewRequest(http.MethodGet, "http://localhost:9200/", nil)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			res, err := transport.RoundTrip(req)
+			if res != nil {
+				_, err = io.ReadAll(res.Body)
+				g.Expect(err).NotTo(HaveOccurred())
+				if tt.close {
+					g.Expect(res.Body.Close()).To(Succeed())
+					// closing twice doesn't release another slot
+					g.Expect(res.Body.Close()).To(Succeed())
+				}
+			}
+
+			l.mu.Lock()
+			inFlight := l.inFlight
+			l.mu.Unlock()
+			if tt.released {
+				g.Expect(inFlight).To(Equal(0))
+			} else {
+				g.Expect(inFlight).To(Equal(1))
+			}
+		})
+	}
+}
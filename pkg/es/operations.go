@@ -0,0 +1,49 @@
+package es
+
+import (
+	"strings"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Steps reported to EsStepFunc by the operations requested on demand
+const (
+	StepPasswordRotated = "PasswordRotated"
+	StepSnapshotStarted = "SnapshotStarted"
+)
+
+const snapshotTimeFormat = "2006.01.02-15.04.05"
+
+// RotatePassword sets a new random password for the user and returns it
+func (c *EsClient) RotatePassword(user string, role string, owner *EsOwner) (string, error) {
+	start := time.Now()
+	pw := uuid.New().String()
+	log.Infof("Rotating password of User: %s", user)
+	e := c.putUser(user, pw, role, owner)
+	metrics.ObserveEsRequest("rotatePassword", start, e)
+	if e != nil {
+		return "", e
+	}
+	return pw, nil
+}
+
+// SnapshotIndex starts a snapshot of the index in the repository without waiting for it to complete,
+// it returns the snapshot name
+func (c *EsClient) SnapshotIndex(repository string, index string) (string, error) {
+	start := time.Now()
+	snapshot, e := c.snapshotIndex(repository, index)
+	metrics.ObserveEsRequest("snapshotIndex", start, e)
+	return snapshot, e
+}
+
+func (c *EsClient) snapshotIndex(repository string, index string) (string, error) {
+
+	snapshot := strings.ToLower(index) + "-" + time.Now().UTC().Format(snapshotTimeFormat)
+	if err := c.createSnapshot(repository, snapshot, []string{index}); err != nil {
+		return "", err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,320 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// OwnerMetaKey is the key of the owner in the _meta of the indices and the metadata of the roles and users
+const OwnerMetaKey = "es-provisioner"
+
+// Kinds of the objects created by the operator
+const (
+	KindIndex = "index"
+	KindRole  = "role"
+	KindUser  = "user"
+)
+
+// EsOwner is the Index resource owning the objects created in Elasticsearch, several Kubernetes
+// clusters can share an Elasticsearch cluster
+type EsOwner struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"index"`
+	UID       string `json:"uid"`
+}
+
+func (o *EsOwner) String() string {
+	if o.Cluster == "" {
+		return o.Namespace + "/" + o.Name
+	}
+	return o.Cluster + "/" + o.Namespace + "/" + o.Name
+}
+
+// owns is true when the owner recorded in Elasticsearch is the Index. The objects created before the
+// owner was recorded don't have one and can be changed by the Index tracking them.
+func (o *EsOwner) owns(recorded *EsOwner) bool {
+	if o == nil || recorded == nil {
+		return true
+	}
+	if o.UID != "" && recorded.UID != "" {
+		return o.UID == recorded.UID
+	}
+	return o.Cluster == recorded.Cluster && o.Namespace == recorded.Namespace && o.Name == recorded.Name
+}
+
+// metadata is the metadata of the roles and users owned by the Index, empty without owner
+func (o *EsOwner) metadata() map[string]interface{} {
+	if o == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{OwnerMetaKey: o}
+}
+
+// ownerOf returns the owner in the metadata or the _meta of an object, nil when it has none
+func ownerOf(metadata map[string]interface{}) *EsOwner {
+	value, ok := metadata[OwnerMetaKey]
+	if !ok {
+		return nil
+	}
+	b, e := json.Marshal(value)
+	if e != nil {
+		return nil
+	}
+	var owner EsOwner
+	if e := json.Unmarshal(b, &owner); e != nil || owner.Namespace == "" {
+		return nil
+	}
+	return &owner
+}
+
+// EsOwnedResource is an object created by the operator in Elasticsearch and its owner
+type EsOwnedResource struct {
+	Kind  string
+	Name  string
+	Owner EsOwner
+}
+
+// ownerMeta records the owner in the _meta of the index body mappings, keeping the rest of the _meta
+func ownerMeta(body string, owner *EsOwner) (string, error) {
+	if owner == nil {
+		return body, nil
+	}
+
+	var payload map[string]interface{}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return "", fmt.Errorf("Cannot set owner, invalid index body: %s", e)
+	}
+	mappings, _ := payload["mappings"].(map[string]interface{})
+	if mappings == nil {
+		mappings = map[string]interface{}{}
+	}
+	meta, _ := mappings["_meta"].(map[string]interface{})
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+	meta[OwnerMetaKey] = owner
+	mappings["_meta"] = meta
+	payload["mappings"] = mappings
+
+	b, e := json.Marshal(payload)
+	if e != nil {
+		return "", fmt.Errorf("Cannot set owner: %s", e)
+	}
+	return string(b), nil
+}
+
+// getOwner returns the owner recorded on the index, role or user, and whether the object exists
+func (c *EsClient) getOwner(kind string, name string) (*EsOwner, bool, error) {
+	path := "/_security/" + kind + "/" + name
+	if kind == KindIndex {
+		path = "/" + name + "/_mapping"
+	}
+	res, err := c.perform(http.MethodGet, path, "")
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot get %s owner: %s", kind, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if res.StatusCode > 299 {
+		return nil, false, responseError("get "+kind+" owner", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+
+	var payload map[string]struct {
+		Metadata map[string]interface{} `json:"metadata"`
+		Mappings struct {
+			Meta map[string]interface{} `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		return nil, false, fmt.Errorf("Cannot get %s owner: %s", kind, err)
+	}
+	// an alias returns the mappings of its indices
+	for _, object := range payload {
+		if kind == KindIndex {
+			return ownerOf(object.Mappings.Meta), true, nil
+		}
+		return ownerOf(object.Metadata), true, nil
+	}
+	return nil, false, nil
+}
+
+// foreignOwner returns the owner of the object when it's another Index, nil when the object is missing
+func (c *EsClient) foreignOwner(kind string, name string, owner *EsOwner) (*EsOwner, error) {
+	if owner == nil || name == "" {
+		return nil, nil
+	}
+	recorded, _, e := c.getOwner(kind, name)
+	if e != nil || owner.owns(recorded) {
+		return nil, e
+	}
+	return recorded, nil
+}
+
+// checkOwner refuses to change an object owned by another Index, the missing objects can be created
+func (c *EsClient) checkOwner(kind string, name string, owner *EsOwner) error {
+	recorded, e := c.foreignOwner(kind, name, owner)
+	if e != nil {
+		return e
+	}
+	if recorded != nil {
+		return &ValidationError{Reason: fmt.Sprintf("%s %s is owned by Index %s", kindName(kind), name, recorded)}
+	}
+	return nil
+}
+
+// isNew is true when the object is missing or already records the owner, like after a retried creation,
+// so putting it doesn't replace an object created outside the provisioning
+func (c *EsClient) isNew(kind string, name string, owner *EsOwner) (bool, error) {
+	recorded, exists, e := c.getOwner(kind, name)
+	if e != nil {
+		return false, e
+	}
+	return !exists || (owner != nil && recorded != nil && owner.owns(recorded)), nil
+}
+
+// kindName is the kind in messages, capitalised
+func kindName(kind string) string {
+	return strings.ToUpper(kind[:1]) + kind[1:]
+}
+
+// checkOwners refuses to remove the index, role or user when another Index owns them
+func (c *EsClient) checkOwners(owner *EsOwner, index string, role string, user string) error {
+	if e := c.checkOwner(KindIndex, index, owner); e != nil {
+		return e
+	}
+	if e := c.checkOwner(KindRole, role, owner); e != nil {
+		return e
+	}
+	return c.checkOwner(KindUser, user, owner)
+}
+
+// tagIndex records the owner in the _meta of an existing index, keeping the rest of the _meta
+func (c *EsClient) tagIndex(index string, owner *EsOwner) error {
+	if owner == nil {
+		return nil
+	}
+	res, err := c.perform(http.MethodGet, "/"+index+"/_mapping", "")
+	if err != nil {
+		return fmt.Errorf("Cannot get mapping: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError("get mapping", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+	var payload map[string]struct {
+		Mappings struct {
+			Meta map[string]interface{} `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("Cannot get mapping: %s", err)
+	}
+
+	for name, i := range payload {
+		meta := i.Mappings.Meta
+		if meta == nil {
+			meta = map[string]interface{}{}
+		}
+		meta[OwnerMetaKey] = owner
+		log.Infof("Recording owner %s of Index %s", owner, name)
+		if _, e := c.putMapping(name, map[string]interface{}{"_meta": meta}); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ListOwned returns the indices, roles and users recording an owner, sorted by kind and name
+func (c *EsClient) ListOwned() ([]EsOwnedResource, error) {
+	start := time.Now()
+	owned, e := c.listOwned()
+	metrics.ObserveEsRequest("listOwned", start, e)
+	return owned, e
+}
+
+func (c *EsClient) listOwned() ([]EsOwnedResource, error) {
+	owned := []EsOwnedResource{}
+	for _, kind := range []string{KindIndex, KindRole, KindUser} {
+		path := "/_security/" + kind
+		if kind == KindIndex {
+			path = "/_mapping?filter_path=*.mappings._meta"
+		}
+		res, err := c.perform(http.MethodGet, path, "")
+		if err != nil {
+			return nil, fmt.Errorf("Cannot list %s owners: %s", kind, err)
+		}
+		var payload map[string]struct {
+			Metadata map[string]interface{} `json:"metadata"`
+			Mappings struct {
+				Meta map[string]interface{} `json:"_meta"`
+			} `json:"mappings"`
+		}
+		if res.StatusCode > 299 {
+			e := responseError("list "+kind+" owners", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+			res.Body.Close()
+			return nil, e
+		}
+		err = json.NewDecoder(res.Body).Decode(&payload)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Cannot list %s owners: %s", kind, err)
+		}
+
+		names := make([]string, 0, len(payload))
+		for name := range payload {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			metadata := payload[name].Metadata
+			if kind == KindIndex {
+				metadata = payload[name].Mappings.Meta
+			}
+			if owner := ownerOf(metadata); owner != nil {
+				owned = append(owned, EsOwnedResource{Kind: kind, Name: name, Owner: *owner})
+			}
+		}
+	}
+	return owned, nil
+}
+
+// DeleteOwned deletes an object listed by ListOwned when it's still owned by the same Index, an object
+// already deleted is skipped
+func (c *EsClient) DeleteOwned(resource *EsOwnedResource) error {
+	start := time.Now()
+	e := c.deleteOwned(resource)
+	metrics.ObserveEsRequest("deleteOwned", start, e)
+	return e
+}
+
+func (c *EsClient) deleteOwned(resource *EsOwnedResource) error {
+	recorded, exists, e := c.getOwner(resource.Kind, resource.Name)
+	if e != nil || !exists {
+		return e
+	}
+	// the object was created again or adopted by another Index since it was listed
+	if recorded == nil || !resource.Owner.owns(recorded) {
+		return &ValidationError{Reason: fmt.Sprintf("%s %s isn't owned by Index %s anymore", kindName(resource.Kind), resource.Name, &resource.Owner)}
+	}
+
+	switch resource.Kind {
+	case KindIndex:
+		return ignoreNotFound(c.deleteIndex(resource.Name))
+	case KindRole:
+		return ignoreNotFound(c.deleteRole(resource.Name))
+	case KindUser:
+		return ignoreNotFound(c.deleteUser(resource.Name))
+	}
+	return fmt.Errorf("Cannot delete %s %s: unknown kind", resource.Kind, resource.Name)
+}
@@ -0,0 +1,95 @@
+package es_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/es/esfake"
+)
+
+func TestListOwned(t *testing.T) {
+	g := NewWithT(t)
+	server, service := newTestService(g)
+	defer server.Close()
+	state := provision(g, service)
+	// objects created outside the operator are not listed
+	esDo(g, server, http.MethodPut, "/other", `{"mappings": {"_meta": {"team": "search"}}}`)
+	esDo(g, server, http.MethodPut, "/_security/role/other-role", `{"indices": []}`)
+
+	owned, err := service.ListOwned()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(owned).To(Equal([]es.EsOwnedResource{
+		{Kind: es.KindIndex, Name: state.Index, Owner: *testOwner},
+		{Kind: es.KindRole, Name: "app-ns-role", Owner: *testOwner},
+		{Kind: es.KindUser, Name: "app-ns-role-user", Owner: *testOwner},
+	}))
+}
+
+func TestDeleteOwned(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is applied to the role after it was listed
+		change  func(g *WithT, server *esfake.Server)
+		err     string
+		deleted bool
+	}{
+		{
+			name:    "deletes the role still owned by the Index",
+			deleted: true,
+		},
+		{
+			name: "skips the role already deleted",
+			change: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodDelete, "/_security/role/app-ns-role", "")
+			},
+			deleted: true,
+		},
+		{
+			name: "keeps the role created again by another Index",
+			change: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/_security/role/app-ns-role",
+					`{"metadata": {"es-provisioner": {"namespace": "other", "index": "logs", "uid": "other"}}}`)
+			},
+			err: "Role app-ns-role isn't owned by Index test/ns/logs anymore",
+		},
+		{
+			name: "keeps the role created again without owner",
+			change: func(g *WithT, server *esfake.Server) {
+				esDo(g, server, http.MethodPut, "/_security/role/app-ns-role", `{"indices": []}`)
+			},
+			err: "isn't owned by Index",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server, service := newTestService(g)
+			defer server.Close()
+			provision(g, service)
+
+			owned, err := service.ListOwned()
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(owned).To(HaveLen(3))
+			if tt.change != nil {
+				tt.change(g, server)
+			}
+
+			err = service.DeleteOwned(&owned[1])
+			if tt.err == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+			}
+			status, _ := server.Do(http.MethodGet, "/_security/role/app-ns-role", "")
+			if tt.deleted {
+				g.Expect(status).To(Equal(http.StatusNotFound))
+			} else {
+				g.Expect(status).To(Equal(http.StatusOK))
+			}
+		})
+	}
+}
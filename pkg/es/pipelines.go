@@ -0,0 +1,202 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// NoPipeline is the pipeline setting value disabling the default or final pipeline of an index
+const NoPipeline = "_none"
+
+// EsPipelineOptions is an ingest pipeline, Processors and OnFailure are JSON arrays of processors
+type EsPipelineOptions struct {
+	Name        string
+	Description string
+	Processors  string
+	OnFailure   string
+	Version     int64
+}
+
+// EsSimulatedDocument is a sample document after running through a pipeline, Error is set when a processor failed
+type EsSimulatedDocument struct {
+	Source string
+	Error  string
+}
+
+// PutPipeline creates or replaces the ingest pipeline
+func (c *EsClient) PutPipeline(ops *EsPipelineOptions) error {
+	start := time.Now()
+	e := c.putPipeline(ops)
+	metrics.ObserveEsRequest("putPipeline", start, e)
+	return e
+}
+
+func (c *EsClient) putPipeline(ops *EsPipelineOptions) error {
+
+	log.Infof("Updating ingest pipeline: %s", ops.Name)
+	body, e := pipelineBody(ops)
+	if e != nil {
+		return e
+	}
+	b, e := json.Marshal(body)
+	if e != nil {
+		return fmt.Errorf("Cannot update pipeline: %s", e)
+	}
+
+	res, err := c.perform(http.MethodPut, "/_ingest/pipeline/"+ops.Name, string(b))
+	if err != nil {
+		return fmt.Errorf("Cannot update pipeline: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError("update pipeline", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+	return nil
+}
+
+// DeletePipeline deletes the ingest pipeline, indices using it fail to index documents until their settings change
+func (c *EsClient) DeletePipeline(name string) error {
+	start := time.Now()
+	e := c.deletePipeline(name)
+	metrics.ObserveEsRequest("deletePipeline", start, e)
+	return e
+}
+
+func (c *EsClient) deletePipeline(name string) error {
+
+	log.Infof("Deleting ingest pipeline: %s", name)
+	res, err := c.perform(http.MethodDelete, "/_ingest/pipeline/"+name, "")
+	if err != nil {
+		return fmt.Errorf("Cannot delete pipeline: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return ignoreNotFound(responseError("delete pipeline", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body}))
+	}
+	return nil
+}
+
+// SimulatePipeline runs the sample documents through the pipeline without storing it, the documents are
+// JSON objects. The pipeline errors are returned, the processor failures are reported per document.
+func (c *EsClient) SimulatePipeline(ops *EsPipelineOptions, docs []string) ([]EsSimulatedDocument, error) {
+	start := time.Now()
+	result, e := c.simulatePipeline(ops, docs)
+	metrics.ObserveEsRequest("simulatePipeline", start, e)
+	return result, e
+}
+
+func (c *EsClient) simulatePipeline(ops *EsPipelineOptions, docs []string) ([]EsSimulatedDocument, error) {
+
+	log.Infof("Simulating ingest pipeline: %s", ops.Name)
+	pipeline, e := pipelineBody(ops)
+	if e != nil {
+		return nil, e
+	}
+	samples := make([]map[string]interface{}, 0, len(docs))
+	for i, doc := range docs {
+		var source map[string]interface{}
+		if e := json.Unmarshal([]byte(doc), &source); e != nil {
+			return nil, &ValidationError{Reason: fmt.Sprintf("Cannot simulate pipeline, invalid sample document %d: %s", i+1, e)}
+		}
+		samples = append(samples, map[string]interface{}{"_source": source})
+	}
+	b, e := json.Marshal(map[string]interface{}{"pipeline": pipeline, "docs": samples})
+	if e != nil {
+		return nil, fmt.Errorf("Cannot simulate pipeline: %s", e)
+	}
+
+	res, err := c.perform(http.MethodPost, "/_ingest/pipeline/_simulate", string(b))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot simulate pipeline: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, responseError("simulate pipeline", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+
+	var simulated struct {
+		Docs []struct {
+			Doc struct {
+				Source json.RawMessage `json:"_source"`
+			} `json:"doc"`
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"docs"`
+	}
+	if e := json.NewDecoder(res.Body).Decode(&simulated); e != nil {
+		return nil, fmt.Errorf("Cannot simulate pipeline: %s", e)
+	}
+	result := make([]EsSimulatedDocument, 0, len(simulated.Docs))
+	for _, d := range simulated.Docs {
+		if d.Error != nil {
+			result = append(result, EsSimulatedDocument{Error: fmt.Sprintf("%s: %s", d.Error.Type, d.Error.Reason)})
+			continue
+		}
+		result = append(result, EsSimulatedDocument{Source: string(d.Doc.Source)})
+	}
+	return result, nil
+}
+
+// pipelineBody is the pipeline definition sent to Elasticsearch, invalid processors are a ValidationError
+func pipelineBody(ops *EsPipelineOptions) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	if ops.Description != "" {
+		body["description"] = ops.Description
+	}
+	if ops.Version != 0 {
+		body["version"] = ops.Version
+	}
+	for key, processors := range map[string]string{"processors": ops.Processors, "on_failure": ops.OnFailure} {
+		if strings.TrimSpace(processors) == "" {
+			continue
+		}
+		var list []interface{}
+		if e := json.Unmarshal([]byte(processors), &list); e != nil {
+			return nil, &ValidationError{Reason: fmt.Sprintf("Cannot update pipeline %s, invalid %s: %s", ops.Name, key, e)}
+		}
+		body[key] = list
+	}
+	if _, ok := body["processors"]; !ok {
+		// the processors are required by Elasticsearch even if empty
+		body["processors"] = []interface{}{}
+	}
+	return body, nil
+}
+
+// pipelineSettings sets the default and final pipelines in the index body settings, they take precedence
+// over the payload and the component templates. Pipelines not set are left unchanged.
+func pipelineSettings(body string, defaultPipeline string, finalPipeline string) (string, error) {
+	if defaultPipeline == "" && finalPipeline == "" {
+		return body, nil
+	}
+
+	var payload map[string]interface{}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return "", fmt.Errorf("Cannot set pipelines, invalid index body: %s", e)
+	}
+	settings, _ := payload["settings"].(map[string]interface{})
+	settings = flattenSettings(settings, "")
+	for key, pipeline := range map[string]string{"default_pipeline": defaultPipeline, "final_pipeline": finalPipeline} {
+		if pipeline == "" {
+			continue
+		}
+		delete(settings, key)
+		settings["index."+key] = pipeline
+	}
+	payload["settings"] = settings
+
+	b, e := json.Marshal(payload)
+	if e != nil {
+		return "", fmt.Errorf("Cannot set pipelines: %s", e)
+	}
+	return string(b), nil
+}
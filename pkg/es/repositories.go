@@ -0,0 +1,110 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// EsSnapshotRepositoryOptions is a snapshot repository of the Type (fs, s3...) with its settings
+type EsSnapshotRepositoryOptions struct {
+	Name     string
+	Type     string
+	Settings map[string]interface{}
+}
+
+// PutSnapshotRepository registers or updates the snapshot repository without verifying it,
+// see VerifySnapshotRepository
+func (c *EsClient) PutSnapshotRepository(ops *EsSnapshotRepositoryOptions) error {
+	start := time.Now()
+	e := c.putSnapshotRepository(ops)
+	metrics.ObserveEsRequest("putSnapshotRepository", start, e)
+	return e
+}
+
+func (c *EsClient) putSnapshotRepository(ops *EsSnapshotRepositoryOptions) error {
+
+	log.Infof("Updating Snapshot Repository: %s", ops.Name)
+	settings := ops.Settings
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{"type": ops.Type, "settings": settings})
+	if err != nil {
+		return fmt.Errorf("Cannot update snapshot repository: %s", err)
+	}
+
+	res, err := c.perform(http.MethodPut, "/_snapshot/"+ops.Name+"?verify=false", string(body))
+	if err != nil {
+		return fmt.Errorf("Cannot update snapshot repository: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError("update snapshot repository", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+	return nil
+}
+
+// VerifySnapshotRepository checks all the nodes can write to the repository and returns their names
+func (c *EsClient) VerifySnapshotRepository(name string) ([]string, error) {
+	start := time.Now()
+	nodes, e := c.verifySnapshotRepository(name)
+	metrics.ObserveEsRequest("verifySnapshotRepository", start, e)
+	return nodes, e
+}
+
+func (c *EsClient) verifySnapshotRepository(name string) ([]string, error) {
+
+	res, err := c.perform(http.MethodPost, "/_snapshot/"+name+"/_verify", "")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot verify snapshot repository: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return nil, responseError("verify snapshot repository", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+
+	var payload struct {
+		Nodes map[string]struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("Cannot verify snapshot repository: %s", err)
+	}
+	nodes := []string{}
+	for _, node := range payload.Nodes {
+		nodes = append(nodes, node.Name)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// DeleteSnapshotRepository unregisters the snapshot repository, the snapshots stored in it are kept.
+// Repositories already deleted are ignored.
+func (c *EsClient) DeleteSnapshotRepository(name string) error {
+	start := time.Now()
+	e := c.deleteSnapshotRepository(name)
+	metrics.ObserveEsRequest("deleteSnapshotRepository", start, e)
+	return e
+}
+
+func (c *EsClient) deleteSnapshotRepository(name string) error {
+
+	log.Infof("Deleting Snapshot Repository: %s", name)
+	res, err := c.perform(http.MethodDelete, "/_snapshot/"+name, "")
+	if err != nil {
+		return fmt.Errorf("Cannot delete snapshot repository: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return ignoreNotFound(responseError("delete snapshot repository", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body}))
+	}
+	return nil
+}
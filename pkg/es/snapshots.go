@@ -0,0 +1,429 @@
+package es
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot states returned by Elasticsearch
+const (
+	SnapshotInProgress = "IN_PROGRESS"
+	SnapshotSuccess    = "SUCCESS"
+	SnapshotPartial    = "PARTIAL"
+	SnapshotFailed     = "FAILED"
+)
+
+// EsSnapshotInfo is a snapshot of a repository, Reason is set when it failed
+type EsSnapshotInfo struct {
+	Snapshot string
+	State    string
+	Indices  []string
+	Reason   string
+	// Policy is the snapshot lifecycle policy that took the snapshot, empty for the other snapshots
+	Policy    string
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// EsRestoreOptions restores the index of the snapshot with the Target name
+type EsRestoreOptions struct {
+	Repository string
+	Snapshot   string
+	Index      string
+	Target     string
+}
+
+// EsSwapOptions moves the alias and the role of an index from one index to another, DeleteFrom deletes
+// the previous index once the alias moved. An index To owned by another Index is refused
+type EsSwapOptions struct {
+	From       string
+	To         string
+	Alias      string
+	Role       string
+	DeleteFrom bool
+	Owner      *EsOwner
+}
+
+// EsSnapshotLifecycleOptions is a snapshot lifecycle (SLM) policy taking snapshots of the indices on a schedule,
+// the retention is not set when ExpireAfter, MinCount and MaxCount are empty
+type EsSnapshotLifecycleOptions struct {
+	Name        string
+	Schedule    string
+	Repository  string
+	Indices     []string
+	ExpireAfter string
+	MinCount    int32
+	MaxCount    int32
+}
+
+// EsSnapshotLifecycleStatus are the last executions of a snapshot lifecycle policy, times are zero when
+// they didn't happen
+type EsSnapshotLifecycleStatus struct {
+	LastSuccess   string
+	LastSuccessAt time.Time
+	LastFailure   string
+	LastFailureAt time.Time
+	NextExecution time.Time
+}
+
+// CreateSnapshot starts a snapshot of the indices in the repository without waiting for it to complete
+func (c *EsClient) CreateSnapshot(repository string, snapshot string, indices []string) error {
+	start := time.Now()
+	e := c.createSnapshot(repository, snapshot, indices)
+	metrics.ObserveEsRequest("createSnapshot", start, e)
+	return e
+}
+
+func (c *EsClient) createSnapshot(repository string, snapshot string, indices []string) error {
+
+	log.Infof("Creating Snapshot %s of Indices %v in Repository %s", snapshot, indices, repository)
+	body, err := json.Marshal(map[string]interface{}{
+		"indices":              strings.Join(indices, ","),
+		"include_global_state": false,
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot create snapshot: %s", err)
+	}
+
+	res, err := c.perform(http.MethodPut, "/_snapshot/"+repository+"/"+snapshot, string(body))
+	if err != nil {
+		return fmt.Errorf("Cannot create snapshot: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError("create snapshot", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+	return nil
+}
+
+// GetSnapshot returns the snapshot, nil when it doesn't exist in the repository
+func (c *EsClient) GetSnapshot(repository string, snapshot string) (*EsSnapshotInfo, error) {
+	start := time.Now()
+	info, e := c.getSnapshot(repository, snapshot)
+	metrics.ObserveEsRequest("getSnapshot", start, e)
+	return info, e
+}
+
+func (c *EsClient) getSnapshot(repository string, snapshot string) (*EsSnapshotInfo, error) {
+
+	res, err := c.perform(http.MethodGet, "/_snapshot/"+repository+"/"+snapshot, "")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get snapshot: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		e := responseError("get snapshot", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+		if missingRepository(e) {
+			return nil, e
+		}
+		return nil, ignoreNotFound(e)
+	}
+
+	var payload struct {
+		Snapshots []struct {
+			Snapshot string   `json:"snapshot"`
+			State    string   `json:"state"`
+			Indices  []string `json:"indices"`
+			Reason   string   `json:"reason"`
+			Metadata struct {
+				Policy string `json:"policy"`
+			} `json:"metadata"`
+			StartTime int64 `json:"start_time_in_millis"`
+			EndTime   int64 `json:"end_time_in_millis"`
+		} `json:"snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("Cannot get snapshot: %s", err)
+	}
+	if len(payload.Snapshots) == 0 {
+		return nil, nil
+	}
+	s := payload.Snapshots[0]
+	info := &EsSnapshotInfo{
+		Snapshot: s.Snapshot,
+		State:    s.State,
+		Indices:  s.Indices,
+		Reason:   s.Reason,
+		Policy:   s.Metadata.Policy,
+	}
+	if s.StartTime > 0 {
+		info.StartTime = time.UnixMilli(s.StartTime)
+	}
+	if s.EndTime > 0 {
+		info.EndTime = time.UnixMilli(s.EndTime)
+	}
+	return info, nil
+}
+
+// DeleteSnapshot deletes the snapshot from the repository, snapshots already deleted are ignored
+func (c *EsClient) DeleteSnapshot(repository string, snapshot string) error {
+	start := time.Now()
+	e := c.deleteSnapshot(repository, snapshot)
+	metrics.ObserveEsRequest("deleteSnapshot", start, e)
+	return e
+}
+
+func (c *EsClient) deleteSnapshot(repository string, snapshot string) error {
+
+	log.Infof("Deleting Snapshot %s from Repository %s", snapshot, repository)
+	res, err := c.perform(http.MethodDelete, "/_snapshot/"+repository+"/"+snapshot, "")
+	if err != nil {
+		return fmt.Errorf("Cannot delete snapshot: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		e := responseError("delete snapshot", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+		if missingRepository(e) {
+			return e
+		}
+		return ignoreNotFound(e)
+	}
+	return nil
+}
+
+// RestoreSnapshot starts restoring the index of the snapshot as a new index without its aliases,
+// IndexRecovered is true once the restore completed
+func (c *EsClient) RestoreSnapshot(ops *EsRestoreOptions) error {
+	start := time.Now()
+	e := c.restoreSnapshot(ops)
+	metrics.ObserveEsRequest("restoreSnapshot", start, e)
+	return e
+}
+
+func (c *EsClient) restoreSnapshot(ops *EsRestoreOptions) error {
+
+	log.Infof("Restoring Index %s from Snapshot %s of Repository %s as %s", ops.Index, ops.Snapshot, ops.Repository, ops.Target)
+	body, err := json.Marshal(map[string]interface{}{
+		"indices":              ops.Index,
+		"include_global_state": false,
+		"include_aliases":      false,
+		"rename_pattern":       "(.+)",
+		"rename_replacement":   ops.Target,
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot restore snapshot: %s", err)
+	}
+
+	res, err := c.perform(http.MethodPost, "/_snapshot/"+ops.Repository+"/"+ops.Snapshot+"/_restore", string(body))
+	if err != nil {
+		return fmt.Errorf("Cannot restore snapshot: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError("restore snapshot", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+	return nil
+}
+
+// IndexRecovered is true when all the shards of the index completed their recovery,
+// false while the index doesn't exist
+func (c *EsClient) IndexRecovered(index string) (bool, error) {
+	start := time.Now()
+	recovered, e := c.indexRecovered(index)
+	metrics.ObserveEsRequest("indexRecovered", start, e)
+	return recovered, e
+}
+
+func (c *EsClient) indexRecovered(index string) (bool, error) {
+
+	res, err := c.perform(http.MethodGet, "/"+index+"/_recovery", "")
+	if err != nil {
+		return false, fmt.Errorf("Cannot get recovery: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode > 299 {
+		return false, responseError("get recovery", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+
+	var recoveries map[string]struct {
+		Shards []struct {
+			Stage string `json:"stage"`
+		} `json:"shards"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&recoveries); err != nil {
+		return false, fmt.Errorf("Cannot get recovery: %s", err)
+	}
+	shards := recoveries[index].Shards
+	if len(shards) == 0 {
+		return false, nil
+	}
+	for _, shard := range shards {
+		if shard.Stage != "DONE" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SwapIndex moves the alias to the new index and grants the role access to it
+func (c *EsClient) SwapIndex(ops *EsSwapOptions) error {
+	start := time.Now()
+	e := c.swapIndex(ops)
+	metrics.ObserveEsRequest("swapIndex", start, e)
+	return e
+}
+
+func (c *EsClient) swapIndex(ops *EsSwapOptions) error {
+	// a restored index of another Index must not be exposed through the alias and the role
+	if e := c.checkOwner(KindIndex, ops.To, ops.Owner); e != nil {
+		return e
+	}
+	e := c.swapAlias(ops.From, ops.To, ops.Alias)
+	if e != nil {
+		return e
+	}
+	if ops.Role != "" {
+		if e := c.putRole(ops.Role, ops.To, ops.Alias, ops.Owner); e != nil {
+			return e
+		}
+	}
+	if ops.DeleteFrom {
+		if e := c.checkOwner(KindIndex, ops.From, ops.Owner); e != nil {
+			return e
+		}
+		return c.deleteIndex(ops.From)
+	}
+	return nil
+}
+
+// PutSnapshotLifecycle creates or replaces the snapshot lifecycle policy, the snapshots are named after the policy
+func (c *EsClient) PutSnapshotLifecycle(ops *EsSnapshotLifecycleOptions) error {
+	start := time.Now()
+	e := c.putSnapshotLifecycle(ops)
+	metrics.ObserveEsRequest("putSnapshotLifecycle", start, e)
+	return e
+}
+
+func (c *EsClient) putSnapshotLifecycle(ops *EsSnapshotLifecycleOptions) error {
+
+	log.Infof("Updating Snapshot Lifecycle Policy: %s", ops.Name)
+	policy := map[string]interface{}{
+		"schedule":   ops.Schedule,
+		"name":       "<" + ops.Name + "-{now/d}>",
+		"repository": ops.Repository,
+		"config": map[string]interface{}{
+			"indices":              ops.Indices,
+			"include_global_state": false,
+		},
+	}
+	retention := map[string]interface{}{}
+	if ops.ExpireAfter != "" {
+		retention["expire_after"] = ops.ExpireAfter
+	}
+	if ops.MinCount > 0 {
+		retention["min_count"] = ops.MinCount
+	}
+	if ops.MaxCount > 0 {
+		retention["max_count"] = ops.MaxCount
+	}
+	if len(retention) > 0 {
+		policy["retention"] = retention
+	}
+	body, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("Cannot update snapshot lifecycle policy: %s", err)
+	}
+
+	res, err := c.perform(http.MethodPut, "/_slm/policy/"+ops.Name, string(body))
+	if err != nil {
+		return fmt.Errorf("Cannot update snapshot lifecycle policy: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError("update snapshot lifecycle policy", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+	return nil
+}
+
+// GetSnapshotLifecycle returns the last executions of the snapshot lifecycle policy, nil when it doesn't exist
+func (c *EsClient) GetSnapshotLifecycle(name string) (*EsSnapshotLifecycleStatus, error) {
+	start := time.Now()
+	status, e := c.getSnapshotLifecycle(name)
+	metrics.ObserveEsRequest("getSnapshotLifecycle", start, e)
+	return status, e
+}
+
+func (c *EsClient) getSnapshotLifecycle(name string) (*EsSnapshotLifecycleStatus, error) {
+
+	res, err := c.perform(http.MethodGet, "/_slm/policy/"+name, "")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get snapshot lifecycle policy: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode > 299 {
+		return nil, responseError("get snapshot lifecycle policy", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+
+	type execution struct {
+		SnapshotName string `json:"snapshot_name"`
+		Time         int64  `json:"time"`
+	}
+	var policies map[string]struct {
+		LastSuccess         *execution `json:"last_success"`
+		LastFailure         *execution `json:"last_failure"`
+		NextExecutionMillis int64      `json:"next_execution_millis"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&policies); err != nil {
+		return nil, fmt.Errorf("Cannot get snapshot lifecycle policy: %s", err)
+	}
+	policy, ok := policies[name]
+	if !ok {
+		return nil, nil
+	}
+	status := &EsSnapshotLifecycleStatus{}
+	if policy.LastSuccess != nil {
+		status.LastSuccess = policy.LastSuccess.SnapshotName
+		status.LastSuccessAt = time.UnixMilli(policy.LastSuccess.Time)
+	}
+	if policy.LastFailure != nil {
+		status.LastFailure = policy.LastFailure.SnapshotName
+		status.LastFailureAt = time.UnixMilli(policy.LastFailure.Time)
+	}
+	if policy.NextExecutionMillis > 0 {
+		status.NextExecution = time.UnixMilli(policy.NextExecutionMillis)
+	}
+	return status, nil
+}
+
+// DeleteSnapshotLifecycle deletes the snapshot lifecycle policy, the snapshots it took are kept
+func (c *EsClient) DeleteSnapshotLifecycle(name string) error {
+	start := time.Now()
+	e := c.deleteSnapshotLifecycle(name)
+	metrics.ObserveEsRequest("deleteSnapshotLifecycle", start, e)
+	return e
+}
+
+func (c *EsClient) deleteSnapshotLifecycle(name string) error {
+
+	log.Infof("Deleting Snapshot Lifecycle Policy: %s", name)
+	res, err := c.perform(http.MethodDelete, "/_slm/policy/"+name, "")
+	if err != nil {
+		return fmt.Errorf("Cannot delete snapshot lifecycle policy: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return ignoreNotFound(responseError("delete snapshot lifecycle policy", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body}))
+	}
+	return nil
+}
+
+// missingRepository is true when the snapshot request failed because the repository doesn't exist, unlike
+// a missing snapshot it's not ignored
+func missingRepository(err error) bool {
+	var esErr *EsError
+	return errors.As(err, &esErr) && esErr.Type == "repository_missing_exception"
+}
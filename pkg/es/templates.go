@@ -0,0 +1,196 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// EsComponentTemplateOptions is a component template, Template is the JSON object with its settings, mappings and aliases
+type EsComponentTemplateOptions struct {
+	Name     string
+	Template string
+	Version  int64
+}
+
+// EsIndexTemplateOptions is an index template applied by Elasticsearch to the new indices matching the patterns
+type EsIndexTemplateOptions struct {
+	Name          string
+	IndexPatterns []string
+	ComposedOf    []string
+	Priority      int64
+	Template      string
+	Version       int64
+}
+
+// PutComponentTemplate creates or replaces the component template
+func (c *EsClient) PutComponentTemplate(ops *EsComponentTemplateOptions) error {
+	start := time.Now()
+	template := ops.Template
+	if template == "" {
+		// the template is required by Elasticsearch even if empty
+		template = "{}"
+	}
+	e := c.putTemplate("component template", "/_component_template/"+ops.Name, template, map[string]interface{}{}, ops.Version)
+	metrics.ObserveEsRequest("putComponentTemplate", start, e)
+	return e
+}
+
+// DeleteComponentTemplate deletes the component template, it fails while an index template uses it
+func (c *EsClient) DeleteComponentTemplate(name string) error {
+	start := time.Now()
+	e := c.deleteTemplate("component template", "/_component_template/"+name)
+	metrics.ObserveEsRequest("deleteComponentTemplate", start, e)
+	return e
+}
+
+// PutIndexTemplate creates or replaces the index template, the component templates it's composed of must exist
+func (c *EsClient) PutIndexTemplate(ops *EsIndexTemplateOptions) error {
+	start := time.Now()
+	body := map[string]interface{}{
+		"index_patterns": ops.IndexPatterns,
+		"composed_of":    ops.ComposedOf,
+		"priority":       ops.Priority,
+	}
+	e := c.putTemplate("index template", "/_index_template/"+ops.Name, ops.Template, body, ops.Version)
+	metrics.ObserveEsRequest("putIndexTemplate", start, e)
+	return e
+}
+
+// DeleteIndexTemplate deletes the index template, the indices already created with it are not changed
+func (c *EsClient) DeleteIndexTemplate(name string) error {
+	start := time.Now()
+	e := c.deleteTemplate("index template", "/_index_template/"+name)
+	metrics.ObserveEsRequest("deleteIndexTemplate", start, e)
+	return e
+}
+
+func (c *EsClient) putTemplate(kind string, path string, template string, body map[string]interface{}, version int64) error {
+
+	log.Infof("Updating %s: %s", kind, path)
+	if template != "" {
+		var t map[string]interface{}
+		if e := json.Unmarshal([]byte(template), &t); e != nil {
+			return &ValidationError{Reason: fmt.Sprintf("Cannot update %s, invalid template: %s", kind, e)}
+		}
+		body["template"] = t
+	}
+	if version != 0 {
+		body["version"] = version
+	}
+	b, e := json.Marshal(body)
+	if e != nil {
+		return fmt.Errorf("Cannot update %s: %s", kind, e)
+	}
+
+	res, err := c.perform(http.MethodPut, path, string(b))
+	if err != nil {
+		return fmt.Errorf("Cannot update %s: %s", kind, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return responseError("update "+kind, &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+	return nil
+}
+
+func (c *EsClient) deleteTemplate(kind string, path string) error {
+
+	log.Infof("Deleting %s: %s", kind, path)
+	res, err := c.perform(http.MethodDelete, path, "")
+	if err != nil {
+		return fmt.Errorf("Cannot delete %s: %s", kind, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return ignoreNotFound(responseError("delete "+kind, &esapi.Response{StatusCode: res.StatusCode, Body: res.Body}))
+	}
+	return nil
+}
+
+// missingComponentTemplates returns the component templates that don't exist in the cluster
+func (c *EsClient) missingComponentTemplates(names []string) ([]string, error) {
+	missing := []string{}
+	for _, name := range names {
+		exists, e := c.exists("component template", "/_component_template/"+name)
+		if e != nil {
+			return nil, e
+		}
+		if !exists {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// composeBody merges the component templates into the index body like an index template composed of them
+// would, the index body takes precedence. The templates are created by the platform team, a missing one
+// is reported as a transient error to wait for it.
+func (c *EsClient) composeBody(index string, body string, componentTemplates []string) (string, error) {
+	if len(componentTemplates) == 0 {
+		return body, nil
+	}
+
+	missing, e := c.missingComponentTemplates(componentTemplates)
+	if e != nil {
+		return "", e
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("Cannot compose index %s, component templates %v not found", index, missing)
+	}
+
+	var payload map[string]interface{}
+	if e := json.Unmarshal([]byte(body), &payload); e != nil {
+		return "", fmt.Errorf("Cannot compose index, invalid index body: %s", e)
+	}
+	template := map[string]interface{}{}
+	for _, key := range []string{"settings", "mappings", "aliases"} {
+		if value, ok := payload[key]; ok {
+			template[key] = value
+		}
+	}
+
+	b, e := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{index},
+		"composed_of":    componentTemplates,
+		"priority":       math.MaxInt32,
+		"template":       template,
+	})
+	if e != nil {
+		return "", fmt.Errorf("Cannot compose index: %s", e)
+	}
+
+	res, err := c.perform(http.MethodPost, "/_index_template/_simulate", string(b))
+	if err != nil {
+		return "", fmt.Errorf("Cannot compose index: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return "", responseError("compose index", &esapi.Response{StatusCode: res.StatusCode, Body: res.Body})
+	}
+
+	var simulated struct {
+		Template map[string]interface{} `json:"template"`
+	}
+	if e := json.NewDecoder(res.Body).Decode(&simulated); e != nil {
+		return "", fmt.Errorf("Cannot compose index: %s", e)
+	}
+	for _, key := range []string{"settings", "mappings", "aliases"} {
+		delete(payload, key)
+		if value, ok := simulated.Template[key]; ok && value != nil {
+			payload[key] = value
+		}
+	}
+
+	b, e = json.Marshal(payload)
+	if e != nil {
+		return "", fmt.Errorf("Cannot compose index: %s", e)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,68 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"com.ramos/es-provisioner/pkg/metrics"
+)
+
+// EsIndexUsage is the shards and total store size of an index, as reported by _cat/indices
+type EsIndexUsage struct {
+	Name      string
+	Primaries int
+	Replicas  int
+	StoreSize int64
+}
+
+// CatIndices returns the usage of the indices of the names, indices or aliases, sorted by index name
+func (c *EsClient) CatIndices(names []string) ([]EsIndexUsage, error) {
+	start := time.Now()
+	usage, e := c.catIndices(names)
+	metrics.ObserveEsRequest("catIndices", start, e)
+	return usage, e
+}
+
+func (c *EsClient) catIndices(names []string) ([]EsIndexUsage, error) {
+
+	if len(names) == 0 {
+		return []EsIndexUsage{}, nil
+	}
+	res, err := c.client.Cat.Indices(
+		c.client.Cat.Indices.WithIndex(names...),
+		c.client.Cat.Indices.WithFormat("json"),
+		c.client.Cat.Indices.WithBytes("b"),
+		c.client.Cat.Indices.WithH("index", "pri", "rep", "store.size"),
+		c.client.Cat.Indices.WithS("index"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get indices usage: %s", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError("get indices usage", res)
+	}
+
+	// _cat returns all the values as strings, the store size is null while an index is recovering
+	var rows []struct {
+		Index     string  `json:"index"`
+		Pri       string  `json:"pri"`
+		Rep       string  `json:"rep"`
+		StoreSize *string `json:"store.size"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("Cannot get indices usage: %s", err)
+	}
+	usage := []EsIndexUsage{}
+	for _, row := range rows {
+		u := EsIndexUsage{Name: row.Index}
+		u.Primaries, _ = strconv.Atoi(row.Pri)
+		u.Replicas, _ = strconv.Atoi(row.Rep)
+		if row.StoreSize != nil {
+			u.StoreSize, _ = strconv.ParseInt(*row.StoreSize, 10, 64)
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
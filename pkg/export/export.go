@@ -0,0 +1,235 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/payload"
+	"github.com/joho/godotenv"
+	coreV1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// LifecycleKey is the Config Map key with the ILM policy of the index, it's exported for reference
+// and not applied by the operator
+const LifecycleKey = "lifecycle.json"
+
+// Options of the exported manifests
+type Options struct {
+	Namespace string
+	// Application of the Indices, the index name when empty
+	Application string
+}
+
+var invalidName = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Run is the export command, it reads the index matching the pattern and writes the manifests
+// to adopt it, for example:
+//
+//	manager export --pattern 'products' --namespace shop > products.yaml
+//
+// The operator keeps one Secret per namespace, so a single Index is exported per namespace.
+func Run(args []string) error {
+	_ = godotenv.Load()
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: manager export [flags]\n\n"+
+			"Writes the Index and Config Map adopting the index matching the pattern. The Indices of a namespace\n"+
+			"share the es-provisioner-index-secret Secret, so only one Index is written per namespace: export\n"+
+			"each index with its own --namespace.\n\n")
+		flags.PrintDefaults()
+	}
+	pattern := flags.String("pattern", "", "Index to export, a name or a wildcard matching a single index. Required.")
+	output := flags.String("output", "", "File to write the manifests to, stdout when empty.")
+	connection := flags.String("es-url", os.Getenv("ES_URL"), "Elasticsearch URL, the ES_URL variable by default.")
+	username := flags.String("es-username", os.Getenv("ES_USERNAME"),
+		"Elasticsearch user, the ES_USERNAME variable by default. The password is read from ES_PASSWORD.")
+	ops := Options{}
+	flags.StringVar(&ops.Namespace, "namespace", "default", "Namespace of the generated manifests.")
+	flags.StringVar(&ops.Application, "application", "", "Application of the generated Index, the index name by default.")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *pattern == "" {
+		return fmt.Errorf("--pattern is required, the index to export to namespace %s", ops.Namespace)
+	}
+
+	service, err := es.NewEsService(&es.EsOptions{
+		Connection: *connection,
+		Username:   *username,
+		Password:   os.Getenv("ES_PASSWORD"),
+		Retries:    1,
+	})
+	if err != nil {
+		return err
+	}
+
+	indices, err := service.GetIndices(*pattern)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := Manifests(indices, &ops)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(manifests)
+		return err
+	}
+	return os.WriteFile(*output, manifests, 0644)
+}
+
+// Manifests returns a multi document YAML with an Index and a Config Map adopting the index. The Index adopts
+// the write alias of the index, or the index when it has none, and the Config Map contains the settings,
+// mappings, the other aliases and the lifecycle policy. The Indices of a namespace would overwrite the
+// Secret of each other, so more than one index is refused.
+func Manifests(indices []es.EsIndexDefinition, ops *Options) ([]byte, error) {
+	if len(indices) > 1 {
+		names := make([]string, 0, len(indices))
+		for _, index := range indices {
+			names = append(names, index.Name)
+		}
+		return nil, fmt.Errorf("cannot export %d indices (%s) to namespace %s: the Indices of a namespace share "+
+			"the Secret %s, export each index with its own --namespace", len(indices), strings.Join(names, ", "),
+			ops.Namespace, esv1.SecretName)
+	}
+
+	var out bytes.Buffer
+	for _, index := range indices {
+		objects, err := manifests(&index, adoptedName(&index), ops)
+		if err != nil {
+			return nil, fmt.Errorf("cannot export index %s: %s", index.Name, err)
+		}
+		for _, obj := range objects {
+			b, err := toYaml(obj)
+			if err != nil {
+				return nil, fmt.Errorf("cannot export index %s: %s", index.Name, err)
+			}
+			out.WriteString("---\n")
+			out.Write(b)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+func manifests(index *es.EsIndexDefinition, name string, ops *Options) ([]runtime.Object, error) {
+	resourceName := kubernetesName(name)
+
+	aliases := map[string]interface{}{}
+	for alias, definition := range index.Aliases {
+		if alias != name {
+			aliases[alias] = definition
+		}
+	}
+
+	data := map[string]string{}
+	parts := map[string]interface{}{
+		payload.SettingsKey: index.Settings,
+		payload.MappingsKey: index.Mappings,
+		payload.AliasesKey:  aliases,
+	}
+	for key, value := range parts {
+		if m, ok := value.(map[string]interface{}); ok && len(m) == 0 {
+			continue
+		}
+		text, err := toJson(value)
+		if err != nil {
+			return nil, err
+		}
+		data[key+".json"] = text
+	}
+	if index.Lifecycle != nil {
+		text, err := toJson(map[string]interface{}{"policy": index.Lifecycle})
+		if err != nil {
+			return nil, err
+		}
+		data[LifecycleKey] = text
+	}
+
+	configMap := &coreV1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resourceName + "-index",
+			Namespace: ops.Namespace,
+		},
+		Data: data,
+	}
+
+	application := ops.Application
+	if application == "" {
+		application = resourceName
+	}
+	resource := &esv1.Index{
+		TypeMeta: metav1.TypeMeta{APIVersion: esv1.GroupVersion.String(), Kind: "Index"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resourceName,
+			Namespace: ops.Namespace,
+		},
+		Spec: esv1.IndexSpec{
+			Name:        name,
+			Application: application,
+			ConfigMap:   configMap.Name,
+			Adopt:       true,
+		},
+	}
+
+	return []runtime.Object{resource, configMap}, nil
+}
+
+// adoptedName is the write alias, the only alias unless it writes to another index, or the index name
+func adoptedName(index *es.EsIndexDefinition) string {
+	for alias, definition := range index.Aliases {
+		d, _ := definition.(map[string]interface{})
+		if d["is_write_index"] == true || (len(index.Aliases) == 1 && d["is_write_index"] != false) {
+			return alias
+		}
+	}
+	return index.Name
+}
+
+// kubernetesName converts the index name into a valid resource name
+func kubernetesName(name string) string {
+	name = invalidName.ReplaceAllString(strings.ToLower(name), "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-")
+}
+
+// toJson returns the indented JSON, escaping the template delimiters since the Config Map keys are templates
+func toJson(value interface{}) (string, error) {
+	b, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	text := strings.ReplaceAll(string(b), "{{", `{{"{{"}}`)
+	return text, nil
+}
+
+func toYaml(obj runtime.Object) ([]byte, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u, "status")
+	return yaml.Marshal(u)
+}
@@ -0,0 +1,52 @@
+package export
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"com.ramos/es-provisioner/pkg/es"
+)
+
+func TestRun(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(Run([]string{"--namespace", "shop"})).To(MatchError(ContainSubstring("--pattern is required")))
+}
+
+func TestManifests(t *testing.T) {
+	tests := []struct {
+		name    string
+		indices []es.EsIndexDefinition
+		// documents expected in the manifests, by kind
+		kinds []string
+		err   string
+	}{
+		{
+			name:    "exports the Index and ConfigMap adopting the index",
+			indices: []es.EsIndexDefinition{{Name: "products"}},
+			kinds:   []string{"Index", "ConfigMap"},
+		},
+		{
+			name:    "refuses more than one index for the namespace",
+			indices: []es.EsIndexDefinition{{Name: "orders"}, {Name: "products"}},
+			err:     "cannot export 2 indices (orders, products) to namespace shop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			manifests, err := Manifests(tt.indices, &Options{Namespace: "shop"})
+			if tt.err != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			for _, kind := range tt.kinds {
+				g.Expect(string(manifests)).To(ContainSubstring("kind: " + kind + "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"github.com/joho/godotenv"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Run is the gc command, it lists the indices, roles and users created by the operator of the cluster
+// whose Index doesn't exist anymore. It doesn't delete them, for example:
+//
+//	manager gc --cluster-name production
+func Run(args []string) error {
+	_ = godotenv.Load()
+
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	clusterName := flags.String("cluster-name", os.Getenv("CLUSTER_NAME"),
+		"Cluster name recorded as owner of the objects, the CLUSTER_NAME variable by default.")
+	connection := flags.String("es-url", os.Getenv("ES_URL"), "Elasticsearch URL, the ES_URL variable by default.")
+	username := flags.String("es-username", os.Getenv("ES_USERNAME"),
+		"Elasticsearch user, the ES_USERNAME variable by default. The password is read from ES_PASSWORD.")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	service, err := es.NewEsService(&es.EsOptions{
+		Connection: *connection,
+		Username:   *username,
+		Password:   os.Getenv("ES_PASSWORD"),
+		Retries:    1,
+	})
+	if err != nil {
+		return err
+	}
+	owned, err := service.ListOwned()
+	if err != nil {
+		return err
+	}
+
+	indices, err := listIndices()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tOWNER")
+	for _, o := range Orphans(owned, indices, *clusterName) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", o.Kind, o.Name, o.Owner.String())
+	}
+	return w.Flush()
+}
+
+// Orphans returns the objects owned by the cluster whose Index doesn't exist, the objects of other
+// clusters sharing Elasticsearch are ignored. The owner is matched by UID, so an Index deleted and
+// created again with the same name doesn't keep the objects of the previous one.
+func Orphans(owned []es.EsOwnedResource, indices []esv1.Index, clusterName string) []es.EsOwnedResource {
+	uids := map[string]bool{}
+	names := map[string]bool{}
+	for _, index := range indices {
+		uids[string(index.UID)] = true
+		names[index.Namespace+"/"+index.Name] = true
+	}
+
+	orphans := []es.EsOwnedResource{}
+	for _, o := range owned {
+		if o.Owner.Cluster != clusterName {
+			continue
+		}
+		if o.Owner.UID != "" && uids[o.Owner.UID] {
+			continue
+		}
+		if o.Owner.UID == "" && names[o.Owner.Namespace+"/"+o.Owner.Name] {
+			continue
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans
+}
+
+func listIndices() ([]esv1.Index, error) {
+	scheme := runtime.NewScheme()
+	if err := esv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	var list esv1.IndexList
+	if err := c.List(context.Background(), &list); err != nil {
+		return nil, fmt.Errorf("cannot list Indices: %s", err)
+	}
+	return list.Items, nil
+}
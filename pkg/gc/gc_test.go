@@ -0,0 +1,79 @@
+package gc
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+)
+
+func TestOrphans(t *testing.T) {
+	indices := []esv1.Index{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "logs", UID: "uid"}},
+	}
+
+	tests := []struct {
+		name string
+		// cluster is the name of the cluster the orphans are listed for
+		cluster string
+		owner   es.EsOwner
+		orphan  bool
+	}{
+		{
+			name:    "keeps the objects of an existing Index",
+			cluster: "test",
+			owner:   es.EsOwner{Cluster: "test", Namespace: "ns", Name: "logs", UID: "uid"},
+		},
+		{
+			name:    "lists the objects of an Index created again with the same name",
+			cluster: "test",
+			owner:   es.EsOwner{Cluster: "test", Namespace: "ns", Name: "logs", UID: "previous"},
+			orphan:  true,
+		},
+		{
+			name:    "lists the objects of a deleted Index",
+			cluster: "test",
+			owner:   es.EsOwner{Cluster: "test", Namespace: "ns", Name: "metrics", UID: "deleted"},
+			orphan:  true,
+		},
+		{
+			name:    "matches the owners recorded without UID by name",
+			cluster: "test",
+			owner:   es.EsOwner{Cluster: "test", Namespace: "ns", Name: "logs"},
+		},
+		{
+			name:    "lists the objects without UID of a deleted Index",
+			cluster: "test",
+			owner:   es.EsOwner{Cluster: "test", Namespace: "other", Name: "logs"},
+			orphan:  true,
+		},
+		{
+			name:    "ignores the objects of another cluster",
+			cluster: "test",
+			owner:   es.EsOwner{Cluster: "other", Namespace: "ns", Name: "metrics", UID: "deleted"},
+		},
+		{
+			name:    "lists the objects without cluster when the cluster name isn't set",
+			cluster: "",
+			owner:   es.EsOwner{Namespace: "ns", Name: "metrics", UID: "deleted"},
+			orphan:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			owned := []es.EsOwnedResource{{Kind: es.KindRole, Name: "app-ns-role", Owner: tt.owner}}
+			orphans := Orphans(owned, indices, tt.cluster)
+			if tt.orphan {
+				g.Expect(orphans).To(Equal(owned))
+			} else {
+				g.Expect(orphans).To(BeEmpty())
+			}
+		})
+	}
+}
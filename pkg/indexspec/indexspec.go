@@ -0,0 +1,133 @@
+package indexspec
+
+import (
+	"fmt"
+	"strings"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/es"
+	"com.ramos/es-provisioner/pkg/model"
+	"com.ramos/es-provisioner/pkg/payload"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+// SynonymsKey is the default Config Map key of a synonym set
+const SynonymsKey = "synonyms.txt"
+
+// ConfigMapGetter returns a Config Map of the Index namespace by name
+type ConfigMapGetter func(name string) (*coreV1.ConfigMap, error)
+
+// Payload returns the index payload rendered from the Config Map templates and the resource version
+// of the Config Map it was read from, both empty when the Index doesn't use a Config Map
+func Payload(index *esv1.Index, clusterName string, get ConfigMapGetter) (string, string, error) {
+	if index.Spec.ConfigMap == "" {
+		return "", "", nil
+	}
+	cm, err := get(index.Spec.ConfigMap)
+	if err != nil {
+		return "", "", err
+	}
+	spec, err := payload.Render(cm.Data, TemplateData(index, clusterName))
+	if err != nil {
+		return "", "", fmt.Errorf("cannot render ConfigMap %s: %s", index.Spec.ConfigMap, err)
+	}
+	return spec, cm.ResourceVersion, nil
+}
+
+// TemplateData is the context of the Config Map templates of the Index
+func TemplateData(index *esv1.Index, clusterName string) *payload.TemplateData {
+	data := &payload.TemplateData{
+		Namespace:       index.Namespace,
+		Application:     index.Spec.Application,
+		Shards:          index.Spec.NumberOfShards,
+		Replicas:        index.Spec.NumberOfReplicas,
+		RefreshInterval: index.Spec.RefreshInterval,
+		ClusterName:     clusterName,
+		Labels:          index.Labels,
+		Annotations:     index.Annotations,
+	}
+	if data.Shards == 0 {
+		data.Shards = model.DEFAULT_SHARDS
+	}
+	if data.RefreshInterval == "" {
+		data.RefreshInterval = model.DEFAULT_REFRESH_INTERVAL
+	}
+	return data
+}
+
+// Synonyms reads the synonym sets from their Config Maps, returning the resource version read for each set
+func Synonyms(index *esv1.Index, get ConfigMapGetter) ([]es.EsSynonymSet, map[string]string, error) {
+	sets := []es.EsSynonymSet{}
+	versions := map[string]string{}
+
+	for _, s := range index.Spec.Synonyms {
+		cm, err := get(s.ConfigMap)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := s.Key
+		if key == "" {
+			key = SynonymsKey
+		}
+		data, ok := cm.Data[key]
+		if !ok {
+			return nil, nil, fmt.Errorf("missing key %s in ConfigMap %s", key, s.ConfigMap)
+		}
+		sets = append(sets, es.EsSynonymSet{
+			Name:       s.Name,
+			Type:       s.Type,
+			Updateable: s.Updateable,
+			Synonyms:   ParseSynonyms(data),
+		})
+		versions[s.Name] = cm.ResourceVersion
+	}
+
+	return sets, versions, nil
+}
+
+// ParseSynonyms returns the rules in Solr format, one per line, skipping blank lines and comments
+func ParseSynonyms(data string) []string {
+	rules := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	return rules
+}
+
+// SetupOptions returns the provisioning options of the Index
+func SetupOptions(index *esv1.Index, namespace string, spec string, synonyms []es.EsSynonymSet) es.EsSetupOptions {
+	return es.EsSetupOptions{
+		Shards:             index.Spec.NumberOfShards,
+		RefreshInterval:    index.Spec.RefreshInterval,
+		Replicas:           index.Spec.NumberOfReplicas,
+		IndexName:          index.Spec.Name,
+		App:                index.Spec.Application,
+		Namespace:          namespace,
+		Spec:               spec,
+		Analyzers:          index.Spec.Analyzers,
+		Properties:         index.Spec.Properties,
+		Source:             index.Spec.SourceEnabled,
+		Synonyms:           synonyms,
+		ComponentTemplates: index.Spec.ComponentTemplates,
+		DefaultPipeline:    PipelineID(namespace, index.Spec.DefaultPipeline),
+		FinalPipeline:      PipelineID(namespace, index.Spec.FinalPipeline),
+		Adopt:              index.Spec.Adopt,
+	}
+}
+
+// Owner is the owner recorded on the objects of the Index in Elasticsearch
+func Owner(index *esv1.Index, clusterName string) *es.EsOwner {
+	return &es.EsOwner{Cluster: clusterName, Namespace: index.Namespace, Name: index.Name, UID: string(index.UID)}
+}
+
+// PipelineID is the Elasticsearch id of the IngestPipeline of the namespace, pipelines are cluster wide
+func PipelineID(namespace string, name string) string {
+	if name == "" || name == es.NoPipeline {
+		return name
+	}
+	return "es-provisioner-" + namespace + "-" + name
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "es_provisioner"
+
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+var (
+	// Provisions counts Index provisioning attempts by outcome
+	Provisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provisions_total",
+		Help:      "Number of Index provisioning attempts by outcome.",
+	}, []string{"outcome"})
+
+	// Deletions counts Index clean up attempts by outcome
+	Deletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deletions_total",
+		Help:      "Number of Index deletion attempts by outcome.",
+	}, []string{"outcome"})
+
+	// EsRequestDuration tracks the latency of each Elasticsearch operation
+	EsRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "es_request_duration_seconds",
+		Help:      "Latency of the Elasticsearch operations by operation and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"operation", "outcome"})
+
+	// EsRetries counts connection and request retries to Elasticsearch
+	EsRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "es_retries_total",
+		Help:      "Number of retries to Elasticsearch by type (connect, request).",
+	}, []string{"type"})
+
+	// Indices is the number of Index resources per status
+	Indices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "indices",
+		Help:      "Number of Index resources per status.",
+	}, []string{"status"})
+
+	// IndexDocs is the number of documents of each provisioned index
+	IndexDocs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "index_docs",
+		Help:      "Number of primary documents in the index.",
+	}, []string{"namespace", "name", "index"})
+
+	// IndexStoreSize is the store size of each provisioned index
+	IndexStoreSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "index_store_size_bytes",
+		Help:      "Total store size of the index including replicas.",
+	}, []string{"namespace", "name", "index"})
+
+	// EsConnected is 1 when the last check could reach and authenticate to the Elasticsearch cluster
+	EsConnected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "es_connected",
+		Help:      "Whether the last check could reach and authenticate to the Elasticsearch cluster.",
+	}, []string{"cluster"})
+
+	// OrphanedResources is the number of Elasticsearch objects owned by an Index that doesn't exist
+	OrphanedResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orphaned_resources",
+		Help:      "Number of Elasticsearch indices, roles and users whose Index doesn't exist, by kind.",
+	}, []string{"kind"})
+
+	// OrphanDeletions counts the deletions of orphaned Elasticsearch objects by kind and outcome
+	OrphanDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orphan_deletions_total",
+		Help:      "Number of deletions of orphaned Elasticsearch objects by kind and outcome.",
+	}, []string{"kind", "outcome"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(Provisions, Deletions, EsRequestDuration, EsRetries,
+		Indices, IndexDocs, IndexStoreSize, OrphanedResources, OrphanDeletions, EsConnected)
+}
+
+// Outcome returns the outcome label for the error
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// ObserveEsRequest records the duration of an Elasticsearch operation started at start
+func ObserveEsRequest(operation string, start time.Time, err error) {
+	EsRequestDuration.WithLabelValues(operation, Outcome(err)).Observe(time.Since(start).Seconds())
+}
@@ -1,5 +1,12 @@
 package model
 
+// Defaults applied when the Index doesn't set them
+const (
+	DEFAULT_SHARDS           = 4
+	DEFAULT_REFRESH_INTERVAL = "30s"
+	DEFAULT_ANALYZER         = "standard"
+)
+
 const INDEX_TEMPLATE = `
 {
 	"settings": {
@@ -24,12 +31,14 @@ const INDEX_TEMPLATE = `
 
 const ROLE_TEMPLATE = `
 {
+	"cluster": ["read_pipeline"],
 	"indices": [
 	  {
 		"names": [ "%s", "%s" ],
 		"privileges": ["create", "create_doc", "index", "read", "write", "view_index_metadata"]
 	  }
-	]
+	],
+	"metadata": %s
   }
 `
 
@@ -37,6 +46,7 @@ const USER_TEMPLATE = `
 {
 	"password" : "%s",
 	"roles" : [ "%s" ],
-	"full_name" : "%s"
+	"full_name" : "%s",
+	"metadata" : %s
 }
 `
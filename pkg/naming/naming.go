@@ -0,0 +1,114 @@
+package naming
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+	"com.ramos/es-provisioner/pkg/indexspec"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+// Annotations of the Namespace overriding the naming policy of the operator, they are set by the
+// cluster administrators as the tenants can't change their Namespace
+const (
+	TemplateAnnotation = "es-provisioner.com.ramos/index-name-template"
+	PrefixAnnotation   = "es-provisioner.com.ramos/index-prefix"
+)
+
+// namespaceSeparator ends the forced prefix of the namespace. The namespaces can't contain it, so the
+// prefix of a namespace is never the start of the prefix of another one, as "shop-" is for "shop-eu".
+const namespaceSeparator = "_"
+
+// DefaultTemplate is the name of the alias of the Indices without spec.name
+const DefaultTemplate = "es-provisioner-{{ .Application }}-{{ .Namespace }}"
+
+// Policy names the aliases of the new Indices. The template is rendered with the same context as the
+// Config Map templates, for example:
+//
+//	{{ .ClusterName }}-{{ .Namespace }}-{{ .Application }}
+//	{{ index .Labels "team" }}-{{ .Application }}
+type Policy struct {
+	template *template.Template
+	// ForceNamespacePrefix prefixes the names with the namespace and the namespaceSeparator when they
+	// don't start with them
+	ForceNamespacePrefix bool
+}
+
+// NewPolicy parses the template of the policy, the DefaultTemplate when empty
+func NewPolicy(text string, forceNamespacePrefix bool) (*Policy, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	t, err := parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{template: t, ForceNamespacePrefix: forceNamespacePrefix}, nil
+}
+
+// IndexName returns the alias of the Index: spec.name or the template of the Namespace or the policy, starting
+// with the prefix of the Namespace. A nil policy uses the DefaultTemplate without forced prefix.
+func (p *Policy) IndexName(index *esv1.Index, ns *coreV1.Namespace, clusterName string) (string, error) {
+	name := index.Spec.Name
+	if name == "" {
+		t, err := p.templateFor(ns)
+		if err != nil {
+			return "", err
+		}
+		var out bytes.Buffer
+		if err := t.Execute(&out, indexspec.TemplateData(index, clusterName)); err != nil {
+			return "", fmt.Errorf("cannot render index name template: %s", err)
+		}
+		name = strings.TrimSpace(out.String())
+	}
+
+	prefix := ns.Annotations[PrefixAnnotation]
+	if prefix == "" && p != nil && p.ForceNamespacePrefix {
+		prefix = ns.Name + namespaceSeparator
+	}
+	if !strings.HasPrefix(name, prefix) {
+		name = prefix + name
+	}
+	if err := validate(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (p *Policy) templateFor(ns *coreV1.Namespace) (*template.Template, error) {
+	if text := ns.Annotations[TemplateAnnotation]; text != "" {
+		return parse(text)
+	}
+	if p == nil || p.template == nil {
+		return parse(DefaultTemplate)
+	}
+	return p.template, nil
+}
+
+func parse(text string) (*template.Template, error) {
+	t, err := template.New("index-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse index name template: %s", err)
+	}
+	return t, nil
+}
+
+// validate checks the restrictions of Elasticsearch on index and alias names
+func validate(name string) error {
+	switch {
+	case name == "", name == ".", name == "..":
+		return fmt.Errorf("invalid index name %q", name)
+	case len(name) > 255:
+		return fmt.Errorf("invalid index name %q: longer than 255 bytes", name)
+	case name != strings.ToLower(name):
+		return fmt.Errorf("invalid index name %q: must be lowercase", name)
+	case strings.ContainsAny(name[:1], "-_+"):
+		return fmt.Errorf("invalid index name %q: cannot start with -, _ or +", name)
+	case strings.ContainsAny(name, "\\/*?\"<>| ,#:"):
+		return fmt.Errorf("invalid index name %q: cannot contain \\, /, *, ?, \", <, >, |, spaces, comma, # or :", name)
+	}
+	return nil
+}
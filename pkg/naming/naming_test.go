@@ -0,0 +1,117 @@
+package naming
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	esv1 "com.ramos/es-provisioner/api/v1"
+)
+
+func TestIndexName(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		force       bool
+		nilPolicy   bool
+		spec        string
+		annotations map[string]string
+		index       string
+		err         string
+	}{
+		{
+			name:  "names the Index with the default template",
+			index: "es-provisioner-app-shop",
+		},
+		{
+			name:      "names the Index with the default template without policy",
+			nilPolicy: true,
+			index:     "es-provisioner-app-shop",
+		},
+		{
+			name:     "renders the template of the policy",
+			template: `{{ .ClusterName }}-{{ .Application }}-{{ index .Labels "env" }}`,
+			index:    "east-app-prod",
+		},
+		{
+			name:        "renders the template of the Namespace",
+			template:    `{{ .Application }}`,
+			annotations: map[string]string{TemplateAnnotation: "{{ .Namespace }}-{{ .Application }}"},
+			index:       "shop-app",
+		},
+		{
+			name:  "keeps spec.name",
+			spec:  "products",
+			index: "products",
+		},
+		{
+			name:  "forces the prefix of the namespace",
+			force: true,
+			spec:  "products",
+			index: "shop_products",
+		},
+		{
+			name:  "keeps the prefix of the namespace",
+			force: true,
+			spec:  "shop_products",
+			index: "shop_products",
+		},
+		{
+			name:  "prefixes a name starting with the namespace and a dash",
+			force: true,
+			spec:  "shop-eu-products",
+			index: "shop_shop-eu-products",
+		},
+		{
+			name:        "forces the prefix of the Namespace",
+			spec:        "products",
+			annotations: map[string]string{PrefixAnnotation: "tenant-a-"},
+			index:       "tenant-a-products",
+		},
+		{
+			name: "rejects an invalid name",
+			spec: "Products",
+			err:  `invalid index name "Products": must be lowercase`,
+		},
+		{
+			name:     "rejects an unknown field",
+			template: `{{ .Team }}`,
+			err:      "cannot render index name template",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var policy *Policy
+			if !tt.nilPolicy {
+				var err error
+				policy, err = NewPolicy(tt.template, tt.force)
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			index := &esv1.Index{
+				ObjectMeta: metaV1.ObjectMeta{Name: "index", Namespace: "shop", Labels: map[string]string{"env": "prod"}},
+				Spec:       esv1.IndexSpec{Application: "app", Name: tt.spec},
+			}
+			ns := &coreV1.Namespace{ObjectMeta: metaV1.ObjectMeta{Name: "shop", Annotations: tt.annotations}}
+
+			name, err := policy.IndexName(index, ns, "east")
+			if tt.err != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(name).To(Equal(tt.index))
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewPolicy("{{ .Application ", false)
+	g.Expect(err).To(MatchError(ContainSubstring("cannot parse index name template")))
+}
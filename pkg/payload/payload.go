@@ -0,0 +1,114 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config Map keys containing the create index payload. Each key can be provided as .json or .yaml,
+// the parts are merged on top of the full payload when both are present.
+const (
+	PayloadKey  = "mapping"
+	SettingsKey = "settings"
+	MappingsKey = "mappings"
+	AliasesKey  = "aliases"
+)
+
+var extensions = []string{".json", ".yaml", ".yml"}
+
+// legacyVerbs finds the fmt verbs of the payloads written before the templates, the shards, replicas and
+// refresh interval were formatted into mapping.json with "%d", "%d" and "%s"
+var legacyVerbs = regexp.MustCompile(`%[dsv]`)
+
+// TemplateData is the context available to the Config Map templates, for example:
+//
+//	"index.number_of_shards": {{ .Shards }},
+//	"_meta": { "team": "{{ index .Labels "team" }}" }
+type TemplateData struct {
+	Namespace       string
+	Application     string
+	Shards          int
+	Replicas        int
+	RefreshInterval string
+	ClusterName     string
+	Labels          map[string]string
+	Annotations     map[string]string
+}
+
+// Render executes the templates in the Config Map data and returns the create index payload as JSON
+func Render(data map[string]string, ctx *TemplateData) (string, error) {
+	payload := map[string]interface{}{}
+
+	found, err := renderKey(data, PayloadKey, ctx, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	for _, part := range []string{SettingsKey, MappingsKey, AliasesKey} {
+		var value map[string]interface{}
+		ok, err := renderKey(data, part, ctx, &value)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			payload[part] = value
+			found = true
+		}
+	}
+
+	if !found {
+		return "", fmt.Errorf("no index payload found, expected one of the keys %s, %s, %s or %s with extension %s",
+			PayloadKey, SettingsKey, MappingsKey, AliasesKey, strings.Join(extensions, ", "))
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// renderKey renders the first key found with a supported extension into out
+func renderKey(data map[string]string, name string, ctx *TemplateData, out interface{}) (bool, error) {
+	for _, ext := range extensions {
+		key := name + ext
+		text, ok := data[key]
+		if !ok {
+			continue
+		}
+		if key == PayloadKey+".json" && isLegacy(text) {
+			text = fmt.Sprintf(text, ctx.Shards, ctx.Replicas, ctx.RefreshInterval)
+		}
+
+		t, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return false, fmt.Errorf("invalid template in key %s: %s", key, err)
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, ctx); err != nil {
+			return false, fmt.Errorf("cannot render key %s: %s", key, err)
+		}
+
+		if ext == ".json" {
+			err = json.Unmarshal(buf.Bytes(), out)
+		} else {
+			err = yaml.Unmarshal(buf.Bytes(), out)
+		}
+		if err != nil {
+			return false, fmt.Errorf("invalid payload in key %s: %s", key, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// isLegacy is true for a payload using the fmt verbs instead of the template actions
+func isLegacy(text string) bool {
+	return !strings.Contains(text, "{{") && legacyVerbs.MatchString(text)
+}
@@ -0,0 +1,95 @@
+package payload
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRender(t *testing.T) {
+	data := &TemplateData{
+		Namespace:       "test",
+		Application:     "app",
+		Shards:          2,
+		Replicas:        1,
+		RefreshInterval: "10s",
+		ClusterName:     "east",
+		Labels:          map[string]string{"team": "search"},
+	}
+
+	tests := []struct {
+		name    string
+		data    map[string]string
+		payload string
+		err     string
+	}{
+		{
+			name: "renders the full payload template",
+			data: map[string]string{"mapping.json": `{
+				"settings": {"index.number_of_shards": {{ .Shards }}, "index.refresh_interval": "{{ .RefreshInterval }}"},
+				"mappings": {"_meta": {"team": "{{ index .Labels "team" }}", "cluster": "{{ .ClusterName }}"}}
+			}`},
+			payload: `{
+				"settings": {"index.number_of_shards": 2, "index.refresh_interval": "10s"},
+				"mappings": {"_meta": {"team": "search", "cluster": "east"}}
+			}`,
+		},
+		{
+			name: "merges the split keys over the full payload",
+			data: map[string]string{
+				"mapping.json":  `{"settings": {"index.number_of_shards": 1}, "aliases": {"a": {}}}`,
+				"settings.yaml": "index:\n  number_of_replicas: {{ .Replicas }}\n",
+				"mappings.yml":  "properties:\n  name:\n    type: text\n",
+			},
+			payload: `{
+				"settings": {"index": {"number_of_replicas": 1}},
+				"mappings": {"properties": {"name": {"type": "text"}}},
+				"aliases": {"a": {}}
+			}`,
+		},
+		{
+			name:    "formats the legacy payload with the shards, replicas and refresh interval",
+			data:    map[string]string{"mapping.json": `{"settings": {"number_of_shards": %d, "number_of_replicas": %d, "refresh_interval": "%s"}}`},
+			payload: `{"settings": {"number_of_shards": 2, "number_of_replicas": 1, "refresh_interval": "10s"}}`,
+		},
+		{
+			name:    "keeps the verbs of a payload with template actions",
+			data:    map[string]string{"mapping.json": `{"mappings": {"_meta": {"format": "%d", "app": "{{ .Application }}"}}}`},
+			payload: `{"mappings": {"_meta": {"format": "%d", "app": "app"}}}`,
+		},
+		{
+			name:    "only formats the legacy verbs in mapping.json",
+			data:    map[string]string{"mappings.json": `{"_meta": {"format": "%s"}}`},
+			payload: `{"mappings": {"_meta": {"format": "%s"}}}`,
+		},
+		{
+			name: "requires a payload key",
+			data: map[string]string{"index.json": "{}"},
+			err:  "no index payload found",
+		},
+		{
+			name: "rejects a missing template field",
+			data: map[string]string{"mapping.json": `{"settings": {"shards": {{ .Missing }}}}`},
+			err:  "cannot render key mapping.json",
+		},
+		{
+			name: "rejects an invalid payload",
+			data: map[string]string{"settings.json": `{"index": `},
+			err:  "invalid payload in key settings.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			payload, err := Render(tt.data, data)
+			if tt.err != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.err)))
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(payload).To(MatchJSON(tt.payload))
+		})
+	}
+}